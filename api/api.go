@@ -4,13 +4,30 @@ package api
 import (
 	"net/http"
 
-	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/service"
 )
 
-// Handlers returns all the service registered url and handler pairs
-func Handlers(repo domain.VisitRepository) map[string]http.HandlerFunc {
+// Handlers returns all the service registered url and handler pairs, handlers only parse the requests and write the
+// responses, the business rules are applied by the visit service
+func Handlers(visits *service.VisitService) map[string]http.HandlerFunc {
 	return map[string]http.HandlerFunc{
-		"GET /api/v1/unique-visitors":  buildUniqueVisitorForPageHandler(repo),
-		"POST /api/v1/user-navigation": buildUserNavigationHandler(repo),
+		"GET /api/v1/page-registry":            buildPageListHandler(visits),
+		"POST /api/v1/page-registry":           buildPageRegisterHandler(visits),
+		"PUT /api/v1/page-registry":            buildPageUpdateHandler(visits),
+		"DELETE /api/v1/page-registry":         buildPageRetireHandler(visits),
+		"GET /api/v1/page-registry/quarantine": buildQuarantineHandler(visits),
+		"GET /api/v1/page-urls/normalize":      buildNormalizeHandler(visits),
+		"GET /api/v1/pages":                    buildPagesHandler(visits),
+		"GET /api/v1/pages/top":                buildTopPagesHandler(visits),
+		"GET /api/v1/unique-visitors":          buildUniqueVisitorForPageHandler(visits),
+		"GET /api/v1/unique-visitors/rollup":   buildUniqueVisitorRollupHandler(visits),
+		"GET /api/v1/unique-visitors/series":   buildUniqueVisitorSeriesHandler(visits),
+		"GET /api/v1/unique-visitors/combined": buildUniqueVisitorCombinedHandler(visits),
+		"POST /api/v1/unique-visitors/bulk":    buildUniqueVisitorBulkHandler(visits),
+		"POST /api/v1/user-navigation":         buildUserNavigationHandler(visits),
+		"POST /api/v1/user-navigation/batch":   buildUserNavigationBatchHandler(visits),
+		"POST /api/v1/user-navigation/stream":  buildUserNavigationStreamHandler(visits),
+		"DELETE /api/v1/visitors/{id}":         buildVisitorEraseHandler(visits),
+		"GET /api/v1/visitors/{id}/pages":      buildVisitorPagesHandler(visits),
 	}
 }
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/service"
+)
+
+// maxBatchBodySize bounds the size of the body of a batch, about a kilobyte per event
+const maxBatchBodySize = service.MaxBatchSize << 10
+
+// buildUserNavigationBatchHandler provides an http handler responsible for storing several visits at once. Each event
+// is validated with the same rules as a single visit, the valid ones are stored with a single repository call and the
+// result of each event is returned in the same order as the request, an invalid event doesn't prevent the others from
+// being stored. Visits of pages that aren't registered are handled according to the registry policy. The body is read
+// as it's decoded, a body larger than maxBatchBodySize or with more than service.MaxBatchSize events is rejected as
+// soon as it's found to be
+func buildUserNavigationBatchHandler(visits *service.VisitService) http.HandlerFunc {
+	// error is only present when the event was not stored, quarantined when it's held until its page is registered
+	type result struct {
+		Stored      bool   `json:"stored"`
+		Quarantined bool   `json:"quarantined,omitempty"`
+		Error       string `json:"error,omitempty"`
+	}
+
+	type responseBody struct {
+		Stored  int      `json:"stored"`
+		Results []result `json:"results"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		received := time.Now()
+
+		body := http.MaxBytesReader(w, r.Body, maxBatchBodySize)
+
+		defer func(Body io.ReadCloser) {
+			_ = Body.Close()
+		}(body)
+
+		events, err := decodeEvents(body)
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		response := responseBody{Results: make([]result, len(events))}
+
+		// decoded holds the visits of the events that could be decoded, indexes the position of their event
+		decoded := make([]domain.Visit, 0, len(events))
+		indexes := make([]int, 0, len(events))
+
+		for i, raw := range events {
+			event := navigationEvent{}
+
+			err := json.Unmarshal(raw, &event)
+			if err != nil {
+				response.Results[i] = result{Error: newErrUnmarshallRequest().Error()}
+
+				continue
+			}
+
+			decoded = append(decoded, event.visit(received))
+			indexes = append(indexes, i)
+		}
+
+		results, err := visits.RecordVisits(r.Context(), decoded)
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		for j, res := range results {
+			i := indexes[j]
+
+			switch res.Outcome {
+			case service.Stored:
+				response.Results[i] = result{Stored: true}
+				response.Stored++
+			case service.Quarantined:
+				response.Results[i] = result{Quarantined: true}
+			default:
+				response.Results[i] = result{Error: res.Err.Error()}
+			}
+		}
+
+		b, err := json.Marshal(response)
+		if err != nil {
+			writeError(w, newErrMarshallResponse())
+
+			return
+		}
+
+		_, _ = w.Write(b)
+	}
+}
+
+// decodeEvents reads the array of events of a batch without decoding them, so that a malformed event only fails itself
+// (see navigationEvent). The array is read one event at a time, it fails once there are more than
+// service.MaxBatchSize events or the body is over its limit (see http.MaxBytesReader)
+func decodeEvents(body io.Reader) ([]json.RawMessage, error) {
+	decoder := json.NewDecoder(body)
+
+	token, err := decoder.Token()
+	if err != nil || token != json.Delim('[') {
+		return nil, decodeError(err)
+	}
+
+	events := make([]json.RawMessage, 0)
+
+	for decoder.More() {
+		if len(events) == service.MaxBatchSize {
+			return nil, service.ErrBatchTooLarge
+		}
+
+		var event json.RawMessage
+
+		err := decoder.Decode(&event)
+		if err != nil {
+			return nil, decodeError(err)
+		}
+
+		events = append(events, event)
+	}
+
+	_, err = decoder.Token()
+	if err != nil {
+		return nil, decodeError(err)
+	}
+
+	return events, nil
+}
+
+// decodeError reports a body over its limit as such, any other error as a body that couldn't be read
+func decodeError(err error) error {
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		return newErrBodyTooLarge(strconv.FormatInt(maxBytesError.Limit, 10))
+	}
+
+	return newErrUnmarshallRequest()
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/service"
+)
+
+func TestBuildUserNavigationBatchHandler(t *testing.T) {
+	type testCase struct {
+		description        string
+		input              string
+		mockRepoFunc       func(visits []domain.Visit) error
+		expectedResponse   []byte
+		expectedStatusCode int
+	}
+
+	testCases := []testCase{
+		{
+			description: "success",
+			input:       `[{"visitor_id": "id", "page_url": "url"}, {"visitor_id": "id2", "page_url": "url", "timestamp": "2024-01-02T03:04:05Z"}]`,
+			mockRepoFunc: func(visits []domain.Visit) error {
+				if len(visits) != 2 {
+					t.Fatalf("len(visits) = %v, want %v", len(visits), 2)
+				}
+				if visits[0].Visitor != "id" || visits[1].Visitor != "id2" {
+					t.Errorf("visits = %v, want id and id2", visits)
+				}
+				if time.Since(visits[0].Time) > time.Minute {
+					t.Errorf("visits[0].Time = %v, want the time the request was received", visits[0].Time)
+				}
+				if !visits[1].Time.Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)) {
+					t.Errorf("visits[1].Time = %v, want %v", visits[1].Time, "2024-01-02T03:04:05Z")
+				}
+
+				return nil
+			},
+			expectedResponse:   []byte(`{"stored":2,"results":[{"stored":true},{"stored":true}]}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description: "success: invalid events are reported and skipped",
+			input:       `[{"page_url": "url"}, {"visitor_id": "id", "page_url": "url"}, {"visitor_id": "id", "page_url": "url", "timestamp": "yesterday"}, {"visitor_id": "id"}]`,
+			mockRepoFunc: func(visits []domain.Visit) error {
+				if len(visits) != 1 {
+					t.Errorf("len(visits) = %v, want %v", len(visits), 1)
+				}
+
+				return nil
+			},
+			expectedResponse: []byte(`{"stored":1,"results":[` +
+				`{"stored":false,"error":"missing request field: visitor id"},` +
+				`{"stored":true},` +
+				`{"stored":false,"error":"unable to read request body"},` +
+				`{"stored":false,"error":"missing request field: page url"}]}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description:        "success: nothing to store",
+			input:              `[{"page_url": "url"}]`,
+			expectedResponse:   []byte(`{"stored":0,"results":[{"stored":false,"error":"missing request field: visitor id"}]}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description:        "success: empty batch",
+			input:              `[]`,
+			expectedResponse:   []byte(`{"stored":0,"results":[]}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description:        "error: not an array",
+			input:              `{"visitor_id": "id", "page_url": "url"}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"unable to read request body"}`),
+		},
+		{
+			description:        "error: no body send",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"unable to read request body"}`),
+		},
+		{
+			description:        "error: batch too large",
+			input:              "[" + strings.Repeat(`{"visitor_id": "id", "page_url": "url"},`, service.MaxBatchSize) + `{"visitor_id": "id", "page_url": "url"}]`,
+			expectedStatusCode: http.StatusRequestEntityTooLarge,
+			expectedResponse:   []byte(`{"error":"batch can't have more than 1000 events"}`),
+		},
+		{
+			description:        "error: batch too large, rejected before the rest of the body is read",
+			input:              "[" + strings.Repeat(`{"visitor_id": "id", "page_url": "url"},`, service.MaxBatchSize+1) + "not json",
+			expectedStatusCode: http.StatusRequestEntityTooLarge,
+			expectedResponse:   []byte(`{"error":"batch can't have more than 1000 events"}`),
+		},
+		{
+			description:        "error: body too large",
+			input:              `[{"visitor_id": "` + strings.Repeat("a", maxBatchBodySize) + `", "page_url": "url"}]`,
+			expectedStatusCode: http.StatusRequestEntityTooLarge,
+			expectedResponse:   []byte(`{"error":"request body can't be larger than 1024000 bytes"}`),
+		},
+		{
+			description:        "error: unterminated array",
+			input:              `[{"visitor_id": "id", "page_url": "url"}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"unable to read request body"}`),
+		},
+		{
+			description: "error: call to repository fails",
+			input:       `[{"visitor_id": "id", "page_url": "url"}]`,
+			mockRepoFunc: func(visits []domain.Visit) error {
+				return errors.New("failed to call repository")
+			},
+			expectedResponse:   []byte(`{"error":"failed to call repository"}`),
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			mockRepo := &mockVisitRepository{
+				t:              t,
+				storeBatchFunc: tc.mockRepoFunc,
+			}
+
+			req, err := http.NewRequest(http.MethodPost, "url", strings.NewReader(tc.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h := buildUserNavigationBatchHandler(service.NewVisitService(mockRepo))
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			resp := rr.Result()
+
+			defer func(Body io.ReadCloser) {
+				_ = Body.Close()
+			}(resp.Body)
+
+			body, _ := io.ReadAll(resp.Body)
+
+			if !bytes.Equal(tc.expectedResponse, body) {
+				t.Errorf("got %v, expected %v", string(body), string(tc.expectedResponse))
+			}
+
+			if resp.StatusCode != tc.expectedStatusCode {
+				t.Errorf("got %v, expected %v", resp.StatusCode, tc.expectedStatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"deus.ai-code-challenge/service"
+)
+
+// buildUniqueVisitorBulkHandler provides an http handler responsible for providing the unique number of visitors of
+// several pages at once, pages without visits are included with a count of zero. The counts are read together, so
+// they are consistent with each other
+func buildUniqueVisitorBulkHandler(visits *service.VisitService) http.HandlerFunc {
+	type requestBody struct {
+		PageURLs []string `json:"page_urls"`
+	}
+
+	// estimated and error_bound are only present when the count is an approximation
+	type count struct {
+		UniqueVisitors uint64  `json:"unique_visitors"`
+		Estimated      bool    `json:"estimated,omitempty"`
+		ErrorBound     float64 `json:"error_bound,omitempty"`
+	}
+
+	type responseBody struct {
+		UniqueVisitors map[string]count `json:"unique_visitors"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		i := &requestBody{}
+
+		err := json.NewDecoder(r.Body).Decode(&i)
+		if err != nil {
+			writeError(w, newErrUnmarshallRequest())
+
+			return
+		}
+
+		defer func(Body io.ReadCloser) {
+			_ = Body.Close()
+		}(r.Body)
+
+		counts, err := visits.UniqueVisitorsBulk(r.Context(), i.PageURLs)
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		response := responseBody{UniqueVisitors: make(map[string]count, len(counts))}
+		for pageURL, uniqueVisitors := range counts {
+			response.UniqueVisitors[pageURL] = count{
+				UniqueVisitors: uniqueVisitors.Count,
+				Estimated:      uniqueVisitors.Estimated,
+				ErrorBound:     uniqueVisitors.ErrorBound,
+			}
+		}
+
+		b, err := json.Marshal(response)
+		if err != nil {
+			writeError(w, newErrMarshallResponse())
+
+			return
+		}
+
+		_, _ = w.Write(b)
+	}
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/service"
+)
+
+func TestBuildUniqueVisitorBulkHandler(t *testing.T) {
+	type testCase struct {
+		description        string
+		input              string
+		mockRepoFunc       func(pageURLs []string) (map[string]domain.UniqueVisitors, error)
+		expectedResponse   []byte
+		expectedStatusCode int
+	}
+
+	testCases := []testCase{
+		{
+			description: "success",
+			input:       `{"page_urls": ["url", "url2", "url3"]}`,
+			mockRepoFunc: func(pageURLs []string) (map[string]domain.UniqueVisitors, error) {
+				if !slices.Equal(pageURLs, []string{"url", "url2", "url3"}) {
+					t.Errorf("pageURLs = %v, want %v", pageURLs, []string{"url", "url2", "url3"})
+				}
+
+				return map[string]domain.UniqueVisitors{
+					"url":  {Count: 10},
+					"url2": {Count: 1000, Estimated: true, ErrorBound: 0.01625},
+					"url3": {},
+				}, nil
+			},
+			expectedResponse:   []byte(`{"unique_visitors":{"url":{"unique_visitors":10},"url2":{"unique_visitors":1000,"estimated":true,"error_bound":0.01625},"url3":{"unique_visitors":0}}}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description:        "error: no page urls provided",
+			input:              `{"page_urls": []}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"missing request field: page urls"}`),
+		},
+		{
+			description:        "error: empty page url",
+			input:              `{"page_urls": ["url", ""]}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"missing request field: page url"}`),
+		},
+		{
+			description:        "error: too many pages",
+			input:              `{"page_urls": [` + strings.Repeat(`"url",`, service.MaxBulkPages) + `"url"]}`,
+			expectedStatusCode: http.StatusRequestEntityTooLarge,
+			expectedResponse:   []byte(`{"error":"batch can't have more than 10000 pages"}`),
+		},
+		{
+			description:        "error: no body send",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"unable to read request body"}`),
+		},
+		{
+			description: "error: call to repository fails",
+			input:       `{"page_urls": ["url"]}`,
+			mockRepoFunc: func(pageURLs []string) (map[string]domain.UniqueVisitors, error) {
+				return nil, errors.New("failed to call repository")
+			},
+			expectedResponse:   []byte(`{"error":"failed to call repository"}`),
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			mockRepo := &mockVisitRepository{
+				t:                       t,
+				countUniqueVisitorsBulk: tc.mockRepoFunc,
+			}
+
+			req, err := http.NewRequest(http.MethodPost, "url", strings.NewReader(tc.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h := buildUniqueVisitorBulkHandler(service.NewVisitService(mockRepo))
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			resp := rr.Result()
+
+			defer func(Body io.ReadCloser) {
+				_ = Body.Close()
+			}(resp.Body)
+
+			body, _ := io.ReadAll(resp.Body)
+
+			if !bytes.Equal(tc.expectedResponse, body) {
+				t.Errorf("got %v, expected %v", string(body), string(tc.expectedResponse))
+			}
+
+			if resp.StatusCode != tc.expectedStatusCode {
+				t.Errorf("got %v, expected %v", resp.StatusCode, tc.expectedStatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/service"
+)
+
+// operators maps the accepted values of the operator query param to their set operator
+var operators = map[string]domain.SetOperator{
+	"union":        domain.Union,
+	"intersection": domain.Intersection,
+	"difference":   domain.Difference,
+}
+
+// buildUniqueVisitorCombinedHandler provides an http.Handler responsible for providing the unique number of visitors
+// of several pages combined: the visitors that saw any of them (union), all of them (intersection) or the first one
+// but none of the others (difference)
+func buildUniqueVisitorCombinedHandler(visits *service.VisitService) http.HandlerFunc {
+	queryParamKey := "pageUrl"
+	operatorParamKey := "operator"
+
+	// estimated and error_bound are only present when the count is an approximation
+	type responseBody struct {
+		Operator       string  `json:"operator"`
+		UniqueVisitors uint64  `json:"unique_visitors"`
+		Estimated      bool    `json:"estimated,omitempty"`
+		ErrorBound     float64 `json:"error_bound,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		pageURLs := query[queryParamKey]
+		if len(pageURLs) == 0 {
+			writeError(w, newErrMissingParamPrefix(queryParamKey))
+
+			return
+		}
+
+		for _, pageURL := range pageURLs {
+			if pageURL == "" {
+				writeError(w, newErrMissingParamPrefix(queryParamKey))
+
+				return
+			}
+		}
+
+		operatorName := query.Get(operatorParamKey)
+		if operatorName == "" {
+			operatorName = "union"
+		}
+
+		operator, found := operators[operatorName]
+		if !found {
+			writeError(w, newErrInvalidParam(operatorParamKey))
+
+			return
+		}
+
+		uniqueVisitors, err := visits.UniqueVisitorsCombined(r.Context(), pageURLs, operator)
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		b, err := json.Marshal(responseBody{
+			Operator:       operatorName,
+			UniqueVisitors: uniqueVisitors.Count,
+			Estimated:      uniqueVisitors.Estimated,
+			ErrorBound:     uniqueVisitors.ErrorBound,
+		})
+		if err != nil {
+			writeError(w, newErrMarshallResponse())
+
+			return
+		}
+
+		_, _ = w.Write(b)
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/service"
+)
+
+func TestBuildUniqueVisitorCombinedHandler(t *testing.T) {
+	type testCase struct {
+		description        string
+		input              string
+		mockRepoFunc       func(pageURLs []string, operator domain.SetOperator) (domain.UniqueVisitors, error)
+		expectedResponse   []byte
+		expectedStatusCode int
+	}
+
+	testCases := []testCase{
+		{
+			description: "success",
+			input:       `?pageUrl=pricing&pageUrl=signup&operator=intersection`,
+			mockRepoFunc: func(pageURLs []string, operator domain.SetOperator) (domain.UniqueVisitors, error) {
+				if !slices.Equal(pageURLs, []string{"pricing", "signup"}) {
+					t.Errorf("pageURLs = %v, want %v", pageURLs, []string{"pricing", "signup"})
+				}
+				if operator != domain.Intersection {
+					t.Errorf("operator = %v, want %v", operator, domain.Intersection)
+				}
+
+				return domain.UniqueVisitors{Count: 2}, nil
+			},
+			expectedResponse:   []byte(`{"operator":"intersection","unique_visitors":2}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description: "success: union by default, estimated count",
+			input:       `?pageUrl=pricing&pageUrl=signup`,
+			mockRepoFunc: func(pageURLs []string, operator domain.SetOperator) (domain.UniqueVisitors, error) {
+				if operator != domain.Union {
+					t.Errorf("operator = %v, want %v", operator, domain.Union)
+				}
+
+				return domain.UniqueVisitors{Count: 1000, Estimated: true, ErrorBound: 0.01625}, nil
+			},
+			expectedResponse:   []byte(`{"operator":"union","unique_visitors":1000,"estimated":true,"error_bound":0.01625}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description:        "error: unknown operator",
+			input:              `?pageUrl=pricing&operator=xor`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"invalid query param: operator"}`),
+		},
+		{
+			description:        "error: no page url provided",
+			input:              `?operator=union`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"missing query param: pageUrl"}`),
+		},
+		{
+			description:        "error: empty page url",
+			input:              `?pageUrl=pricing&pageUrl=`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"missing query param: pageUrl"}`),
+		},
+		{
+			description: "error: pages can't be combined",
+			input:       `?pageUrl=pricing&operator=intersection`,
+			mockRepoFunc: func(pageURLs []string, operator domain.SetOperator) (domain.UniqueVisitors, error) {
+				return domain.UniqueVisitors{}, fmt.Errorf("%w: too many pages", domain.ErrInvalidCombination)
+			},
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"invalid combination: too many pages"}`),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			mockRepo := &mockVisitRepository{
+				t:             t,
+				countCombined: tc.mockRepoFunc,
+			}
+
+			req, err := http.NewRequest(http.MethodGet, "url"+tc.input, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h := buildUniqueVisitorCombinedHandler(service.NewVisitService(mockRepo))
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			resp := rr.Result()
+
+			defer func(Body io.ReadCloser) {
+				_ = Body.Close()
+			}(resp.Body)
+
+			body, _ := io.ReadAll(resp.Body)
+
+			if !bytes.Equal(tc.expectedResponse, body) {
+				t.Errorf("got %v, expected %v", string(body), string(tc.expectedResponse))
+			}
+
+			if resp.StatusCode != tc.expectedStatusCode {
+				t.Errorf("got %v, expected %v", resp.StatusCode, tc.expectedStatusCode)
+			}
+		})
+	}
+}
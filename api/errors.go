@@ -5,6 +5,8 @@ import (
 	"errors"
 	"log"
 	"net/http"
+
+	"deus.ai-code-challenge/domain"
 )
 
 type genericError struct {
@@ -19,39 +21,63 @@ func (e genericError) Error() string {
 	return e.Err
 }
 
-type errInvalidPageURL struct {
+type errMissingParamPrefix struct {
 	Err string `json:"error"`
 }
 
-func newErrInvalidPageURL(field string) errInvalidPageURL {
-	return errInvalidPageURL{Err: "invalid page url: " + field}
+func newErrMissingParamPrefix(field string) errMissingParamPrefix {
+	return errMissingParamPrefix{Err: "missing query param: " + field}
 }
 
-func (e errInvalidPageURL) Error() string {
+func (e errMissingParamPrefix) Error() string {
 	return e.Err
 }
 
-type errMissingFieldPrefix struct {
+type errInvalidParam struct {
 	Err string `json:"error"`
 }
 
-func newErrMissingFieldPrefix(field string) errMissingFieldPrefix {
-	return errMissingFieldPrefix{Err: "missing request field: " + field}
+func newErrInvalidParam(field string) errInvalidParam {
+	return errInvalidParam{Err: "invalid query param: " + field}
 }
 
-func (e errMissingFieldPrefix) Error() string {
+func (e errInvalidParam) Error() string {
 	return e.Err
 }
 
-type errMissingParamPrefix struct {
+type errUnsupportedContentType struct {
 	Err string `json:"error"`
 }
 
-func newErrMissingParamPrefix(field string) errMissingParamPrefix {
-	return errMissingParamPrefix{Err: "missing query param: " + field}
+func newErrUnsupportedContentType(contentType string) errUnsupportedContentType {
+	return errUnsupportedContentType{Err: "unsupported content type: " + contentType}
 }
 
-func (e errMissingParamPrefix) Error() string {
+func (e errUnsupportedContentType) Error() string {
+	return e.Err
+}
+
+type errLineTooLong struct {
+	Err string `json:"error"`
+}
+
+func newErrLineTooLong(limit string) errLineTooLong {
+	return errLineTooLong{Err: "line can't be longer than " + limit + " bytes"}
+}
+
+func (e errLineTooLong) Error() string {
+	return e.Err
+}
+
+type errBodyTooLarge struct {
+	Err string `json:"error"`
+}
+
+func newErrBodyTooLarge(limit string) errBodyTooLarge {
+	return errBodyTooLarge{Err: "request body can't be larger than " + limit + " bytes"}
+}
+
+func (e errBodyTooLarge) Error() string {
 	return e.Err
 }
 
@@ -79,30 +105,46 @@ func (e errUnmarshallRequest) Error() string {
 	return e.Err
 }
 
+// statusCodes maps the kind of domain errors to the http status code they're reported with
+var statusCodes = map[domain.ErrorKind]int{
+	domain.KindInternal:    http.StatusInternalServerError,
+	domain.KindInvalid:     http.StatusBadRequest,
+	domain.KindNotFound:    http.StatusNotFound,
+	domain.KindConflict:    http.StatusConflict,
+	domain.KindRefused:     http.StatusUnprocessableEntity,
+	domain.KindTooLarge:    http.StatusRequestEntityTooLarge,
+	domain.KindUnavailable: http.StatusServiceUnavailable,
+	domain.KindTimeout:     http.StatusGatewayTimeout,
+}
+
 // writeError emulates what http.Error does but uses json instead of text to represent the data
-// this also ensures that all error responses follow the same structure
+// this also ensures that all error responses follow the same structure. Errors of the api are reported with their own
+// status code, domain errors according to their kind (see statusCodes)
 func writeError(w http.ResponseWriter, error error) {
 	w.Header().Set("Content-Type", "application/json")
 
-	var errInvalidPageURL errInvalidPageURL
-	var errMissingFieldPrefix errMissingFieldPrefix
 	var errMissingParamPrefix errMissingParamPrefix
+	var errInvalidParam errInvalidParam
+	var errUnsupportedContentType errUnsupportedContentType
+	var errBodyTooLarge errBodyTooLarge
 	var errMarshallResponse errMarshallResponse
 	var errUnmarshallRequest errUnmarshallRequest
 
 	switch {
-	case errors.As(error, &errInvalidPageURL):
-		w.WriteHeader(http.StatusBadRequest)
-	case errors.As(error, &errMissingFieldPrefix):
-		w.WriteHeader(http.StatusBadRequest)
 	case errors.As(error, &errMissingParamPrefix):
 		w.WriteHeader(http.StatusBadRequest)
+	case errors.As(error, &errInvalidParam):
+		w.WriteHeader(http.StatusBadRequest)
+	case errors.As(error, &errUnsupportedContentType):
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+	case errors.As(error, &errBodyTooLarge):
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
 	case errors.As(error, &errMarshallResponse):
 		w.WriteHeader(http.StatusInternalServerError)
 	case errors.As(error, &errUnmarshallRequest):
 		w.WriteHeader(http.StatusBadRequest)
 	default:
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(statusCodes[domain.KindOf(error)])
 		error = wrap(error)
 	}
 
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"deus.ai-code-challenge/service"
+)
+
+// buildNormalizeHandler provides an http.Handler responsible for showing the canonical form of a page url (dry-run),
+// i.e. the page its visits would be accounted for in, urls rejected by the policy are reported as such
+func buildNormalizeHandler(visits *service.VisitService) http.HandlerFunc {
+	queryParamKey := "pageUrl"
+
+	type responseBody struct {
+		PageURL    string `json:"page_url"`
+		Normalized string `json:"normalized"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		pageURL := r.URL.Query().Get(queryParamKey)
+		if pageURL == "" {
+			writeError(w, newErrMissingParamPrefix(queryParamKey))
+
+			return
+		}
+
+		normalized, err := visits.NormalizePageURL(pageURL)
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		b, err := json.Marshal(responseBody{PageURL: pageURL, Normalized: normalized})
+		if err != nil {
+			writeError(w, newErrMarshallResponse())
+
+			return
+		}
+
+		_, _ = w.Write(b)
+	}
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/service"
+)
+
+type normalizerFunc func(url domain.PageURL) (domain.PageURL, error)
+
+func (f normalizerFunc) Normalize(url domain.PageURL) (domain.PageURL, error) {
+	return f(url)
+}
+
+func TestBuildNormalizeHandler(t *testing.T) {
+	type testCase struct {
+		description        string
+		input              string
+		normalizer         normalizerFunc
+		expectedResponse   []byte
+		expectedStatusCode int
+	}
+
+	testCases := []testCase{
+		{
+			description: "success",
+			input:       `?pageUrl=https%3A%2F%2FExample.org%2Fpage%3Futm_source%3Dx`,
+			normalizer: func(url domain.PageURL) (domain.PageURL, error) {
+				if url != "https://Example.org/page?utm_source=x" {
+					t.Errorf("url = %v, want %v", url, "https://Example.org/page?utm_source=x")
+				}
+
+				return strings.ToLower(strings.TrimSuffix(url, "?utm_source=x")), nil
+			},
+			expectedResponse:   []byte(`{"page_url":"https://Example.org/page?utm_source=x","normalized":"https://example.org/page"}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description:        "error: no page url provided",
+			input:              ``,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"missing query param: pageUrl"}`),
+		},
+		{
+			description: "error: invalid page url",
+			input:       `?pageUrl=http%3A%2F%2F%5B%3A%3A1`,
+			normalizer: func(url domain.PageURL) (domain.PageURL, error) {
+				return "", fmt.Errorf("%w: %s", domain.ErrInvalidPageURL, url)
+			},
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"invalid page url: http://[::1"}`),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "url"+tc.input, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h := buildNormalizeHandler(service.NewVisitService(nil, service.WithNormalizer(tc.normalizer)))
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			resp := rr.Result()
+
+			defer func(Body io.ReadCloser) {
+				_ = Body.Close()
+			}(resp.Body)
+
+			body, _ := io.ReadAll(resp.Body)
+
+			if !bytes.Equal(tc.expectedResponse, body) {
+				t.Errorf("got %v, expected %v", string(body), string(tc.expectedResponse))
+			}
+
+			if resp.StatusCode != tc.expectedStatusCode {
+				t.Errorf("got %v, expected %v", resp.StatusCode, tc.expectedStatusCode)
+			}
+		})
+	}
+}
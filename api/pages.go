@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/service"
+)
+
+// buildPagesHandler provides an http.Handler responsible for listing the pages visits were received for along with
+// their unique visitors, a page at a time. Pages can be filtered by a url prefix and sorted by url or count, the
+// cursor of the next page is returned while there are more pages
+func buildPagesHandler(visits *service.VisitService) http.HandlerFunc {
+	prefixParamKey := "prefix"
+	sortParamKey := "sort"
+	cursorParamKey := "cursor"
+	limitParamKey := "limit"
+
+	// estimated and error_bound are only present when the count is an approximation
+	type page struct {
+		PageURL        string  `json:"page_url"`
+		UniqueVisitors uint64  `json:"unique_visitors"`
+		Estimated      bool    `json:"estimated,omitempty"`
+		ErrorBound     float64 `json:"error_bound,omitempty"`
+	}
+
+	// next_cursor is only present when there are more pages
+	type responseBody struct {
+		Pages      []page `json:"pages"`
+		NextCursor string `json:"next_cursor,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		limit := service.DefaultListedPages
+		if query.Has(limitParamKey) {
+			var err error
+
+			limit, err = strconv.Atoi(query.Get(limitParamKey))
+			if err != nil {
+				writeError(w, newErrInvalidParam(limitParamKey))
+
+				return
+			}
+		}
+
+		list, err := visits.ListPages(r.Context(),
+			query.Get(prefixParamKey),
+			domain.PageOrder(query.Get(sortParamKey)),
+			query.Get(cursorParamKey),
+			limit,
+		)
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		response := responseBody{Pages: make([]page, 0, len(list.Pages)), NextCursor: list.Next}
+		for _, count := range list.Pages {
+			response.Pages = append(response.Pages, page{
+				PageURL:        count.PageURL,
+				UniqueVisitors: count.UniqueVisitors.Count,
+				Estimated:      count.UniqueVisitors.Estimated,
+				ErrorBound:     count.UniqueVisitors.ErrorBound,
+			})
+		}
+
+		b, err := json.Marshal(response)
+		if err != nil {
+			writeError(w, newErrMarshallResponse())
+
+			return
+		}
+
+		_, _ = w.Write(b)
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/service"
+)
+
+func TestBuildPagesHandler(t *testing.T) {
+	type testCase struct {
+		description        string
+		input              string
+		mockRepoFunc       func(query domain.PageQuery) ([]domain.PageCount, error)
+		expectedResponse   []byte
+		expectedStatusCode int
+	}
+
+	testCases := []testCase{
+		{
+			description: "success: last page, defaults",
+			input:       ``,
+			mockRepoFunc: func(query domain.PageQuery) ([]domain.PageCount, error) {
+				if query.Order != domain.OrderByURL || query.Limit != service.DefaultListedPages+1 || query.After != nil {
+					t.Errorf("got %v, expected the first page by url", query)
+				}
+
+				return []domain.PageCount{{PageURL: "/blog", UniqueVisitors: domain.UniqueVisitors{Count: 3}}}, nil
+			},
+			expectedResponse:   []byte(`{"pages":[{"page_url":"/blog","unique_visitors":3}]}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description: "success: more pages, by count with a prefix",
+			input:       `?prefix=/blog&sort=count&limit=1`,
+			mockRepoFunc: func(query domain.PageQuery) ([]domain.PageCount, error) {
+				if query.Prefix != "/blog" || query.Order != domain.OrderByCount || query.Limit != 2 {
+					t.Errorf("got %v, expected 2 pages under /blog by count", query)
+				}
+
+				return []domain.PageCount{
+					{PageURL: "/blog/a", UniqueVisitors: domain.UniqueVisitors{Count: 3}},
+					{PageURL: "/blog/b", UniqueVisitors: domain.UniqueVisitors{Count: 2}},
+				}, nil
+			},
+			expectedResponse:   []byte(`{"pages":[{"page_url":"/blog/a","unique_visitors":3}],"next_cursor":"eyJwIjoiL2Jsb2ciLCJvIjoiY291bnQiLCJ1IjoiL2Jsb2cvYSIsImMiOjN9"}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description: "success: after a cursor",
+			input:       `?prefix=/blog&sort=count&limit=1&cursor=eyJwIjoiL2Jsb2ciLCJvIjoiY291bnQiLCJ1IjoiL2Jsb2cvYSIsImMiOjN9`,
+			mockRepoFunc: func(query domain.PageQuery) ([]domain.PageCount, error) {
+				if query.After == nil || query.After.PageURL != "/blog/a" || query.After.UniqueVisitors.Count != 3 {
+					t.Errorf("got %v, expected the pages after /blog/a", query.After)
+				}
+
+				return []domain.PageCount{}, nil
+			},
+			expectedResponse:   []byte(`{"pages":[]}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description:        "error: cursor of another listing",
+			input:              `?sort=url&cursor=eyJwIjoiL2Jsb2ciLCJvIjoiY291bnQiLCJ1IjoiL2Jsb2cvYSIsImMiOjN9`,
+			expectedResponse:   []byte(`{"error":"invalid cursor"}`),
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "error: unknown sort",
+			input:              `?sort=title`,
+			expectedResponse:   []byte(`{"error":"invalid order: title"}`),
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "error: limit isn't a number",
+			input:              `?limit=all`,
+			expectedResponse:   []byte(`{"error":"invalid query param: limit"}`),
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "error: limit out of bounds",
+			input:              `?limit=0`,
+			expectedResponse:   []byte(`{"error":"invalid limit: must be between 1 and 1000"}`),
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			mockRepo := &mockVisitRepository{
+				t:         t,
+				listPages: tc.mockRepoFunc,
+			}
+
+			req, err := http.NewRequest(http.MethodGet, "url"+tc.input, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h := buildPagesHandler(service.NewVisitService(mockRepo))
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			resp := rr.Result()
+
+			defer func(Body io.ReadCloser) {
+				_ = Body.Close()
+			}(resp.Body)
+
+			body, _ := io.ReadAll(resp.Body)
+
+			if !bytes.Equal(tc.expectedResponse, body) {
+				t.Errorf("got %v, expected %v", string(body), string(tc.expectedResponse))
+			}
+
+			if resp.StatusCode != tc.expectedStatusCode {
+				t.Errorf("got %v, expected %v", resp.StatusCode, tc.expectedStatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/service"
+)
+
+// pageBody is the representation of a registered page, released is only present when quarantined visits were stored
+type pageBody struct {
+	PageURL    string    `json:"page_url"`
+	Title      string    `json:"title,omitempty"`
+	Retired    bool      `json:"retired"`
+	Registered time.Time `json:"registered_at"`
+	Updated    time.Time `json:"updated_at"`
+	Released   int       `json:"released,omitempty"`
+}
+
+func newPageBody(page domain.Page) pageBody {
+	return pageBody{
+		PageURL:    page.URL,
+		Title:      page.Title,
+		Retired:    page.Retired,
+		Registered: page.Registered,
+		Updated:    page.Updated,
+	}
+}
+
+// buildPageListHandler provides an http handler responsible for listing every registered page (including the retired
+// ones) sorted by url
+func buildPageListHandler(visits *service.VisitService) http.HandlerFunc {
+	type responseBody struct {
+		Pages []pageBody `json:"pages"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		pages, err := visits.Pages()
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		response := responseBody{Pages: make([]pageBody, 0, len(pages))}
+		for _, page := range pages {
+			response.Pages = append(response.Pages, newPageBody(page))
+		}
+
+		b, err := json.Marshal(response)
+		if err != nil {
+			writeError(w, newErrMarshallResponse())
+
+			return
+		}
+
+		_, _ = w.Write(b)
+	}
+}
+
+// buildPageRegisterHandler provides an http handler responsible for registering a page, the visits quarantined while
+// the page was unknown are stored
+func buildPageRegisterHandler(visits *service.VisitService) http.HandlerFunc {
+	type requestBody struct {
+		PageURL string `json:"page_url,omitempty"`
+		Title   string `json:"title,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		i := &requestBody{}
+
+		err := json.NewDecoder(r.Body).Decode(&i)
+		if err != nil {
+			writeError(w, newErrUnmarshallRequest())
+
+			return
+		}
+
+		defer func(Body io.ReadCloser) {
+			_ = Body.Close()
+		}(r.Body)
+
+		page, released, err := visits.RegisterPage(r.Context(), domain.Page{URL: i.PageURL, Title: i.Title})
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		writePage(w, http.StatusCreated, page, released)
+	}
+}
+
+// buildPageUpdateHandler provides an http handler responsible for changing the title of a registered page and whether
+// it's retired, the visits quarantined while the page was retired are stored when it's brought back
+func buildPageUpdateHandler(visits *service.VisitService) http.HandlerFunc {
+	queryParamKey := "pageUrl"
+
+	type requestBody struct {
+		Title   string `json:"title,omitempty"`
+		Retired bool   `json:"retired,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		pageURL := r.URL.Query().Get(queryParamKey)
+		if pageURL == "" {
+			writeError(w, newErrMissingParamPrefix(queryParamKey))
+
+			return
+		}
+
+		i := &requestBody{}
+
+		err := json.NewDecoder(r.Body).Decode(&i)
+		if err != nil {
+			writeError(w, newErrUnmarshallRequest())
+
+			return
+		}
+
+		defer func(Body io.ReadCloser) {
+			_ = Body.Close()
+		}(r.Body)
+
+		page, released, err := visits.UpdatePage(r.Context(), domain.Page{URL: pageURL, Title: i.Title, Retired: i.Retired})
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		writePage(w, http.StatusOK, page, released)
+	}
+}
+
+// buildPageRetireHandler provides an http handler responsible for retiring a registered page, its visits are kept
+func buildPageRetireHandler(visits *service.VisitService) http.HandlerFunc {
+	queryParamKey := "pageUrl"
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		pageURL := r.URL.Query().Get(queryParamKey)
+		if pageURL == "" {
+			writeError(w, newErrMissingParamPrefix(queryParamKey))
+
+			return
+		}
+
+		page, err := visits.RetirePage(pageURL)
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		writePage(w, http.StatusOK, page, 0)
+	}
+}
+
+// writePage writes the page with the number of quarantined visits that were released (stored) with it
+func writePage(w http.ResponseWriter, status int, page domain.Page, released int) {
+	body := newPageBody(page)
+	body.Released = released
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		writeError(w, newErrMarshallResponse())
+
+		return
+	}
+
+	w.WriteHeader(status)
+	_, _ = w.Write(b)
+}
+
+// buildQuarantineHandler provides an http handler responsible for showing how many visits are quarantined per page
+func buildQuarantineHandler(visits *service.VisitService) http.HandlerFunc {
+	type responseBody struct {
+		Visits domain.Count                    `json:"visits"`
+		Pages  map[domain.PageURL]domain.Count `json:"pages"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		pages, err := visits.Quarantined()
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		response := responseBody{Pages: pages}
+		for _, visits := range pages {
+			response.Visits += visits
+		}
+
+		b, err := json.Marshal(response)
+		if err != nil {
+			writeError(w, newErrMarshallResponse())
+
+			return
+		}
+
+		_, _ = w.Write(b)
+	}
+}
@@ -0,0 +1,417 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/service"
+)
+
+type mockPageRegistry struct {
+	t            *testing.T
+	registerFunc func(page domain.Page) (domain.Page, error)
+	pageFunc     func(url string) (domain.Page, error)
+	pagesFunc    func() ([]domain.Page, error)
+	updateFunc   func(page domain.Page) (domain.Page, error)
+	retireFunc   func(url string) (domain.Page, error)
+}
+
+func (m *mockPageRegistry) Register(page domain.Page) (domain.Page, error) {
+	if m.registerFunc != nil {
+		return m.registerFunc(page)
+	}
+
+	m.t.Fatal("mockPageRegistry registerFunc is nil")
+	return domain.Page{}, nil
+}
+
+func (m *mockPageRegistry) Page(url string) (domain.Page, error) {
+	if m.pageFunc != nil {
+		return m.pageFunc(url)
+	}
+
+	m.t.Fatal("mockPageRegistry pageFunc is nil")
+	return domain.Page{}, nil
+}
+
+func (m *mockPageRegistry) Pages() ([]domain.Page, error) {
+	if m.pagesFunc != nil {
+		return m.pagesFunc()
+	}
+
+	m.t.Fatal("mockPageRegistry pagesFunc is nil")
+	return nil, nil
+}
+
+func (m *mockPageRegistry) Update(page domain.Page) (domain.Page, error) {
+	if m.updateFunc != nil {
+		return m.updateFunc(page)
+	}
+
+	m.t.Fatal("mockPageRegistry updateFunc is nil")
+	return domain.Page{}, nil
+}
+
+func (m *mockPageRegistry) Retire(url string) (domain.Page, error) {
+	if m.retireFunc != nil {
+		return m.retireFunc(url)
+	}
+
+	m.t.Fatal("mockPageRegistry retireFunc is nil")
+	return domain.Page{}, nil
+}
+
+type mockQuarantine struct {
+	t               *testing.T
+	quarantineFunc  func(visit domain.Visit) error
+	releaseFunc     func(url string) ([]domain.Visit, error)
+	quarantinedFunc func() (map[string]domain.Count, error)
+	forgetFunc      func(visitor string) (domain.Count, error)
+}
+
+func (m *mockQuarantine) Quarantine(visit domain.Visit) error {
+	if m.quarantineFunc != nil {
+		return m.quarantineFunc(visit)
+	}
+
+	m.t.Fatal("mockQuarantine quarantineFunc is nil")
+	return nil
+}
+
+func (m *mockQuarantine) Release(url string) ([]domain.Visit, error) {
+	if m.releaseFunc != nil {
+		return m.releaseFunc(url)
+	}
+
+	m.t.Fatal("mockQuarantine releaseFunc is nil")
+	return nil, nil
+}
+
+func (m *mockQuarantine) Quarantined() (map[string]domain.Count, error) {
+	if m.quarantinedFunc != nil {
+		return m.quarantinedFunc()
+	}
+
+	m.t.Fatal("mockQuarantine quarantinedFunc is nil")
+	return nil, nil
+}
+
+func (m *mockQuarantine) Forget(visitor string) (domain.Count, error) {
+	if m.forgetFunc != nil {
+		return m.forgetFunc(visitor)
+	}
+
+	m.t.Fatal("mockQuarantine forgetFunc is nil")
+	return 0, nil
+}
+
+func TestBuildUserNavigationHandlerUnknownPages(t *testing.T) {
+	type testCase struct {
+		description        string
+		input              string
+		unknownPages       service.UnknownPagePolicy
+		mockPageFunc       func(url string) (domain.Page, error)
+		mockQuarantineFunc func(visit domain.Visit) error
+		mockRepoFunc       func(visit domain.Visit) error
+		expectedResponse   []byte
+		expectedStatusCode int
+	}
+
+	notFound := func(url string) (domain.Page, error) {
+		return domain.Page{}, fmt.Errorf("%w: %s", domain.ErrPageNotFound, url)
+	}
+
+	testCases := []testCase{
+		{
+			description:  "success: unknown page accepted without checking the registry",
+			input:        `{"visitor_id": "id", "page_url": "url"}`,
+			unknownPages: service.AcceptUnknownPages,
+			mockRepoFunc: func(visit domain.Visit) error {
+				return nil
+			},
+			expectedResponse:   []byte(``),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description:  "success: registered page",
+			input:        `{"visitor_id": "id", "page_url": "url"}`,
+			unknownPages: service.RejectUnknownPages,
+			mockPageFunc: func(url string) (domain.Page, error) {
+				return domain.Page{URL: url}, nil
+			},
+			mockRepoFunc: func(visit domain.Visit) error {
+				return nil
+			},
+			expectedResponse:   []byte(``),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description:        "error: unknown page rejected",
+			input:              `{"visitor_id": "id", "page_url": "url"}`,
+			unknownPages:       service.RejectUnknownPages,
+			mockPageFunc:       notFound,
+			expectedResponse:   []byte(`{"error":"unknown page: url"}`),
+			expectedStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			description:  "error: retired page rejected",
+			input:        `{"visitor_id": "id", "page_url": "url"}`,
+			unknownPages: service.RejectUnknownPages,
+			mockPageFunc: func(url string) (domain.Page, error) {
+				return domain.Page{URL: url, Retired: true}, nil
+			},
+			expectedResponse:   []byte(`{"error":"unknown page: url"}`),
+			expectedStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			description:  "success: unknown page quarantined",
+			input:        `{"visitor_id": "id", "page_url": "url"}`,
+			unknownPages: service.QuarantineUnknownPages,
+			mockPageFunc: notFound,
+			mockQuarantineFunc: func(visit domain.Visit) error {
+				if visit.PageURL != "url" || visit.Visitor != "id" {
+					t.Errorf("got %v, expected the visit of id to url", visit)
+				}
+
+				return nil
+			},
+			expectedResponse:   []byte(``),
+			expectedStatusCode: http.StatusAccepted,
+		},
+		{
+			description:  "error: quarantine full",
+			input:        `{"visitor_id": "id", "page_url": "url"}`,
+			unknownPages: service.QuarantineUnknownPages,
+			mockPageFunc: notFound,
+			mockQuarantineFunc: func(visit domain.Visit) error {
+				return domain.ErrQuarantineFull
+			},
+			expectedResponse:   []byte(`{"error":"quarantine is full"}`),
+			expectedStatusCode: http.StatusServiceUnavailable,
+		},
+		{
+			description:  "error: registry failure",
+			input:        `{"visitor_id": "id", "page_url": "url"}`,
+			unknownPages: service.QuarantineUnknownPages,
+			mockPageFunc: func(url string) (domain.Page, error) {
+				return domain.Page{}, errors.New("failed to call registry")
+			},
+			expectedResponse:   []byte(`{"error":"failed to call registry"}`),
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			mockRepo := &mockVisitRepository{t: t, storeFunc: tc.mockRepoFunc}
+			registry := service.Registry{
+				Pages:        &mockPageRegistry{t: t, pageFunc: tc.mockPageFunc},
+				Quarantine:   &mockQuarantine{t: t, quarantineFunc: tc.mockQuarantineFunc},
+				UnknownPages: tc.unknownPages,
+			}
+
+			req, err := http.NewRequest(http.MethodPost, "url", strings.NewReader(tc.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h := buildUserNavigationHandler(service.NewVisitService(mockRepo, service.WithRegistry(registry)))
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			resp := rr.Result()
+
+			defer func(Body io.ReadCloser) {
+				_ = Body.Close()
+			}(resp.Body)
+
+			body, _ := io.ReadAll(resp.Body)
+
+			if !bytes.Equal(tc.expectedResponse, body) {
+				t.Errorf("got %v, expected %v", string(body), string(tc.expectedResponse))
+			}
+
+			if resp.StatusCode != tc.expectedStatusCode {
+				t.Errorf("got %v, expected %v", resp.StatusCode, tc.expectedStatusCode)
+			}
+		})
+	}
+}
+
+func TestBuildPageRegistryHandlers(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	type testCase struct {
+		description        string
+		method             string
+		input              string
+		body               string
+		registry           *mockPageRegistry
+		mockReleaseFunc    func(url string) ([]domain.Visit, error)
+		mockStoreBatchFunc func(visits []domain.Visit) error
+		expectedResponse   []byte
+		expectedStatusCode int
+	}
+
+	testCases := []testCase{
+		{
+			description: "success: register releases the quarantined visits",
+			method:      http.MethodPost,
+			body:        `{"page_url": "https://example.org/blog", "title": "Blog"}`,
+			registry: &mockPageRegistry{
+				registerFunc: func(page domain.Page) (domain.Page, error) {
+					page.Registered, page.Updated = now, now
+
+					return page, nil
+				},
+			},
+			mockReleaseFunc: func(url string) ([]domain.Visit, error) {
+				return []domain.Visit{{Visitor: "a", PageURL: url}, {Visitor: "b", PageURL: url}}, nil
+			},
+			mockStoreBatchFunc: func(visits []domain.Visit) error {
+				if len(visits) != 2 {
+					t.Errorf("got %v, expected 2 visits", len(visits))
+				}
+
+				return nil
+			},
+			expectedResponse:   []byte(`{"page_url":"https://example.org/blog","title":"Blog","retired":false,"registered_at":"2024-01-02T03:04:05Z","updated_at":"2024-01-02T03:04:05Z","released":2}`),
+			expectedStatusCode: http.StatusCreated,
+		},
+		{
+			description: "error: register an existing page",
+			method:      http.MethodPost,
+			body:        `{"page_url": "https://example.org/blog"}`,
+			registry: &mockPageRegistry{
+				registerFunc: func(page domain.Page) (domain.Page, error) {
+					return domain.Page{}, fmt.Errorf("%w: %s", domain.ErrPageExists, page.URL)
+				},
+			},
+			expectedResponse:   []byte(`{"error":"page already registered: https://example.org/blog"}`),
+			expectedStatusCode: http.StatusConflict,
+		},
+		{
+			description:        "error: register without a page url",
+			method:             http.MethodPost,
+			body:               `{"title": "Blog"}`,
+			registry:           &mockPageRegistry{},
+			expectedResponse:   []byte(`{"error":"missing request field: page url"}`),
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description: "success: update keeps quarantined visits of a retired page",
+			method:      http.MethodPut,
+			input:       `?pageUrl=https://example.org/blog`,
+			body:        `{"title": "Old blog", "retired": true}`,
+			registry: &mockPageRegistry{
+				updateFunc: func(page domain.Page) (domain.Page, error) {
+					page.Registered, page.Updated = now, now
+
+					return page, nil
+				},
+			},
+			expectedResponse:   []byte(`{"page_url":"https://example.org/blog","title":"Old blog","retired":true,"registered_at":"2024-01-02T03:04:05Z","updated_at":"2024-01-02T03:04:05Z"}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description: "error: update an unknown page",
+			method:      http.MethodPut,
+			input:       `?pageUrl=url`,
+			body:        `{"title": "Blog"}`,
+			registry: &mockPageRegistry{
+				updateFunc: func(page domain.Page) (domain.Page, error) {
+					return domain.Page{}, fmt.Errorf("%w: %s", domain.ErrPageNotFound, page.URL)
+				},
+			},
+			expectedResponse:   []byte(`{"error":"page not found: url"}`),
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			description: "success: retire",
+			method:      http.MethodDelete,
+			input:       `?pageUrl=https://example.org/blog`,
+			registry: &mockPageRegistry{
+				retireFunc: func(url string) (domain.Page, error) {
+					return domain.Page{URL: url, Retired: true, Registered: now, Updated: now}, nil
+				},
+			},
+			expectedResponse:   []byte(`{"page_url":"https://example.org/blog","retired":true,"registered_at":"2024-01-02T03:04:05Z","updated_at":"2024-01-02T03:04:05Z"}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description:        "error: retire without a page url",
+			method:             http.MethodDelete,
+			registry:           &mockPageRegistry{},
+			expectedResponse:   []byte(`{"error":"missing query param: pageUrl"}`),
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description: "success: list",
+			method:      http.MethodGet,
+			registry: &mockPageRegistry{
+				pagesFunc: func() ([]domain.Page, error) {
+					return []domain.Page{{URL: "a", Registered: now, Updated: now}}, nil
+				},
+			},
+			expectedResponse:   []byte(`{"pages":[{"page_url":"a","retired":false,"registered_at":"2024-01-02T03:04:05Z","updated_at":"2024-01-02T03:04:05Z"}]}`),
+			expectedStatusCode: http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			tc.registry.t = t
+			mockRepo := &mockVisitRepository{t: t, storeBatchFunc: tc.mockStoreBatchFunc}
+			registry := service.Registry{
+				Pages:        tc.registry,
+				Quarantine:   &mockQuarantine{t: t, releaseFunc: tc.mockReleaseFunc},
+				UnknownPages: service.QuarantineUnknownPages,
+			}
+
+			if tc.mockReleaseFunc == nil {
+				registry.Quarantine = &mockQuarantine{t: t, releaseFunc: func(url string) ([]domain.Visit, error) {
+					return nil, nil
+				}}
+			}
+
+			req, err := http.NewRequest(tc.method, "url"+tc.input, strings.NewReader(tc.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			visits := service.NewVisitService(mockRepo, service.WithRegistry(registry))
+			handlers := map[string]http.HandlerFunc{
+				http.MethodGet:    buildPageListHandler(visits),
+				http.MethodPost:   buildPageRegisterHandler(visits),
+				http.MethodPut:    buildPageUpdateHandler(visits),
+				http.MethodDelete: buildPageRetireHandler(visits),
+			}
+
+			rr := httptest.NewRecorder()
+			handlers[tc.method].ServeHTTP(rr, req)
+			resp := rr.Result()
+
+			defer func(Body io.ReadCloser) {
+				_ = Body.Close()
+			}(resp.Body)
+
+			body, _ := io.ReadAll(resp.Body)
+
+			if !bytes.Equal(tc.expectedResponse, body) {
+				t.Errorf("got %v, expected %v", string(body), string(tc.expectedResponse))
+			}
+
+			if resp.StatusCode != tc.expectedStatusCode {
+				t.Errorf("got %v, expected %v", resp.StatusCode, tc.expectedStatusCode)
+			}
+		})
+	}
+}
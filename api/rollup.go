@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"deus.ai-code-challenge/service"
+)
+
+// buildUniqueVisitorRollupHandler provides an http.Handler responsible for providing the unique number of visitors
+// across every page under a url prefix (e.g. /blog) or matching a glob pattern (e.g. /blog/*), a visitor that saw
+// several of the pages is counted once
+func buildUniqueVisitorRollupHandler(visits *service.VisitService) http.HandlerFunc {
+	prefixParamKey := "prefix"
+	patternParamKey := "pattern"
+
+	// estimated and error_bound are only present when the count is an approximation
+	type responseBody struct {
+		Pattern        string  `json:"pattern"`
+		Pages          uint64  `json:"pages"`
+		UniqueVisitors uint64  `json:"unique_visitors"`
+		Estimated      bool    `json:"estimated,omitempty"`
+		ErrorBound     float64 `json:"error_bound,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		prefix := query.Get(prefixParamKey)
+		pattern := query.Get(patternParamKey)
+
+		switch {
+		case prefix == "" && pattern == "":
+			writeError(w, newErrMissingParamPrefix(prefixParamKey+" or "+patternParamKey))
+
+			return
+		case prefix != "" && pattern != "":
+			writeError(w, newErrInvalidParam(prefixParamKey+" can't be combined with "+patternParamKey))
+
+			return
+		case prefix != "":
+			pattern = service.PrefixPattern(prefix)
+		}
+
+		rollup, err := visits.UniqueVisitorsMatching(r.Context(), pattern)
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		b, err := json.Marshal(responseBody{
+			Pattern:        pattern,
+			Pages:          rollup.Pages,
+			UniqueVisitors: rollup.UniqueVisitors.Count,
+			Estimated:      rollup.UniqueVisitors.Estimated,
+			ErrorBound:     rollup.UniqueVisitors.ErrorBound,
+		})
+		if err != nil {
+			writeError(w, newErrMarshallResponse())
+
+			return
+		}
+
+		_, _ = w.Write(b)
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/service"
+)
+
+func TestBuildUniqueVisitorRollupHandler(t *testing.T) {
+	type testCase struct {
+		description        string
+		input              string
+		mockRepoFunc       func(pattern string) (domain.PageRollup, error)
+		expectedResponse   []byte
+		expectedStatusCode int
+	}
+
+	testCases := []testCase{
+		{
+			description: "success: pattern",
+			input:       `?pattern=/blog/*`,
+			mockRepoFunc: func(pattern string) (domain.PageRollup, error) {
+				if pattern != "/blog/*" {
+					t.Errorf("pattern = %v, want %v", pattern, "/blog/*")
+				}
+
+				return domain.PageRollup{Pages: 2, UniqueVisitors: domain.UniqueVisitors{Count: 10}}, nil
+			},
+			expectedResponse:   []byte(`{"pattern":"/blog/*","pages":2,"unique_visitors":10}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description: "success: prefix is matched by whole segments and escaped",
+			input:       `?prefix=/blog*/`,
+			mockRepoFunc: func(pattern string) (domain.PageRollup, error) {
+				if pattern != `/blog\*/**` {
+					t.Errorf("pattern = %v, want %v", pattern, `/blog\*/**`)
+				}
+
+				return domain.PageRollup{Pages: 3, UniqueVisitors: domain.UniqueVisitors{Count: 1000, Estimated: true, ErrorBound: 0.01625}}, nil
+			},
+			expectedResponse:   []byte(`{"pattern":"/blog\\*/**","pages":3,"unique_visitors":1000,"estimated":true,"error_bound":0.01625}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description:        "error: no prefix nor pattern",
+			input:              ``,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"missing query param: prefix or pattern"}`),
+		},
+		{
+			description:        "error: prefix and pattern",
+			input:              `?prefix=/blog&pattern=/blog/*`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"invalid query param: prefix can't be combined with pattern"}`),
+		},
+		{
+			description: "error: invalid pattern",
+			input:       `?pattern=/blog/[`,
+			mockRepoFunc: func(pattern string) (domain.PageRollup, error) {
+				return domain.PageRollup{}, fmt.Errorf("%w: %s", domain.ErrInvalidPattern, pattern)
+			},
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"invalid pattern: /blog/["}`),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			mockRepo := &mockVisitRepository{
+				t:             t,
+				countMatching: tc.mockRepoFunc,
+			}
+
+			req, err := http.NewRequest(http.MethodGet, "url"+tc.input, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h := buildUniqueVisitorRollupHandler(service.NewVisitService(mockRepo))
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			resp := rr.Result()
+
+			defer func(Body io.ReadCloser) {
+				_ = Body.Close()
+			}(resp.Body)
+
+			body, _ := io.ReadAll(resp.Body)
+
+			if !bytes.Equal(tc.expectedResponse, body) {
+				t.Errorf("got %v, expected %v", string(body), string(tc.expectedResponse))
+			}
+
+			if resp.StatusCode != tc.expectedStatusCode {
+				t.Errorf("got %v, expected %v", resp.StatusCode, tc.expectedStatusCode)
+			}
+		})
+	}
+}
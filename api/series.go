@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"deus.ai-code-challenge/service"
+)
+
+// intervals maps the accepted values of the interval query param to their duration
+var intervals = map[string]time.Duration{
+	"hour": time.Hour,
+	"day":  24 * time.Hour,
+	"week": 7 * 24 * time.Hour,
+}
+
+// buildUniqueVisitorSeriesHandler provides an http.Handler responsible for providing the unique number of visitors
+// of a specific page per hour, day or week in [from, to). Periods without visits are included with a count of zero,
+// so that clients can plot the result directly. Hours older than -rollup-after are only kept per day, hourly series
+// reaching them get a 400
+func buildUniqueVisitorSeriesHandler(visits *service.VisitService) http.HandlerFunc {
+	queryParamKey := "pageUrl"
+	intervalParamKey := "interval"
+	fromParamKey := "from"
+	toParamKey := "to"
+
+	type bucket struct {
+		Start          time.Time `json:"start"`
+		UniqueVisitors uint64    `json:"unique_visitors"`
+		Estimated      bool      `json:"estimated,omitempty"`
+		ErrorBound     float64   `json:"error_bound,omitempty"`
+	}
+
+	type responseBody struct {
+		Interval string   `json:"interval"`
+		Buckets  []bucket `json:"buckets"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		pageURL := query.Get(queryParamKey)
+		if pageURL == "" {
+			writeError(w, newErrMissingParamPrefix(queryParamKey))
+
+			return
+		}
+
+		intervalName := query.Get(intervalParamKey)
+		if intervalName == "" {
+			intervalName = "day"
+		}
+
+		interval, found := intervals[intervalName]
+		if !found {
+			writeError(w, newErrInvalidParam(intervalParamKey))
+
+			return
+		}
+
+		if query.Get(fromParamKey) == "" {
+			writeError(w, newErrMissingParamPrefix(fromParamKey))
+
+			return
+		}
+
+		from, to, _, err := parseTimeRange(query, fromParamKey, toParamKey)
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		series, err := visits.UniqueVisitorsSeries(r.Context(), pageURL, interval, from, to)
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		response := responseBody{Interval: intervalName, Buckets: make([]bucket, 0, len(series))}
+		for _, b := range series {
+			response.Buckets = append(response.Buckets, bucket{
+				Start:          b.Start,
+				UniqueVisitors: b.UniqueVisitors.Count,
+				Estimated:      b.UniqueVisitors.Estimated,
+				ErrorBound:     b.UniqueVisitors.ErrorBound,
+			})
+		}
+
+		b, err := json.Marshal(response)
+		if err != nil {
+			writeError(w, newErrMarshallResponse())
+
+			return
+		}
+
+		_, _ = w.Write(b)
+	}
+}
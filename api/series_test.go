@@ -0,0 +1,136 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/service"
+)
+
+func TestBuildUniqueVisitorSeriesHandler(t *testing.T) {
+	type testCase struct {
+		description        string
+		input              string
+		mockRepoFunc       func(pageURL string, interval time.Duration, from, to time.Time) ([]domain.Bucket, error)
+		expectedResponse   []byte
+		expectedStatusCode int
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []testCase{
+		{
+			description: "success",
+			input:       `?pageUrl=url&interval=hour&from=2024-01-01T00:00:00Z&to=2024-01-01T02:00:00Z`,
+			mockRepoFunc: func(pageURL string, interval time.Duration, from, to time.Time) ([]domain.Bucket, error) {
+				if pageURL != "url" {
+					t.Errorf("pageURL = %v, want %v", pageURL, "url")
+				}
+				if interval != time.Hour {
+					t.Errorf("interval = %v, want %v", interval, time.Hour)
+				}
+				if !from.Equal(start) || !to.Equal(start.Add(2*time.Hour)) {
+					t.Errorf("range = [%v, %v), want [%v, %v)", from, to, start, start.Add(2*time.Hour))
+				}
+
+				return []domain.Bucket{
+					{Start: start, UniqueVisitors: domain.UniqueVisitors{Count: 2}},
+					{Start: start.Add(time.Hour), UniqueVisitors: domain.UniqueVisitors{}},
+				}, nil
+			},
+			expectedResponse:   []byte(`{"interval":"hour","buckets":[{"start":"2024-01-01T00:00:00Z","unique_visitors":2},{"start":"2024-01-01T01:00:00Z","unique_visitors":0}]}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description: "success: interval defaults to day",
+			input:       `?pageUrl=url&from=2024-01-01T00:00:00Z&to=2024-01-02T00:00:00Z`,
+			mockRepoFunc: func(pageURL string, interval time.Duration, from, to time.Time) ([]domain.Bucket, error) {
+				if interval != 24*time.Hour {
+					t.Errorf("interval = %v, want %v", interval, 24*time.Hour)
+				}
+
+				return []domain.Bucket{
+					{Start: start, UniqueVisitors: domain.UniqueVisitors{Count: 1000, Estimated: true, ErrorBound: 0.01}},
+				}, nil
+			},
+			expectedResponse:   []byte(`{"interval":"day","buckets":[{"start":"2024-01-01T00:00:00Z","unique_visitors":1000,"estimated":true,"error_bound":0.01}]}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description:        "error: no page url provided",
+			input:              `?from=2024-01-01T00:00:00Z`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"missing query param: pageUrl"}`),
+		},
+		{
+			description:        "error: no from provided",
+			input:              `?pageUrl=url`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"missing query param: from"}`),
+		},
+		{
+			description:        "error: invalid interval",
+			input:              `?pageUrl=url&interval=month&from=2024-01-01T00:00:00Z`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"invalid query param: interval"}`),
+		},
+		{
+			description: "error: too many buckets",
+			input:       `?pageUrl=url&interval=hour&from=2000-01-01T00:00:00Z`,
+			mockRepoFunc: func(pageURL string, interval time.Duration, from, to time.Time) ([]domain.Bucket, error) {
+				return nil, domain.ErrTooManyBuckets
+			},
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"series can't have more than 10000 buckets"}`),
+		},
+		{
+			description: "error: call to repository fails",
+			input:       `?pageUrl=url&from=2024-01-01T00:00:00Z`,
+			mockRepoFunc: func(pageURL string, interval time.Duration, from, to time.Time) ([]domain.Bucket, error) {
+				return nil, errors.New("failed to call repository")
+			},
+			expectedResponse:   []byte(`{"error":"failed to call repository"}`),
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			mockRepo := &mockVisitRepository{
+				t:                         t,
+				countUniqueVisitorsSeries: tc.mockRepoFunc,
+			}
+
+			req, err := http.NewRequest(http.MethodGet, "url"+tc.input, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h := buildUniqueVisitorSeriesHandler(service.NewVisitService(mockRepo))
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			resp := rr.Result()
+
+			defer func(Body io.ReadCloser) {
+				_ = Body.Close()
+			}(resp.Body)
+
+			body, _ := io.ReadAll(resp.Body)
+
+			if !bytes.Equal(tc.expectedResponse, body) {
+				t.Errorf("got %v, expected %v", string(body), string(tc.expectedResponse))
+			}
+
+			if resp.StatusCode != tc.expectedStatusCode {
+				t.Errorf("got %v, expected %v", resp.StatusCode, tc.expectedStatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/service"
+)
+
+const (
+	// maxLineSize bounds the size of a single line of a stream, longer lines are rejected
+	maxLineSize = 64 << 10
+	// maxSampleErrors bounds the number of errors reported back in the summary of a stream
+	maxSampleErrors = 10
+)
+
+// buildUserNavigationStreamHandler provides an http handler responsible for storing the visits of an NDJSON stream
+// (one event per line, following the same rules as a single visit). Lines are decoded as they arrive, without
+// buffering the whole body, and are stored in batches of at most service.MaxBatchSize: a batch is stored as soon as
+// it's full or no more data is available yet, so a slow stream doesn't hold events back.
+//
+// The response is an NDJSON stream as well: an acknowledgement after each batch is stored (when the connection allows
+// responding while the request is being read) and a final summary with a sample of the errors. A malformed line is
+// only reported, it doesn't stop the stream. Visits of pages that aren't registered are handled according to the
+// registry policy, quarantined ones are neither accepted nor rejected
+func buildUserNavigationStreamHandler(visits *service.VisitService) http.HandlerFunc {
+	type lineError struct {
+		Line  int    `json:"line"`
+		Error string `json:"error"`
+	}
+
+	// errors and error are only present in the final summary, error when the stream couldn't be fully processed
+	type summary struct {
+		Lines       int         `json:"lines"`
+		Accepted    int         `json:"accepted"`
+		Rejected    int         `json:"rejected"`
+		Quarantined int         `json:"quarantined,omitempty"`
+		Errors      []lineError `json:"errors,omitempty"`
+		Error       string      `json:"error,omitempty"`
+		Done        bool        `json:"done"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		contentType := r.Header.Get("Content-Type")
+		if contentType != "" {
+			mediaType, _, err := mime.ParseMediaType(contentType)
+			if err != nil || mediaType != "application/x-ndjson" {
+				writeError(w, newErrUnsupportedContentType(contentType))
+
+				return
+			}
+		}
+
+		defer func(Body io.ReadCloser) {
+			_ = Body.Close()
+		}(r.Body)
+
+		// acknowledgements are only sent if the response can be written while the request is still being read
+		rc := http.NewResponseController(w)
+		acknowledge := rc.EnableFullDuplex() == nil
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+
+		encoder := json.NewEncoder(w)
+		reader := bufio.NewReaderSize(r.Body, maxLineSize)
+
+		s := summary{}
+
+		// pending holds the visits waiting to be stored, lines the line number of each of them
+		pending := make([]domain.Visit, 0, service.MaxBatchSize)
+		lines := make([]int, 0, service.MaxBatchSize)
+
+		reject := func(line int, err error) {
+			s.Rejected++
+			if len(s.Errors) < maxSampleErrors {
+				s.Errors = append(s.Errors, lineError{Line: line, Error: err.Error()})
+			}
+		}
+
+		store := func() error {
+			if len(pending) == 0 {
+				return nil
+			}
+
+			results, err := visits.RecordVisits(r.Context(), pending)
+			if err != nil {
+				return err
+			}
+
+			for i, res := range results {
+				switch res.Outcome {
+				case service.Stored:
+					s.Accepted++
+				case service.Quarantined:
+					s.Quarantined++
+				default:
+					reject(lines[i], res.Err)
+				}
+			}
+
+			pending = pending[:0]
+			lines = lines[:0]
+
+			if acknowledge {
+				_ = encoder.Encode(summary{Lines: s.Lines, Accepted: s.Accepted, Rejected: s.Rejected, Quarantined: s.Quarantined})
+				_ = rc.Flush()
+			}
+
+			return nil
+		}
+
+		for {
+			line, err := readLine(reader)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+				s.Error = newErrUnmarshallRequest().Error()
+
+				break
+			}
+
+			s.Lines++
+
+			switch {
+			case err != nil:
+				reject(s.Lines, newErrLineTooLong(strconv.Itoa(maxLineSize)))
+			case len(line) == 0:
+				// blank lines are allowed (e.g. a trailing new line) and are not accounted for
+			default:
+				visit, err := decodeNavigationEvent(line, time.Now(), visits)
+				if err != nil {
+					reject(s.Lines, err)
+				} else {
+					pending = append(pending, visit)
+					lines = append(lines, s.Lines)
+				}
+			}
+
+			if len(pending) == service.MaxBatchSize || reader.Buffered() == 0 {
+				err = store()
+				if err != nil {
+					s.Error = err.Error()
+
+					break
+				}
+			}
+		}
+
+		if s.Error == "" {
+			err := store()
+			if err != nil {
+				s.Error = err.Error()
+			}
+		}
+
+		s.Done = true
+		_ = encoder.Encode(s)
+	}
+}
+
+// decodeNavigationEvent decodes and validates a single event, received is when the event was read. Invalid events are
+// reported as soon as they're read, instead of when their batch is stored
+func decodeNavigationEvent(line []byte, received time.Time, visits *service.VisitService) (domain.Visit, error) {
+	event := navigationEvent{}
+
+	err := json.Unmarshal(line, &event)
+	if err != nil {
+		return domain.Visit{}, newErrUnmarshallRequest()
+	}
+
+	visit := event.visit(received)
+
+	return visit, visits.ValidateVisit(visit)
+}
+
+// readLine reads the next line without its line break. Lines longer than the reader buffer are discarded and
+// reported with bufio.ErrBufferFull, the last line of the stream doesn't need to end with a line break
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		for errors.Is(err, bufio.ErrBufferFull) {
+			_, err = r.ReadSlice('\n')
+		}
+
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+
+		return nil, bufio.ErrBufferFull
+	}
+
+	if errors.Is(err, io.EOF) && len(line) > 0 {
+		err = nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSpace(line), nil
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/service"
+)
+
+func TestBuildUserNavigationStreamHandler(t *testing.T) {
+	type testCase struct {
+		description        string
+		input              string
+		contentType        string
+		mockRepoFunc       func(visits []domain.Visit) error
+		expectedResponse   []byte
+		expectedStatusCode int
+	}
+
+	testCases := []testCase{
+		{
+			description: "success",
+			input:       "{\"visitor_id\": \"id\", \"page_url\": \"url\"}\n{\"visitor_id\": \"id2\", \"page_url\": \"url\"}\n",
+			contentType: "application/x-ndjson",
+			mockRepoFunc: func(visits []domain.Visit) error {
+				if len(visits) != 2 {
+					t.Errorf("len(visits) = %v, want %v", len(visits), 2)
+				}
+
+				return nil
+			},
+			expectedResponse:   []byte(`{"lines":2,"accepted":2,"rejected":0,"done":true}` + "\n"),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description: "success: malformed lines don't stop the stream",
+			input: "{\"visitor_id\": \"id\", \"page_url\": \"url\"}\n" +
+				"{\"visitor_id\": \n" +
+				"\n" +
+				"{\"page_url\": \"url\"}\n" +
+				"{\"visitor_id\": \"id2\", \"page_url\": \"url\"}",
+			mockRepoFunc: func(visits []domain.Visit) error {
+				return nil
+			},
+			expectedResponse: []byte(`{"lines":5,"accepted":2,"rejected":2,"errors":[` +
+				`{"line":2,"error":"unable to read request body"},` +
+				`{"line":4,"error":"missing request field: visitor id"}],"done":true}` + "\n"),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description: "success: lines that are too long are rejected",
+			input:       strings.Repeat("a", maxLineSize+1) + "\n{\"visitor_id\": \"id\", \"page_url\": \"url\"}\n",
+			mockRepoFunc: func(visits []domain.Visit) error {
+				return nil
+			},
+			expectedResponse: []byte(`{"lines":2,"accepted":1,"rejected":1,"errors":[` +
+				`{"line":1,"error":"line can't be longer than 65536 bytes"}],"done":true}` + "\n"),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description: "success: the sample of errors is bounded",
+			input:       strings.Repeat("{}\n", maxSampleErrors+5),
+			expectedResponse: func() []byte {
+				var errs []string
+				for line := 1; line <= maxSampleErrors; line++ {
+					errs = append(errs, fmt.Sprintf(`{"line":%d,"error":"missing request field: visitor id"}`, line))
+				}
+
+				return []byte(`{"lines":15,"accepted":0,"rejected":15,"errors":[` + strings.Join(errs, ",") + `],"done":true}` + "\n")
+			}(),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description:        "success: empty stream",
+			expectedResponse:   []byte(`{"lines":0,"accepted":0,"rejected":0,"done":true}` + "\n"),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description: "error: call to repository fails",
+			input:       "{\"visitor_id\": \"id\", \"page_url\": \"url\"}\n{\"visitor_id\": \"id2\", \"page_url\": \"url\"}\n",
+			mockRepoFunc: func(visits []domain.Visit) error {
+				return errors.New("failed to call repository")
+			},
+			expectedResponse:   []byte(`{"lines":2,"accepted":0,"rejected":0,"error":"failed to call repository","done":true}` + "\n"),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description:        "error: unsupported content type",
+			input:              "{\"visitor_id\": \"id\", \"page_url\": \"url\"}\n",
+			contentType:        "text/csv",
+			expectedResponse:   []byte(`{"error":"unsupported content type: text/csv"}`),
+			expectedStatusCode: http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			mockRepo := &mockVisitRepository{
+				t:              t,
+				storeBatchFunc: tc.mockRepoFunc,
+			}
+
+			req, err := http.NewRequest(http.MethodPost, "url", strings.NewReader(tc.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+
+			h := buildUserNavigationStreamHandler(service.NewVisitService(mockRepo))
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			resp := rr.Result()
+
+			defer func(Body io.ReadCloser) {
+				_ = Body.Close()
+			}(resp.Body)
+
+			body, _ := io.ReadAll(resp.Body)
+
+			if !bytes.Equal(tc.expectedResponse, body) {
+				t.Errorf("got %v, expected %v", string(body), string(tc.expectedResponse))
+			}
+
+			if resp.StatusCode != tc.expectedStatusCode {
+				t.Errorf("got %v, expected %v", resp.StatusCode, tc.expectedStatusCode)
+			}
+		})
+	}
+}
+
+// TestUserNavigationStreamIsIncremental checks that events are stored and acknowledged while the stream is still open
+func TestUserNavigationStreamIsIncremental(t *testing.T) {
+	stored := make(chan []domain.Visit)
+
+	mockRepo := &mockVisitRepository{
+		t: t,
+		storeBatchFunc: func(visits []domain.Visit) error {
+			stored <- append([]domain.Visit(nil), visits...)
+
+			return nil
+		},
+	}
+
+	server := httptest.NewServer(buildUserNavigationStreamHandler(service.NewVisitService(mockRepo)))
+	defer server.Close()
+
+	pr, pw := io.Pipe()
+
+	responses := make(chan *http.Response)
+	go func() {
+		resp, err := http.Post(server.URL, "application/x-ndjson", pr)
+		if err != nil {
+			t.Error(err)
+			close(responses)
+
+			return
+		}
+
+		responses <- resp
+	}()
+
+	_, _ = io.WriteString(pw, "{\"visitor_id\": \"id\", \"page_url\": \"url\"}\n")
+
+	visits := <-stored
+	if len(visits) != 1 || visits[0].Visitor != "id" {
+		t.Errorf("got %v, expected the first event", visits)
+	}
+
+	resp, ok := <-responses
+	if !ok {
+		t.FailNow()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	lines := bufio.NewScanner(resp.Body)
+
+	if !lines.Scan() || lines.Text() != `{"lines":1,"accepted":1,"rejected":0,"done":false}` {
+		t.Errorf("got %v, expected the acknowledgement of the first event", lines.Text())
+	}
+
+	_, _ = io.WriteString(pw, "{\"visitor_id\": \"id2\", \"page_url\": \"url\"}\n")
+
+	visits = <-stored
+	if len(visits) != 1 || visits[0].Visitor != "id2" {
+		t.Errorf("got %v, expected the second event", visits)
+	}
+
+	_ = pw.Close()
+
+	for lines.Scan() {
+		if strings.Contains(lines.Text(), `"done":true`) && lines.Text() != `{"lines":2,"accepted":2,"rejected":0,"done":true}` {
+			t.Errorf("got %v, expected the summary of both events", lines.Text())
+		}
+	}
+}
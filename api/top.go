@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"deus.ai-code-challenge/service"
+)
+
+// buildTopPagesHandler provides an http.Handler responsible for providing the pages with the most unique visitors,
+// ranked by their count (ties by url). By default the all-time counts are ranked, with window only the visitors seen
+// within it (e.g. 15m) are
+func buildTopPagesHandler(visits *service.VisitService) http.HandlerFunc {
+	limitParamKey := "limit"
+	windowParamKey := "window"
+
+	// estimated and error_bound are only present when the count is an approximation
+	type page struct {
+		PageURL        string  `json:"page_url"`
+		UniqueVisitors uint64  `json:"unique_visitors"`
+		Estimated      bool    `json:"estimated,omitempty"`
+		ErrorBound     float64 `json:"error_bound,omitempty"`
+	}
+
+	type responseBody struct {
+		Pages []page `json:"pages"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		limit := service.DefaultTopPages
+		if query.Has(limitParamKey) {
+			var err error
+
+			limit, err = strconv.Atoi(query.Get(limitParamKey))
+			if err != nil {
+				writeError(w, newErrInvalidParam(limitParamKey))
+
+				return
+			}
+		}
+
+		var window time.Duration
+		if query.Has(windowParamKey) {
+			var err error
+
+			window, err = time.ParseDuration(query.Get(windowParamKey))
+			if err != nil || window <= 0 {
+				writeError(w, newErrInvalidParam(windowParamKey))
+
+				return
+			}
+		}
+
+		top, err := visits.TopPages(r.Context(), limit, window)
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		response := responseBody{Pages: make([]page, 0, len(top))}
+		for _, count := range top {
+			response.Pages = append(response.Pages, page{
+				PageURL:        count.PageURL,
+				UniqueVisitors: count.UniqueVisitors.Count,
+				Estimated:      count.UniqueVisitors.Estimated,
+				ErrorBound:     count.UniqueVisitors.ErrorBound,
+			})
+		}
+
+		b, err := json.Marshal(response)
+		if err != nil {
+			writeError(w, newErrMarshallResponse())
+
+			return
+		}
+
+		_, _ = w.Write(b)
+	}
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/service"
+)
+
+func TestBuildTopPagesHandler(t *testing.T) {
+	type testCase struct {
+		description        string
+		input              string
+		mockRepoFunc       func(limit int, window time.Duration) ([]domain.PageCount, error)
+		expectedResponse   []byte
+		expectedStatusCode int
+	}
+
+	testCases := []testCase{
+		{
+			description: "success: default limit, all-time counts",
+			input:       ``,
+			mockRepoFunc: func(limit int, window time.Duration) ([]domain.PageCount, error) {
+				if limit != service.DefaultTopPages || window != 0 {
+					t.Errorf("got %v and %v, expected %v and 0", limit, window, service.DefaultTopPages)
+				}
+
+				return []domain.PageCount{
+					{PageURL: "/blog", UniqueVisitors: domain.UniqueVisitors{Count: 3}},
+					{PageURL: "/about", UniqueVisitors: domain.UniqueVisitors{Count: 1000, Estimated: true, ErrorBound: 0.01625}},
+				}, nil
+			},
+			expectedResponse:   []byte(`{"pages":[{"page_url":"/blog","unique_visitors":3},{"page_url":"/about","unique_visitors":1000,"estimated":true,"error_bound":0.01625}]}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description: "success: limit and window",
+			input:       `?limit=2&window=15m`,
+			mockRepoFunc: func(limit int, window time.Duration) ([]domain.PageCount, error) {
+				if limit != 2 || window != 15*time.Minute {
+					t.Errorf("got %v and %v, expected 2 and 15m", limit, window)
+				}
+
+				return []domain.PageCount{}, nil
+			},
+			expectedResponse:   []byte(`{"pages":[]}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description:        "error: limit isn't a number",
+			input:              `?limit=ten`,
+			expectedResponse:   []byte(`{"error":"invalid query param: limit"}`),
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "error: limit out of bounds",
+			input:              `?limit=101`,
+			expectedResponse:   []byte(`{"error":"invalid limit: must be between 1 and 100"}`),
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "error: invalid window",
+			input:              `?window=-5m`,
+			expectedResponse:   []byte(`{"error":"invalid query param: window"}`),
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description: "error: window larger than the repository keeps track of",
+			input:       `?window=2h`,
+			mockRepoFunc: func(limit int, window time.Duration) ([]domain.PageCount, error) {
+				return nil, domain.ErrInvalidWindow
+			},
+			expectedResponse:   []byte(`{"error":"invalid window"}`),
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			mockRepo := &mockVisitRepository{
+				t:             t,
+				countTopPages: tc.mockRepoFunc,
+			}
+
+			req, err := http.NewRequest(http.MethodGet, "url"+tc.input, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h := buildTopPagesHandler(service.NewVisitService(mockRepo))
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			resp := rr.Result()
+
+			defer func(Body io.ReadCloser) {
+				_ = Body.Close()
+			}(resp.Body)
+
+			body, _ := io.ReadAll(resp.Body)
+
+			if !bytes.Equal(tc.expectedResponse, body) {
+				t.Errorf("got %v, expected %v", string(body), string(tc.expectedResponse))
+			}
+
+			if resp.StatusCode != tc.expectedStatusCode {
+				t.Errorf("got %v, expected %v", resp.StatusCode, tc.expectedStatusCode)
+			}
+		})
+	}
+}
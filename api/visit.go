@@ -5,19 +5,42 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"time"
 
 	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/service"
 )
 
-// buildUserNavigationHandler provides an http handler responsible for storing a new visit
-func buildUserNavigationHandler(repository domain.VisitRepository) http.HandlerFunc {
-	type requestBody struct {
-		VisitorId string `json:"visitor_id,omitempty"`
-		PageURL   string `json:"page_url,omitempty"`
+// navigationEvent is a visitor navigating to a page, as sent by clients
+type navigationEvent struct {
+	VisitorId string     `json:"visitor_id,omitempty"`
+	PageURL   string     `json:"page_url,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+}
+
+// visit converts the event into a visit, which happened when the request was received unless a timestamp is given.
+// The visit is validated by the service
+func (e navigationEvent) visit(received time.Time) domain.Visit {
+	visitedAt := received
+	if e.Timestamp != nil {
+		visitedAt = *e.Timestamp
+	}
+
+	return domain.Visit{
+		Visitor: e.VisitorId,
+		PageURL: e.PageURL,
+		Time:    visitedAt,
 	}
+}
 
+// buildUserNavigationHandler provides an http handler responsible for storing a new visit,
+// the visit happened when the request was received unless a timestamp is given. Visits of pages that aren't registered
+// are handled according to the registry policy, a quarantined visit is acknowledged with 202 Accepted
+func buildUserNavigationHandler(visits *service.VisitService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		i := &requestBody{}
+		received := time.Now()
+
+		i := &navigationEvent{}
 
 		err := json.NewDecoder(r.Body).Decode(&i)
 		if err != nil {
@@ -30,44 +53,33 @@ func buildUserNavigationHandler(repository domain.VisitRepository) http.HandlerF
 			_ = Body.Close()
 		}(r.Body)
 
-		if i.VisitorId == "" {
-			writeError(w, newErrMissingFieldPrefix("visitor id"))
-
-			return
-		}
-
-		if i.PageURL == "" {
-			writeError(w, newErrMissingFieldPrefix("page url"))
-
-			return
-		}
-
-		_, err = url.Parse(i.PageURL)
+		outcome, err := visits.RecordVisit(r.Context(), i.visit(received))
 		if err != nil {
-			writeError(w, newErrInvalidPageURL(i.PageURL))
+			writeError(w, err)
 
 			return
 		}
 
-		err = repository.Store(domain.Visit{
-			Visitor: i.VisitorId,
-			PageURL: i.PageURL,
-		})
-		if err != nil {
-			writeError(w, err)
-
-			return
+		if outcome == service.Quarantined {
+			w.WriteHeader(http.StatusAccepted)
 		}
 	}
 }
 
 // buildUniqueVisitorForPageHandler provides an http.Handler responsible for providing the unique number of visitor
-// for a specific page
-func buildUniqueVisitorForPageHandler(repository domain.VisitRepository) http.HandlerFunc {
+// for a specific page, optionally only counting the visits made in [from, to) or the visitors seen within the last
+// window (e.g. 15m)
+func buildUniqueVisitorForPageHandler(visits *service.VisitService) http.HandlerFunc {
 	queryParamKey := "pageUrl"
+	fromParamKey := "from"
+	toParamKey := "to"
+	windowParamKey := "window"
 
+	// estimated and error_bound are only present when the count is an approximation
 	type responseBody struct {
-		UniqueVisitors uint64 `json:"unique_visitors"`
+		UniqueVisitors uint64  `json:"unique_visitors"`
+		Estimated      bool    `json:"estimated,omitempty"`
+		ErrorBound     float64 `json:"error_bound,omitempty"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -78,21 +90,50 @@ func buildUniqueVisitorForPageHandler(repository domain.VisitRepository) http.Ha
 			return
 		}
 
-		_, err := url.Parse(pageURL)
+		from, to, ranged, err := parseTimeRange(r.URL.Query(), fromParamKey, toParamKey)
 		if err != nil {
-			writeError(w, newErrInvalidPageURL(pageURL))
+			writeError(w, err)
 
 			return
 		}
 
-		numberOfUniqueVisitors, err := repository.CountUniqueVisitors(pageURL)
+		var window time.Duration
+		if r.URL.Query().Has(windowParamKey) {
+			if ranged {
+				writeError(w, newErrInvalidParam(windowParamKey+" can't be combined with "+fromParamKey+" and "+toParamKey))
+
+				return
+			}
+
+			window, err = time.ParseDuration(r.URL.Query().Get(windowParamKey))
+			if err != nil {
+				writeError(w, newErrInvalidParam(windowParamKey))
+
+				return
+			}
+		}
+
+		var uniqueVisitors domain.UniqueVisitors
+		switch {
+		case ranged:
+			uniqueVisitors, err = visits.UniqueVisitorsBetween(r.Context(), pageURL, from, to)
+		case r.URL.Query().Has(windowParamKey):
+			uniqueVisitors, err = visits.RecentUniqueVisitors(r.Context(), pageURL, window)
+		default:
+			uniqueVisitors, err = visits.UniqueVisitors(r.Context(), pageURL)
+		}
+
 		if err != nil {
 			writeError(w, err)
 
 			return
 		}
 
-		b, err := json.Marshal(responseBody{UniqueVisitors: numberOfUniqueVisitors})
+		b, err := json.Marshal(responseBody{
+			UniqueVisitors: uniqueVisitors.Count,
+			Estimated:      uniqueVisitors.Estimated,
+			ErrorBound:     uniqueVisitors.ErrorBound,
+		})
 		if err != nil {
 			writeError(w, newErrMarshallResponse())
 
@@ -102,3 +143,38 @@ func buildUniqueVisitorForPageHandler(repository domain.VisitRepository) http.Ha
 		_, _ = w.Write(b)
 	}
 }
+
+// parseTimeRange reads the (RFC 3339) time range from the query params, reporting whether any of them was given.
+// A missing from means since the beginning and a missing to means until now
+func parseTimeRange(query url.Values, fromKey, toKey string) (time.Time, time.Time, bool, error) {
+	if !query.Has(fromKey) && !query.Has(toKey) {
+		return time.Time{}, time.Time{}, false, nil
+	}
+
+	from := time.Unix(0, 0)
+	to := time.Now()
+
+	if query.Has(fromKey) {
+		t, err := time.Parse(time.RFC3339, query.Get(fromKey))
+		if err != nil {
+			return time.Time{}, time.Time{}, false, newErrInvalidParam(fromKey)
+		}
+
+		from = t
+	}
+
+	if query.Has(toKey) {
+		t, err := time.Parse(time.RFC3339, query.Get(toKey))
+		if err != nil {
+			return time.Time{}, time.Time{}, false, newErrInvalidParam(toKey)
+		}
+
+		to = t
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, false, newErrInvalidParam(fromKey + " must be before " + toKey)
+	}
+
+	return from, to, true, nil
+}
@@ -2,23 +2,38 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/service"
 )
 
 type mockVisitRepository struct {
-	t                   *testing.T
-	storeFunc           func(domain.Visit) error
-	countUniqueVisitors func(pageURL string) (uint64, error)
+	t                          *testing.T
+	storeFunc                  func(domain.Visit) error
+	storeBatchFunc             func([]domain.Visit) error
+	countUniqueVisitors        func(pageURL string) (domain.UniqueVisitors, error)
+	countUniqueVisitorsBulk    func(pageURLs []string) (map[string]domain.UniqueVisitors, error)
+	countCombined              func(pageURLs []string, operator domain.SetOperator) (domain.UniqueVisitors, error)
+	countMatching              func(pattern string) (domain.PageRollup, error)
+	countUniqueVisitorsBetween func(pageURL string, from, to time.Time) (domain.UniqueVisitors, error)
+	countUniqueVisitorsSeries  func(pageURL string, interval time.Duration, from, to time.Time) ([]domain.Bucket, error)
+	countRecentUniqueVisitors  func(pageURL string, window time.Duration) (domain.UniqueVisitors, error)
+	countTopPages              func(limit int, window time.Duration) ([]domain.PageCount, error)
+	listPages                  func(query domain.PageQuery) ([]domain.PageCount, error)
+	listVisitorPages           func(visitor string) ([]domain.VisitedPage, error)
+	eraseVisitor               func(visitor string) (domain.ErasedPages, error)
+	renameVisitors             func(renames []domain.Rename) (domain.Count, error)
 }
 
-func (m *mockVisitRepository) Store(visit domain.Visit) error {
+func (m *mockVisitRepository) Store(ctx context.Context, visit domain.Visit) error {
 	if m.storeFunc != nil {
 		return m.storeFunc(visit)
 	}
@@ -27,25 +42,151 @@ func (m *mockVisitRepository) Store(visit domain.Visit) error {
 	return nil
 }
 
-func (m *mockVisitRepository) CountUniqueVisitors(pageURL string) (uint64, error) {
+func (m *mockVisitRepository) CountUniqueVisitors(ctx context.Context, pageURL string) (domain.UniqueVisitors, error) {
 	if m.countUniqueVisitors != nil {
 		return m.countUniqueVisitors(pageURL)
 	}
 
 	m.t.Fatal("mockVisitRepository CountUniqueVisitors is nil")
+	return domain.UniqueVisitors{}, nil
+}
+
+func (m *mockVisitRepository) CountUniqueVisitorsBetween(ctx context.Context, pageURL string, from, to time.Time) (domain.UniqueVisitors, error) {
+	if m.countUniqueVisitorsBetween != nil {
+		return m.countUniqueVisitorsBetween(pageURL, from, to)
+	}
+
+	m.t.Fatal("mockVisitRepository CountUniqueVisitorsBetween is nil")
+	return domain.UniqueVisitors{}, nil
+}
+
+func (m *mockVisitRepository) StoreBatch(ctx context.Context, visits []domain.Visit) error {
+	if m.storeBatchFunc != nil {
+		return m.storeBatchFunc(visits)
+	}
+
+	m.t.Fatal("mockVisitRepository storeBatchFunc is nil")
+	return nil
+}
+
+func (m *mockVisitRepository) CountUniqueVisitorsBulk(ctx context.Context, pageURLs []string) (map[string]domain.UniqueVisitors, error) {
+	if m.countUniqueVisitorsBulk != nil {
+		return m.countUniqueVisitorsBulk(pageURLs)
+	}
+
+	m.t.Fatal("mockVisitRepository CountUniqueVisitorsBulk is nil")
+	return nil, nil
+}
+
+func (m *mockVisitRepository) CountUniqueVisitorsCombined(ctx context.Context, pageURLs []string, operator domain.SetOperator) (domain.UniqueVisitors, error) {
+	if m.countCombined != nil {
+		return m.countCombined(pageURLs, operator)
+	}
+
+	m.t.Fatal("mockVisitRepository CountUniqueVisitorsCombined is nil")
+	return domain.UniqueVisitors{}, nil
+}
+
+func (m *mockVisitRepository) CountUniqueVisitorsMatching(ctx context.Context, pattern string) (domain.PageRollup, error) {
+	if m.countMatching != nil {
+		return m.countMatching(pattern)
+	}
+
+	m.t.Fatal("mockVisitRepository CountUniqueVisitorsMatching is nil")
+	return domain.PageRollup{}, nil
+}
+
+func (m *mockVisitRepository) CountRecentUniqueVisitors(ctx context.Context, pageURL string, window time.Duration) (domain.UniqueVisitors, error) {
+	if m.countRecentUniqueVisitors != nil {
+		return m.countRecentUniqueVisitors(pageURL, window)
+	}
+
+	m.t.Fatal("mockVisitRepository CountRecentUniqueVisitors is nil")
+	return domain.UniqueVisitors{}, nil
+}
+
+func (m *mockVisitRepository) CountTopPages(ctx context.Context, limit int, window time.Duration) ([]domain.PageCount, error) {
+	if m.countTopPages != nil {
+		return m.countTopPages(limit, window)
+	}
+
+	m.t.Fatal("mockVisitRepository CountTopPages is nil")
+	return nil, nil
+}
+
+func (m *mockVisitRepository) ListPages(ctx context.Context, query domain.PageQuery) ([]domain.PageCount, error) {
+	if m.listPages != nil {
+		return m.listPages(query)
+	}
+
+	m.t.Fatal("mockVisitRepository ListPages is nil")
+	return nil, nil
+}
+
+func (m *mockVisitRepository) ListVisitorPages(ctx context.Context, visitor string) ([]domain.VisitedPage, error) {
+	if m.listVisitorPages != nil {
+		return m.listVisitorPages(visitor)
+	}
+
+	m.t.Fatal("mockVisitRepository ListVisitorPages is nil")
+	return nil, nil
+}
+
+func (m *mockVisitRepository) EraseVisitor(ctx context.Context, visitor string) (domain.ErasedPages, error) {
+	if m.eraseVisitor != nil {
+		return m.eraseVisitor(visitor)
+	}
+
+	m.t.Fatal("mockVisitRepository EraseVisitor is nil")
+	return domain.ErasedPages{}, nil
+}
+
+func (m *mockVisitRepository) RenameVisitors(ctx context.Context, renames []domain.Rename) (domain.Count, error) {
+	if m.renameVisitors != nil {
+		return m.renameVisitors(renames)
+	}
+
+	m.t.Fatal("mockVisitRepository RenameVisitors is nil")
 	return 0, nil
 }
 
+func (m *mockVisitRepository) CountUniqueVisitorsSeries(ctx context.Context, pageURL string, interval time.Duration, from, to time.Time) ([]domain.Bucket, error) {
+	if m.countUniqueVisitorsSeries != nil {
+		return m.countUniqueVisitorsSeries(pageURL, interval, from, to)
+	}
+
+	m.t.Fatal("mockVisitRepository CountUniqueVisitorsSeries is nil")
+	return nil, nil
+}
+
 func TestBuildUserNavigationHandler(t *testing.T) {
 	type testCase struct {
 		description        string
 		input              string
+		policy             service.PageURLPolicy
 		mockRepoFunc       func(visit domain.Visit) error
 		expectedResponse   []byte
 		expectedStatusCode int
 	}
 
 	testCases := []testCase{
+		{
+			description: "success: strict policy",
+			input:       `{"visitor_id": "id", "page_url": "https://blog.example.org/post"}`,
+			policy:      service.PageURLPolicy{Strict: true, MaxLength: 64, AllowedHosts: []string{"*.example.org"}},
+			mockRepoFunc: func(visit domain.Visit) error {
+				return nil
+			},
+			expectedResponse:   []byte(``),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description:        "error: page url rejected by the strict policy",
+			input:              `{"visitor_id": "id", "page_url": "https://example.com/post"}`,
+			policy:             service.PageURLPolicy{Strict: true, AllowedHosts: []string{"example.org"}},
+			expectedResponse:   []byte(`{"error":"page url rejected: host not allowed: example.com"}`),
+			expectedStatusCode: http.StatusBadRequest,
+		},
 		{
 			description: "success",
 			input:       `{"visitor_id": "id", "page_url": "url"}`,
@@ -56,12 +197,35 @@ func TestBuildUserNavigationHandler(t *testing.T) {
 				if visit.Visitor != "id" {
 					t.Errorf("visit.Visitor = %v, want %v", visit.Visitor, "id")
 				}
+				if time.Since(visit.Time) > time.Minute {
+					t.Errorf("visit.Time = %v, want the time the request was received", visit.Time)
+				}
 
 				return nil
 			},
 			expectedResponse:   []byte(``),
 			expectedStatusCode: http.StatusOK,
 		},
+		{
+			description: "success: with timestamp",
+			input:       `{"visitor_id": "id", "page_url": "url", "timestamp": "2024-01-02T03:04:05Z"}`,
+			mockRepoFunc: func(visit domain.Visit) error {
+				expected := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+				if !visit.Time.Equal(expected) {
+					t.Errorf("visit.Time = %v, want %v", visit.Time, expected)
+				}
+
+				return nil
+			},
+			expectedResponse:   []byte(``),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description:        "error: invalid timestamp",
+			input:              `{"visitor_id": "id", "page_url": "url", "timestamp": "yesterday"}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"unable to read request body"}`),
+		},
 		{
 			description:        "error: no visitor id provided",
 			input:              `{"page_url": "url"}`,
@@ -95,6 +259,15 @@ func TestBuildUserNavigationHandler(t *testing.T) {
 			expectedResponse:   []byte(`{"error":"failed to call repository"}`),
 			expectedStatusCode: http.StatusInternalServerError,
 		},
+		{
+			description: "error: request deadline exceeded",
+			input:       `{"visitor_id": "id", "page_url": "url"}`,
+			mockRepoFunc: func(visit domain.Visit) error {
+				return context.DeadlineExceeded
+			},
+			expectedResponse:   []byte(`{"error":"context deadline exceeded"}`),
+			expectedStatusCode: http.StatusGatewayTimeout,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -109,7 +282,7 @@ func TestBuildUserNavigationHandler(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			h := buildUserNavigationHandler(mockRepo)
+			h := buildUserNavigationHandler(service.NewVisitService(mockRepo, service.WithPageURLPolicy(tc.policy)))
 
 			rr := httptest.NewRecorder()
 			h.ServeHTTP(rr, req)
@@ -134,27 +307,121 @@ func TestBuildUserNavigationHandler(t *testing.T) {
 
 func TestBuildUniqueVisitorForPageHandler(t *testing.T) {
 	type testCase struct {
-		description        string
-		input              string
-		mockRepoFunc       func(pageURL string) (uint64, error)
-		expectedResponse   []byte
-		expectedStatusCode int
+		description         string
+		input               string
+		policy              service.PageURLPolicy
+		mockRepoFunc        func(pageURL string) (domain.UniqueVisitors, error)
+		mockRepoBetweenFunc func(pageURL string, from, to time.Time) (domain.UniqueVisitors, error)
+		mockRepoRecentFunc  func(pageURL string, window time.Duration) (domain.UniqueVisitors, error)
+		expectedResponse    []byte
+		expectedStatusCode  int
 	}
 
 	testCases := []testCase{
 		{
 			description: "success",
 			input:       `?pageUrl=url`,
-			mockRepoFunc: func(pageURL string) (uint64, error) {
+			mockRepoFunc: func(pageURL string) (domain.UniqueVisitors, error) {
 				if pageURL != "url" {
 					t.Errorf("pageURL = %v, want %v", pageURL, "url")
 				}
 
-				return 10, nil
+				return domain.UniqueVisitors{Count: 10}, nil
 			},
 			expectedResponse:   []byte(`{"unique_visitors":10}`),
 			expectedStatusCode: http.StatusOK,
 		},
+		{
+			description:        "error: relative page url in strict mode",
+			input:              `?pageUrl=/blog`,
+			policy:             service.PageURLPolicy{Strict: true},
+			expectedResponse:   []byte(`{"error":"page url rejected: not an absolute http(s) url: /blog"}`),
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description: "success: estimated count",
+			input:       `?pageUrl=url`,
+			mockRepoFunc: func(pageURL string) (domain.UniqueVisitors, error) {
+				return domain.UniqueVisitors{Count: 1000, Estimated: true, ErrorBound: 0.01625}, nil
+			},
+			expectedResponse:   []byte(`{"unique_visitors":1000,"estimated":true,"error_bound":0.01625}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description: "success: time range",
+			input:       `?pageUrl=url&from=2024-01-01T00:00:00Z&to=2024-01-02T00:00:00Z`,
+			mockRepoBetweenFunc: func(pageURL string, from, to time.Time) (domain.UniqueVisitors, error) {
+				if !from.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+					t.Errorf("from = %v, want %v", from, "2024-01-01T00:00:00Z")
+				}
+				if !to.Equal(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)) {
+					t.Errorf("to = %v, want %v", to, "2024-01-02T00:00:00Z")
+				}
+
+				return domain.UniqueVisitors{Count: 4}, nil
+			},
+			expectedResponse:   []byte(`{"unique_visitors":4}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description: "success: only from",
+			input:       `?pageUrl=url&from=2024-01-01T00:00:00Z`,
+			mockRepoBetweenFunc: func(pageURL string, from, to time.Time) (domain.UniqueVisitors, error) {
+				if time.Since(to) > time.Minute {
+					t.Errorf("to = %v, want now", to)
+				}
+
+				return domain.UniqueVisitors{Count: 5}, nil
+			},
+			expectedResponse:   []byte(`{"unique_visitors":5}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description: "success: window",
+			input:       `?pageUrl=url&window=15m`,
+			mockRepoRecentFunc: func(pageURL string, window time.Duration) (domain.UniqueVisitors, error) {
+				if window != 15*time.Minute {
+					t.Errorf("window = %v, want %v", window, 15*time.Minute)
+				}
+
+				return domain.UniqueVisitors{Count: 3}, nil
+			},
+			expectedResponse:   []byte(`{"unique_visitors":3}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description:        "error: invalid window",
+			input:              `?pageUrl=url&window=15`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"invalid query param: window"}`),
+		},
+		{
+			description: "error: window larger than the repository keeps track of",
+			input:       `?pageUrl=url&window=2h`,
+			mockRepoRecentFunc: func(pageURL string, window time.Duration) (domain.UniqueVisitors, error) {
+				return domain.UniqueVisitors{}, domain.ErrInvalidWindow
+			},
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"invalid window"}`),
+		},
+		{
+			description:        "error: window with time range",
+			input:              `?pageUrl=url&window=15m&from=2024-01-01T00:00:00Z`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"invalid query param: window can't be combined with from and to"}`),
+		},
+		{
+			description:        "error: invalid from",
+			input:              `?pageUrl=url&from=yesterday`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"invalid query param: from"}`),
+		},
+		{
+			description:        "error: from after to",
+			input:              `?pageUrl=url&from=2024-01-02T00:00:00Z&to=2024-01-01T00:00:00Z`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedResponse:   []byte(`{"error":"invalid query param: from must be before to"}`),
+		},
 		{
 			description:        "error: no query param provided",
 			input:              ``,
@@ -170,12 +437,12 @@ func TestBuildUniqueVisitorForPageHandler(t *testing.T) {
 		{
 			description: "error: call to repository fails",
 			input:       `?pageUrl=url`,
-			mockRepoFunc: func(pageURL string) (uint64, error) {
+			mockRepoFunc: func(pageURL string) (domain.UniqueVisitors, error) {
 				if pageURL != "url" {
 					t.Errorf("pageURL = %v, want %v", pageURL, "url")
 				}
 
-				return 0, errors.New("failed to call repository")
+				return domain.UniqueVisitors{}, errors.New("failed to call repository")
 			},
 			expectedResponse:   []byte(`{"error":"failed to call repository"}`),
 			expectedStatusCode: http.StatusInternalServerError,
@@ -185,8 +452,10 @@ func TestBuildUniqueVisitorForPageHandler(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.description, func(t *testing.T) {
 			mockRepo := &mockVisitRepository{
-				t:                   t,
-				countUniqueVisitors: tc.mockRepoFunc,
+				t:                          t,
+				countUniqueVisitors:        tc.mockRepoFunc,
+				countUniqueVisitorsBetween: tc.mockRepoBetweenFunc,
+				countRecentUniqueVisitors:  tc.mockRepoRecentFunc,
 			}
 
 			req, err := http.NewRequest(http.MethodPost, "url"+tc.input, nil)
@@ -194,7 +463,7 @@ func TestBuildUniqueVisitorForPageHandler(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			h := buildUniqueVisitorForPageHandler(mockRepo)
+			h := buildUniqueVisitorForPageHandler(service.NewVisitService(mockRepo, service.WithPageURLPolicy(tc.policy)))
 
 			rr := httptest.NewRecorder()
 			h.ServeHTTP(rr, req)
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/service"
+)
+
+// buildVisitorPagesHandler provides an http.Handler responsible for providing the pages a visitor was seen in, in the
+// order they were first seen in, along with when they were first and last seen in each of them
+func buildVisitorPagesHandler(visits *service.VisitService) http.HandlerFunc {
+	pathValueKey := "id"
+
+	// first_seen and last_seen are only present when the visits had a time
+	type page struct {
+		PageURL   string     `json:"page_url"`
+		FirstSeen *time.Time `json:"first_seen,omitempty"`
+		LastSeen  *time.Time `json:"last_seen,omitempty"`
+	}
+
+	type responseBody struct {
+		VisitorID string `json:"visitor_id"`
+		Pages     []page `json:"pages"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		visitor := r.PathValue(pathValueKey)
+
+		visited, err := visits.VisitorPages(r.Context(), visitor)
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		response := responseBody{VisitorID: visitor, Pages: make([]page, 0, len(visited))}
+		for _, v := range visited {
+			p := page{PageURL: v.PageURL}
+			if !v.FirstSeen.IsZero() {
+				p.FirstSeen, p.LastSeen = &v.FirstSeen, &v.LastSeen
+			}
+
+			response.Pages = append(response.Pages, p)
+		}
+
+		b, err := json.Marshal(response)
+		if err != nil {
+			writeError(w, newErrMarshallResponse())
+
+			return
+		}
+
+		_, _ = w.Write(b)
+	}
+}
+
+// buildVisitorEraseHandler provides an http.Handler responsible for erasing a visitor from every page (e.g. a "forget
+// me" request). The caller recorded in the audit trail is taken from the X-Caller header, or is the client address.
+// Nothing authenticates either, so the caller is always recorded as unverified. The visitor id is echoed as given, the
+// pseudonym the audit trail records isn't exposed
+func buildVisitorEraseHandler(visits *service.VisitService) http.HandlerFunc {
+	pathValueKey := "id"
+	callerHeader := "X-Caller"
+
+	type responseBody struct {
+		VisitorID      string       `json:"visitor_id"`
+		Caller         string       `json:"caller"`
+		CallerVerified bool         `json:"caller_verified"`
+		ErasedAt       time.Time    `json:"erased_at"`
+		Pages          domain.Count `json:"pages"`
+		Retained       domain.Count `json:"retained"`
+		Quarantined    domain.Count `json:"quarantined"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller := domain.Caller{Name: r.Header.Get(callerHeader)}
+		if caller.Name == "" {
+			caller.Name = r.RemoteAddr
+		}
+
+		visitor := r.PathValue(pathValueKey)
+
+		erasure, err := visits.EraseVisitor(r.Context(), visitor, caller)
+		if err != nil {
+			writeError(w, err)
+
+			return
+		}
+
+		b, err := json.Marshal(responseBody{
+			VisitorID:      visitor,
+			Caller:         erasure.Caller.Name,
+			CallerVerified: erasure.Caller.Verified,
+			ErasedAt:       erasure.Time,
+			Pages:          erasure.Pages,
+			Retained:       erasure.Retained,
+			Quarantined:    erasure.Quarantined,
+		})
+		if err != nil {
+			writeError(w, newErrMarshallResponse())
+
+			return
+		}
+
+		_, _ = w.Write(b)
+	}
+}
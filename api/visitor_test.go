@@ -0,0 +1,280 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/service"
+)
+
+func TestBuildVisitorPagesHandler(t *testing.T) {
+	seen := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	type testCase struct {
+		description        string
+		visitor            string
+		mockRepoFunc       func(visitor string) ([]domain.VisitedPage, error)
+		expectedResponse   []byte
+		expectedStatusCode int
+	}
+
+	testCases := []testCase{
+		{
+			description: "success: pages with and without times",
+			visitor:     "id",
+			mockRepoFunc: func(visitor string) ([]domain.VisitedPage, error) {
+				if visitor != "id" {
+					t.Errorf("visitor = %v, want %v", visitor, "id")
+				}
+
+				return []domain.VisitedPage{
+					{PageURL: "/blog", FirstSeen: seen, LastSeen: seen.Add(time.Hour)},
+					{PageURL: "/legacy"},
+				}, nil
+			},
+			expectedResponse:   []byte(`{"visitor_id":"id","pages":[{"page_url":"/blog","first_seen":"2024-01-02T03:04:05Z","last_seen":"2024-01-02T04:04:05Z"},{"page_url":"/legacy"}]}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description: "success: unknown visitor",
+			visitor:     "unknown",
+			mockRepoFunc: func(visitor string) ([]domain.VisitedPage, error) {
+				return []domain.VisitedPage{}, nil
+			},
+			expectedResponse:   []byte(`{"visitor_id":"unknown","pages":[]}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description:        "error: no visitor id",
+			visitor:            "",
+			expectedResponse:   []byte(`{"error":"missing request field: visitor id"}`),
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description: "error: history turned off",
+			visitor:     "id",
+			mockRepoFunc: func(visitor string) ([]domain.VisitedPage, error) {
+				return nil, domain.ErrHistoryDisabled
+			},
+			expectedResponse:   []byte(`{"error":"visitor history is disabled"}`),
+			expectedStatusCode: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			mockRepo := &mockVisitRepository{
+				t:                t,
+				listVisitorPages: tc.mockRepoFunc,
+			}
+
+			req, err := http.NewRequest(http.MethodGet, "url", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.SetPathValue("id", tc.visitor)
+
+			h := buildVisitorPagesHandler(service.NewVisitService(mockRepo))
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			resp := rr.Result()
+
+			defer func(Body io.ReadCloser) {
+				_ = Body.Close()
+			}(resp.Body)
+
+			body, _ := io.ReadAll(resp.Body)
+
+			if !bytes.Equal(tc.expectedResponse, body) {
+				t.Errorf("got %v, expected %v", string(body), string(tc.expectedResponse))
+			}
+
+			if resp.StatusCode != tc.expectedStatusCode {
+				t.Errorf("got %v, expected %v", resp.StatusCode, tc.expectedStatusCode)
+			}
+		})
+	}
+}
+
+type mockErasureAudit struct {
+	t          *testing.T
+	recordFunc func(erasure domain.Erasure) error
+}
+
+func (m *mockErasureAudit) Record(erasure domain.Erasure) error {
+	if m.recordFunc != nil {
+		return m.recordFunc(erasure)
+	}
+
+	m.t.Fatal("mockErasureAudit recordFunc is nil")
+	return nil
+}
+
+// mockPseudonymizer prefixes visitor ids, with a single key
+type mockPseudonymizer struct{}
+
+func (mockPseudonymizer) Pseudonymize(visitor string) (string, []string) {
+	return "pseudonym-" + visitor, nil
+}
+
+func (mockPseudonymizer) Pseudonyms(visitor string) []string {
+	return []string{"pseudonym-" + visitor}
+}
+
+func TestBuildVisitorEraseHandler(t *testing.T) {
+	erasedAt := regexp.MustCompile(`"erased_at":"[^"]+"`)
+
+	type testCase struct {
+		description        string
+		visitor            string
+		caller             string
+		mockRepoFunc       func(visitor string) (domain.ErasedPages, error)
+		mockAuditFunc      func(erasure domain.Erasure) error
+		pseudonymized      bool
+		expectedResponse   []byte
+		expectedStatusCode int
+	}
+
+	testCases := []testCase{
+		{
+			description: "success: caller from the header",
+			visitor:     "id",
+			caller:      "legal",
+			mockRepoFunc: func(visitor string) (domain.ErasedPages, error) {
+				if visitor != "id" {
+					t.Errorf("visitor = %v, want %v", visitor, "id")
+				}
+
+				return domain.ErasedPages{Erased: 3, Retained: 1}, nil
+			},
+			mockAuditFunc: func(erasure domain.Erasure) error {
+				if erasure.Visitor != "id" || erasure.Caller != (domain.Caller{Name: "legal"}) || erasure.Pages != 3 || erasure.Retained != 1 {
+					t.Errorf("got %v, expected the erasure of id by an unverified legal from 3 pages, retained by 1", erasure)
+				}
+
+				return nil
+			},
+			expectedResponse:   []byte(`{"visitor_id":"id","caller":"legal","caller_verified":false,"erased_at":"<now>","pages":3,"retained":1,"quarantined":0}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description: "success: caller from the client address",
+			visitor:     "id",
+			mockRepoFunc: func(visitor string) (domain.ErasedPages, error) {
+				return domain.ErasedPages{}, nil
+			},
+			mockAuditFunc: func(erasure domain.Erasure) error {
+				return nil
+			},
+			expectedResponse:   []byte(`{"visitor_id":"id","caller":"192.0.2.1:1234","caller_verified":false,"erased_at":"<now>","pages":0,"retained":0,"quarantined":0}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description: "success: pseudonymized, the id is echoed as given",
+			visitor:     "id",
+			mockRepoFunc: func(visitor string) (domain.ErasedPages, error) {
+				if visitor != "pseudonym-id" {
+					t.Errorf("visitor = %v, want %v", visitor, "pseudonym-id")
+				}
+
+				return domain.ErasedPages{Erased: 1}, nil
+			},
+			mockAuditFunc: func(erasure domain.Erasure) error {
+				if erasure.Visitor != "pseudonym-id" {
+					t.Errorf("got %v, expected the pseudonym to be recorded", erasure.Visitor)
+				}
+
+				return nil
+			},
+			pseudonymized:      true,
+			expectedResponse:   []byte(`{"visitor_id":"id","caller":"192.0.2.1:1234","caller_verified":false,"erased_at":"<now>","pages":1,"retained":0,"quarantined":0}`),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description:        "error: no visitor id",
+			visitor:            "",
+			expectedResponse:   []byte(`{"error":"missing request field: visitor id"}`),
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description: "error: repository failure",
+			visitor:     "id",
+			mockRepoFunc: func(visitor string) (domain.ErasedPages, error) {
+				return domain.ErasedPages{}, errors.New("disk full")
+			},
+			expectedResponse:   []byte(`{"error":"disk full"}`),
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+		{
+			description: "error: audit failure",
+			visitor:     "id",
+			mockRepoFunc: func(visitor string) (domain.ErasedPages, error) {
+				return domain.ErasedPages{Erased: 1}, nil
+			},
+			mockAuditFunc: func(erasure domain.Erasure) error {
+				return errors.New("disk full")
+			},
+			expectedResponse:   []byte(`{"error":"disk full"}`),
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			mockRepo := &mockVisitRepository{
+				t:            t,
+				eraseVisitor: tc.mockRepoFunc,
+			}
+
+			opts := []service.Option{service.WithErasureAudit(&mockErasureAudit{t: t, recordFunc: tc.mockAuditFunc})}
+			if tc.pseudonymized {
+				opts = append(opts, service.WithPseudonymizer(mockPseudonymizer{}))
+			}
+
+			visits := service.NewVisitService(mockRepo, opts...)
+
+			req, err := http.NewRequest(http.MethodDelete, "url", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.SetPathValue("id", tc.visitor)
+			req.RemoteAddr = "192.0.2.1:1234"
+			if tc.caller != "" {
+				req.Header.Set("X-Caller", tc.caller)
+			}
+
+			h := buildVisitorEraseHandler(visits)
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			resp := rr.Result()
+
+			defer func(Body io.ReadCloser) {
+				_ = Body.Close()
+			}(resp.Body)
+
+			body, _ := io.ReadAll(resp.Body)
+
+			// erasures happen now, the time is only checked to be there
+			body = erasedAt.ReplaceAll(body, []byte(`"erased_at":"<now>"`))
+
+			if !bytes.Equal(tc.expectedResponse, body) {
+				t.Errorf("got %v, expected %v", string(body), string(tc.expectedResponse))
+			}
+
+			if resp.StatusCode != tc.expectedStatusCode {
+				t.Errorf("got %v, expected %v", resp.StatusCode, tc.expectedStatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package domain
+
+import (
+	"time"
+)
+
+// Erasure is a visitor erased on request (e.g. a "forget me" request): Caller is who asked for it, Pages the number of
+// pages the visitor was removed from, Retained the number of pages that may still count it (see ErasedPages) and
+// Quarantined the number of its visits held in quarantine that were dropped
+type Erasure struct {
+	Visitor     string
+	Caller      Caller
+	Time        time.Time
+	Pages       Count
+	Retained    Count
+	Quarantined Count
+}
+
+// Caller is who asked for an erasure. Name is only Verified if the transport authenticated it, otherwise it's whatever
+// the caller claimed to be (or where the request came from) and the audit trail must be read as such
+type Caller struct {
+	Name     string
+	Verified bool
+}
+
+// ErasedPages is what erasing a visitor from the pages did: Erased is the number of pages it was removed from and
+// Retained the number of pages that may still count it, because they count their visitors with a sketch (which doesn't
+// hold visitor ids, so it can't forget one)
+type ErasedPages struct {
+	Erased   Count
+	Retained Count
+}
+
+// ErasureAudit is responsible for keeping track of every erasure, so that it can be shown when and by whom a visitor
+// was erased
+type ErasureAudit interface {
+	// Record appends the erasure to the audit trail
+	Record(erasure Erasure) error
+}
+
+// ErrAuditUnavailable is returned when a visitor can't be erased because erasures can't be audited
+var ErrAuditUnavailable = &Error{Kind: KindUnavailable, Msg: "erasure audit trail is not configured"}
@@ -0,0 +1,59 @@
+package domain
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrorKind classifies the errors of the domain, so that each transport can report them its own way (e.g. an http
+// status code or a gRPC code) without knowing every error
+type ErrorKind int
+
+const (
+	// KindInternal is an unexpected failure (e.g. the storage failed), it's the kind of any error that isn't an Error
+	KindInternal ErrorKind = iota
+	// KindInvalid is an input that is malformed or breaks a rule (e.g. a missing visitor id)
+	KindInvalid
+	// KindNotFound is a reference to something that doesn't exist (e.g. a page that isn't registered)
+	KindNotFound
+	// KindConflict is a change that clashes with the current state (e.g. registering a page twice)
+	KindConflict
+	// KindRefused is a valid input refused by a business rule (e.g. a visit to an unknown page)
+	KindRefused
+	// KindTooLarge is an input beyond the limits (e.g. too many visits at once)
+	KindTooLarge
+	// KindUnavailable is a request that can't be handled right now, but may be later
+	KindUnavailable
+	// KindTimeout is a request that couldn't be handled before its deadline
+	KindTimeout
+)
+
+// Error is an error of the domain. The errors defined by the domain are Errors, so they can be matched with errors.Is
+// and classified with KindOf even when wrapped with more details (e.g. fmt.Errorf("%w: %s", ErrPageNotFound, url))
+type Error struct {
+	Kind ErrorKind
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+// KindOf returns the kind of the Error that err is or wraps, KindInternal if there's none. Context errors are
+// classified too: a deadline exceeded is a KindTimeout and a cancellation (e.g. the server shutting down) a
+// KindUnavailable
+func KindOf(err error) ErrorKind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return KindTimeout
+	case errors.Is(err, context.Canceled):
+		return KindUnavailable
+	default:
+		return KindInternal
+	}
+}
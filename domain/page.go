@@ -0,0 +1,52 @@
+package domain
+
+import (
+	"time"
+)
+
+// Page is a page we've published, only visits to registered pages that are not retired are expected
+type Page struct {
+	URL        PageURL
+	Title      string
+	Retired    bool
+	Registered time.Time
+	Updated    time.Time
+}
+
+// PageRegistry is responsible for managing the pages we've published
+type PageRegistry interface {
+	// Register adds a new page, registering a page that already exists (even if retired) fails with ErrPageExists
+	Register(page Page) (Page, error)
+	// Page returns the page registered with the url, or ErrPageNotFound
+	Page(url PageURL) (Page, error)
+	// Pages lists every registered page (including the retired ones) sorted by url
+	Pages() ([]Page, error)
+	// Update changes the title and retired flag of an existing page, or fails with ErrPageNotFound
+	Update(page Page) (Page, error)
+	// Retire marks an existing page as retired, or fails with ErrPageNotFound
+	Retire(url PageURL) (Page, error)
+}
+
+// VisitQuarantine holds the visits to pages that aren't registered, until the page is registered (or they're dropped)
+type VisitQuarantine interface {
+	// Quarantine holds the visit, or fails with ErrQuarantineFull
+	Quarantine(visit Visit) error
+	// Release removes and returns the visits held for the page
+	Release(url PageURL) ([]Visit, error)
+	// Quarantined returns the number of visits held per page
+	Quarantined() (map[PageURL]Count, error)
+	// Forget drops the visits held of the visitor, returning how many there were
+	Forget(visitor string) (Count, error)
+}
+
+// ErrPageNotFound is returned when a page isn't registered
+var ErrPageNotFound = &Error{Kind: KindNotFound, Msg: "page not found"}
+
+// ErrPageExists is returned when registering a page that is already registered
+var ErrPageExists = &Error{Kind: KindConflict, Msg: "page already registered"}
+
+// ErrUnknownPage is returned when a visit is for a page that isn't registered (or is retired)
+var ErrUnknownPage = &Error{Kind: KindRefused, Msg: "unknown page"}
+
+// ErrQuarantineFull is returned when a visit can't be quarantined because too many are already held
+var ErrQuarantineFull = &Error{Kind: KindUnavailable, Msg: "quarantine is full"}
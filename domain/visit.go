@@ -1,19 +1,269 @@
 // Package domain defines the "visit" concept and the interface required by the domain/features to manage "visits".
 package domain
 
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"time"
+)
+
+// Visit represents a visitor navigating to a page, Time is when the visit happened
 type Visit struct {
 	Visitor string
 	PageURL string
+	Time    time.Time
 }
 
 type PageURL = string
 type Count = uint64
 
+// UniqueVisitors is the number of unique visitors of a page, which may be an estimate instead of an exact count
+// (e.g. when visitors are accounted for with a probabilistic sketch to save memory)
+//   - Estimated is false when Count is exact
+//   - ErrorBound is the relative standard error of an estimated Count (e.g. 0.01 means the count is usually within 1%)
+type UniqueVisitors struct {
+	Count      Count
+	Estimated  bool
+	ErrorBound float64
+}
+
 // VisitRepository is responsible for managing data related to user navigation according to the requirements provided
 //
 // Even though Store and CountUniqueVisitors can't fail when working with in-memory data structures, an error was added to the return
 // so that we can better account for future changes (e.g. using redis instead of storing everything in memory so that there's no data lost when services are shutdown)
 type VisitRepository interface {
-	Store(visit Visit) error
-	CountUniqueVisitors(url PageURL) (Count, error)
+	Store(ctx context.Context, visit Visit) error
+	CountUniqueVisitors(ctx context.Context, url PageURL) (UniqueVisitors, error)
+}
+
+// The features beyond storing and counting visits are optional, each is an interface of its own that extends
+// VisitRepository (see BucketedVisitRepository too), so that repositories (and the decorators wrapping them) only
+// implement the ones they support. Callers check for the feature they need, reporting ErrUnsupported without it
+
+// BatchVisitRepository extends VisitRepository with storing several visits in a single call, so implementations can
+// amortize their cost (locks, writes...)
+type BatchVisitRepository interface {
+	VisitRepository
+	StoreBatch(ctx context.Context, visits []Visit) error
+}
+
+// BulkVisitRepository extends VisitRepository with counting the unique visitors of several pages at once, the counts are
+// a consistent snapshot
+type BulkVisitRepository interface {
+	VisitRepository
+	CountUniqueVisitorsBulk(ctx context.Context, urls []PageURL) (map[PageURL]UniqueVisitors, error)
+}
+
+// CombinedVisitRepository extends VisitRepository with counting the unique visitors of the set that results from
+// combining the visitors of several pages with the operator (e.g. the visitors that saw every page)
+type CombinedVisitRepository interface {
+	VisitRepository
+	CountUniqueVisitorsCombined(ctx context.Context, urls []PageURL, operator SetOperator) (UniqueVisitors, error)
+}
+
+// RollupVisitRepository extends VisitRepository with counting the unique visitors of every page whose url matches the
+// glob pattern (e.g. /blog/*), visitors that saw several of them are counted once
+type RollupVisitRepository interface {
+	VisitRepository
+	CountUniqueVisitorsMatching(ctx context.Context, pattern string) (PageRollup, error)
+}
+
+// RangedVisitRepository extends VisitRepository with counting the unique visitors of a page whose visits happened in
+// [from, to)
+type RangedVisitRepository interface {
+	VisitRepository
+	CountUniqueVisitorsBetween(ctx context.Context, url PageURL, from, to time.Time) (UniqueVisitors, error)
+}
+
+// RecentVisitRepository extends VisitRepository with counting the unique visitors of a page seen within the last window
+// (e.g. 15 minutes)
+type RecentVisitRepository interface {
+	VisitRepository
+	CountRecentUniqueVisitors(ctx context.Context, url PageURL, window time.Duration) (UniqueVisitors, error)
+}
+
+// RankedVisitRepository extends VisitRepository with the pages with the most unique visitors: CountTopPages returns
+// limit of them, ranked by their count. A window of 0 ranks the all-time counts, otherwise the visitors seen within the
+// last window are ranked
+type RankedVisitRepository interface {
+	VisitRepository
+	CountTopPages(ctx context.Context, limit int, window time.Duration) ([]PageCount, error)
+}
+
+// ListedVisitRepository extends VisitRepository with listing the pages: ListPages lists up to query.Limit pages whose
+// url starts with query.Prefix along with their count, in the order of the query and starting after query.After
+type ListedVisitRepository interface {
+	VisitRepository
+	ListPages(ctx context.Context, query PageQuery) ([]PageCount, error)
+}
+
+// the visitor was seen in along with when, in the order they were first seen in (see ByFirstSeen)
+// the visitor was seen in along with when, in the order they were first seen in
+type HistoryVisitRepository interface {
+	VisitRepository
+	ListVisitorPages(ctx context.Context, visitor string) ([]VisitedPage, error)
+}
+
+// ErasableVisitRepository extends VisitRepository with erasing visitors: EraseVisitor removes the visitor from every
+// page, so it's no longer counted, and returns the number of pages it was removed from along with the ones that may
+// still count it. Durable implementations must persist the erasure, so the visitor doesn't come back on restart
+type ErasableVisitRepository interface {
+	VisitRepository
+	EraseVisitor(ctx context.Context, visitor string) (ErasedPages, error)
+}
+
+// RenamableVisitRepository extends VisitRepository with renaming visitors: RenameVisitors moves every visit of each
+// visitor to its new id (e.g. a new pseudonym), so it's counted once under the new id, and returns the number of pages
+// they were moved in. Renames of visitors that aren't held change nothing. Durable implementations must persist the moves
+type RenamableVisitRepository interface {
+	VisitRepository
+	RenameVisitors(ctx context.Context, renames []Rename) (Count, error)
+}
+
+// Rename moves a visitor from one id to another
+type Rename struct {
+	From string
+	To   string
+}
+
+// ErrUnsupported is returned when a feature isn't provided by the repository in use
+var ErrUnsupported = &Error{Kind: KindUnavailable, Msg: "not supported by the repository"}
+
+// ErrFutureVisit is returned when the time of a visit is too far in the future to be trusted
+var ErrFutureVisit = &Error{Kind: KindInvalid, Msg: "visit time is in the future"}
+
+// ErrRolledUpRange is returned when a range splits a period of time that's no longer kept at the resolution asked for
+// (e.g. an hour of a day whose hourly counts were rolled up into a daily one)
+var ErrRolledUpRange = &Error{Kind: KindInvalid, Msg: "range is finer than the data kept"}
+
+// ErrInvalidWindow is returned when a rolling window is not positive or is larger than the repository keeps track of
+var ErrInvalidWindow = &Error{Kind: KindInvalid, Msg: "invalid window"}
+
+// SetOperator defines how the visitors of several pages are combined
+type SetOperator string
+
+const (
+	// Union holds the visitors that saw any of the pages
+	Union SetOperator = "union"
+	// Intersection holds the visitors that saw every page
+	Intersection SetOperator = "intersection"
+	// Difference holds the visitors that saw the first page but none of the others
+	Difference SetOperator = "difference"
+)
+
+// PageURLNormalizer canonicalizes page urls, so that the different urls of a page are accounted for together
+type PageURLNormalizer interface {
+	Normalize(url PageURL) (PageURL, error)
+}
+
+// VisitorPseudonymizer replaces visitor ids with pseudonyms (e.g. keyed hashes), so raw visitor ids aren't kept
+type VisitorPseudonymizer interface {
+	// Pseudonymize returns the pseudonym of the visitor and its pseudonyms under the keys used before, which it may
+	// still be held under
+	Pseudonymize(visitor string) (current string, previous []string)
+	// Pseudonyms returns the pseudonyms of the visitor under every key, including the ones no longer recognized
+	Pseudonyms(visitor string) []string
+}
+
+// PageCount is the number of unique visitors of a page
+type PageCount struct {
+	PageURL        PageURL
+	UniqueVisitors UniqueVisitors
+}
+
+// VisitedPage is a page a visitor was seen in, FirstSeen and LastSeen are zero when none of the visits had a time
+type VisitedPage struct {
+	PageURL   PageURL
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// ByFirstSeen orders pages by when they were first seen (pages seen without a time last), ties by url. It's the order
+// pages are listed in for a visitor, see HistoryVisitRepository
+func ByFirstSeen(a, b VisitedPage) int {
+	switch {
+	case a.FirstSeen.IsZero() != b.FirstSeen.IsZero():
+		if a.FirstSeen.IsZero() {
+			return 1
+		}
+
+		return -1
+	case !a.FirstSeen.Equal(b.FirstSeen):
+		return a.FirstSeen.Compare(b.FirstSeen)
+	default:
+		return cmp.Compare(a.PageURL, b.PageURL)
+	}
+}
+
+// PageOrder defines how pages are listed
+type PageOrder string
+
+const (
+	// OrderByURL lists the pages by url, in ascending order
+	OrderByURL PageOrder = "url"
+	// OrderByCount lists the pages from the most visited, pages with the same count by url
+	OrderByCount PageOrder = "count"
+)
+
+// PageQuery selects the pages listed, a page at a time: After is the last page of the previous one (nil for the first),
+// so that pages added between calls don't shift the ones not listed yet
+type PageQuery struct {
+	Prefix string
+	Order  PageOrder
+	After  *PageCount
+	Limit  int
+}
+
+// PageRollup is the number of unique visitors across a group of pages (e.g. every page under /blog)
+type PageRollup struct {
+	Pages          Count
+	UniqueVisitors UniqueVisitors
+}
+
+// ErrInvalidPageURL is returned when a page url can't be parsed
+var ErrInvalidPageURL = &Error{Kind: KindInvalid, Msg: "invalid page url"}
+
+// ErrRejectedPageURL is returned when a page url is valid but not accepted (e.g. its host isn't allowed)
+var ErrRejectedPageURL = &Error{Kind: KindInvalid, Msg: "page url rejected"}
+
+// ErrMissingField is returned when a required field (e.g. the visitor id) is empty
+var ErrMissingField = &Error{Kind: KindInvalid, Msg: "missing request field"}
+
+// ErrInvalidPattern is returned when a page url pattern is malformed
+var ErrInvalidPattern = &Error{Kind: KindInvalid, Msg: "invalid pattern"}
+
+// ErrInvalidLimit is returned when the number of items asked for is out of bounds
+var ErrInvalidLimit = &Error{Kind: KindInvalid, Msg: "invalid limit"}
+
+// ErrInvalidCursor is returned when a cursor can't be decoded or doesn't belong to the query it's used with
+var ErrInvalidCursor = &Error{Kind: KindInvalid, Msg: "invalid cursor"}
+
+// ErrInvalidOrder is returned when pages can't be listed in the order asked for
+var ErrInvalidOrder = &Error{Kind: KindInvalid, Msg: "invalid order"}
+
+// ErrHistoryDisabled is returned when the pages of a visitor are asked for but the visitor history isn't kept
+var ErrHistoryDisabled = &Error{Kind: KindUnavailable, Msg: "visitor history is disabled"}
+
+// ErrInvalidCombination is returned when the visitors of the pages can't be combined (e.g. unknown operator)
+var ErrInvalidCombination = &Error{Kind: KindInvalid, Msg: "invalid combination"}
+
+// MaxSeriesBuckets bounds the number of buckets a single series can have
+const MaxSeriesBuckets = 10_000
+
+// ErrTooManyBuckets is returned when a series would have more than MaxSeriesBuckets buckets
+var ErrTooManyBuckets = &Error{Kind: KindInvalid, Msg: fmt.Sprintf("series can't have more than %d buckets", MaxSeriesBuckets)}
+
+// Bucket is the number of unique visitors of a page in the period of time that starts at Start
+type Bucket struct {
+	Start          time.Time
+	UniqueVisitors UniqueVisitors
+}
+
+// BucketedVisitRepository extends VisitRepository with unique visitor counts grouped by period of time (e.g. per day),
+// periods without visits are included with a count of zero
+type BucketedVisitRepository interface {
+	VisitRepository
+	// CountUniqueVisitorsSeries splits [from, to) in periods of the given interval and counts the unique visitors of the page in each of them
+	CountUniqueVisitorsSeries(ctx context.Context, url PageURL, interval time.Duration, from, to time.Time) ([]Bucket, error)
 }
@@ -0,0 +1,24 @@
+// Package deadline is responsible for bounding how long a request can take
+package deadline
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// WrapDeadline wraps the handler so that the context of every request is done after timeout, calls that honor it
+// (e.g. a repository waiting for its lock) give up instead of keeping the client waiting. A timeout of 0 means no
+// deadline, the request is still bounded by the client disconnecting or the server shutting down
+func WrapDeadline(handler http.Handler, timeout time.Duration) http.Handler {
+	if timeout <= 0 {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -6,31 +6,109 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"deus.ai-code-challenge/api"
+	"deus.ai-code-challenge/domain"
 	"deus.ai-code-challenge/infrastructure"
+	"deus.ai-code-challenge/infrastructure/deadline"
+	"deus.ai-code-challenge/normalize"
+	"deus.ai-code-challenge/pseudonym"
 	"deus.ai-code-challenge/repository"
+	"deus.ai-code-challenge/service"
 )
 
+// config holds the values passed to the program through flags
+type config struct {
+	port          int
+	dataDir       string
+	fsync         string
+	fsyncInterval time.Duration
+
+	snapshotInterval  time.Duration
+	snapshotRetention int
+
+	counting        string
+	hllPrecision    uint
+	hybridThreshold uint64
+
+	rollupAfter    time.Duration
+	maxWindow      time.Duration
+	visitorHistory bool
+
+	urlRules string
+
+	strictURLs   bool
+	maxURLLength int
+	allowedHosts string
+
+	unknownPages   string
+	maxQuarantined int
+
+	requestTimeout time.Duration
+
+	visitorKeys string
+
+	retainVisits      time.Duration
+	retainPages       time.Duration
+	retentionInterval time.Duration
+	retentionBatch    int
+
+	memoryBudget int64
+	spillDir     string
+
+	shards int
+}
+
 func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	port := flag.Int("port", 8080, "port to listen on")
+	cfg := config{}
+	flag.IntVar(&cfg.port, "port", 8080, "port to listen on")
+	flag.StringVar(&cfg.dataDir, "data-dir", "", "directory where visits are persisted, visits are kept in memory only if empty")
+	flag.StringVar(&cfg.fsync, "fsync", "interval", "when the visit log is flushed to disk: always, interval or never")
+	flag.DurationVar(&cfg.fsyncInterval, "fsync-interval", time.Second, "how often the visit log is flushed to disk when -fsync=interval")
+	flag.DurationVar(&cfg.snapshotInterval, "snapshot-interval", 10*time.Minute, "how often persisted visits are snapshotted, 0 disables snapshots")
+	flag.IntVar(&cfg.snapshotRetention, "snapshot-retention", 2, "number of snapshots kept on disk")
+	flag.StringVar(&cfg.counting, "counting", "exact", "how unique visitors are counted: exact, approximate (HyperLogLog) or hybrid (exact until -hybrid-threshold)")
+	flag.UintVar(&cfg.hllPrecision, "hll-precision", repository.DefaultPrecision, "precision of the HyperLogLog sketches, between 4 and 18")
+	flag.Uint64Var(&cfg.hybridThreshold, "hybrid-threshold", repository.DefaultThreshold, "unique visitors above which a page switches to a HyperLogLog sketch when -counting=hybrid")
+	flag.DurationVar(&cfg.rollupAfter, "rollup-after", repository.DefaultRollupAfter, "how long visits are kept in hourly buckets before being rolled up into daily ones")
+	flag.DurationVar(&cfg.maxWindow, "max-window", repository.DefaultMaxWindow, "largest rolling window unique visitors can be counted in, recent visitors are kept in memory for that long")
+	flag.BoolVar(&cfg.visitorHistory, "visitor-history", false, "keep the pages each visitor was seen in, so visitors can be looked up (every visitor id is kept in memory, even when counting approximately)")
+	flag.StringVar(&cfg.urlRules, "url-rules", "", "JSON file with the rules used to canonicalize page urls, urls are kept as received if empty")
+	flag.BoolVar(&cfg.strictURLs, "strict-urls", false, "only accept absolute http(s) page urls of at most -max-url-length characters and, if -allowed-hosts is set, from those hosts")
+	flag.IntVar(&cfg.maxURLLength, "max-url-length", service.DefaultMaxPageURLLength, "longest page url accepted when -strict-urls is set, 0 means no limit")
+	flag.StringVar(&cfg.allowedHosts, "allowed-hosts", "", "comma separated hosts page urls are accepted from when -strict-urls is set (*.example.org matches its subdomains), any host if empty")
+	flag.StringVar(&cfg.unknownPages, "unknown-pages", "accept", "what happens to visits of pages that aren't registered: accept, reject or quarantine (held until the page is registered)")
+	flag.IntVar(&cfg.maxQuarantined, "max-quarantined", repository.DefaultMaxQuarantined, "number of visits held at most when -unknown-pages=quarantine")
+	flag.DurationVar(&cfg.requestTimeout, "request-timeout", 10*time.Second, "how long a request can take before it's given up with a 504, streamed visits aren't bounded, 0 means no deadline")
+	flag.StringVar(&cfg.visitorKeys, "visitor-keys", "", "JSON file with the keys visitor ids are pseudonymized with (HMAC) before being stored, ids are kept as received if empty")
+	flag.DurationVar(&cfg.retainVisits, "retain-visits", 0, "visits older than this are dropped (e.g. 9490h for about 13 months), 0 keeps them forever")
+	flag.DurationVar(&cfg.retainPages, "retain-pages", 0, "pages without visits for this long are dropped (e.g. 2160h for 90 days), 0 keeps them forever")
+	flag.DurationVar(&cfg.retentionInterval, "retention-interval", time.Hour, "how often -retain-visits and -retain-pages are enforced")
+	flag.IntVar(&cfg.retentionBatch, "retention-batch", repository.DefaultRetentionBatch, "pages (or visitors) expired per hold of the repository lock, bounding how long writes wait for the retention")
+	flag.Int64Var(&cfg.memoryBudget, "memory-budget", 0, "bytes the pages and recent visitors can take in memory (estimated), the least recently touched pages are spilled to disk above it, 0 means no limit (can't be combined with -visitor-history)")
+	flag.StringVar(&cfg.spillDir, "spill-dir", "", "directory pages over -memory-budget are spilled to, -data-dir (or the temporary directory) if empty")
+	flag.IntVar(&cfg.shards, "shards", 1, "number of independently locked shards pages are spread over when visits are kept in memory only (no -data-dir), -memory-budget is split between them")
 	flag.Parse()
 
 	started := make(chan struct{})
 	go func() {
 		<-started
-		log.Printf("deus.ai server starting on port %d", *port)
+		log.Printf("deus.ai server starting on port %d", cfg.port)
 	}()
 
-	err := start(ctx, stop, *port, started)
+	err := start(ctx, stop, cfg, started)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -38,14 +116,333 @@ func main() {
 
 // start registers the handlers (wrapped with logging) in a ServeMux
 // and calls infrastructure.Run to run the http Server
-func start(ctx context.Context, stop func(), port int, started chan<- struct{}) error {
-	repo := repository.NewVisitsInMemoryRepository()
+func start(ctx context.Context, stop func(), cfg config, started chan<- struct{}) error {
+	normalizer, err := newNormalizer(cfg)
+	if err != nil {
+		return err
+	}
+
+	repo, closeRepo, err := newRepository(cfg)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		err := closeRepo()
+		if err != nil {
+			log.Printf("unable to close repository: %v", err)
+		}
+	}()
+
+	// the janitor is stopped before the repository is closed
+	if cfg.retainVisits > 0 || cfg.retainPages > 0 {
+		stopJanitor, err := startJanitor(ctx, cfg, repo)
+		if err != nil {
+			return err
+		}
+
+		defer stopJanitor()
+	}
+
+	// page urls are only canonicalized when rules are given, otherwise they are kept as received
+	if cfg.urlRules != "" {
+		repo = normalize.NewRepository(repo, normalizer)
+	}
+
+	registry, closeRegistry, err := newRegistry(cfg, normalizer)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		err := closeRegistry()
+		if err != nil {
+			log.Printf("unable to close quarantine: %v", err)
+		}
+	}()
+
+	audit, err := newErasureAudit(cfg)
+	if err != nil {
+		return err
+	}
+
+	opts := []service.Option{
+		service.WithNormalizer(normalizer),
+		service.WithPageURLPolicy(pageURLPolicy(cfg)),
+		service.WithRegistry(registry),
+		service.WithErasureAudit(audit),
+	}
+
+	// visitor ids are only pseudonymized when keys are given, otherwise they are kept as received
+	if cfg.visitorKeys != "" {
+		pseudonyms, err := newPseudonymizer(cfg)
+		if err != nil {
+			return err
+		}
+
+		opts = append(opts, service.WithPseudonymizer(pseudonyms))
+	}
 
 	mux := http.NewServeMux()
 
-	for url, handler := range api.Handlers(repo) {
-		mux.Handle(url, infrastructure.Wrap(handler))
+	visits := service.NewVisitService(repo, opts...)
+
+	for url, handler := range api.Handlers(visits) {
+		mux.Handle(url, infrastructure.Wrap(deadline.WrapDeadline(handler, requestTimeout(cfg, url))))
+	}
+
+	// the expvar metrics (e.g. what the retention removed)
+	mux.Handle("GET /debug/vars", infrastructure.Wrap(expvar.Handler()))
+
+	return infrastructure.Run(ctx, stop, cfg.port, mux, started)
+}
+
+// startJanitor enforces the retention flags on the repository in the background and publishes what it removes with
+// expvar, the returned function stops it
+func startJanitor(ctx context.Context, cfg config, repo domain.VisitRepository) (func(), error) {
+	expirer, ok := repo.(repository.Expirer)
+	if !ok {
+		return nil, fmt.Errorf("the repository doesn't support retention")
+	}
+
+	if cfg.retentionInterval <= 0 {
+		return nil, fmt.Errorf("retention interval must be positive")
+	}
+
+	janitor := repository.NewJanitor(expirer, repository.Retention{
+		Visits: cfg.retainVisits,
+		Pages:  cfg.retainPages,
+		Batch:  cfg.retentionBatch,
+	}, cfg.retentionInterval)
+
+	if expvar.Get("retention") == nil {
+		expvar.Publish("retention", janitor.Metrics())
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		janitor.Run(ctx)
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}, nil
+}
+
+// requestTimeout is the deadline of the requests to the url, streams are long-lived by design so they're only bounded by
+// the client disconnecting or the server shutting down
+func requestTimeout(cfg config, url string) time.Duration {
+	if strings.HasSuffix(url, "/stream") {
+		return 0
+	}
+
+	return cfg.requestTimeout
+}
+
+// newNormalizer builds the page url normalizer from the rules file, without one urls are left untouched
+func newNormalizer(cfg config) (*normalize.Normalizer, error) {
+	rules := normalize.Rules{}
+
+	if cfg.urlRules != "" {
+		var err error
+
+		rules, err = normalize.LoadRules(cfg.urlRules)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return normalize.New(rules)
+}
+
+// newPseudonymizer builds the visitor id pseudonymizer from the keys file. Keys can only be rotated (more than one key
+// given) if every page counts exactly and the visitor history is kept: a rotation moves each visitor to its new
+// pseudonym, a sketch can't forget the previous one (the visitor would be counted twice) and the history is how the
+// pages of a visitor are found
+func newPseudonymizer(cfg config) (*pseudonym.Pseudonymizer, error) {
+	keys, err := pseudonym.LoadConfig(cfg.visitorKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys.Keys) > 1 {
+		if cfg.counting != "" && cfg.counting != "exact" {
+			return nil, fmt.Errorf("visitor keys can't be rotated when counting is %s, only when it's exact", cfg.counting)
+		}
+
+		if !cfg.visitorHistory {
+			return nil, fmt.Errorf("visitor keys can't be rotated without the visitor history")
+		}
+	}
+
+	return pseudonym.New(keys)
+}
+
+// pageURLPolicy builds the policy page urls are validated with, any url that can be parsed is accepted unless
+// -strict-urls is set
+func pageURLPolicy(cfg config) service.PageURLPolicy {
+	if !cfg.strictURLs {
+		return service.PageURLPolicy{}
+	}
+
+	var hosts []string
+	for _, host := range strings.Split(cfg.allowedHosts, ",") {
+		host = strings.TrimSpace(host)
+		if host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+
+	return service.PageURLPolicy{Strict: true, MaxLength: cfg.maxURLLength, AllowedHosts: hosts}
+}
+
+// newRegistry builds the page registry and the quarantine (file backed if a data directory is given, in-memory
+// otherwise), page urls are canonicalized with the same rules as the visits. The default (zero value) config accepts
+// visits of every page. The returned function releases the resources held by the quarantine
+func newRegistry(cfg config, normalizer *normalize.Normalizer) (service.Registry, func() error, error) {
+	unknownPages := service.AcceptUnknownPages
+	if cfg.unknownPages != "" {
+		var err error
+
+		unknownPages, err = service.ParseUnknownPagePolicy(cfg.unknownPages)
+		if err != nil {
+			return service.Registry{}, nil, err
+		}
+	}
+
+	maxQuarantined := repository.DefaultMaxQuarantined
+	if cfg.maxQuarantined > 0 {
+		maxQuarantined = cfg.maxQuarantined
+	}
+
+	var pages domain.PageRegistry = repository.NewInMemoryPageRegistry()
+	var quarantine domain.VisitQuarantine = repository.NewInMemoryQuarantine(maxQuarantined)
+
+	closeQuarantine := func() error { return nil }
+
+	if cfg.dataDir != "" {
+		var err error
+
+		pages, err = repository.NewFilePageRegistry(cfg.dataDir)
+		if err != nil {
+			return service.Registry{}, nil, err
+		}
+
+		file, err := repository.NewFileQuarantine(cfg.dataDir, maxQuarantined)
+		if err != nil {
+			return service.Registry{}, nil, err
+		}
+
+		quarantine, closeQuarantine = file, file.Close
+	}
+
+	if cfg.urlRules != "" {
+		pages = normalize.NewPageRegistry(pages, normalizer)
+		quarantine = normalize.NewQuarantine(quarantine, normalizer)
+	}
+
+	return service.Registry{Pages: pages, Quarantine: quarantine, UnknownPages: unknownPages}, closeQuarantine, nil
+}
+
+// newErasureAudit builds the audit trail of the erased visitors, file backed if a data directory is given (erasures
+// must be kept as long as the visits are) and in-memory otherwise
+func newErasureAudit(cfg config) (domain.ErasureAudit, error) {
+	if cfg.dataDir == "" {
+		return repository.NewInMemoryErasureAudit(), nil
+	}
+
+	return repository.NewFileErasureAudit(cfg.dataDir)
+}
+
+// newRepository builds the repository selected by the flags: file backed if a data directory is given,
+// in-memory otherwise. The returned function releases the resources held by the repository
+func newRepository(cfg config) (domain.VisitRepository, func() error, error) {
+	counting, err := countingOption(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opts := []repository.Option{counting, repository.WithVisitorHistory(cfg.visitorHistory)}
+
+	// zero values keep the repository defaults
+	if cfg.hybridThreshold > 0 {
+		opts = append(opts, repository.WithHybridThreshold(cfg.hybridThreshold))
+	}
+
+	if cfg.rollupAfter > 0 {
+		opts = append(opts, repository.WithRollupAfter(cfg.rollupAfter))
+	}
+
+	if cfg.maxWindow > 0 {
+		opts = append(opts, repository.WithMaxWindow(cfg.maxWindow))
+	}
+
+	if cfg.memoryBudget > 0 {
+		// the history holds every visitor id and can't be spilled, the budget wouldn't hold with it
+		if cfg.visitorHistory {
+			return nil, nil, fmt.Errorf("-memory-budget can't be combined with -visitor-history")
+		}
+
+		spillDir := cfg.spillDir
+		if spillDir == "" {
+			spillDir = cfg.dataDir
+		}
+
+		opts = append(opts, repository.WithMemoryBudget(cfg.memoryBudget, spillDir))
+	}
+
+	if cfg.dataDir == "" && cfg.shards > 1 {
+		repo := repository.NewShardedVisitRepository(cfg.shards, opts...)
+
+		return repo, repo.Close, nil
+	}
+
+	if cfg.dataDir == "" {
+		repo := repository.NewVisitsInMemoryRepository(opts...)
+
+		return repo, repo.Close, nil
+	}
+
+	if cfg.shards > 1 {
+		return nil, nil, fmt.Errorf("-shards only applies when visits are kept in memory only, without -data-dir")
+	}
+
+	policy, err := repository.ParseSyncPolicy(cfg.fsync)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opts = append(opts,
+		repository.WithSyncPolicy(policy, cfg.fsyncInterval),
+		repository.WithSnapshots(cfg.snapshotInterval, cfg.snapshotRetention),
+	)
+
+	repo, err := repository.NewFileVisitRepository(cfg.dataDir, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return repo, repo.Close, nil
+}
+
+// countingOption validates the counting flags, the default (zero value) config counts exactly
+func countingOption(cfg config) (repository.Option, error) {
+	if cfg.counting == "" {
+		return repository.WithCounting(repository.CountExact, repository.DefaultPrecision), nil
+	}
+
+	mode, err := repository.ParseCountingMode(cfg.counting)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.hllPrecision < repository.MinPrecision || cfg.hllPrecision > repository.MaxPrecision {
+		return nil, fmt.Errorf("hll precision must be between %d and %d", repository.MinPrecision, repository.MaxPrecision)
 	}
 
-	return infrastructure.Run(ctx, stop, port, mux, started)
+	return repository.WithCounting(mode, uint8(cfg.hllPrecision)), nil
 }
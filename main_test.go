@@ -10,6 +10,8 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+
+	"deus.ai-code-challenge/repository"
 )
 
 func TestStart(t *testing.T) {
@@ -81,6 +83,42 @@ func TestStart(t *testing.T) {
 				},
 			},
 		},
+		{
+			description: "user-navigation with timestamps -> unique-visitors in time ranges",
+			reqs: []req{
+				{
+					method:       http.MethodPost,
+					url:          "/api/v1/user-navigation",
+					body:         `{"visitor_id": "id", "page_url": "url", "timestamp": "2024-01-01T10:15:00Z"}`,
+					expectedCode: http.StatusOK,
+				},
+				{
+					method:       http.MethodPost,
+					url:          "/api/v1/user-navigation",
+					body:         `{"visitor_id": "id2", "page_url": "url", "timestamp": "2024-01-01T11:15:00Z"}`,
+					expectedCode: http.StatusOK,
+				},
+				{
+					method: http.MethodGet,
+					url: ParseQuery("/api/v1/unique-visitors", map[string]string{
+						"pageUrl": "url",
+						"from":    "2024-01-01T10:00:00Z",
+						"to":      "2024-01-01T11:00:00Z",
+					}),
+					expectedCode: http.StatusOK,
+					expectedBody: `{"unique_visitors":1}`,
+				},
+				{
+					method: http.MethodGet,
+					url: ParseQuery("/api/v1/unique-visitors", map[string]string{
+						"pageUrl": "url",
+						"from":    "2024-01-01T00:00:00Z",
+					}),
+					expectedCode: http.StatusOK,
+					expectedBody: `{"unique_visitors":2}`,
+				},
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -95,7 +133,7 @@ func TestStart(t *testing.T) {
 
 			started := make(chan struct{})
 			go func() {
-				err := start(ctx, stop, port, started)
+				err := start(ctx, stop, config{port: port}, started)
 
 				if !errors.Is(tc.err, err) {
 					t.Errorf("got %v, expected %v", err, tc.err)
@@ -125,6 +163,84 @@ func TestStart(t *testing.T) {
 	}
 }
 
+func TestNewPseudonymizer(t *testing.T) {
+	// the example rotates from one key to another
+	rotated := "docs/visitor-keys.example.json"
+
+	type testCase struct {
+		description string
+		cfg         config
+		valid       bool
+	}
+
+	testCases := []testCase{
+		{
+			description: "exact counting with the history",
+			cfg:         config{visitorKeys: rotated, counting: "exact", visitorHistory: true},
+			valid:       true,
+		},
+		{
+			description: "approximate counting",
+			cfg:         config{visitorKeys: rotated, counting: "approximate", visitorHistory: true},
+		},
+		{
+			description: "hybrid counting",
+			cfg:         config{visitorKeys: rotated, counting: "hybrid", visitorHistory: true},
+		},
+		{
+			description: "without the history",
+			cfg:         config{visitorKeys: rotated, counting: "exact"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			_, err := newPseudonymizer(tc.cfg)
+			if (err == nil) != tc.valid {
+				t.Errorf("got %v, expected valid to be %v", err, tc.valid)
+			}
+		})
+	}
+}
+
+func TestNewRepository(t *testing.T) {
+	type testCase struct {
+		description string
+		cfg         config
+		valid       bool
+	}
+
+	testCases := []testCase{
+		{
+			description: "memory budget",
+			cfg:         config{counting: "exact", hllPrecision: repository.DefaultPrecision, memoryBudget: 1 << 20, spillDir: t.TempDir()},
+			valid:       true,
+		},
+		{
+			description: "visitor history",
+			cfg:         config{counting: "exact", hllPrecision: repository.DefaultPrecision, visitorHistory: true},
+			valid:       true,
+		},
+		{
+			description: "memory budget with the visitor history",
+			cfg:         config{counting: "exact", hllPrecision: repository.DefaultPrecision, memoryBudget: 1 << 20, spillDir: t.TempDir(), visitorHistory: true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			_, closeRepo, err := newRepository(tc.cfg)
+			if (err == nil) != tc.valid {
+				t.Errorf("got %v, expected valid to be %v", err, tc.valid)
+			}
+
+			if closeRepo != nil {
+				_ = closeRepo()
+			}
+		})
+	}
+}
+
 func GetFreePort() (int, error) {
 	addr, err := net.ResolveTCPAddr("tcp", "localhost:0")
 	if err == nil {
@@ -0,0 +1,207 @@
+// Package normalize is responsible for canonicalizing page urls before they are stored or queried, so that urls that
+// point to the same page (e.g. different host casing or tracking params) are accounted for as a single page.
+package normalize
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// Rules defines how page urls are canonicalized, the zero value leaves urls untouched
+//   - LowercaseHost lowercases the host (hosts are case-insensitive, paths are not)
+//   - DropDefaultPort drops :80 from http urls and :443 from https urls
+//   - DropFragment drops the fragment (#section), it's never sent to servers anyway
+//   - Scheme, when set (e.g. https), replaces the scheme of http and https urls
+//   - TrailingSlash is either empty (kept as is), strip or add
+//   - StripParams lists the query params to drop, a trailing * matches by prefix (e.g. utm_*)
+//   - KeepParams, when set, lists the only query params that are kept (same syntax as StripParams)
+//   - SortParams sorts the query params by name (the order of repeated params is kept)
+type Rules struct {
+	LowercaseHost   bool     `json:"lowercase_host"`
+	DropDefaultPort bool     `json:"drop_default_port"`
+	DropFragment    bool     `json:"drop_fragment"`
+	Scheme          string   `json:"scheme"`
+	TrailingSlash   string   `json:"trailing_slash"`
+	StripParams     []string `json:"strip_params"`
+	KeepParams      []string `json:"keep_params"`
+	SortParams      bool     `json:"sort_params"`
+}
+
+const (
+	trailingSlashStrip = "strip"
+	trailingSlashAdd   = "add"
+)
+
+func (r Rules) isZero() bool {
+	return !r.LowercaseHost && !r.DropDefaultPort && !r.DropFragment && r.Scheme == "" && r.TrailingSlash == "" &&
+		len(r.StripParams) == 0 && len(r.KeepParams) == 0 && !r.SortParams
+}
+
+// LoadRules reads the rules from a JSON file, unknown fields are rejected so that typos don't go unnoticed
+func LoadRules(path string) (Rules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Rules{}, err
+	}
+
+	defer func() {
+		_ = f.Close()
+	}()
+
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+
+	rules := Rules{}
+
+	err = decoder.Decode(&rules)
+	if err != nil {
+		return Rules{}, fmt.Errorf("unable to read url rules %s: %w", path, err)
+	}
+
+	return rules, nil
+}
+
+// Normalizer canonicalizes page urls according to its rules
+type Normalizer struct {
+	rules Rules
+}
+
+// New is a constructor for the Normalizer, it validates the rules
+func New(rules Rules) (*Normalizer, error) {
+	switch rules.TrailingSlash {
+	case "", trailingSlashStrip, trailingSlashAdd:
+	default:
+		return nil, fmt.Errorf("unknown trailing slash rule: %s", rules.TrailingSlash)
+	}
+
+	if rules.Scheme != "" && rules.Scheme != "http" && rules.Scheme != "https" {
+		return nil, fmt.Errorf("unknown scheme rule: %s", rules.Scheme)
+	}
+
+	return &Normalizer{rules: rules}, nil
+}
+
+// Normalize returns the canonical form of the page url, urls without a scheme and host (e.g. /blog) are supported
+func (n *Normalizer) Normalize(pageURL domain.PageURL) (domain.PageURL, error) {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", domain.ErrInvalidPageURL, pageURL)
+	}
+
+	r := n.rules
+
+	// urls are only re-encoded when there's something to canonicalize
+	if r.isZero() {
+		return pageURL, nil
+	}
+
+	if r.LowercaseHost {
+		u.Host = strings.ToLower(u.Host)
+	}
+
+	// before the scheme is replaced, the default port depends on the original one
+	if r.DropDefaultPort {
+		port := u.Port()
+		if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+			u.Host = strings.TrimSuffix(u.Host, ":"+port)
+		}
+	}
+
+	if r.Scheme != "" && (u.Scheme == "http" || u.Scheme == "https") {
+		u.Scheme = r.Scheme
+	}
+
+	if r.DropFragment {
+		u.Fragment = ""
+		u.RawFragment = ""
+	}
+
+	switch r.TrailingSlash {
+	case trailingSlashStrip:
+		// the root of a relative url is kept, an empty path wouldn't be a page
+		if u.Host != "" || len(u.Path) > 1 {
+			u.Path = strings.TrimSuffix(u.Path, "/")
+			u.RawPath = strings.TrimSuffix(u.RawPath, "/")
+		}
+	case trailingSlashAdd:
+		if !strings.HasSuffix(u.Path, "/") {
+			u.Path += "/"
+			if u.RawPath != "" {
+				u.RawPath += "/"
+			}
+		}
+	}
+
+	u.RawQuery = n.query(u.RawQuery)
+	if u.RawQuery == "" {
+		u.ForceQuery = false
+	}
+
+	return u.String(), nil
+}
+
+// query applies the param rules to the raw query, params are filtered and sorted without being re-encoded
+func (n *Normalizer) query(rawQuery string) string {
+	if rawQuery == "" || (len(n.rules.StripParams) == 0 && len(n.rules.KeepParams) == 0 && !n.rules.SortParams) {
+		return rawQuery
+	}
+
+	type param struct {
+		name, raw string
+	}
+
+	var params []param
+	for _, raw := range strings.Split(rawQuery, "&") {
+		if raw == "" {
+			continue
+		}
+
+		name, _, _ := strings.Cut(raw, "=")
+
+		unescaped, err := url.QueryUnescape(name)
+		if err == nil {
+			name = unescaped
+		}
+
+		if matchesAny(n.rules.StripParams, name) {
+			continue
+		}
+
+		if len(n.rules.KeepParams) > 0 && !matchesAny(n.rules.KeepParams, name) {
+			continue
+		}
+
+		params = append(params, param{name: name, raw: raw})
+	}
+
+	if n.rules.SortParams {
+		slices.SortStableFunc(params, func(a, b param) int {
+			return strings.Compare(a.name, b.name)
+		})
+	}
+
+	raws := make([]string, 0, len(params))
+	for _, p := range params {
+		raws = append(raws, p.raw)
+	}
+
+	return strings.Join(raws, "&")
+}
+
+// matchesAny reports whether the param name is in the list, entries ending with * match by prefix
+func matchesAny(names []string, name string) bool {
+	for _, n := range names {
+		prefix, isPrefix := strings.CutSuffix(n, "*")
+		if (isPrefix && strings.HasPrefix(name, prefix)) || n == name {
+			return true
+		}
+	}
+
+	return false
+}
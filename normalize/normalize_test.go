@@ -0,0 +1,200 @@
+package normalize
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"deus.ai-code-challenge/domain"
+)
+
+func TestNormalize(t *testing.T) {
+	type testCase struct {
+		description string
+		rules       Rules
+		input       string
+		expected    string
+	}
+
+	testCases := []testCase{
+		{
+			description: "zero rules keep the url as received",
+			input:       "HTTPS://Example.org:443/a b/?utm_source=x#top",
+			expected:    "HTTPS://Example.org:443/a b/?utm_source=x#top",
+		},
+		{
+			description: "lowercase host, the path is kept",
+			rules:       Rules{LowercaseHost: true},
+			input:       "https://Example.ORG/Page",
+			expected:    "https://example.org/Page",
+		},
+		{
+			description: "drop default ports",
+			rules:       Rules{DropDefaultPort: true},
+			input:       "https://example.org:443/page",
+			expected:    "https://example.org/page",
+		},
+		{
+			description: "other ports are kept",
+			rules:       Rules{DropDefaultPort: true},
+			input:       "http://example.org:443/page",
+			expected:    "http://example.org:443/page",
+		},
+		{
+			description: "drop fragment",
+			rules:       Rules{DropFragment: true},
+			input:       "https://example.org/page#section",
+			expected:    "https://example.org/page",
+		},
+		{
+			description: "scheme",
+			rules:       Rules{Scheme: "https"},
+			input:       "http://example.org/page",
+			expected:    "https://example.org/page",
+		},
+		{
+			description: "strip trailing slash",
+			rules:       Rules{TrailingSlash: "strip"},
+			input:       "https://example.org/blog/",
+			expected:    "https://example.org/blog",
+		},
+		{
+			description: "strip trailing slash keeps the root of relative urls",
+			rules:       Rules{TrailingSlash: "strip"},
+			input:       "/",
+			expected:    "/",
+		},
+		{
+			description: "add trailing slash",
+			rules:       Rules{TrailingSlash: "add"},
+			input:       "/blog?page=2",
+			expected:    "/blog/?page=2",
+		},
+		{
+			description: "strip params by name and prefix",
+			rules:       Rules{StripParams: []string{"utm_*", "gclid"}},
+			input:       "https://example.org/page?utm_source=x&id=1&gclid=abc&utm_medium=y",
+			expected:    "https://example.org/page?id=1",
+		},
+		{
+			description: "strip every param",
+			rules:       Rules{StripParams: []string{"utm_*"}},
+			input:       "https://example.org/page?utm_source=x",
+			expected:    "https://example.org/page",
+		},
+		{
+			description: "keep params",
+			rules:       Rules{KeepParams: []string{"id", "p*"}},
+			input:       "/page?ref=x&page=2&id=1",
+			expected:    "/page?page=2&id=1",
+		},
+		{
+			description: "sort params, repeated params keep their order",
+			rules:       Rules{SortParams: true},
+			input:       "/page?b=2&a=2&b=1&a%20b=3",
+			expected:    "/page?a=2&a%20b=3&b=2&b=1",
+		},
+		{
+			description: "every rule",
+			rules: Rules{
+				LowercaseHost:   true,
+				DropDefaultPort: true,
+				DropFragment:    true,
+				Scheme:          "https",
+				TrailingSlash:   "strip",
+				StripParams:     []string{"utm_*"},
+				SortParams:      true,
+			},
+			input:    "http://Example.org:80/page/?utm_campaign=z&query=x&a=1#top",
+			expected: "https://example.org/page?a=1&query=x",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			n, err := New(tc.rules)
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+
+			got, err := n.Normalize(tc.input)
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+
+			if got != tc.expected {
+				t.Errorf("got %v, expected %v", got, tc.expected)
+			}
+		})
+	}
+
+	n, _ := New(Rules{LowercaseHost: true})
+
+	_, err := n.Normalize("http://[::1")
+	if !errors.Is(err, domain.ErrInvalidPageURL) {
+		t.Errorf("got %v, expected %v", err, domain.ErrInvalidPageURL)
+	}
+
+	for _, rules := range []Rules{{TrailingSlash: "remove"}, {Scheme: "ftp"}} {
+		_, err := New(rules)
+		if err == nil {
+			t.Errorf("%+v: expected an error", rules)
+		}
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+
+	type testCase struct {
+		description   string
+		content       string
+		expected      Rules
+		expectedError bool
+	}
+
+	testCases := []testCase{
+		{
+			description: "valid rules",
+			content:     `{"lowercase_host": true, "strip_params": ["utm_*"], "trailing_slash": "strip"}`,
+			expected:    Rules{LowercaseHost: true, StripParams: []string{"utm_*"}, TrailingSlash: "strip"},
+		},
+		{
+			description:   "unknown field",
+			content:       `{"lowercase_hosts": true}`,
+			expectedError: true,
+		},
+		{
+			description:   "malformed",
+			content:       `{`,
+			expectedError: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			path := filepath.Join(dir, string(rune('a'+i))+".json")
+
+			err := os.WriteFile(path, []byte(tc.content), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rules, err := LoadRules(path)
+			if (err != nil) != tc.expectedError {
+				t.Fatalf("got %v, expected error: %v", err, tc.expectedError)
+			}
+
+			if !tc.expectedError && (rules.LowercaseHost != tc.expected.LowercaseHost ||
+				rules.TrailingSlash != tc.expected.TrailingSlash || len(rules.StripParams) != len(tc.expected.StripParams)) {
+				t.Errorf("got %+v, expected %+v", rules, tc.expected)
+			}
+		})
+	}
+
+	_, err := LoadRules(filepath.Join(dir, "missing.json"))
+	if err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,110 @@
+package normalize
+
+import (
+	"deus.ai-code-challenge/domain"
+)
+
+// PageRegistry canonicalizes the page urls of every page registered and looked up before handing them to the registry
+// it wraps, so that a visit is matched against its page whatever url it was received with
+type PageRegistry struct {
+	next       domain.PageRegistry
+	normalizer *Normalizer
+}
+
+// NewPageRegistry is a constructor for the normalizing PageRegistry
+func NewPageRegistry(next domain.PageRegistry, normalizer *Normalizer) *PageRegistry {
+	return &PageRegistry{next: next, normalizer: normalizer}
+}
+
+// Register registers the page under its canonical url
+func (r *PageRegistry) Register(page domain.Page) (domain.Page, error) {
+	pageURL, err := r.normalizer.Normalize(page.URL)
+	if err != nil {
+		return domain.Page{}, err
+	}
+
+	page.URL = pageURL
+
+	return r.next.Register(page)
+}
+
+// Page looks the page up by its canonical url
+func (r *PageRegistry) Page(url domain.PageURL) (domain.Page, error) {
+	pageURL, err := r.normalizer.Normalize(url)
+	if err != nil {
+		return domain.Page{}, err
+	}
+
+	return r.next.Page(pageURL)
+}
+
+// Pages lists the registered pages, already under their canonical urls
+func (r *PageRegistry) Pages() ([]domain.Page, error) {
+	return r.next.Pages()
+}
+
+// Update updates the page registered under its canonical url
+func (r *PageRegistry) Update(page domain.Page) (domain.Page, error) {
+	pageURL, err := r.normalizer.Normalize(page.URL)
+	if err != nil {
+		return domain.Page{}, err
+	}
+
+	page.URL = pageURL
+
+	return r.next.Update(page)
+}
+
+// Retire retires the page registered under its canonical url
+func (r *PageRegistry) Retire(url domain.PageURL) (domain.Page, error) {
+	pageURL, err := r.normalizer.Normalize(url)
+	if err != nil {
+		return domain.Page{}, err
+	}
+
+	return r.next.Retire(pageURL)
+}
+
+// Quarantine canonicalizes the page urls of the visits held and released, so that the visits received with any url of
+// a page are released together
+type Quarantine struct {
+	next       domain.VisitQuarantine
+	normalizer *Normalizer
+}
+
+// NewQuarantine is a constructor for the normalizing Quarantine
+func NewQuarantine(next domain.VisitQuarantine, normalizer *Normalizer) *Quarantine {
+	return &Quarantine{next: next, normalizer: normalizer}
+}
+
+// Quarantine holds the visit under the canonical url of its page
+func (q *Quarantine) Quarantine(visit domain.Visit) error {
+	pageURL, err := q.normalizer.Normalize(visit.PageURL)
+	if err != nil {
+		return err
+	}
+
+	visit.PageURL = pageURL
+
+	return q.next.Quarantine(visit)
+}
+
+// Release releases the visits held under the canonical url of the page
+func (q *Quarantine) Release(url domain.PageURL) ([]domain.Visit, error) {
+	pageURL, err := q.normalizer.Normalize(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return q.next.Release(pageURL)
+}
+
+// Quarantined counts the visits held by canonical url
+func (q *Quarantine) Quarantined() (map[domain.PageURL]domain.Count, error) {
+	return q.next.Quarantined()
+}
+
+// Forget drops the visits held of the visitor, visitor ids aren't normalized
+func (q *Quarantine) Forget(visitor string) (domain.Count, error) {
+	return q.next.Forget(visitor)
+}
@@ -0,0 +1,234 @@
+package normalize
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// Repository canonicalizes the page urls of every visit stored and of every query before handing them to the
+// repository it wraps, so that all the urls of a page are accounted for together. Patterns (CountUniqueVisitorsMatching)
+// are matched as given, against the canonical urls. It has every feature of domain.VisitRepository, the ones the
+// wrapped repository doesn't support fail with domain.ErrUnsupported
+type Repository struct {
+	next       domain.VisitRepository
+	normalizer *Normalizer
+}
+
+// NewRepository is a constructor for the normalizing Repository
+func NewRepository(next domain.VisitRepository, normalizer *Normalizer) *Repository {
+	return &Repository{next: next, normalizer: normalizer}
+}
+
+// Store stores the visit under the canonical url of its page
+func (r *Repository) Store(ctx context.Context, visit domain.Visit) error {
+	pageURL, err := r.normalizer.Normalize(visit.PageURL)
+	if err != nil {
+		return err
+	}
+
+	visit.PageURL = pageURL
+
+	return r.next.Store(ctx, visit)
+}
+
+// StoreBatch stores the visits under the canonical urls of their pages, none of them if any url is invalid. They're
+// stored one at a time if the wrapped repository doesn't support batches
+func (r *Repository) StoreBatch(ctx context.Context, visits []domain.Visit) error {
+	normalized := make([]domain.Visit, 0, len(visits))
+	for _, visit := range visits {
+		pageURL, err := r.normalizer.Normalize(visit.PageURL)
+		if err != nil {
+			return err
+		}
+
+		visit.PageURL = pageURL
+		normalized = append(normalized, visit)
+	}
+
+	batches, ok := r.next.(domain.BatchVisitRepository)
+	if ok {
+		return batches.StoreBatch(ctx, normalized)
+	}
+
+	for _, visit := range normalized {
+		err := r.next.Store(ctx, visit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CountUniqueVisitors counts the unique visitors of the canonical url of the page
+func (r *Repository) CountUniqueVisitors(ctx context.Context, url domain.PageURL) (domain.UniqueVisitors, error) {
+	pageURL, err := r.normalizer.Normalize(url)
+	if err != nil {
+		return domain.UniqueVisitors{}, err
+	}
+
+	return r.next.CountUniqueVisitors(ctx, pageURL)
+}
+
+// CountUniqueVisitorsBulk returns the counts keyed by the urls as given, several of them may share a canonical url
+func (r *Repository) CountUniqueVisitorsBulk(ctx context.Context, urls []domain.PageURL) (map[domain.PageURL]domain.UniqueVisitors, error) {
+	pageURLs, err := r.normalizeAll(urls)
+	if err != nil {
+		return nil, err
+	}
+
+	bulk, ok := r.next.(domain.BulkVisitRepository)
+	if !ok {
+		return nil, fmt.Errorf("%w: bulk counts", domain.ErrUnsupported)
+	}
+
+	counts, err := bulk.CountUniqueVisitorsBulk(ctx, pageURLs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[domain.PageURL]domain.UniqueVisitors, len(urls))
+	for i, url := range urls {
+		result[url] = counts[pageURLs[i]]
+	}
+
+	return result, nil
+}
+
+// CountUniqueVisitorsCombined combines the visitors of the canonical urls of the pages
+func (r *Repository) CountUniqueVisitorsCombined(ctx context.Context, urls []domain.PageURL, operator domain.SetOperator) (domain.UniqueVisitors, error) {
+	pageURLs, err := r.normalizeAll(urls)
+	if err != nil {
+		return domain.UniqueVisitors{}, err
+	}
+
+	combined, ok := r.next.(domain.CombinedVisitRepository)
+	if !ok {
+		return domain.UniqueVisitors{}, fmt.Errorf("%w: combined counts", domain.ErrUnsupported)
+	}
+
+	return combined.CountUniqueVisitorsCombined(ctx, pageURLs, operator)
+}
+
+// CountUniqueVisitorsMatching matches the pattern as given against the canonical urls
+func (r *Repository) CountUniqueVisitorsMatching(ctx context.Context, pattern string) (domain.PageRollup, error) {
+	rollups, ok := r.next.(domain.RollupVisitRepository)
+	if !ok {
+		return domain.PageRollup{}, fmt.Errorf("%w: rollups", domain.ErrUnsupported)
+	}
+
+	return rollups.CountUniqueVisitorsMatching(ctx, pattern)
+}
+
+// CountTopPages lists the most visited pages by their canonical urls
+func (r *Repository) CountTopPages(ctx context.Context, limit int, window time.Duration) ([]domain.PageCount, error) {
+	ranked, ok := r.next.(domain.RankedVisitRepository)
+	if !ok {
+		return nil, fmt.Errorf("%w: top pages", domain.ErrUnsupported)
+	}
+
+	return ranked.CountTopPages(ctx, limit, window)
+}
+
+// ListPages lists the pages by their canonical urls, query.Prefix is matched as given
+func (r *Repository) ListPages(ctx context.Context, query domain.PageQuery) ([]domain.PageCount, error) {
+	listed, ok := r.next.(domain.ListedVisitRepository)
+	if !ok {
+		return nil, fmt.Errorf("%w: page listings", domain.ErrUnsupported)
+	}
+
+	return listed.ListPages(ctx, query)
+}
+
+// ListVisitorPages lists the pages of the visitor by their canonical urls
+func (r *Repository) ListVisitorPages(ctx context.Context, visitor string) ([]domain.VisitedPage, error) {
+	history, ok := r.next.(domain.HistoryVisitRepository)
+	if !ok {
+		return nil, fmt.Errorf("%w: visitor history", domain.ErrUnsupported)
+	}
+
+	return history.ListVisitorPages(ctx, visitor)
+}
+
+// EraseVisitor removes the visitor from every page, visitor ids aren't normalized
+func (r *Repository) EraseVisitor(ctx context.Context, visitor string) (domain.ErasedPages, error) {
+	erasable, ok := r.next.(domain.ErasableVisitRepository)
+	if !ok {
+		return domain.ErasedPages{}, fmt.Errorf("%w: erasures", domain.ErrUnsupported)
+	}
+
+	return erasable.EraseVisitor(ctx, visitor)
+}
+
+// RenameVisitors moves the visitors to their new id in every page, visitor ids aren't normalized
+func (r *Repository) RenameVisitors(ctx context.Context, renames []domain.Rename) (domain.Count, error) {
+	renamable, ok := r.next.(domain.RenamableVisitRepository)
+	if !ok {
+		return 0, fmt.Errorf("%w: renames", domain.ErrUnsupported)
+	}
+
+	return renamable.RenameVisitors(ctx, renames)
+}
+
+// CountUniqueVisitorsBetween counts the unique visitors of the canonical url of the page in [from, to)
+func (r *Repository) CountUniqueVisitorsBetween(ctx context.Context, url domain.PageURL, from, to time.Time) (domain.UniqueVisitors, error) {
+	pageURL, err := r.normalizer.Normalize(url)
+	if err != nil {
+		return domain.UniqueVisitors{}, err
+	}
+
+	ranged, ok := r.next.(domain.RangedVisitRepository)
+	if !ok {
+		return domain.UniqueVisitors{}, fmt.Errorf("%w: counts between times", domain.ErrUnsupported)
+	}
+
+	return ranged.CountUniqueVisitorsBetween(ctx, pageURL, from, to)
+}
+
+// CountRecentUniqueVisitors counts the recent unique visitors of the canonical url of the page
+func (r *Repository) CountRecentUniqueVisitors(ctx context.Context, url domain.PageURL, window time.Duration) (domain.UniqueVisitors, error) {
+	pageURL, err := r.normalizer.Normalize(url)
+	if err != nil {
+		return domain.UniqueVisitors{}, err
+	}
+
+	recent, ok := r.next.(domain.RecentVisitRepository)
+	if !ok {
+		return domain.UniqueVisitors{}, fmt.Errorf("%w: recent counts", domain.ErrUnsupported)
+	}
+
+	return recent.CountRecentUniqueVisitors(ctx, pageURL, window)
+}
+
+// CountUniqueVisitorsSeries counts the unique visitors of the canonical url of the page in each period
+func (r *Repository) CountUniqueVisitorsSeries(ctx context.Context, url domain.PageURL, interval time.Duration, from, to time.Time) ([]domain.Bucket, error) {
+	pageURL, err := r.normalizer.Normalize(url)
+	if err != nil {
+		return nil, err
+	}
+
+	bucketed, ok := r.next.(domain.BucketedVisitRepository)
+	if !ok {
+		return nil, fmt.Errorf("%w: series", domain.ErrUnsupported)
+	}
+
+	return bucketed.CountUniqueVisitorsSeries(ctx, pageURL, interval, from, to)
+}
+
+// normalizeAll returns the canonical urls of the pages, in the same order
+func (r *Repository) normalizeAll(urls []domain.PageURL) ([]domain.PageURL, error) {
+	pageURLs := make([]domain.PageURL, 0, len(urls))
+	for _, url := range urls {
+		pageURL, err := r.normalizer.Normalize(url)
+		if err != nil {
+			return nil, err
+		}
+
+		pageURLs = append(pageURLs, pageURL)
+	}
+
+	return pageURLs, nil
+}
@@ -0,0 +1,43 @@
+package normalize
+
+import (
+	"context"
+	"testing"
+
+	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/repository"
+)
+
+func TestRepository(t *testing.T) {
+	n, err := New(Rules{LowercaseHost: true, DropFragment: true, StripParams: []string{"utm_*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRepository(repository.NewVisitsInMemoryRepository(), n)
+
+	for _, visit := range []domain.Visit{
+		{Visitor: "id", PageURL: "https://example.org/page"},
+		{Visitor: "id2", PageURL: "https://EXAMPLE.org/page?utm_source=newsletter"},
+	} {
+		err := r.Store(context.Background(), visit)
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+	}
+
+	err = r.StoreBatch(context.Background(), []domain.Visit{{Visitor: "id3", PageURL: "https://example.org/page#pricing"}})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	counter, _ := r.CountUniqueVisitors(context.Background(), "https://example.org/page?utm_medium=email")
+	if counter.Count != 3 {
+		t.Errorf("got %v, expected %v", counter.Count, 3)
+	}
+
+	counts, _ := r.CountUniqueVisitorsBulk(context.Background(), []domain.PageURL{"https://Example.org/page", "https://example.org/other"})
+	if counts["https://Example.org/page"].Count != 3 || counts["https://example.org/other"].Count != 0 {
+		t.Errorf("got %v, expected the counts keyed by the urls as given", counts)
+	}
+}
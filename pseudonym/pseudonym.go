@@ -0,0 +1,136 @@
+// Package pseudonym is responsible for replacing visitor ids with keyed hashes (HMAC-SHA256) before they are stored or
+// looked up, so that raw visitor ids are never held in memory or on disk. Keys can be rotated: once a new key becomes
+// active the previous ones are still recognized, so visitors can be moved to their new pseudonym whenever they're seen.
+package pseudonym
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+// MinSecretLength is the shortest secret accepted, shorter keys make pseudonyms easier to reverse by brute force
+const MinSecretLength = 16
+
+// pseudonymSize is the number of bytes of the HMAC kept, 128 bits make collisions practically impossible
+const pseudonymSize = 16
+
+// Key is a secret used to pseudonymize visitor ids from ActiveFrom onwards (until a newer key becomes active)
+type Key struct {
+	Secret     string    `json:"secret"`
+	ActiveFrom time.Time `json:"active_from"`
+}
+
+// Config lists the keys, in any order
+type Config struct {
+	Keys []Key `json:"keys"`
+}
+
+// LoadConfig reads the keys from a JSON file, unknown fields are rejected so that typos don't go unnoticed
+func LoadConfig(path string) (Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	defer func() {
+		_ = f.Close()
+	}()
+
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+
+	config := Config{}
+
+	err = decoder.Decode(&config)
+	if err != nil {
+		return Config{}, fmt.Errorf("unable to read visitor keys %s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+// Pseudonymizer replaces visitor ids with their pseudonym under the active key. It holds no state besides the keys, so
+// whether a visitor was already moved from its previous pseudonym is left to whoever moves it
+type Pseudonymizer struct {
+	keys []Key
+	now  func() time.Time
+}
+
+// New is a constructor for the Pseudonymizer, it validates the keys
+func New(config Config) (*Pseudonymizer, error) {
+	if len(config.Keys) == 0 {
+		return nil, fmt.Errorf("at least a visitor key is required")
+	}
+
+	keys := slices.SortedFunc(slices.Values(config.Keys), func(a, b Key) int {
+		return a.ActiveFrom.Compare(b.ActiveFrom)
+	})
+
+	for i, key := range keys {
+		if len(key.Secret) < MinSecretLength {
+			return nil, fmt.Errorf("visitor keys must be at least %d bytes long", MinSecretLength)
+		}
+
+		if i > 0 && key.ActiveFrom.Equal(keys[i-1].ActiveFrom) {
+			return nil, fmt.Errorf("visitor keys can't become active at the same time: %v", key.ActiveFrom)
+		}
+	}
+
+	return &Pseudonymizer{
+		keys: keys,
+		now:  time.Now,
+	}, nil
+}
+
+// Pseudonymize returns the pseudonym of the visitor under the active key and its pseudonyms under the keys active
+// before it, the most recent first (none before the first rotation). Before the first key becomes active the first key
+// is used
+func (p *Pseudonymizer) Pseudonymize(visitor string) (current string, previous []string) {
+	// the active key is the last one active from now or earlier
+	active := max(0, sortedIndex(p.keys, p.now())-1)
+	current = Hash(p.keys[active].Secret, visitor)
+
+	for i := active - 1; i >= 0; i-- {
+		previous = append(previous, Hash(p.keys[i].Secret, visitor))
+	}
+
+	return current, previous
+}
+
+// Pseudonyms returns the pseudonyms of the visitor under every key, including the ones no longer recognized, so that
+// everything held of the visitor can be found (e.g. to erase it)
+func (p *Pseudonymizer) Pseudonyms(visitor string) []string {
+	pseudonyms := make([]string, 0, len(p.keys))
+	for _, key := range p.keys {
+		pseudonyms = append(pseudonyms, Hash(key.Secret, visitor))
+	}
+
+	return pseudonyms
+}
+
+// sortedIndex returns the number of keys active from t or earlier
+func sortedIndex(keys []Key, t time.Time) int {
+	i, _ := slices.BinarySearchFunc(keys, t, func(key Key, t time.Time) int {
+		if key.ActiveFrom.After(t) {
+			return 1
+		}
+
+		return -1
+	})
+
+	return i
+}
+
+// Hash returns the truncated HMAC-SHA256 of the visitor id, encoded so it can be used anywhere a visitor id is
+func Hash(secret, visitor string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(visitor))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:pseudonymSize])
+}
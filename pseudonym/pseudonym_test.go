@@ -0,0 +1,148 @@
+package pseudonym
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPseudonymize(t *testing.T) {
+	rotation := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	first := Key{Secret: "0123456789abcdef-first", ActiveFrom: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	old := Key{Secret: "0123456789abcdef-old", ActiveFrom: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}
+	current := Key{Secret: "0123456789abcdef-new", ActiveFrom: rotation}
+
+	p, err := New(Config{Keys: []Key{current, first, old}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	firstPseudonym, oldPseudonym, newPseudonym := Hash(first.Secret, "visitor"), Hash(old.Secret, "visitor"), Hash(current.Secret, "visitor")
+	if oldPseudonym == newPseudonym || oldPseudonym == "visitor" {
+		t.Fatalf("got %v and %v, expected different pseudonyms", oldPseudonym, newPseudonym)
+	}
+
+	type testCase struct {
+		description      string
+		now              time.Time
+		expectedCurrent  string
+		expectedPrevious []string
+	}
+
+	testCases := []testCase{
+		{
+			description:      "before the rotation the old key is active",
+			now:              rotation.Add(-time.Hour),
+			expectedCurrent:  oldPseudonym,
+			expectedPrevious: []string{firstPseudonym},
+		},
+		{
+			description:      "once the new key is active the previous ones are still recognized",
+			now:              rotation.Add(time.Hour),
+			expectedCurrent:  newPseudonym,
+			expectedPrevious: []string{oldPseudonym, firstPseudonym},
+		},
+		{
+			description:      "however long after",
+			now:              rotation.Add(365 * 24 * time.Hour),
+			expectedCurrent:  newPseudonym,
+			expectedPrevious: []string{oldPseudonym, firstPseudonym},
+		},
+		{
+			description:     "before every key the first one is used",
+			now:             first.ActiveFrom.Add(-time.Hour),
+			expectedCurrent: firstPseudonym,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			p.now = func() time.Time { return tc.now }
+
+			current, previous := p.Pseudonymize("visitor")
+			if current != tc.expectedCurrent || !slices.Equal(previous, tc.expectedPrevious) {
+				t.Errorf("got %v and %v, expected %v and %v", current, previous, tc.expectedCurrent, tc.expectedPrevious)
+			}
+		})
+	}
+
+	pseudonyms := p.Pseudonyms("visitor")
+	if !slices.Equal(pseudonyms, []string{firstPseudonym, oldPseudonym, newPseudonym}) {
+		t.Errorf("got %v, expected %v", pseudonyms, []string{firstPseudonym, oldPseudonym, newPseudonym})
+	}
+}
+
+func TestNew(t *testing.T) {
+	activeFrom := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	type testCase struct {
+		description string
+		config      Config
+		valid       bool
+	}
+
+	testCases := []testCase{
+		{
+			description: "valid",
+			config:      Config{Keys: []Key{{Secret: "0123456789abcdef", ActiveFrom: activeFrom}}},
+			valid:       true,
+		},
+		{
+			description: "no keys",
+			config:      Config{},
+		},
+		{
+			description: "short secret",
+			config:      Config{Keys: []Key{{Secret: "short", ActiveFrom: activeFrom}}},
+		},
+		{
+			description: "keys active at the same time",
+			config: Config{Keys: []Key{
+				{Secret: "0123456789abcdef", ActiveFrom: activeFrom},
+				{Secret: "fedcba9876543210", ActiveFrom: activeFrom},
+			}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			_, err := New(tc.config)
+			if (err == nil) != tc.valid {
+				t.Errorf("got %v, expected valid = %v", err, tc.valid)
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "keys.json")
+
+	err := os.WriteFile(path, []byte(`{"keys":[{"secret":"0123456789abcdef","active_from":"2024-01-01T00:00:00Z"}]}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(config.Keys) != 1 {
+		t.Errorf("got %v, expected a key", config)
+	}
+
+	err = os.WriteFile(path, []byte(`{"keys":[],"grace":"720h"}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = LoadConfig(path)
+	if err == nil {
+		t.Errorf("got no error, expected unknown fields to be rejected")
+	}
+}
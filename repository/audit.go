@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/pseudonym"
+)
+
+// erasuresFile is the name of the file erasures are recorded in, within the data directory
+const erasuresFile = "erasures.log"
+
+// erasuresKeyFile is the name of the file holding the key visitor ids are hashed with before they're recorded, within
+// the data directory
+const erasuresKeyFile = "erasures.key"
+
+// erasuresKeySize is the number of random bytes of the key generated when there's none yet
+const erasuresKeySize = 32
+
+// InMemoryErasureAudit keeps the erasures in memory, in the order they were recorded. They're lost on restart, so it's
+// only meant for servers that don't persist visits either
+type InMemoryErasureAudit struct {
+	m        sync.Mutex
+	erasures []domain.Erasure
+}
+
+// NewInMemoryErasureAudit is a constructor for the in-memory ErasureAudit
+func NewInMemoryErasureAudit() *InMemoryErasureAudit {
+	return &InMemoryErasureAudit{}
+}
+
+// Record appends the erasure
+func (a *InMemoryErasureAudit) Record(erasure domain.Erasure) error {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	a.erasures = append(a.erasures, erasure)
+
+	return nil
+}
+
+// Erasures returns every erasure recorded, in the order they were
+func (a *InMemoryErasureAudit) Erasures() []domain.Erasure {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	return slices.Clone(a.erasures)
+}
+
+// FileErasureAudit is a durable ErasureAudit, each erasure is appended to a file as a line of JSON and flushed before
+// Record returns. The file is only ever appended to, it's never read back by the server. Visitor ids are recorded as
+// their keyed hash (see pseudonym.Hash) so the file doesn't bring back the ids it's about, whoever holds the key can
+// still check whether a given visitor was erased
+type FileErasureAudit struct {
+	m    sync.Mutex
+	path string
+	key  string
+}
+
+// erasure is the persisted representation of a domain.Erasure
+type erasure struct {
+	Visitor        string    `json:"visitor_id"`
+	Caller         string    `json:"caller"`
+	CallerVerified bool      `json:"caller_verified"`
+	Time           time.Time `json:"erased_at"`
+	Pages          uint64    `json:"pages"`
+	Retained       uint64    `json:"retained"`
+	Quarantined    uint64    `json:"quarantined"`
+}
+
+// NewFileErasureAudit is a constructor for the file backed ErasureAudit, erasures are recorded in dir (usually the
+// same directory as the visits). The key visitor ids are hashed with is read from dir, a random one is generated the
+// first time
+func NewFileErasureAudit(dir string) (*FileErasureAudit, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := erasuresKey(filepath.Join(dir, erasuresKeyFile))
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileErasureAudit{path: filepath.Join(dir, erasuresFile), key: key}, nil
+}
+
+// erasuresKey reads the key at path, or generates it (only readable by the server) if there's no key yet. The key is
+// durable before it's used: losing it would make the erasures already recorded impossible to look up
+func erasuresKey(path string) (string, error) {
+	key, err := os.ReadFile(path)
+	if err == nil {
+		if len(key) < pseudonym.MinSecretLength {
+			return "", fmt.Errorf("erasures key %s must be at least %d bytes long", path, pseudonym.MinSecretLength)
+		}
+
+		return string(key), nil
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	key = make([]byte, erasuresKeySize)
+	_, _ = rand.Read(key)
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = file.Write(key)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	err = errors.Join(err, file.Close())
+	if err != nil {
+		return "", err
+	}
+
+	return string(key), syncDir(filepath.Dir(path))
+}
+
+// Record appends the erasure to the file, it's only recorded if the file could be written and flushed
+func (a *FileErasureAudit) Record(e domain.Erasure) error {
+	line, err := json.Marshal(erasure{
+		Visitor:        pseudonym.Hash(a.key, e.Visitor),
+		Caller:         e.Caller.Name,
+		CallerVerified: e.Caller.Verified,
+		Time:           e.Time,
+		Pages:          e.Pages,
+		Retained:       e.Retained,
+		Quarantined:    e.Quarantined,
+	})
+	if err != nil {
+		return err
+	}
+
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	file, err := os.OpenFile(a.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(append(line, '\n'))
+	if err == nil {
+		err = file.Sync()
+	}
+
+	err = errors.Join(err, file.Close())
+	if err != nil {
+		return err
+	}
+
+	// the file may have just been created, erasures are rare enough to always make sure it's there
+	return syncDir(filepath.Dir(a.path))
+}
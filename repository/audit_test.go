@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/pseudonym"
+)
+
+func TestFileErasureAudit(t *testing.T) {
+	dir := t.TempDir()
+	erasedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	audit, err := NewFileErasureAudit(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, erasure := range []domain.Erasure{
+		{Visitor: "a", Caller: domain.Caller{Name: "legal", Verified: true}, Time: erasedAt, Pages: 2, Retained: 1},
+		{Visitor: "b", Caller: domain.Caller{Name: "127.0.0.1:1234"}, Time: erasedAt.Add(time.Hour), Quarantined: 1},
+	} {
+		err := audit.Record(erasure)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, erasuresFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := os.ReadFile(filepath.Join(dir, erasuresKeyFile))
+	if err != nil || len(key) != erasuresKeySize {
+		t.Fatalf("got %d bytes and %v, expected a key of %d bytes", len(key), err, erasuresKeySize)
+	}
+
+	// visitor ids are only recorded as their keyed hash
+	hashedA, hashedB := pseudonym.Hash(string(key), "a"), pseudonym.Hash(string(key), "b")
+
+	expected := `{"visitor_id":"` + hashedA + `","caller":"legal","caller_verified":true,"erased_at":"2024-01-02T03:04:05Z","pages":2,"retained":1,"quarantined":0}
+{"visitor_id":"` + hashedB + `","caller":"127.0.0.1:1234","caller_verified":false,"erased_at":"2024-01-02T04:04:05Z","pages":0,"retained":0,"quarantined":1}
+`
+	if string(b) != expected {
+		t.Errorf("got %v, expected %v", string(b), expected)
+	}
+
+	// the key is kept across restarts so the same visitor is recorded the same way
+	audit, err = NewFileErasureAudit(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if audit.key != string(key) {
+		t.Errorf("got a new key, expected the one in %s", erasuresKeyFile)
+	}
+}
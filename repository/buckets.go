@@ -0,0 +1,221 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+const (
+	hour = int64(time.Hour / time.Second)
+	day  = int64(24 * time.Hour / time.Second)
+
+	// DefaultRollupAfter is how long visits are kept in hourly buckets before being rolled up into daily ones
+	DefaultRollupAfter = 48 * time.Hour
+)
+
+// timeBuckets holds the unique visitors of a page per period of time, so that the unique visitors in a time range
+// can be counted by merging the buckets within it instead of scanning every visit.
+//   - hours holds one set per hour (key is the unix time, in seconds, of the start of the hour)
+//   - days holds one set per day (key is the unix time, in seconds, of the start of the day, UTC)
+//
+// Recent visits are kept in hourly buckets, once an hour is older than the rollup period (relative to the newest hour
+// of the page, which keeps the structure a function of the visits and not of when they were replayed) its bucket is
+// merged into the daily bucket. Older data can only be queried with day resolution, in exchange for fewer buckets.
+type timeBuckets struct {
+	newest int64
+	hours  map[int64]visitorSet
+	days   map[int64]visitorSet
+}
+
+func newTimeBuckets() *timeBuckets {
+	return &timeBuckets{
+		hours: make(map[int64]visitorSet),
+		days:  make(map[int64]visitorSet),
+	}
+}
+
+// horizon returns the hour (unix time, in seconds) before which the hours are rolled up into days
+func (b *timeBuckets) horizon(rollupAfter time.Duration) int64 {
+	return b.newest - int64(rollupAfter/time.Second)
+}
+
+// bucketFor returns the bucket map and key where a visit made at t is accounted for
+func (b *timeBuckets) bucketFor(t time.Time, rollupAfter time.Duration) (map[int64]visitorSet, int64) {
+	h := floor(t.Unix(), hour)
+	if h < b.horizon(rollupAfter) {
+		return b.days, floor(h, day)
+	}
+
+	return b.hours, h
+}
+
+// accounts reports whether adding the visitor at t would leave the buckets unchanged
+func (b *timeBuckets) accounts(visitor visitorID, t time.Time, rollupAfter time.Duration) bool {
+	buckets, key := b.bucketFor(t, rollupAfter)
+
+	set, found := buckets[key]
+
+	return found && set.accounts(visitor)
+}
+
+// rollup merges the hourly buckets older than the rollup period into their daily buckets, adapt converts the merged
+// sets (see InMemoryVisitRepository.adaptBucket)
+func (b *timeBuckets) rollup(rollupAfter time.Duration, adapt func(visitorSet) visitorSet) {
+	cutoff := b.horizon(rollupAfter)
+
+	for h, set := range b.hours {
+		if h >= cutoff {
+			continue
+		}
+
+		d := floor(h, day)
+
+		existing, found := b.days[d]
+		if found {
+			set = union(existing, set)
+		}
+
+		b.days[d] = adapt(set)
+		delete(b.hours, h)
+	}
+}
+
+// between returns the buckets that overlap [from, to)
+func (b *timeBuckets) between(from, to time.Time) []visitorSet {
+	start, end := from.Unix(), to.Unix()
+
+	var sets []visitorSet
+	for h, set := range b.hours {
+		if h+hour > start && h < end {
+			sets = append(sets, set)
+		}
+	}
+
+	for d, set := range b.days {
+		if d+day > start && d < end {
+			sets = append(sets, set)
+		}
+	}
+
+	return sets
+}
+
+// floor rounds the unix time t down to a multiple of period
+func floor(t, period int64) int64 {
+	r := t % period
+	if r < 0 {
+		r += period
+	}
+
+	return t - r
+}
+
+// splits reports whether [from, to) splits a day whose rolled up hours would be counted on both sides of the bound:
+// from splits it when the day has rolled up hours, to when some of them come after it
+func (b *timeBuckets) splits(from, to time.Time, rollupAfter time.Duration) bool {
+	start, end := from.Unix(), to.Unix()
+	horizon := b.horizon(rollupAfter)
+
+	return (start != floor(start, day) && floor(start, day) < horizon) || (end != floor(end, day) && end < horizon)
+}
+
+// countBetween merges the buckets of the page that overlap [from, to), the caller must hold the lock. Ranges that split
+// a day of the page older than the rollup period are refused, the daily bucket would count the whole day
+func (i *InMemoryVisitRepository) countBetween(url domain.PageURL, from, to time.Time) (domain.UniqueVisitors, error) {
+	_, buckets, err := i.page(url)
+	if err != nil {
+		return domain.UniqueVisitors{}, err
+	}
+
+	return i.countBuckets(url, buckets, from, to)
+}
+
+// countBuckets merges the given buckets of the page that overlap [from, to), see countBetween
+func (i *InMemoryVisitRepository) countBuckets(url domain.PageURL, buckets *timeBuckets, from, to time.Time) (domain.UniqueVisitors, error) {
+	if buckets == nil {
+		return domain.UniqueVisitors{}, nil
+	}
+
+	if buckets.splits(from, to, i.opts.rollupAfter) {
+		horizon := time.Unix(buckets.horizon(i.opts.rollupAfter), 0).UTC()
+
+		return domain.UniqueVisitors{}, fmt.Errorf("%w: the visits of %s before %s are only kept per day, the range must start and end at midnight UTC",
+			domain.ErrRolledUpRange, url, horizon.Format(time.RFC3339))
+	}
+
+	sets := buckets.between(from, to)
+	if len(sets) == 0 {
+		return domain.UniqueVisitors{}, nil
+	}
+
+	return union(sets...).estimate(), nil
+}
+
+// week is aligned to mondays, the unix epoch was a thursday
+const (
+	week       = 7 * day
+	weekOffset = 4 * day
+)
+
+// CountUniqueVisitorsSeries splits [from, to) in periods of interval and merges the time buckets of the page in each one.
+// Periods are aligned to the interval (weeks start on monday, days at midnight UTC), so the first period starts at or
+// before from. All periods are counted under a single read lock, so the series is a consistent snapshot, and from the
+// same buckets: a spilled page is only read once
+func (i *InMemoryVisitRepository) CountUniqueVisitorsSeries(ctx context.Context, url domain.PageURL, interval time.Duration, from, to time.Time) ([]domain.Bucket, error) {
+	starts, err := periods(interval, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	err = i.m.RLock(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer i.m.RUnlock()
+
+	_, buckets, err := i.page(url)
+	if err != nil {
+		return nil, err
+	}
+
+	series := make([]domain.Bucket, 0, len(starts))
+	for _, start := range starts {
+		visitors, err := i.countBuckets(url, buckets, start, start.Add(interval))
+		if err != nil {
+			return nil, err
+		}
+
+		series = append(series, domain.Bucket{Start: start, UniqueVisitors: visitors})
+	}
+
+	return series, nil
+}
+
+// periods returns the start of each period of interval that overlaps [from, to), at most domain.MaxSeriesBuckets
+func periods(interval time.Duration, from, to time.Time) ([]time.Time, error) {
+	step := int64(interval / time.Second)
+	if step <= 0 || interval%time.Second != 0 {
+		return nil, fmt.Errorf("invalid series interval: %s", interval)
+	}
+
+	start := floor(from.Unix(), step)
+	if step == week {
+		start = floor(from.Unix()-weekOffset, week) + weekOffset
+	}
+
+	end := to.Unix()
+	if (end-start)/step >= domain.MaxSeriesBuckets {
+		return nil, domain.ErrTooManyBuckets
+	}
+
+	var starts []time.Time
+	for t := start; t < end; t += step {
+		starts = append(starts, time.Unix(t, 0).UTC())
+	}
+
+	return starts, nil
+}
@@ -0,0 +1,339 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+func TestInMemoryRepositoryBetween(t *testing.T) {
+	base := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	type count struct {
+		from, to      time.Time
+		expectedCount domain.Count
+	}
+
+	type testCase struct {
+		description string
+		inputs      []domain.Visit
+		counts      []count
+	}
+
+	testCases := []testCase{
+		{
+			description: "visits in different hours",
+			inputs: []domain.Visit{
+				{Visitor: "id", PageURL: "url", Time: base},
+				{Visitor: "id", PageURL: "url", Time: base.Add(time.Hour)},
+				{Visitor: "id2", PageURL: "url", Time: base.Add(time.Hour + time.Minute)},
+				{Visitor: "id3", PageURL: "url", Time: base.Add(3 * time.Hour)},
+				{Visitor: "id4", PageURL: "url2", Time: base.Add(time.Hour)},
+			},
+			counts: []count{
+				{from: base, to: base.Add(time.Hour), expectedCount: 1},
+				{from: base.Add(time.Hour), to: base.Add(2 * time.Hour), expectedCount: 2},
+				{from: base, to: base.Add(2 * time.Hour), expectedCount: 2},
+				{from: base, to: base.Add(4 * time.Hour), expectedCount: 3},
+				{from: base.Add(-time.Hour), to: base, expectedCount: 0},
+			},
+		},
+		{
+			description: "ranges are resolved to the hour",
+			inputs: []domain.Visit{
+				{Visitor: "id", PageURL: "url", Time: base.Add(10 * time.Minute)},
+				{Visitor: "id2", PageURL: "url", Time: base.Add(50 * time.Minute)},
+			},
+			counts: []count{
+				{from: base.Add(30 * time.Minute), to: base.Add(40 * time.Minute), expectedCount: 2},
+			},
+		},
+		{
+			description: "old hours are rolled up into days",
+			inputs: []domain.Visit{
+				{Visitor: "id", PageURL: "url", Time: base},
+				{Visitor: "id2", PageURL: "url", Time: base.Add(2 * time.Hour)},
+				{Visitor: "id3", PageURL: "url", Time: base.Add(72 * time.Hour)},
+				// older than the rollup period, accounted for directly in the daily bucket
+				{Visitor: "id4", PageURL: "url", Time: base.Add(-time.Hour)},
+			},
+			counts: []count{
+				{from: base.Add(-12 * time.Hour), to: base.Add(12 * time.Hour), expectedCount: 3},
+				{from: base.Add(72 * time.Hour), to: base.Add(73 * time.Hour), expectedCount: 1},
+				{from: base.Add(-36 * time.Hour), to: base.Add(96 * time.Hour), expectedCount: 4},
+			},
+		},
+		{
+			description: "visits without time are not in any bucket",
+			inputs: []domain.Visit{
+				{Visitor: "id", PageURL: "url"},
+			},
+			counts: []count{
+				{from: time.Unix(0, 0), to: base, expectedCount: 0},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			r := NewVisitsInMemoryRepository()
+
+			for _, visit := range tc.inputs {
+				err := r.Store(context.Background(), visit)
+				if err != nil {
+					t.Fatal("unexpected error", err)
+				}
+			}
+
+			for _, c := range tc.counts {
+				counter, err := r.CountUniqueVisitorsBetween(context.Background(), "url", c.from, c.to)
+				if err != nil {
+					t.Fatal("unexpected error", err)
+				}
+
+				if counter.Count != c.expectedCount {
+					t.Errorf("[%v, %v): got %v, expected %v", c.from, c.to, counter.Count, c.expectedCount)
+				}
+			}
+		})
+	}
+}
+
+func TestRolledUpRanges(t *testing.T) {
+	base := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	r := NewVisitsInMemoryRepository(WithRollupAfter(24 * time.Hour))
+
+	// the hours of the 10th are rolled up once the 12th is visited, the ones of the 11th from 12:00 are kept
+	err := r.StoreBatch(ctx, []domain.Visit{
+		{Visitor: "a", PageURL: "url", Time: base.Add(-11 * time.Hour)},
+		{Visitor: "b", PageURL: "url", Time: base},
+		{Visitor: "c", PageURL: "url", Time: base.Add(36 * time.Hour)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type testCase struct {
+		description   string
+		from, to      time.Time
+		expectedCount domain.Count
+		expectedErr   error
+	}
+
+	testCases := []testCase{
+		{description: "hours of a rolled up day", from: base, to: base.Add(time.Hour), expectedErr: domain.ErrRolledUpRange},
+		{description: "ending within a rolled up day", from: base.Add(-36 * time.Hour), to: base, expectedErr: domain.ErrRolledUpRange},
+		{description: "whole rolled up days", from: base.Add(-12 * time.Hour), to: base.Add(12 * time.Hour), expectedCount: 2},
+		{description: "hours past the horizon", from: base.Add(24 * time.Hour), to: base.Add(37 * time.Hour), expectedCount: 1},
+		{description: "ending past the horizon", from: base.Add(-12 * time.Hour), to: base.Add(30 * time.Hour), expectedCount: 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			count, err := r.CountUniqueVisitorsBetween(ctx, "url", tc.from, tc.to)
+			if !errors.Is(err, tc.expectedErr) || count.Count != tc.expectedCount {
+				t.Errorf("got %v and %v, expected %v and %v", count.Count, err, tc.expectedCount, tc.expectedErr)
+			}
+		})
+	}
+
+	_, err = r.CountUniqueVisitorsSeries(ctx, "url", time.Hour, base.Add(-12*time.Hour), base.Add(12*time.Hour))
+	if !errors.Is(err, domain.ErrRolledUpRange) {
+		t.Errorf("got %v, expected %v", err, domain.ErrRolledUpRange)
+	}
+
+	series, err := r.CountUniqueVisitorsSeries(ctx, "url", 24*time.Hour, base.Add(-12*time.Hour), base.Add(12*time.Hour))
+	if err != nil || len(series) != 1 || series[0].UniqueVisitors.Count != 2 {
+		t.Errorf("got %v and %v, expected a day with 2 visitors", series, err)
+	}
+}
+
+func TestFileRepositoryBetween(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	r, err := NewFileVisitRepository(dir, WithSnapshots(0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := func(visits ...domain.Visit) {
+		for _, visit := range visits {
+			err := r.Store(context.Background(), visit)
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+		}
+	}
+
+	store(domain.Visit{Visitor: "id", PageURL: "url", Time: base}, domain.Visit{Visitor: "id2", PageURL: "url", Time: base})
+
+	err = r.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// same visitor in a later hour, must be logged even though the page already accounts for the visitor
+	store(domain.Visit{Visitor: "id", PageURL: "url", Time: base.Add(time.Hour)})
+
+	err = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = NewFileVisitRepository(dir, WithSnapshots(0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = r.Close()
+	}()
+
+	counter, _ := r.CountUniqueVisitorsBetween(context.Background(), "url", base, base.Add(time.Hour))
+	if counter.Count != 2 {
+		t.Errorf("got %v, expected %v", counter.Count, 2)
+	}
+
+	counter, _ = r.CountUniqueVisitorsBetween(context.Background(), "url", base.Add(time.Hour), base.Add(2*time.Hour))
+	if counter.Count != 1 {
+		t.Errorf("got %v, expected %v", counter.Count, 1)
+	}
+
+	counter, _ = r.CountUniqueVisitors(context.Background(), "url")
+	if counter.Count != 2 {
+		t.Errorf("got %v, expected %v", counter.Count, 2)
+	}
+}
+
+func TestInMemoryRepositorySeries(t *testing.T) {
+	// a wednesday
+	base := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	r := NewVisitsInMemoryRepository()
+
+	for _, visit := range []domain.Visit{
+		{Visitor: "id", PageURL: "url", Time: base},
+		{Visitor: "id2", PageURL: "url", Time: base.Add(30 * time.Minute)},
+		{Visitor: "id", PageURL: "url", Time: base.Add(2 * time.Hour)},
+		{Visitor: "id3", PageURL: "url", Time: base.Add(24 * time.Hour)},
+	} {
+		err := r.Store(context.Background(), visit)
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+	}
+
+	type testCase struct {
+		description    string
+		interval       time.Duration
+		from, to       time.Time
+		expectedStarts []time.Time
+		expectedCounts []domain.Count
+	}
+
+	testCases := []testCase{
+		{
+			description:    "hourly, empty hours are zero-filled",
+			interval:       time.Hour,
+			from:           base,
+			to:             base.Add(3 * time.Hour),
+			expectedStarts: []time.Time{base, base.Add(time.Hour), base.Add(2 * time.Hour)},
+			expectedCounts: []domain.Count{2, 0, 1},
+		},
+		{
+			description:    "daily, periods are aligned to midnight",
+			interval:       24 * time.Hour,
+			from:           base,
+			to:             base.Add(36 * time.Hour),
+			expectedStarts: []time.Time{base.Add(-12 * time.Hour), base.Add(12 * time.Hour)},
+			expectedCounts: []domain.Count{2, 1},
+		},
+		{
+			description:    "weekly, periods start on monday",
+			interval:       7 * 24 * time.Hour,
+			from:           base,
+			to:             base.Add(time.Hour),
+			expectedStarts: []time.Time{time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)},
+			expectedCounts: []domain.Count{3},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			series, err := r.CountUniqueVisitorsSeries(context.Background(), "url", tc.interval, tc.from, tc.to)
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+
+			if len(series) != len(tc.expectedStarts) {
+				t.Fatalf("got %d buckets, expected %d", len(series), len(tc.expectedStarts))
+			}
+
+			for i, b := range series {
+				if !b.Start.Equal(tc.expectedStarts[i]) {
+					t.Errorf("bucket %d: got start %v, expected %v", i, b.Start, tc.expectedStarts[i])
+				}
+
+				if b.UniqueVisitors.Count != tc.expectedCounts[i] {
+					t.Errorf("bucket %d: got %v, expected %v", i, b.UniqueVisitors.Count, tc.expectedCounts[i])
+				}
+			}
+		})
+	}
+
+	_, err := r.CountUniqueVisitorsSeries(context.Background(), "url", time.Hour, base.Add(-2*365*24*time.Hour), base)
+	if !errors.Is(err, domain.ErrTooManyBuckets) {
+		t.Errorf("got %v, expected %v", err, domain.ErrTooManyBuckets)
+	}
+}
+
+func TestApproximateBuckets(t *testing.T) {
+	base := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	r := NewVisitsInMemoryRepository(WithCounting(CountApproximate, 12))
+	breakEven := (1 << 12) / visitorSize
+
+	// the first hour stays small, the second one gets more visitors than its sketch would cost
+	visits := []domain.Visit{{Visitor: "a", PageURL: "url", Time: base}}
+	for visitor := range breakEven + 1 {
+		visits = append(visits, domain.Visit{Visitor: fmt.Sprintf("v%d", visitor), PageURL: "url", Time: base.Add(time.Hour)})
+	}
+
+	err := r.StoreBatch(ctx, visits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, isExact := r.buckets["url"].hours[floor(base.Unix(), hour)].(exactSet)
+	if !isExact {
+		t.Error("expected the small bucket to be an exact set")
+	}
+
+	_, isSketch := r.buckets["url"].hours[floor(base.Add(time.Hour).Unix(), hour)].(*hyperLogLog)
+	if !isSketch {
+		t.Error("expected the large bucket to be a sketch")
+	}
+
+	count, err := r.CountUniqueVisitorsBetween(ctx, "url", base, base.Add(time.Hour))
+	if err != nil || count != (domain.UniqueVisitors{Count: 1}) {
+		t.Errorf("got %v and %v, expected an exact count of 1", count, err)
+	}
+
+	count, err = r.CountUniqueVisitorsBetween(ctx, "url", base, base.Add(2*time.Hour))
+	if err != nil || !count.Estimated {
+		t.Errorf("got %v and %v, expected an estimate", count, err)
+	}
+
+	all, err := r.CountUniqueVisitors(ctx, "url")
+	if err != nil || !all.Estimated {
+		t.Errorf("got %v and %v, expected the all-time count to be an estimate", all, err)
+	}
+}
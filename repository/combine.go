@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+
+	"deus.ai-code-challenge/domain"
+)
+
+const (
+	// MaxCombinedPages bounds the number of pages whose visitors can be combined in a single query
+	MaxCombinedPages = 100
+	// MaxEstimatedCombinedPages bounds the number of pages of an intersection or difference that involves sketches,
+	// those are estimated with the inclusion–exclusion principle which merges 2^pages sketches
+	MaxEstimatedCombinedPages = 8
+)
+
+// CountUniqueVisitorsCombined counts the visitors of the pages combined with the operator, under a single read lock.
+// With exact sets the count is exact. Sketches can only be merged, so unions are estimated as usual, but intersections
+// and differences are derived from the sizes of unions (inclusion–exclusion), which means their error is relative to
+// the size of the union of the pages: small intersections of large pages have a large relative error
+func (i *InMemoryVisitRepository) CountUniqueVisitorsCombined(ctx context.Context, urls []domain.PageURL, operator domain.SetOperator) (domain.UniqueVisitors, error) {
+	err := validCombination(urls)
+	if err != nil {
+		return domain.UniqueVisitors{}, err
+	}
+
+	err = i.m.RLock(ctx)
+	if err != nil {
+		return domain.UniqueVisitors{}, err
+	}
+
+	defer i.m.RUnlock()
+
+	sets := make([]visitorSet, 0, len(urls))
+	for _, url := range urls {
+		visitors, err := i.visitorsOf(url)
+		if err != nil {
+			return domain.UniqueVisitors{}, err
+		}
+
+		sets = append(sets, visitors)
+	}
+
+	return combine(sets, operator)
+}
+
+// validCombination checks the number of pages to combine
+func validCombination(urls []domain.PageURL) error {
+	if len(urls) == 0 || len(urls) > MaxCombinedPages {
+		return fmt.Errorf("%w: between 1 and %d pages can be combined", domain.ErrInvalidCombination, MaxCombinedPages)
+	}
+
+	return nil
+}
+
+// visitorsOf returns the all-time set of the page, empty if the page isn't known, the caller must hold the lock
+func (i *InMemoryVisitRepository) visitorsOf(url domain.PageURL) (visitorSet, error) {
+	visitors, _, err := i.page(url)
+	if err != nil || visitors != nil {
+		return visitors, err
+	}
+
+	return exactSet{}, nil
+}
+
+// combine counts the visitors of the sets combined with the operator
+func combine(sets []visitorSet, operator domain.SetOperator) (domain.UniqueVisitors, error) {
+	switch operator {
+	case domain.Union:
+		return union(sets...).estimate(), nil
+	case domain.Intersection, domain.Difference:
+	default:
+		return domain.UniqueVisitors{}, fmt.Errorf("%w: unknown operator %q", domain.ErrInvalidCombination, operator)
+	}
+
+	exact := make([]exactSet, 0, len(sets))
+	for _, set := range sets {
+		e, isExact := set.(exactSet)
+		if !isExact {
+			break
+		}
+
+		exact = append(exact, e)
+	}
+
+	if len(exact) == len(sets) {
+		if operator == domain.Intersection {
+			return domain.UniqueVisitors{Count: intersectionSize(exact)}, nil
+		}
+
+		return domain.UniqueVisitors{Count: differenceSize(exact)}, nil
+	}
+
+	if len(sets) > MaxEstimatedCombinedPages {
+		return domain.UniqueVisitors{}, fmt.Errorf("%w: at most %d pages can be combined with %s when counting approximately", domain.ErrInvalidCombination, MaxEstimatedCombinedPages, operator)
+	}
+
+	all := union(sets...)
+
+	var estimate float64
+	if operator == domain.Intersection {
+		estimate = inclusionExclusion(sets)
+	} else {
+		// |A \ (B ∪ C ...)| = |A ∪ B ∪ C ...| - |B ∪ C ...|
+		estimate = float64(all.estimate().Count) - float64(union(sets[1:]...).estimate().Count)
+	}
+
+	// the estimate can't be negative nor larger than the first page
+	estimate = min(max(estimate, 0), float64(sets[0].estimate().Count))
+
+	// the error of each union is relative to its size, which is at most the size of the union of every page
+	sketch, _ := all.(*hyperLogLog)
+	absoluteError := errorBound(sketch.precision) * float64(all.estimate().Count)
+
+	return domain.UniqueVisitors{
+		Count:      domain.Count(estimate + 0.5),
+		Estimated:  true,
+		ErrorBound: absoluteError / max(estimate, 1),
+	}, nil
+}
+
+// inclusionExclusion estimates the size of the intersection of the sets from the sizes of the unions of every
+// non-empty subset of them: |A ∩ B| = |A| + |B| - |A ∪ B|, and so on for more sets
+func inclusionExclusion(sets []visitorSet) float64 {
+	var size float64
+	for subset := uint(1); subset < 1<<len(sets); subset++ {
+		members := make([]visitorSet, 0, bits.OnesCount(subset))
+		for i, set := range sets {
+			if subset&(1<<i) != 0 {
+				members = append(members, set)
+			}
+		}
+
+		count := float64(union(members...).estimate().Count)
+		if len(members)%2 == 1 {
+			size += count
+		} else {
+			size -= count
+		}
+	}
+
+	return size
+}
+
+// intersectionSize counts the visitors present in every set, only the smallest set is iterated
+func intersectionSize(sets []exactSet) domain.Count {
+	smallest := sets[0]
+	for _, set := range sets[1:] {
+		if len(set) < len(smallest) {
+			smallest = set
+		}
+	}
+
+	var count domain.Count
+
+visitors:
+	for visitor := range smallest {
+		for _, set := range sets {
+			if !set.accounts(visitor) {
+				continue visitors
+			}
+		}
+
+		count++
+	}
+
+	return count
+}
+
+// differenceSize counts the visitors of the first set that are not present in any other set
+func differenceSize(sets []exactSet) domain.Count {
+	var count domain.Count
+
+visitors:
+	for visitor := range sets[0] {
+		for _, set := range sets[1:] {
+			if set.accounts(visitor) {
+				continue visitors
+			}
+		}
+
+		count++
+	}
+
+	return count
+}
@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"testing"
+
+	"deus.ai-code-challenge/domain"
+)
+
+func TestInMemoryRepositoryCombined(t *testing.T) {
+	r := NewVisitsInMemoryRepository()
+
+	err := r.StoreBatch(context.Background(), []domain.Visit{
+		{Visitor: "id", PageURL: "pricing"},
+		{Visitor: "id2", PageURL: "pricing"},
+		{Visitor: "id3", PageURL: "pricing"},
+		{Visitor: "id2", PageURL: "signup"},
+		{Visitor: "id3", PageURL: "signup"},
+		{Visitor: "id4", PageURL: "signup"},
+		{Visitor: "id3", PageURL: "checkout"},
+	})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	type testCase struct {
+		description   string
+		urls          []domain.PageURL
+		operator      domain.SetOperator
+		expectedCount domain.Count
+	}
+
+	testCases := []testCase{
+		{description: "union", urls: []domain.PageURL{"pricing", "signup"}, operator: domain.Union, expectedCount: 4},
+		{description: "intersection", urls: []domain.PageURL{"pricing", "signup"}, operator: domain.Intersection, expectedCount: 2},
+		{description: "intersection of three pages", urls: []domain.PageURL{"pricing", "signup", "checkout"}, operator: domain.Intersection, expectedCount: 1},
+		{description: "difference", urls: []domain.PageURL{"pricing", "signup"}, operator: domain.Difference, expectedCount: 1},
+		{description: "difference of three pages", urls: []domain.PageURL{"signup", "pricing", "checkout"}, operator: domain.Difference, expectedCount: 1},
+		{description: "single page", urls: []domain.PageURL{"pricing"}, operator: domain.Intersection, expectedCount: 3},
+		{description: "page without visits", urls: []domain.PageURL{"pricing", "unknown"}, operator: domain.Intersection, expectedCount: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			counter, err := r.CountUniqueVisitorsCombined(context.Background(), tc.urls, tc.operator)
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+
+			if counter.Count != tc.expectedCount || counter.Estimated {
+				t.Errorf("got %v, expected %v (exact)", counter, tc.expectedCount)
+			}
+		})
+	}
+
+	for _, invalid := range []struct {
+		urls     []domain.PageURL
+		operator domain.SetOperator
+	}{
+		{urls: nil, operator: domain.Union},
+		{urls: []domain.PageURL{"pricing"}, operator: "xor"},
+		{urls: make([]domain.PageURL, MaxCombinedPages+1), operator: domain.Union},
+	} {
+		_, err := r.CountUniqueVisitorsCombined(context.Background(), invalid.urls, invalid.operator)
+		if !errors.Is(err, domain.ErrInvalidCombination) {
+			t.Errorf("got %v, expected %v", err, domain.ErrInvalidCombination)
+		}
+	}
+}
+
+func TestInMemoryRepositoryCombinedApproximate(t *testing.T) {
+	r := NewVisitsInMemoryRepository(WithCounting(CountApproximate, DefaultPrecision))
+
+	// a: 0-1999, b: 1000-2999, c: 1500-1699
+	var visits []domain.Visit
+	for i := range 3_000 {
+		visitor := strconv.Itoa(i)
+		if i < 2_000 {
+			visits = append(visits, domain.Visit{Visitor: visitor, PageURL: "a"})
+		}
+		if i >= 1_000 {
+			visits = append(visits, domain.Visit{Visitor: visitor, PageURL: "b"})
+		}
+		if i >= 1_500 && i < 1_700 {
+			visits = append(visits, domain.Visit{Visitor: visitor, PageURL: "c"})
+		}
+	}
+
+	err := r.StoreBatch(context.Background(), visits)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	type testCase struct {
+		description string
+		urls        []domain.PageURL
+		operator    domain.SetOperator
+		expected    float64
+	}
+
+	testCases := []testCase{
+		{description: "union", urls: []domain.PageURL{"a", "b"}, operator: domain.Union, expected: 3_000},
+		{description: "intersection", urls: []domain.PageURL{"a", "b"}, operator: domain.Intersection, expected: 1_000},
+		{description: "intersection of three pages", urls: []domain.PageURL{"a", "b", "c"}, operator: domain.Intersection, expected: 200},
+		{description: "difference", urls: []domain.PageURL{"a", "b"}, operator: domain.Difference, expected: 1_000},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			counter, err := r.CountUniqueVisitorsCombined(context.Background(), tc.urls, tc.operator)
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+
+			if !counter.Estimated {
+				t.Errorf("got an exact count, expected an estimate")
+			}
+
+			// within 3 standard errors
+			if math.Abs(float64(counter.Count)-tc.expected) > 3*counter.ErrorBound*tc.expected {
+				t.Errorf("got %v (error bound %v), expected %v", counter.Count, counter.ErrorBound, tc.expected)
+			}
+		})
+	}
+
+	urls := make([]domain.PageURL, MaxEstimatedCombinedPages+1)
+	for i := range urls {
+		urls[i] = "a"
+	}
+
+	_, err = r.CountUniqueVisitorsCombined(context.Background(), urls, domain.Intersection)
+	if !errors.Is(err, domain.ErrInvalidCombination) {
+		t.Errorf("got %v, expected %v", err, domain.ErrInvalidCombination)
+	}
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// forget drops the visitor from the recent visitors of the page
+func (r *recentVisitors) forget(url domain.PageURL, visitor visitorID) {
+	element, found := r.index[url][visitor]
+	if !found {
+		return
+	}
+
+	r.pages[url].Remove(element)
+	delete(r.index[url], visitor)
+	r.visits--
+
+	if r.pages[url].Len() == 0 {
+		delete(r.pages, url)
+		delete(r.index, url)
+	}
+}
+
+// erase removes the visitor from every page (the all-time sets, the time buckets, the recent visitors and the history)
+// and returns the number of pages it was removed from, the caller must hold the write lock. The pages are found through
+// the history, every page is looked at when it's turned off (see seenIn). Spilled pages are only faulted in when they
+// hold the visitor.
+//
+// Pages counted with a sketch (the all-time set or a time bucket) don't hold the visitor id and can't forget it, so
+// their count is left as is and they're reported as retaining the visitor. A sketch may account for a visitor it never
+// saw, so with the history turned off every page that may account for it is reported
+func (i *InMemoryVisitRepository) erase(visitor visitorID) (domain.ErasedPages, error) {
+	var erased domain.ErasedPages
+	for url := range i.seenIn(visitor) {
+		err := i.faultIf(url, func(visitors visitorSet, buckets *timeBuckets) bool {
+			return holds(visitors, buckets, visitor)
+		})
+		if err != nil {
+			return erased, err
+		}
+
+		visitors, resident := i.data[url]
+		if resident && visitors.remove(visitor) {
+			i.count[url] = visitors.estimate().Count
+			i.top.update(url, i.uniqueVisitors(url))
+			erased.Erased++
+		}
+
+		buckets, found := i.buckets[url]
+		if found {
+			for _, set := range buckets.hours {
+				set.remove(visitor)
+			}
+
+			for _, set := range buckets.days {
+				set.remove(visitor)
+			}
+		}
+
+		i.recent.forget(url, visitor)
+
+		if resident && holds(visitors, buckets, visitor) {
+			erased.Retained++
+		}
+
+		if resident {
+			i.account(url, false)
+			i.fit()
+		}
+	}
+
+	delete(i.history, visitor)
+
+	return erased, nil
+}
+
+// holds reports whether the visitor may be in the all-time set or the time buckets of a page
+func holds(visitors visitorSet, buckets *timeBuckets, visitor visitorID) bool {
+	if visitors.accounts(visitor) {
+		return true
+	}
+
+	if buckets == nil {
+		return false
+	}
+
+	for _, set := range buckets.hours {
+		if set.accounts(visitor) {
+			return true
+		}
+	}
+
+	for _, set := range buckets.days {
+		if set.accounts(visitor) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// EraseVisitor removes the visitor from every page it was seen in, decrementing their counts, and returns the number
+// of pages it was removed from. Counts of pages kept as sketches can't be decremented, since sketches don't hold
+// visitor ids, those pages are returned as retaining the visitor
+func (i *InMemoryVisitRepository) EraseVisitor(ctx context.Context, visitor string) (domain.ErasedPages, error) {
+	err := i.m.Lock(ctx)
+	if err != nil {
+		return domain.ErasedPages{}, err
+	}
+
+	defer i.m.Unlock()
+
+	return i.erase(visitor)
+}
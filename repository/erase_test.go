@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+func TestEraseVisitor(t *testing.T) {
+	base := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	visits := []domain.Visit{
+		{Visitor: "a", PageURL: "/blog", Time: base},
+		{Visitor: "a", PageURL: "/about", Time: base},
+		{Visitor: "b", PageURL: "/blog", Time: base},
+	}
+
+	// expectCounts checks the all-time, bucketed and ranked counts of /blog and /about
+	expectCounts := func(t *testing.T, r fullRepository, blog, about domain.Count) {
+		t.Helper()
+
+		for url, expected := range map[domain.PageURL]domain.Count{"/blog": blog, "/about": about} {
+			count, err := r.CountUniqueVisitors(context.Background(), url)
+			if err != nil || count.Count != expected {
+				t.Errorf("%s: got %v and %v, expected %v", url, count.Count, err, expected)
+			}
+
+			count, err = r.CountUniqueVisitorsBetween(context.Background(), url, base, base.Add(time.Hour))
+			if err != nil || count.Count != expected {
+				t.Errorf("%s between: got %v and %v, expected %v", url, count.Count, err, expected)
+			}
+		}
+
+		top, err := r.CountTopPages(context.Background(), 1, 0)
+		if err != nil || len(top) != 1 || top[0].UniqueVisitors.Count != max(blog, about) {
+			t.Errorf("top: got %v and %v, expected a count of %v", top, err, max(blog, about))
+		}
+	}
+
+	t.Run("in memory", func(t *testing.T) {
+		i := NewVisitsInMemoryRepository(WithVisitorHistory(true), withClock(&clock{now: base}))
+
+		err := i.StoreBatch(context.Background(), visits)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pages, err := i.EraseVisitor(context.Background(), "a")
+		if err != nil || pages != (domain.ErasedPages{Erased: 2}) {
+			t.Errorf("got %v and %v, expected 2 pages", pages, err)
+		}
+
+		expectCounts(t, i, 1, 0)
+
+		recent, err := i.CountRecentUniqueVisitors(context.Background(), "/blog", time.Hour)
+		if err != nil || recent.Count != 1 {
+			t.Errorf("got %v and %v, expected 1 recent visitor", recent.Count, err)
+		}
+
+		history, err := i.ListVisitorPages(context.Background(), "a")
+		if err != nil || len(history) != 0 {
+			t.Errorf("got %v and %v, expected no pages", history, err)
+		}
+
+		// erasing again changes nothing
+		pages, err = i.EraseVisitor(context.Background(), "a")
+		if err != nil || pages != (domain.ErasedPages{}) {
+			t.Errorf("got %v and %v, expected 0 pages", pages, err)
+		}
+
+		// the visitor is counted again if seen again
+		err = i.Store(context.Background(), visits[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectCounts(t, i, 2, 0)
+	})
+
+	t.Run("sketches can't forget a visitor", func(t *testing.T) {
+		i := NewVisitsInMemoryRepository(WithVisitorHistory(true), WithCounting(CountApproximate, DefaultPrecision), withClock(&clock{now: base}))
+
+		err := i.StoreBatch(context.Background(), visits)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// both pages the visitor was seen in are reported as still counting it
+		pages, err := i.EraseVisitor(context.Background(), "a")
+		if err != nil || pages != (domain.ErasedPages{Retained: 2}) {
+			t.Errorf("got %v and %v, expected 2 pages retaining the visitor", pages, err)
+		}
+
+		count, err := i.CountUniqueVisitors(context.Background(), "/blog")
+		if err != nil || count.Count != 2 {
+			t.Errorf("got %v and %v, expected 2", count.Count, err)
+		}
+	})
+
+	t.Run("the tombstone survives a restart", func(t *testing.T) {
+		for _, snapshot := range []bool{false, true} {
+			dir := t.TempDir()
+
+			r, err := NewFileVisitRepository(dir, WithVisitorHistory(true), WithSnapshots(0, 1), withClock(&clock{now: base}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = r.StoreBatch(context.Background(), visits)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// with a snapshot the visits are in it and only the tombstone is replayed, otherwise both are
+			if snapshot {
+				err = r.Snapshot()
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, err = r.EraseVisitor(context.Background(), "a")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = r.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, err = NewFileVisitRepository(dir, WithVisitorHistory(true), WithSnapshots(0, 1), withClock(&clock{now: base}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expectCounts(t, r, 1, 0)
+
+			err = r.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
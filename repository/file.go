@@ -0,0 +1,467 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// FileVisitRepository is a durable VisitRepository, it keeps the same in-memory structure as InMemoryVisitRepository
+// (so reads are just as fast) but every new (visitor, page) pair is appended to a write-ahead log before being
+// accounted for.
+//
+// Visits that are already accounted for are not written to the log, keeping it as small as the data it represents. With
+// the visitor history on, a visit that moves when the visitor was first or last seen in the page isn't accounted for yet.
+//
+// Periodically a snapshot of the data is written and the log segments it covers are dropped. On startup the newest
+// valid snapshot is loaded (falling back to older ones if it's corrupt) and only the log tail is replayed to rebuild
+// the data/count maps. Segments are only dropped once every retained snapshot covers them, so that falling back to an
+// older snapshot never loses visits.
+type FileVisitRepository struct {
+	mem  *InMemoryVisitRepository
+	log  *visitLog
+	dir  string
+	opts options
+
+	// snapshotM serializes snapshots, snapshotted is the log segment covered by the newest snapshot
+	snapshotM   sync.Mutex
+	snapshotted uint64
+
+	// expired is set when data was expired since the newest snapshot, which has to be replaced even if nothing was logged
+	expired atomic.Bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewFileVisitRepository is a constructor for the file backed VisitRepository, data is stored in dir
+func NewFileVisitRepository(dir string, opts ...Option) (*FileVisitRepository, error) {
+	o := buildOptions(opts)
+
+	if o.syncPolicy == SyncInterval && o.syncInterval <= 0 {
+		return nil, errors.New("fsync interval must be positive")
+	}
+
+	if o.snapshotRetention < 1 {
+		return nil, errors.New("snapshot retention must be at least 1")
+	}
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	mem, snapshotted, err := loadNewestSnapshot(dir, o)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := openVisitLog(dir, snapshotted, func(record logRecord) error {
+		switch record.op {
+		case opErase:
+			_, err := mem.erase(record.visit.Visitor)
+
+			return err
+		case opRename:
+			_, err := mem.rename(record.visit.Visitor, record.to)
+
+			return err
+		default:
+			err := mem.fault(record.visit.PageURL, true)
+			if err != nil {
+				return err
+			}
+
+			mem.add(record.visit)
+			mem.fit()
+
+			return nil
+		}
+	})
+	if err != nil {
+		_ = mem.Close()
+
+		return nil, err
+	}
+
+	f := &FileVisitRepository{
+		mem:         mem,
+		log:         l,
+		dir:         dir,
+		opts:        o,
+		snapshotted: snapshotted,
+		done:        make(chan struct{}),
+	}
+
+	if o.syncPolicy == SyncInterval {
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			f.log.syncEvery(o.syncInterval, f.done)
+		}()
+	}
+
+	if o.snapshotInterval > 0 {
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			f.snapshotEvery(o.snapshotInterval)
+		}()
+	}
+
+	return f, nil
+}
+
+// loadNewestSnapshot loads the newest valid snapshot in dir, returning the log segment from which replay must start
+func loadNewestSnapshot(dir string, o options) (*InMemoryVisitRepository, uint64, error) {
+	snapshots, err := listSequence(dir, snapshotPrefix, snapshotSuffix)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, seq := range slices.Backward(snapshots) {
+		mem := newInMemoryRepository(o)
+
+		err := loadSnapshot(snapshotPath(dir, seq), mem)
+		if err == nil {
+			return mem, seq, nil
+		}
+
+		_ = mem.Close()
+
+		log.Printf("unable to load snapshot %s, falling back to the previous one: %v", snapshotPath(dir, seq), err)
+	}
+
+	if len(snapshots) > 0 {
+		log.Printf("no valid snapshot found in %s, replaying the whole log", dir)
+	}
+
+	return newInMemoryRepository(o), 0, nil
+}
+
+// Store appends the visit to the log (if it changes the data, i.e. a new visitor for the page or for the time bucket)
+// and only then accounts for it in memory, if the log can't be written the visit is not accounted for
+func (f *FileVisitRepository) Store(ctx context.Context, visit domain.Visit) error {
+	err := f.mem.m.Lock(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer f.mem.m.Unlock()
+
+	err = f.mem.fault(visit.PageURL, true)
+	if err != nil {
+		return err
+	}
+
+	if f.mem.contains(visit) {
+		f.mem.revisit(visit)
+		f.mem.fit()
+
+		return nil
+	}
+
+	err = f.log.append(f.opts.syncPolicy, visit)
+	if err != nil {
+		return err
+	}
+
+	f.mem.add(visit)
+	f.mem.fit()
+
+	return nil
+}
+
+// StoreBatch appends the visits that change the data to the log with a single write (and a single flush, when the
+// policy requires it) and only then accounts for them in memory, if the log can't be written none of them are
+func (f *FileVisitRepository) StoreBatch(ctx context.Context, visits []domain.Visit) error {
+	err := f.mem.m.Lock(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer f.mem.m.Unlock()
+
+	var changes []domain.Visit
+	for _, visit := range visits {
+		err := f.mem.fault(visit.PageURL, true)
+		if err != nil {
+			return err
+		}
+
+		if f.mem.contains(visit) {
+			f.mem.revisit(visit)
+
+			continue
+		}
+
+		changes = append(changes, visit)
+	}
+
+	if len(changes) == 0 {
+		f.mem.fit()
+
+		return nil
+	}
+
+	err = f.log.append(f.opts.syncPolicy, changes...)
+	if err != nil {
+		return err
+	}
+
+	for _, visit := range changes {
+		f.mem.add(visit)
+	}
+
+	f.mem.fit()
+
+	return nil
+}
+
+// CountUniqueVisitors reads the count from memory, the log is only read on startup
+func (f *FileVisitRepository) CountUniqueVisitors(ctx context.Context, url domain.PageURL) (domain.UniqueVisitors, error) {
+	return f.mem.CountUniqueVisitors(ctx, url)
+}
+
+// CountUniqueVisitorsBulk reads the counts from memory, the log is only read on startup
+func (f *FileVisitRepository) CountUniqueVisitorsBulk(ctx context.Context, urls []domain.PageURL) (map[domain.PageURL]domain.UniqueVisitors, error) {
+	return f.mem.CountUniqueVisitorsBulk(ctx, urls)
+}
+
+// CountUniqueVisitorsCombined reads the visitors from memory, the log is only read on startup
+func (f *FileVisitRepository) CountUniqueVisitorsCombined(ctx context.Context, urls []domain.PageURL, operator domain.SetOperator) (domain.UniqueVisitors, error) {
+	return f.mem.CountUniqueVisitorsCombined(ctx, urls, operator)
+}
+
+// CountUniqueVisitorsMatching reads the page index and visitors from memory, the log is only read on startup
+func (f *FileVisitRepository) CountUniqueVisitorsMatching(ctx context.Context, pattern string) (domain.PageRollup, error) {
+	return f.mem.CountUniqueVisitorsMatching(ctx, pattern)
+}
+
+// CountUniqueVisitorsBetween reads the time buckets from memory, the log is only read on startup
+func (f *FileVisitRepository) CountUniqueVisitorsBetween(ctx context.Context, url domain.PageURL, from, to time.Time) (domain.UniqueVisitors, error) {
+	return f.mem.CountUniqueVisitorsBetween(ctx, url, from, to)
+}
+
+// CountRecentUniqueVisitors reads the recent visitors from memory, they are rebuilt from the log replayed on startup
+func (f *FileVisitRepository) CountRecentUniqueVisitors(ctx context.Context, url domain.PageURL, window time.Duration) (domain.UniqueVisitors, error) {
+	return f.mem.CountRecentUniqueVisitors(ctx, url, window)
+}
+
+// CountTopPages reads the ranking from memory, it's rebuilt from the snapshot and the log replayed on startup
+func (f *FileVisitRepository) CountTopPages(ctx context.Context, limit int, window time.Duration) ([]domain.PageCount, error) {
+	return f.mem.CountTopPages(ctx, limit, window)
+}
+
+// ListPages reads the sorted urls and counts from memory, they're rebuilt from the snapshot and the log replayed on startup
+func (f *FileVisitRepository) ListPages(ctx context.Context, query domain.PageQuery) ([]domain.PageCount, error) {
+	return f.mem.ListPages(ctx, query)
+}
+
+// ListVisitorPages reads the visitor history from memory, it's rebuilt from the snapshot and the log replayed on startup
+func (f *FileVisitRepository) ListVisitorPages(ctx context.Context, visitor string) ([]domain.VisitedPage, error) {
+	return f.mem.ListVisitorPages(ctx, visitor)
+}
+
+// EraseVisitor appends a tombstone of the visitor to the log and only then removes it from memory, so that replaying
+// the visits logged before it doesn't bring the visitor back. If the log can't be written the visitor is kept.
+// The visits stay on disk until the snapshots and log segments holding them are pruned
+func (f *FileVisitRepository) EraseVisitor(ctx context.Context, visitor string) (domain.ErasedPages, error) {
+	err := f.mem.m.Lock(ctx)
+	if err != nil {
+		return domain.ErasedPages{}, err
+	}
+
+	defer f.mem.m.Unlock()
+
+	err = f.log.appendErasure(f.opts.syncPolicy, visitor)
+	if err != nil {
+		return domain.ErasedPages{}, err
+	}
+
+	return f.mem.erase(visitor)
+}
+
+// RenameVisitors appends the move of each visitor to the log and only then moves it in memory, under a single lock. If
+// the log can't be written the visitor keeps its id, and so do the ones after it. Renames that wouldn't change anything
+// (e.g. the visitor was already moved) aren't logged
+func (f *FileVisitRepository) RenameVisitors(ctx context.Context, renames []domain.Rename) (domain.Count, error) {
+	err := f.mem.m.Lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	defer f.mem.m.Unlock()
+
+	var moved domain.Count
+	for _, rename := range renames {
+		changes, err := f.mem.renames(rename.From, rename.To)
+		if err != nil {
+			return moved, err
+		}
+
+		if !changes {
+			continue
+		}
+
+		err = f.log.appendRename(f.opts.syncPolicy, rename.From, rename.To)
+		if err != nil {
+			return moved, err
+		}
+
+		n, err := f.mem.rename(rename.From, rename.To)
+		moved += n
+
+		if err != nil {
+			return moved, err
+		}
+	}
+
+	return moved, nil
+}
+
+// CountUniqueVisitorsSeries reads the time buckets from memory, the log is only read on startup
+func (f *FileVisitRepository) CountUniqueVisitorsSeries(ctx context.Context, url domain.PageURL, interval time.Duration, from, to time.Time) ([]domain.Bucket, error) {
+	return f.mem.CountUniqueVisitorsSeries(ctx, url, interval, from, to)
+}
+
+// Expire drops the data older than the retention from memory (see InMemoryVisitRepository.Expire), nothing is logged:
+// the next snapshot leaves the data out and the log segments holding it are pruned along with the older snapshots.
+// Until then a restart brings the data back, to be dropped again by the next run
+func (f *FileVisitRepository) Expire(ctx context.Context, retention Retention) (Expired, error) {
+	expired, err := f.mem.Expire(ctx, retention)
+	if expired != (Expired{}) {
+		f.expired.Store(true)
+	}
+
+	return expired, err
+}
+
+// Snapshot writes a snapshot of the current data and drops the log segments no longer needed by the retained snapshots.
+// Writes are only blocked while the data is copied, not while the copy is written and synced to disk
+func (f *FileVisitRepository) Snapshot() error {
+	f.snapshotM.Lock()
+	defer f.snapshotM.Unlock()
+
+	seq, err := f.writeSnapshot()
+	if err != nil || seq == 0 {
+		return err
+	}
+
+	return f.prune()
+}
+
+// writeSnapshot rotates the log and writes the snapshot covering every segment before the new one,
+// returning the number of the new segment (0 if there was nothing new to snapshot)
+func (f *FileVisitRepository) writeSnapshot() (uint64, error) {
+	expired := f.expired.Swap(false)
+
+	seq, snapshot, err := f.copySnapshot(expired)
+	if err == nil && snapshot != nil {
+		err = writeFileAtomic(snapshotPath(f.dir, seq), snapshot)
+	}
+
+	if err != nil {
+		if expired {
+			f.expired.Store(true)
+		}
+
+		return 0, err
+	}
+
+	if snapshot == nil {
+		return 0, nil
+	}
+
+	f.snapshotted = seq
+
+	return seq, nil
+}
+
+// copySnapshot rotates the log and encodes the data in memory under the read lock, so that the copy matches the
+// segments before the new one. Writing the copy (and waiting for the disk) is left to the caller, without the lock: a
+// writer waiting for the lock would hold every reader queued behind it meanwhile. The copy is nil if there was nothing
+// new to snapshot
+func (f *FileVisitRepository) copySnapshot(expired bool) (uint64, []byte, error) {
+	// snapshots aren't tied to a request, so they wait for the lock for as long as it takes
+	_ = f.mem.m.RLock(context.Background())
+	defer f.mem.m.RUnlock()
+
+	if !expired && f.log.empty() && f.log.current() == f.snapshotted {
+		return 0, nil, nil
+	}
+
+	seq, err := f.log.rotate()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var snapshot bytes.Buffer
+
+	err = encodeSnapshot(&snapshot, f.mem)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return seq, snapshot.Bytes(), nil
+}
+
+// prune deletes the snapshots beyond the retention and the log segments covered by every remaining snapshot
+func (f *FileVisitRepository) prune() error {
+	snapshots, err := listSequence(f.dir, snapshotPrefix, snapshotSuffix)
+	if err != nil {
+		return err
+	}
+
+	if len(snapshots) <= f.opts.snapshotRetention {
+		return removeSegmentsBefore(f.dir, snapshots[0])
+	}
+
+	expired := snapshots[:len(snapshots)-f.opts.snapshotRetention]
+	for _, seq := range expired {
+		err := os.Remove(snapshotPath(f.dir, seq))
+		if err != nil {
+			return err
+		}
+	}
+
+	return removeSegmentsBefore(f.dir, snapshots[len(expired)])
+}
+
+// snapshotEvery writes a snapshot every interval until the repository is closed
+func (f *FileVisitRepository) snapshotEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-ticker.C:
+			err := f.Snapshot()
+			if err != nil {
+				log.Printf("unable to snapshot visits: %v", err)
+			}
+		}
+	}
+}
+
+// Close stops the background flushing and snapshotting and closes the log (and the spill file, if any), the repository
+// can't be used afterward
+func (f *FileVisitRepository) Close() error {
+	close(f.done)
+	f.wg.Wait()
+
+	_ = f.mem.m.Lock(context.Background())
+	defer f.mem.m.Unlock()
+
+	return errors.Join(f.log.close(), f.mem.Close())
+}
@@ -0,0 +1,510 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+func TestFileRepository(t *testing.T) {
+	type testCase struct {
+		description    string
+		policy         SyncPolicy
+		inputs         []domain.Visit
+		expectedCounts map[domain.PageURL]domain.Count
+	}
+
+	testCases := []testCase{
+		{
+			description: "visits survive a restart (sync always)",
+			policy:      SyncAlways,
+			inputs: []domain.Visit{
+				{Visitor: "id", PageURL: "url"},
+				{Visitor: "id2", PageURL: "url"},
+				{Visitor: "id2", PageURL: "url2"},
+			},
+			expectedCounts: map[domain.PageURL]domain.Count{
+				"url":  2,
+				"url2": 1,
+				"url3": 0,
+			},
+		},
+		{
+			description: "repeated visits are accounted for once (sync interval)",
+			policy:      SyncInterval,
+			inputs: []domain.Visit{
+				{Visitor: "id", PageURL: "url"},
+				{Visitor: "id", PageURL: "url"},
+				{Visitor: "id", PageURL: "url"},
+			},
+			expectedCounts: map[domain.PageURL]domain.Count{
+				"url": 1,
+			},
+		},
+		{
+			description: "visits survive a restart (sync never)",
+			policy:      SyncNever,
+			inputs: []domain.Visit{
+				{Visitor: "id", PageURL: "url"},
+			},
+			expectedCounts: map[domain.PageURL]domain.Count{
+				"url": 1,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dir := t.TempDir()
+
+			r, err := NewFileVisitRepository(dir, WithSyncPolicy(tc.policy, time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, visit := range tc.inputs {
+				err := r.Store(context.Background(), visit)
+				if err != nil {
+					t.Fatal("unexpected error", err)
+				}
+			}
+
+			err = r.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, err = NewFileVisitRepository(dir, WithSyncPolicy(tc.policy, time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer func() {
+				_ = r.Close()
+			}()
+
+			for k, v := range tc.expectedCounts {
+				counter, err := r.CountUniqueVisitors(context.Background(), k)
+				if err != nil {
+					t.Fatal("unexpected error", err)
+				}
+
+				if counter.Count != v {
+					t.Errorf("%s: got %v, expected %v", k, counter, v)
+				}
+			}
+		})
+	}
+}
+
+func TestFileRepositoryBatch(t *testing.T) {
+	dir := t.TempDir()
+
+	r, err := NewFileVisitRepository(dir, WithSyncPolicy(SyncAlways, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.Store(context.Background(), domain.Visit{Visitor: "id", PageURL: "url"})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	err = r.StoreBatch(context.Background(), []domain.Visit{
+		{Visitor: "id", PageURL: "url"},
+		{Visitor: "id2", PageURL: "url"},
+		{Visitor: "id", PageURL: "url2"},
+	})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	// the visit already accounted for is not logged again
+	if r.log.records != 3 {
+		t.Errorf("got %v records, expected %v", r.log.records, 3)
+	}
+
+	err = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = NewFileVisitRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = r.Close()
+	}()
+
+	for url, expected := range map[domain.PageURL]domain.Count{"url": 2, "url2": 1} {
+		counter, _ := r.CountUniqueVisitors(context.Background(), url)
+		if counter.Count != expected {
+			t.Errorf("%v: got %v, expected %v", url, counter.Count, expected)
+		}
+	}
+}
+
+func TestFileRepositoryTornWrite(t *testing.T) {
+	dir := t.TempDir()
+
+	r, err := NewFileVisitRepository(dir, WithSyncPolicy(SyncAlways, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, visit := range []domain.Visit{{Visitor: "id", PageURL: "url"}, {Visitor: "id2", PageURL: "url"}} {
+		err := r.Store(context.Background(), visit)
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+	}
+
+	err = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a crash in the middle of the last append
+	path := segmentPath(dir, 0)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Truncate(path, info.Size()-3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = NewFileVisitRepository(dir, WithSyncPolicy(SyncAlways, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counter, _ := r.CountUniqueVisitors(context.Background(), "url")
+	if counter.Count != 1 {
+		t.Errorf("got %v, expected %v", counter, 1)
+	}
+
+	// the torn record is dropped so new appends are readable
+	err = r.Store(context.Background(), domain.Visit{Visitor: "id3", PageURL: "url"})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	err = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = NewFileVisitRepository(dir, WithSyncPolicy(SyncAlways, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = r.Close()
+	}()
+
+	counter, _ = r.CountUniqueVisitors(context.Background(), "url")
+	if counter.Count != 2 {
+		t.Errorf("got %v, expected %v", counter, 2)
+	}
+}
+
+func TestFileRepositoryFailedWrite(t *testing.T) {
+	dir := t.TempDir()
+
+	r, err := NewFileVisitRepository(dir, WithSyncPolicy(SyncAlways, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.Store(context.Background(), domain.Visit{Visitor: "id", PageURL: "url"})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	// a write that fails half way leaves part of a record behind, which is cut off before the next one
+	_, err = r.log.file.Write([]byte{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.log.truncate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a visit that doesn't fit in a record is refused before anything is written
+	err = r.Store(context.Background(), domain.Visit{Visitor: string(make([]byte, maxRecordSize)), PageURL: "url"})
+	if domain.KindOf(err) != domain.KindTooLarge {
+		t.Errorf("got %v, expected %v", err, domain.KindTooLarge)
+	}
+
+	err = r.Store(context.Background(), domain.Visit{Visitor: "id2", PageURL: "url"})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	err = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = NewFileVisitRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = r.Close()
+	}()
+
+	counter, _ := r.CountUniqueVisitors(context.Background(), "url")
+	if counter.Count != 2 {
+		t.Errorf("got %v, expected %v", counter.Count, 2)
+	}
+}
+
+func TestFileRepositoryFailedRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	r, err := NewFileVisitRepository(dir, WithSyncPolicy(SyncAlways, 0), WithSnapshots(0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.Store(context.Background(), domain.Visit{Visitor: "id", PageURL: "url"})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	// the next segment can't be created, so the snapshot fails and the log keeps appending to the active one
+	err = os.Mkdir(segmentPath(dir, 1), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.Snapshot()
+	if err == nil {
+		t.Error("expected an error")
+	}
+
+	err = r.Store(context.Background(), domain.Visit{Visitor: "id2", PageURL: "url"})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	err = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Remove(segmentPath(dir, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = NewFileVisitRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = r.Close()
+	}()
+
+	counter, _ := r.CountUniqueVisitors(context.Background(), "url")
+	if counter.Count != 2 {
+		t.Errorf("got %v, expected %v", counter.Count, 2)
+	}
+}
+
+func TestFileRepositorySnapshots(t *testing.T) {
+	dir := t.TempDir()
+
+	open := func() *FileVisitRepository {
+		r, err := NewFileVisitRepository(dir, WithSyncPolicy(SyncNever, 0), WithSnapshots(0, 2))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return r
+	}
+
+	store := func(r *FileVisitRepository, visits ...domain.Visit) {
+		for _, visit := range visits {
+			err := r.Store(context.Background(), visit)
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+		}
+	}
+
+	expectCount := func(r *FileVisitRepository, url domain.PageURL, expected domain.Count) {
+		counter, err := r.CountUniqueVisitors(context.Background(), url)
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+
+		if counter.Count != expected {
+			t.Errorf("%s: got %v, expected %v", url, counter, expected)
+		}
+	}
+
+	r := open()
+	store(r, domain.Visit{Visitor: "id", PageURL: "url"}, domain.Visit{Visitor: "id2", PageURL: "url"})
+
+	for range 3 {
+		err := r.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		store(r, domain.Visit{Visitor: "id3", PageURL: "url"}, domain.Visit{Visitor: "id", PageURL: "url2"})
+	}
+
+	err := r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// snapshots with nothing new to cover are skipped, so only 2 were taken and both are retained
+	snapshots, _ := listSequence(dir, snapshotPrefix, snapshotSuffix)
+	if !slices.Equal(snapshots, []uint64{1, 2}) {
+		t.Errorf("got snapshots %v, expected %v", snapshots, []uint64{1, 2})
+	}
+
+	segments, _ := listSequence(dir, segmentPrefix, segmentSuffix)
+	if !slices.Equal(segments, []uint64{1, 2}) {
+		t.Errorf("got segments %v, expected %v", segments, []uint64{1, 2})
+	}
+
+	r = open()
+	expectCount(r, "url", 3)
+	expectCount(r, "url2", 1)
+
+	store(r, domain.Visit{Visitor: "id4", PageURL: "url"})
+
+	err = r.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// retention drops the oldest snapshot and the segment covered by every remaining one
+	snapshots, _ = listSequence(dir, snapshotPrefix, snapshotSuffix)
+	if !slices.Equal(snapshots, []uint64{2, 3}) {
+		t.Errorf("got snapshots %v, expected %v", snapshots, []uint64{2, 3})
+	}
+
+	segments, _ = listSequence(dir, segmentPrefix, segmentSuffix)
+	if !slices.Equal(segments, []uint64{2, 3}) {
+		t.Errorf("got segments %v, expected %v", segments, []uint64{2, 3})
+	}
+
+	// a corrupt snapshot falls back to the previous one and replays the remaining segments
+	err = os.WriteFile(snapshotPath(dir, 3), []byte("garbage"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r = open()
+	defer func() {
+		_ = r.Close()
+	}()
+
+	expectCount(r, "url", 4)
+	expectCount(r, "url2", 1)
+}
+
+func TestFileRepositorySnapshotsApproximate(t *testing.T) {
+	dir := t.TempDir()
+
+	r, err := NewFileVisitRepository(dir, WithSnapshots(0, 1), WithCounting(CountApproximate, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, visit := range []domain.Visit{{Visitor: "id", PageURL: "url"}, {Visitor: "id2", PageURL: "url"}} {
+		err := r.Store(context.Background(), visit)
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+	}
+
+	err = r.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.Store(context.Background(), domain.Visit{Visitor: "id3", PageURL: "url"})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	err = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = NewFileVisitRepository(dir, WithSnapshots(0, 1), WithCounting(CountApproximate, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = r.Close()
+	}()
+
+	expected := domain.UniqueVisitors{Count: 3, Estimated: true, ErrorBound: errorBound(10)}
+
+	counter, err := r.CountUniqueVisitors(context.Background(), "url")
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	if counter != expected {
+		t.Errorf("got %v, expected %v", counter, expected)
+	}
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	type testCase struct {
+		input       string
+		expected    SyncPolicy
+		expectedErr bool
+	}
+
+	testCases := []testCase{
+		{input: "always", expected: SyncAlways},
+		{input: "interval", expected: SyncInterval},
+		{input: "never", expected: SyncNever},
+		{input: "sometimes", expectedErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			policy, err := ParseSyncPolicy(tc.input)
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("got %v, expected error %v", err, tc.expectedErr)
+			}
+
+			if policy != tc.expected {
+				t.Errorf("got %v, expected %v", policy, tc.expected)
+			}
+		})
+	}
+}
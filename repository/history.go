@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// visitorHistory is the reverse of the data: a map of visitor ids (key) with the pages they were seen in (values),
+// along with when they were first and last seen in each of them. Times are only known for visits that have one.
+// It keeps every visitor id even when pages are counted with sketches, so it can be turned off (see
+// WithVisitorHistory), a nil history records nothing
+type visitorHistory map[visitorID]map[domain.PageURL]*seenRange
+
+// seenRange is when a visitor was first and last seen in a page, both are zero if none of the visits had a time
+type seenRange struct {
+	first time.Time
+	last  time.Time
+}
+
+// record accounts for the visit in the history of its visitor
+func (h visitorHistory) record(visit domain.Visit) {
+	if h == nil {
+		return
+	}
+
+	pages, found := h[visit.Visitor]
+	if !found {
+		pages = make(map[domain.PageURL]*seenRange)
+		h[visit.Visitor] = pages
+	}
+
+	seen, found := pages[visit.PageURL]
+	if !found {
+		seen = &seenRange{}
+		pages[visit.PageURL] = seen
+	}
+
+	seen.extend(visit.Time)
+}
+
+// covers reports whether recording the visit would leave the history unchanged, which it always does when it's turned off
+func (h visitorHistory) covers(visit domain.Visit) bool {
+	if h == nil {
+		return true
+	}
+
+	seen, found := h[visit.Visitor][visit.PageURL]
+	if !found {
+		return false
+	}
+
+	return visit.Time.IsZero() || (!visit.Time.Before(seen.first) && !visit.Time.After(seen.last))
+}
+
+// extend widens the range to include t, a zero t is ignored
+func (s *seenRange) extend(t time.Time) {
+	if t.IsZero() {
+		return
+	}
+
+	if s.first.IsZero() || t.Before(s.first) {
+		s.first = t
+	}
+
+	if t.After(s.last) {
+		s.last = t
+	}
+}
+
+// pages returns the pages the visitor was seen in, in the order they were first seen in (pages without a time last,
+// ties by url)
+func (h visitorHistory) pages(visitor visitorID) []domain.VisitedPage {
+	pages := make([]domain.VisitedPage, 0, len(h[visitor]))
+	for url, seen := range h[visitor] {
+		pages = append(pages, domain.VisitedPage{PageURL: url, FirstSeen: seen.first, LastSeen: seen.last})
+	}
+
+	slices.SortFunc(pages, domain.ByFirstSeen)
+
+	return pages
+}
+
+// ListVisitorPages returns the pages the visitor was seen in along with when, in the order they were first seen in.
+// It fails with domain.ErrHistoryDisabled if the repository was built without the visitor history
+func (i *InMemoryVisitRepository) ListVisitorPages(ctx context.Context, visitor string) ([]domain.VisitedPage, error) {
+	if i.history == nil {
+		return nil, domain.ErrHistoryDisabled
+	}
+
+	err := i.m.RLock(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer i.m.RUnlock()
+
+	return i.history.pages(visitor), nil
+}
@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+func TestVisitorHistory(t *testing.T) {
+	base := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	visits := []domain.Visit{
+		{Visitor: "a", PageURL: "/blog", Time: base.Add(time.Minute)},
+		{Visitor: "a", PageURL: "/about", Time: base},
+		{Visitor: "a", PageURL: "/blog", Time: base.Add(3 * time.Minute)},
+		// visits aren't always received in order
+		{Visitor: "a", PageURL: "/blog", Time: base.Add(2 * time.Minute)},
+		{Visitor: "a", PageURL: "/legacy"},
+		{Visitor: "b", PageURL: "/shop", Time: base},
+	}
+
+	expected := []domain.VisitedPage{
+		{PageURL: "/about", FirstSeen: base, LastSeen: base},
+		{PageURL: "/blog", FirstSeen: base.Add(time.Minute), LastSeen: base.Add(3 * time.Minute)},
+		{PageURL: "/legacy"},
+	}
+
+	t.Run("in memory", func(t *testing.T) {
+		i := NewVisitsInMemoryRepository(WithVisitorHistory(true), withClock(&clock{now: base}))
+
+		err := i.StoreBatch(context.Background(), visits)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pages, err := i.ListVisitorPages(context.Background(), "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(pages, expected) {
+			t.Errorf("got %v, expected %v", pages, expected)
+		}
+
+		pages, err = i.ListVisitorPages(context.Background(), "unknown")
+		if err != nil || len(pages) != 0 {
+			t.Errorf("got %v and %v, expected no pages", pages, err)
+		}
+	})
+
+	t.Run("survives a restart, from the snapshot and the log", func(t *testing.T) {
+		dir := t.TempDir()
+
+		r, err := NewFileVisitRepository(dir, WithVisitorHistory(true), WithSnapshots(0, 1), withClock(&clock{now: base}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, visit := range visits[:3] {
+			err := r.Store(context.Background(), visit)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err = r.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = r.StoreBatch(context.Background(), visits[3:])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err = NewFileVisitRepository(dir, WithVisitorHistory(true), WithSnapshots(0, 1), withClock(&clock{now: base}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer func() {
+			_ = r.Close()
+		}()
+
+		pages, err := r.ListVisitorPages(context.Background(), "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(pages, expected) {
+			t.Errorf("got %v, expected %v", pages, expected)
+		}
+	})
+
+	// visits of a visitor already counted in the page (in the same hour, or a colliding one of a sketch) change the
+	// history all the same, they must be logged
+	for description, counting := range map[string]CountingMode{"exact": CountExact, "approximate": CountApproximate} {
+		t.Run("repeated visits survive a restart from the log, "+description, func(t *testing.T) {
+			dir := t.TempDir()
+			opts := []Option{WithVisitorHistory(true), WithSnapshots(0, 1), WithCounting(counting, MinPrecision), withClock(&clock{now: base})}
+
+			r, err := NewFileVisitRepository(dir, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var repeated []domain.Visit
+			for visitor := range 50 {
+				repeated = append(repeated,
+					domain.Visit{Visitor: fmt.Sprintf("v%d", visitor), PageURL: "/blog", Time: base.Add(5 * time.Minute)},
+					domain.Visit{Visitor: fmt.Sprintf("v%d", visitor), PageURL: "/blog", Time: base.Add(55 * time.Minute)},
+				)
+			}
+
+			for _, visit := range repeated {
+				err := r.Store(context.Background(), visit)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = r.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, err = NewFileVisitRepository(dir, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer func() {
+				_ = r.Close()
+			}()
+
+			for visitor := range 50 {
+				pages, err := r.ListVisitorPages(context.Background(), fmt.Sprintf("v%d", visitor))
+				expected := []domain.VisitedPage{{PageURL: "/blog", FirstSeen: base.Add(5 * time.Minute), LastSeen: base.Add(55 * time.Minute)}}
+				if err != nil || !reflect.DeepEqual(pages, expected) {
+					t.Errorf("v%d: got %v and %v, expected %v", visitor, pages, err, expected)
+				}
+			}
+		})
+	}
+
+	t.Run("turned off", func(t *testing.T) {
+		i := NewVisitsInMemoryRepository(WithVisitorHistory(false))
+
+		err := i.StoreBatch(context.Background(), visits)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = i.ListVisitorPages(context.Background(), "a")
+		if !errors.Is(err, domain.ErrHistoryDisabled) {
+			t.Errorf("got %v, expected %v", err, domain.ErrHistoryDisabled)
+		}
+	})
+}
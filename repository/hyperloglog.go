@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+
+	"deus.ai-code-challenge/domain"
+)
+
+const (
+	// MinPrecision and MaxPrecision bound the precision of the HyperLogLog sketches, a sketch of precision p uses
+	// 2^p registers (one byte each) and has a relative standard error of 1.04/sqrt(2^p)
+	MinPrecision = 4
+	MaxPrecision = 18
+
+	// DefaultPrecision uses 16KiB per sketch for an error of ~0.8%
+	DefaultPrecision = 14
+)
+
+// hyperLogLog is a HyperLogLog sketch, it estimates the number of unique visitors using a fixed amount of memory
+// no matter how many visitors are added to it. Each visitor id is hashed, the first p bits of the hash select a
+// register and the register keeps the longest run of leading zeros (+1) seen in the remaining bits.
+type hyperLogLog struct {
+	precision uint8
+	registers []uint8
+}
+
+func newHyperLogLog(precision uint8) *hyperLogLog {
+	return &hyperLogLog{
+		precision: precision,
+		registers: make([]uint8, 1<<precision),
+	}
+}
+
+// position returns the register selected by the visitor and the value it would hold for it
+func (h *hyperLogLog) position(visitor visitorID) (uint64, uint8) {
+	hash := hashVisitor(visitor)
+
+	index := hash >> (64 - h.precision)
+	rank := uint8(bits.LeadingZeros64(hash<<h.precision|1<<(h.precision-1))) + 1
+
+	return index, rank
+}
+
+func (h *hyperLogLog) add(visitor visitorID) bool {
+	index, rank := h.position(visitor)
+	if h.registers[index] >= rank {
+		return false
+	}
+
+	h.registers[index] = rank
+
+	return true
+}
+
+func (h *hyperLogLog) accounts(visitor visitorID) bool {
+	index, rank := h.position(visitor)
+
+	return h.registers[index] >= rank
+}
+
+// remove can't forget the visitor: registers only keep the largest rank hashed into them, so there's no telling which
+// visitors set them. The sketch doesn't hold the visitor id either, only a hash of it
+func (h *hyperLogLog) remove(visitor visitorID) bool {
+	return false
+}
+
+func (h *hyperLogLog) estimate() domain.UniqueVisitors {
+	m := float64(len(h.registers))
+
+	var sum float64
+	var zeros int
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	e := alpha(len(h.registers)) * m * m / sum
+
+	// small range correction, linear counting is more accurate while there are empty registers
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+
+	return domain.UniqueVisitors{
+		Count:      domain.Count(math.Round(e)),
+		Estimated:  true,
+		ErrorBound: errorBound(h.precision),
+	}
+}
+
+// errorBound returns the relative standard error of a sketch with the given precision
+func errorBound(precision uint8) float64 {
+	return 1.04 / math.Sqrt(float64(uint64(1)<<precision))
+}
+
+func alpha(m int) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	default:
+		return 0.7213 / (1 + 1.079/float64(m))
+	}
+}
+
+// hashVisitor hashes the visitor id into 64 well distributed bits, fnv alone doesn't mix the high bits enough
+// (which select the register) so the result goes through the murmur3 finalizer. The hash must be stable across
+// restarts since sketches are persisted
+func hashVisitor(visitor visitorID) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(visitor))
+
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+
+	return x
+}
+
+// merge adds every visitor of other to the sketch, other must have the same or a higher precision
+func (h *hyperLogLog) merge(other *hyperLogLog) {
+	if other.precision == h.precision {
+		for i, r := range other.registers {
+			h.registers[i] = max(h.registers[i], r)
+		}
+
+		return
+	}
+
+	// folding a higher precision sketch: the extra index bits become the leading bits of the remaining hash
+	shift := other.precision - h.precision
+	for i, r := range other.registers {
+		if r == 0 {
+			continue
+		}
+
+		dropped := uint64(i) & (1<<shift - 1)
+
+		rank := r + shift
+		if dropped != 0 {
+			rank = uint8(bits.LeadingZeros64(dropped<<(64-shift))) + 1
+		}
+
+		index := i >> shift
+		h.registers[index] = max(h.registers[index], rank)
+	}
+}
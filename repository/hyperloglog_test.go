@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	type testCase struct {
+		description string
+		precision   uint8
+		visitors    int
+		repetitions int
+	}
+
+	testCases := []testCase{
+		{description: "empty", precision: DefaultPrecision, visitors: 0, repetitions: 1},
+		{description: "small count", precision: DefaultPrecision, visitors: 100, repetitions: 3},
+		{description: "large count", precision: DefaultPrecision, visitors: 200_000, repetitions: 1},
+		{description: "low precision", precision: 10, visitors: 50_000, repetitions: 2},
+		{description: "min precision", precision: MinPrecision, visitors: 1_000, repetitions: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			h := newHyperLogLog(tc.precision)
+
+			for range tc.repetitions {
+				for i := range tc.visitors {
+					h.add("visitor-" + strconv.Itoa(i))
+				}
+			}
+
+			estimate := h.estimate()
+			if !estimate.Estimated {
+				t.Error("expected the count to be estimated")
+			}
+
+			if estimate.ErrorBound != errorBound(tc.precision) {
+				t.Errorf("got error bound %v, expected %v", estimate.ErrorBound, errorBound(tc.precision))
+			}
+
+			// 4 standard errors makes the test deterministic in practice, the hash is stable
+			allowed := 4 * estimate.ErrorBound * float64(tc.visitors)
+			if math.Abs(float64(estimate.Count)-float64(tc.visitors)) > allowed {
+				t.Errorf("got %v, expected %v ± %v", estimate.Count, tc.visitors, allowed)
+			}
+		})
+	}
+}
+
+func TestHyperLogLogAccounts(t *testing.T) {
+	h := newHyperLogLog(DefaultPrecision)
+
+	if h.accounts("id") {
+		t.Error("empty sketch should not account for any visitor")
+	}
+
+	if !h.add("id") {
+		t.Error("adding a visitor to an empty sketch should change it")
+	}
+
+	if !h.accounts("id") {
+		t.Error("sketch should account for an added visitor")
+	}
+
+	if h.add("id") {
+		t.Error("adding the same visitor twice should not change the sketch")
+	}
+}
@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"time"
+)
+
+// Expirer is implemented by the repositories that can drop the data older than a retention
+type Expirer interface {
+	Expire(ctx context.Context, retention Retention) (Expired, error)
+}
+
+// Janitor enforces a retention on a repository in the background, what it removes is logged and added to its metrics
+type Janitor struct {
+	repo      Expirer
+	retention Retention
+	interval  time.Duration
+	metrics   *expvar.Map
+}
+
+// NewJanitor is a constructor for the Janitor, the retention is enforced every interval
+func NewJanitor(repo Expirer, retention Retention, interval time.Duration) *Janitor {
+	return &Janitor{
+		repo:      repo,
+		retention: retention,
+		interval:  interval,
+		metrics:   new(expvar.Map).Init(),
+	}
+}
+
+// Metrics returns the totals of what was removed since the janitor started (pages, buckets, visitors and history),
+// along with the number of runs, the failed ones and how long the last run took (last_run_ms). They can be published
+// with expvar.Publish
+func (j *Janitor) Metrics() *expvar.Map {
+	return j.metrics
+}
+
+// Run enforces the retention every interval until ctx is done
+func (j *Janitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, _ = j.RunOnce(ctx)
+		}
+	}
+}
+
+// RunOnce enforces the retention right away, reporting what was removed
+func (j *Janitor) RunOnce(ctx context.Context) (Expired, error) {
+	started := time.Now()
+
+	expired, err := j.repo.Expire(ctx, j.retention)
+
+	elapsed := time.Since(started)
+
+	j.metrics.Add("runs", 1)
+	j.metrics.Add("pages", int64(expired.Pages))
+	j.metrics.Add("buckets", int64(expired.Buckets))
+	j.metrics.Add("visitors", int64(expired.Visitors))
+	j.metrics.Add("history", int64(expired.History))
+
+	lastRun := new(expvar.Int)
+	lastRun.Set(elapsed.Milliseconds())
+	j.metrics.Set("last_run_ms", lastRun)
+
+	if err != nil {
+		j.metrics.Add("failures", 1)
+		log.Printf("retention stopped after %v, having removed %d pages, %d buckets, %d visitors and %d history entries: %v",
+			elapsed, expired.Pages, expired.Buckets, expired.Visitors, expired.History, err)
+
+		return expired, err
+	}
+
+	log.Printf("retention removed %d pages, %d buckets, %d visitors and %d history entries in %v",
+		expired.Pages, expired.Buckets, expired.Visitors, expired.History, elapsed)
+
+	return expired, nil
+}
@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"container/heap"
+	"context"
+	"slices"
+	"strings"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// sortedChunkSize is the number of page urls a chunk of sortedPages is split at
+const sortedChunkSize = 512
+
+// sortedPages keeps the page urls in order, so they can be listed from any url without sorting every page. Urls are
+// kept in sorted chunks of up to sortedChunkSize urls, a new url only shifts the urls of its chunk and the chunk it
+// goes in is found with a binary search
+type sortedPages struct {
+	chunks [][]domain.PageURL
+}
+
+// insert adds the url, unless it's already there
+func (s *sortedPages) insert(url domain.PageURL) {
+	if len(s.chunks) == 0 {
+		s.chunks = [][]domain.PageURL{{url}}
+
+		return
+	}
+
+	// the url goes in the first chunk whose last url isn't before it, or in the last chunk if it's after every url
+	c, _ := slices.BinarySearchFunc(s.chunks, url, func(chunk []domain.PageURL, url domain.PageURL) int {
+		return strings.Compare(chunk[len(chunk)-1], url)
+	})
+	c = min(c, len(s.chunks)-1)
+
+	chunk := s.chunks[c]
+
+	position, found := slices.BinarySearch(chunk, url)
+	if found {
+		return
+	}
+
+	chunk = slices.Insert(chunk, position, url)
+	if len(chunk) <= sortedChunkSize {
+		s.chunks[c] = chunk
+
+		return
+	}
+
+	half := len(chunk) / 2
+	s.chunks[c] = slices.Clip(chunk[:half])
+	s.chunks = slices.Insert(s.chunks, c+1, slices.Clone(chunk[half:]))
+}
+
+// remove drops the url, a chunk left empty is dropped along
+func (s *sortedPages) remove(url domain.PageURL) {
+	c, _ := slices.BinarySearchFunc(s.chunks, url, func(chunk []domain.PageURL, url domain.PageURL) int {
+		return strings.Compare(chunk[len(chunk)-1], url)
+	})
+	if c == len(s.chunks) {
+		return
+	}
+
+	position, found := slices.BinarySearch(s.chunks[c], url)
+	if !found {
+		return
+	}
+
+	s.chunks[c] = slices.Delete(s.chunks[c], position, position+1)
+	if len(s.chunks[c]) == 0 {
+		s.chunks = slices.Delete(s.chunks, c, c+1)
+	}
+}
+
+// ascend calls fn with every url from the given one (included) onward in order, until fn returns false
+func (s *sortedPages) ascend(from domain.PageURL, fn func(domain.PageURL) bool) {
+	c, _ := slices.BinarySearchFunc(s.chunks, from, func(chunk []domain.PageURL, url domain.PageURL) int {
+		return strings.Compare(chunk[len(chunk)-1], url)
+	})
+
+	for ; c < len(s.chunks); c++ {
+		chunk := s.chunks[c]
+
+		position, _ := slices.BinarySearch(chunk, from)
+		for _, url := range chunk[position:] {
+			if !fn(url) {
+				return
+			}
+		}
+
+		from = ""
+	}
+}
+
+// ListPages lists the pages whose url starts with the prefix along with their count, in the order of the query and
+// starting after query.After. Pages are listed by url straight from the sorted urls; listing by count has to look at
+// every page under the prefix, but at most limit of them are kept while doing so
+func (i *InMemoryVisitRepository) ListPages(ctx context.Context, query domain.PageQuery) ([]domain.PageCount, error) {
+	if query.Limit <= 0 {
+		return []domain.PageCount{}, nil
+	}
+
+	err := i.m.RLock(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer i.m.RUnlock()
+
+	if query.Order == domain.OrderByCount {
+		return i.listByCount(query), nil
+	}
+
+	return i.listByURL(query), nil
+}
+
+// listByURL lists the pages in url order, the caller must hold the lock
+func (i *InMemoryVisitRepository) listByURL(query domain.PageQuery) []domain.PageCount {
+	from := query.Prefix
+	if query.After != nil && query.After.PageURL > from {
+		from = query.After.PageURL
+	}
+
+	pages := make([]domain.PageCount, 0, query.Limit)
+
+	i.sorted.ascend(from, func(url domain.PageURL) bool {
+		if !strings.HasPrefix(url, query.Prefix) || len(pages) == query.Limit {
+			return false
+		}
+
+		if query.After == nil || url != query.After.PageURL {
+			pages = append(pages, domain.PageCount{PageURL: url, UniqueVisitors: i.uniqueVisitors(url)})
+		}
+
+		return true
+	})
+
+	return pages
+}
+
+// listByCount lists the pages from the most visited (ties by url), the caller must hold the lock
+func (i *InMemoryVisitRepository) listByCount(query domain.PageQuery) []domain.PageCount {
+	kept := &lowest{}
+
+	i.sorted.ascend(query.Prefix, func(url domain.PageURL) bool {
+		if !strings.HasPrefix(url, query.Prefix) {
+			return false
+		}
+
+		page := domain.PageCount{PageURL: url, UniqueVisitors: i.uniqueVisitors(url)}
+
+		switch {
+		case query.After != nil && !ranksAbove(*query.After, page):
+		case kept.Len() < query.Limit:
+			heap.Push(kept, page)
+		case ranksAbove(page, (*kept)[0]):
+			(*kept)[0] = page
+			heap.Fix(kept, 0)
+		}
+
+		return true
+	})
+
+	pages := make([]domain.PageCount, kept.Len())
+	for i := len(pages) - 1; i >= 0; i-- {
+		pages[i] = heap.Pop(kept).(domain.PageCount)
+	}
+
+	return pages
+}
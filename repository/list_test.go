@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"testing"
+
+	"deus.ai-code-challenge/domain"
+)
+
+func TestSortedPages(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	s := &sortedPages{}
+	expected := make([]domain.PageURL, 0, 5000)
+
+	// enough urls to split chunks several times, inserted in random order and some of them twice
+	for _, n := range rng.Perm(5000) {
+		url := fmt.Sprintf("/page-%05d", n)
+		s.insert(url)
+		s.insert(url)
+		expected = append(expected, url)
+	}
+
+	slices.Sort(expected)
+
+	for _, from := range []domain.PageURL{"", "/page-01234", "/page-01234x", "/page-99999"} {
+		var got []domain.PageURL
+		s.ascend(from, func(url domain.PageURL) bool {
+			got = append(got, url)
+
+			return true
+		})
+
+		start, _ := slices.BinarySearch(expected, from)
+		if !slices.Equal(got, expected[start:]) {
+			t.Errorf("got %v urls from %v, expected %v", len(got), from, len(expected[start:]))
+		}
+	}
+}
+
+func TestInMemoryRepositoryListPages(t *testing.T) {
+	page := func(url domain.PageURL, count domain.Count) domain.PageCount {
+		return domain.PageCount{PageURL: url, UniqueVisitors: domain.UniqueVisitors{Count: count}}
+	}
+
+	type testCase struct {
+		description   string
+		query         domain.PageQuery
+		expectedPages []domain.PageCount
+	}
+
+	last := page("/blog/b", 1)
+
+	testCases := []testCase{
+		{
+			description:   "by url",
+			query:         domain.PageQuery{Order: domain.OrderByURL, Limit: 3},
+			expectedPages: []domain.PageCount{page("/about", 1), page("/blog", 3), page("/blog/a", 2)},
+		},
+		{
+			description:   "by url with a prefix, after a page",
+			query:         domain.PageQuery{Prefix: "/blog", Order: domain.OrderByURL, After: &domain.PageCount{PageURL: "/blog"}, Limit: 3},
+			expectedPages: []domain.PageCount{page("/blog/a", 2), page("/blog/b", 1)},
+		},
+		{
+			description:   "by count",
+			query:         domain.PageQuery{Order: domain.OrderByCount, Limit: 2},
+			expectedPages: []domain.PageCount{page("/blog", 3), page("/blog/a", 2)},
+		},
+		{
+			description:   "by count after a page, ties by url",
+			query:         domain.PageQuery{Order: domain.OrderByCount, After: &domain.PageCount{PageURL: "/blog/a", UniqueVisitors: domain.UniqueVisitors{Count: 2}}, Limit: 5},
+			expectedPages: []domain.PageCount{page("/about", 1), page("/blog/b", 1), page("/shop", 1)},
+		},
+		{
+			description:   "after the last page",
+			query:         domain.PageQuery{Prefix: "/blog/", Order: domain.OrderByCount, After: &last, Limit: 5},
+			expectedPages: []domain.PageCount{},
+		},
+	}
+
+	i := NewVisitsInMemoryRepository()
+
+	err := i.StoreBatch(context.Background(), []domain.Visit{
+		{Visitor: "a", PageURL: "/blog"},
+		{Visitor: "b", PageURL: "/blog"},
+		{Visitor: "c", PageURL: "/blog"},
+		{Visitor: "a", PageURL: "/blog/a"},
+		{Visitor: "b", PageURL: "/blog/a"},
+		{Visitor: "a", PageURL: "/blog/b"},
+		{Visitor: "a", PageURL: "/about"},
+		{Visitor: "a", PageURL: "/shop"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			pages, err := i.ListPages(context.Background(), tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(pages, tc.expectedPages) {
+				t.Errorf("got %v, expected %v", pages, tc.expectedPages)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"sync"
+)
+
+// rwLock is a readers/writer lock whose callers can give up waiting for it when their context is done, e.g. when
+// the client disconnects or the server is shutting down. Like sync.RWMutex, a writer waiting for the lock blocks new
+// readers so that writers aren't starved. The zero value is an unlocked lock
+type rwLock struct {
+	m       sync.Mutex
+	readers int
+	writer  bool
+	// writers is the number of writers waiting for the lock, waiting the number of callers (readers or writers)
+	// waiting for changed to be closed
+	writers int
+	waiting int
+	changed chan struct{}
+}
+
+// Lock takes the lock for writing, returning the context error if it's done before the lock is taken
+func (l *rwLock) Lock(ctx context.Context) error {
+	l.m.Lock()
+	l.writers++
+
+	for l.writer || l.readers > 0 {
+		err := l.wait(ctx)
+		if err != nil {
+			l.writers--
+			// readers held back by this writer may be able to go ahead now
+			l.broadcast()
+			l.m.Unlock()
+
+			return err
+		}
+	}
+
+	l.writers--
+	l.writer = true
+	l.m.Unlock()
+
+	return nil
+}
+
+// Unlock releases the lock taken for writing
+func (l *rwLock) Unlock() {
+	l.m.Lock()
+	l.writer = false
+	l.broadcast()
+	l.m.Unlock()
+}
+
+// RLock takes the lock for reading, returning the context error if it's done before the lock is taken
+func (l *rwLock) RLock(ctx context.Context) error {
+	l.m.Lock()
+
+	for l.writer || l.writers > 0 {
+		err := l.wait(ctx)
+		if err != nil {
+			l.m.Unlock()
+
+			return err
+		}
+	}
+
+	l.readers++
+	l.m.Unlock()
+
+	return nil
+}
+
+// RUnlock releases the lock taken for reading
+func (l *rwLock) RUnlock() {
+	l.m.Lock()
+	l.readers--
+	if l.readers == 0 {
+		l.broadcast()
+	}
+	l.m.Unlock()
+}
+
+// wait releases l.m until the state of the lock changes or the context is done, l.m is held again when it returns
+func (l *rwLock) wait(ctx context.Context) error {
+	if l.changed == nil {
+		l.changed = make(chan struct{})
+	}
+
+	changed := l.changed
+	l.waiting++
+	l.m.Unlock()
+
+	var err error
+	select {
+	case <-changed:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.m.Lock()
+	l.waiting--
+
+	return err
+}
+
+// broadcast wakes up every caller waiting for the lock, the caller must hold l.m. Nothing is allocated when no one is
+// waiting, so an uncontended lock stays cheap
+func (l *rwLock) broadcast() {
+	if l.waiting == 0 || l.changed == nil {
+		return
+	}
+
+	close(l.changed)
+	l.changed = nil
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+func TestRWLock(t *testing.T) {
+	l := &rwLock{}
+
+	err := l.RLock(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// readers share the lock
+	err = l.RLock(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err = l.Lock(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, expected %v", err, context.DeadlineExceeded)
+	}
+
+	// the writer that gave up no longer holds back readers
+	err = l.RLock(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 3 {
+		l.RUnlock()
+	}
+
+	err = l.Lock(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locked := make(chan error)
+	go func() {
+		locked <- l.RLock(context.Background())
+	}()
+
+	select {
+	case err := <-locked:
+		t.Fatalf("got %v, expected the reader to wait for the writer", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	l.Unlock()
+
+	err = <-locked
+	if err != nil {
+		t.Errorf("got %v, expected no error", err)
+	}
+
+	l.RUnlock()
+}
+
+func TestInMemoryVisitRepositoryCancellation(t *testing.T) {
+	i := NewVisitsInMemoryRepository()
+
+	err := i.m.Lock(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stored := make(chan error)
+	go func() {
+		stored <- i.Store(ctx, domain.Visit{Visitor: "a", PageURL: "/blog"})
+	}()
+
+	cancel()
+
+	err = <-stored
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, expected %v", err, context.Canceled)
+	}
+
+	i.m.Unlock()
+
+	count, err := i.CountUniqueVisitors(context.Background(), "/blog")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count.Count != 0 {
+		t.Errorf("got %v, expected the cancelled visit not to be stored", count.Count)
+	}
+}
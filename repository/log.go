@@ -0,0 +1,663 @@
+package repository
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// SyncPolicy defines when the visit log is flushed to stable storage (fsync)
+type SyncPolicy int
+
+const (
+	// SyncAlways flushes the log after every write, no acknowledged visit is ever lost
+	SyncAlways SyncPolicy = iota
+	// SyncInterval flushes the log periodically, at most one interval worth of visits is lost if the machine crashes
+	SyncInterval
+	// SyncNever leaves flushing to the operating system
+	SyncNever
+)
+
+// ParseSyncPolicy converts the textual representation of a policy (always, interval or never) into a SyncPolicy
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch s {
+	case "always":
+		return SyncAlways, nil
+	case "interval":
+		return SyncInterval, nil
+	case "never":
+		return SyncNever, nil
+	default:
+		return 0, fmt.Errorf("unknown fsync policy: %s", s)
+	}
+}
+
+const (
+	// opStore identifies a record holding a new (visitor, page) pair without the time of the visit
+	opStore byte = 1
+	// opVisit identifies a record holding a (visitor, page) pair and the time of the visit
+	opVisit byte = 2
+	// opErase identifies a tombstone: the visitor was erased, none of its visits logged before count anymore
+	opErase byte = 3
+	// opRename identifies a record moving every visit of a visitor to a new id (e.g. a new pseudonym)
+	opRename byte = 4
+
+	// recordHeaderSize is the size of the length + checksum prefix of each record
+	recordHeaderSize = 8
+
+	// maxRecordSize bounds the payload of a record: larger ones are refused when written, and replay doesn't allocate
+	// huge buffers when reading a corrupt length
+	maxRecordSize = 1 << 20
+)
+
+var (
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	errCorruptRecord = errors.New("corrupt log record")
+
+	// errRecordTooLarge is returned when a record is larger than maxRecordSize, replay would take it for corruption
+	errRecordTooLarge = &domain.Error{Kind: domain.KindTooLarge, Msg: fmt.Sprintf("visit can't take more than %d bytes", maxRecordSize)}
+)
+
+// visitLog is an append-only write-ahead log of visits, split into numbered segment files so that the entries
+// covered by a snapshot can be dropped by deleting whole files. Each record is written as:
+//   - payload length, uint32 little endian
+//   - payload checksum (crc32 castagnoli), uint32 little endian
+//   - payload: operation, visitor id and page url (both prefixed by their uvarint length) and, for opVisit,
+//     the time of the visit (unix nanoseconds, varint). An opErase payload only holds the operation and visitor id,
+//     an opRename one the operation, the visitor id and its new id
+//
+// The checksum allows replay to detect a torn write at the tail of the file (e.g. a crash in the middle of an append),
+// in which case the active segment is truncated to the last complete record. A write that fails is cut off right away,
+// so the records appended after it aren't lost behind a torn one.
+type visitLog struct {
+	m       sync.Mutex
+	dir     string
+	seq     uint64
+	file    *os.File
+	size    int64
+	dirty   bool
+	records int
+}
+
+// logRecord is a record read back from the log, op tells what it holds: a visit (opStore or opVisit), the tombstone
+// of visit.Visitor (opErase) or the move of visit.Visitor to the id in to (opRename)
+type logRecord struct {
+	op    byte
+	visit domain.Visit
+	to    string
+}
+
+const (
+	segmentPrefix = "visits-"
+	segmentSuffix = ".log"
+
+	// legacyLogFileName is the single log file used before the log was split into segments
+	legacyLogFileName = "visits.log"
+)
+
+func segmentPath(dir string, seq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%020d%s", segmentPrefix, seq, segmentSuffix))
+}
+
+// openVisitLog replays, in order, every segment in dir numbered from onwards into apply (stopping at the first error
+// it returns) and opens the last one for appending. Segments numbered before from are ignored since they are already covered by a snapshot
+func openVisitLog(dir string, from uint64, apply func(logRecord) error) (*visitLog, error) {
+	err := migrateLegacyLog(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	segments, err := listSequence(dir, segmentPrefix, segmentSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	segments = slices.DeleteFunc(segments, func(seq uint64) bool { return seq < from })
+	if len(segments) == 0 {
+		segments = []uint64{from}
+	}
+
+	last := len(segments) - 1
+	for _, seq := range segments[:last] {
+		err := replaySegment(segmentPath(dir, seq), apply)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return openSegment(dir, segments[last], apply)
+}
+
+// migrateLegacyLog renames the single log file written by older versions into the first segment
+func migrateLegacyLog(dir string) error {
+	legacy := filepath.Join(dir, legacyLogFileName)
+
+	_, err := os.Stat(legacy)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(legacy, segmentPath(dir, 0))
+}
+
+// replaySegment applies every complete record of the segment in path, a corrupt record ends the replay of the segment
+func replaySegment(path string, apply func(logRecord) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	offset, _, err := replayLog(file, apply)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if offset != info.Size() {
+		log.Printf("visit log segment %s is corrupt after offset %d, ignoring the remaining records", path, offset)
+	}
+
+	return nil
+}
+
+// openSegment replays and opens the segment for appending
+func openSegment(dir string, seq uint64, apply func(logRecord) error) (*visitLog, error) {
+	path := segmentPath(dir, seq)
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	offset, records, err := replayLog(file, apply)
+	if err != nil {
+		_ = file.Close()
+
+		return nil, err
+	}
+
+	// drop whatever was left after the last complete record, the append offset is then the end of the file
+	err = file.Truncate(offset)
+	if err != nil {
+		_ = file.Close()
+
+		return nil, err
+	}
+
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		_ = file.Close()
+
+		return nil, err
+	}
+
+	return &visitLog{dir: dir, seq: seq, file: file, size: offset, records: records}, nil
+}
+
+// replayLog reads every record in r, returning the offset right after the last complete record
+// and the number of records read
+func replayLog(r io.Reader, apply func(logRecord) error) (int64, int, error) {
+	reader := bufio.NewReader(r)
+
+	var offset int64
+	var records int
+	for {
+		record, n, err := readRecord(reader)
+		switch {
+		case errors.Is(err, io.EOF):
+			return offset, records, nil
+		case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, errCorruptRecord):
+			return offset, records, nil
+		case err != nil:
+			return 0, 0, err
+		}
+
+		err = apply(record)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		offset += int64(n)
+		records++
+	}
+}
+
+// rotate flushes the active segment and starts a new one, returning its number. Every record appended before the call
+// lives in a segment numbered below the returned value. The new segment is created before the active one is given up,
+// so the log keeps appending to the active one if anything fails
+func (l *visitLog) rotate() (uint64, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	path := segmentPath(l.dir, l.seq+1)
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return 0, err
+	}
+
+	err = errors.Join(syncDir(l.dir), l.file.Sync())
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(path)
+
+		return 0, err
+	}
+
+	previous := l.file
+
+	l.seq++
+	l.file = file
+	l.size = 0
+	l.dirty = false
+	l.records = 0
+
+	// the previous segment was flushed, failing to close it loses nothing
+	err = previous.Close()
+	if err != nil {
+		log.Printf("unable to close visit log segment %d: %v", l.seq-1, err)
+	}
+
+	return l.seq, nil
+}
+
+// empty reports whether nothing was appended to the active segment
+func (l *visitLog) empty() bool {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	return l.records == 0
+}
+
+// current returns the number of the active segment
+func (l *visitLog) current() uint64 {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	return l.seq
+}
+
+// removeSegmentsBefore deletes every segment numbered below seq
+func removeSegmentsBefore(dir string, seq uint64) error {
+	segments, err := listSequence(dir, segmentPrefix, segmentSuffix)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range segments {
+		if s >= seq {
+			break
+		}
+
+		err := os.Remove(segmentPath(dir, s))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listSequence returns, in ascending order, the numbers of the files in dir named prefix<number>suffix
+func listSequence(dir, prefix, suffix string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var seqs []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		seqs = append(seqs, seq)
+	}
+
+	slices.Sort(seqs)
+
+	return seqs, nil
+}
+
+// writeFileAtomic replaces the file at path with b. It's written to a temporary file first, flushed and renamed over the
+// previous one, so a crash never leaves a partial file under the final name
+func writeFileAtomic(path string, b []byte) error {
+	tmp := path + ".tmp"
+
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(b)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	err = errors.Join(err, file.Close())
+	if err != nil {
+		_ = os.Remove(tmp)
+
+		return err
+	}
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir flushes the directory entries, making file creations and renames durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	return errors.Join(d.Sync(), d.Close())
+}
+
+// append writes the visits to the log with a single write, flushing it immediately if the policy requires it
+func (l *visitLog) append(policy SyncPolicy, visits ...domain.Visit) error {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	var records []byte
+	for _, visit := range visits {
+		record, err := encodeRecord(visit)
+		if err != nil {
+			return err
+		}
+
+		records = append(records, record...)
+	}
+
+	return l.write(policy, records, len(visits))
+}
+
+// appendErasure writes the tombstone of the visitor to the log, flushing it immediately if the policy requires it
+func (l *visitLog) appendErasure(policy SyncPolicy, visitor string) error {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	record, err := encodeErasure(visitor)
+	if err != nil {
+		return err
+	}
+
+	return l.write(policy, record, 1)
+}
+
+// appendRename writes the move of the visitor to a new id to the log, flushing it immediately if the policy requires it
+func (l *visitLog) appendRename(policy SyncPolicy, from, to string) error {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	record, err := encodeRename(from, to)
+	if err != nil {
+		return err
+	}
+
+	return l.write(policy, record, 1)
+}
+
+// write appends the encoded records, the caller must hold the lock. If they can't be written (or flushed, when the
+// policy requires it) whatever part of them was is cut off, so the segment still ends with a complete record
+func (l *visitLog) write(policy SyncPolicy, records []byte, count int) error {
+	_, err := l.file.Write(records)
+	if err == nil && policy == SyncAlways {
+		err = l.file.Sync()
+	}
+
+	if err != nil {
+		return errors.Join(err, l.truncate())
+	}
+
+	l.size += int64(len(records))
+	l.records += count
+	l.dirty = policy != SyncAlways
+
+	return nil
+}
+
+// truncate drops whatever was written after the last complete record and moves the append offset back to it
+func (l *visitLog) truncate() error {
+	err := l.file.Truncate(l.size)
+	if err != nil {
+		return err
+	}
+
+	_, err = l.file.Seek(l.size, io.SeekStart)
+
+	return err
+}
+
+// sync flushes the log to stable storage if anything was written since the last flush
+func (l *visitLog) sync() error {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if !l.dirty {
+		return nil
+	}
+
+	l.dirty = false
+
+	return l.file.Sync()
+}
+
+// syncEvery flushes the log every interval until done is closed
+func (l *visitLog) syncEvery(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			err := l.sync()
+			if err != nil {
+				log.Printf("unable to sync visit log: %v", err)
+			}
+		}
+	}
+}
+
+// close flushes and closes the log
+func (l *visitLog) close() error {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	return errors.Join(l.file.Sync(), l.file.Close())
+}
+
+func encodeRecord(visit domain.Visit) ([]byte, error) {
+	op := opVisit
+	if visit.Time.IsZero() {
+		op = opStore
+	}
+
+	payload := make([]byte, 0, 1+3*binary.MaxVarintLen64+len(visit.Visitor)+len(visit.PageURL))
+	payload = append(payload, op)
+	payload = appendString(payload, visit.Visitor)
+	payload = appendString(payload, visit.PageURL)
+
+	if op == opVisit {
+		payload = binary.AppendVarint(payload, visit.Time.UnixNano())
+	}
+
+	return frameRecord(payload)
+}
+
+func encodeErasure(visitor string) ([]byte, error) {
+	payload := make([]byte, 0, 1+binary.MaxVarintLen64+len(visitor))
+	payload = append(payload, opErase)
+	payload = appendString(payload, visitor)
+
+	return frameRecord(payload)
+}
+
+func encodeRename(from, to string) ([]byte, error) {
+	payload := make([]byte, 0, 1+2*binary.MaxVarintLen64+len(from)+len(to))
+	payload = append(payload, opRename)
+	payload = appendString(payload, from)
+	payload = appendString(payload, to)
+
+	return frameRecord(payload)
+}
+
+// frameRecord prefixes the payload with its length and checksum, payloads larger than maxRecordSize are refused
+func frameRecord(payload []byte) ([]byte, error) {
+	if len(payload) > maxRecordSize {
+		return nil, errRecordTooLarge
+	}
+
+	record := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+
+	return append(record, payload...), nil
+}
+
+// readRecord reads a single record, returning it and the number of bytes consumed
+func readRecord(r io.Reader) (logRecord, int, error) {
+	header := make([]byte, recordHeaderSize)
+
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return logRecord{}, 0, err
+	}
+
+	size := binary.LittleEndian.Uint32(header[0:4])
+	checksum := binary.LittleEndian.Uint32(header[4:8])
+
+	if size > maxRecordSize {
+		return logRecord{}, 0, errCorruptRecord
+	}
+
+	payload := make([]byte, size)
+
+	_, err = io.ReadFull(r, payload)
+	if errors.Is(err, io.EOF) {
+		return logRecord{}, 0, io.ErrUnexpectedEOF
+	}
+
+	if err != nil {
+		return logRecord{}, 0, err
+	}
+
+	if crc32.Checksum(payload, crcTable) != checksum || len(payload) == 0 {
+		return logRecord{}, 0, errCorruptRecord
+	}
+
+	record, ok := decodeRecord(payload)
+	if !ok {
+		return logRecord{}, 0, errCorruptRecord
+	}
+
+	return record, recordHeaderSize + len(payload), nil
+}
+
+func decodeRecord(payload []byte) (logRecord, bool) {
+	op := payload[0]
+	if op != opErase && op != opRename {
+		visit, ok := decodeVisit(payload)
+
+		return logRecord{op: op, visit: visit}, ok
+	}
+
+	visitor, rest, ok := readString(payload[1:])
+	if !ok {
+		return logRecord{}, false
+	}
+
+	record := logRecord{op: op, visit: domain.Visit{Visitor: visitor}}
+	if op == opErase {
+		return record, true
+	}
+
+	record.to, _, ok = readString(rest)
+
+	return record, ok
+}
+
+func decodeVisit(payload []byte) (domain.Visit, bool) {
+	op := payload[0]
+	if op != opStore && op != opVisit {
+		return domain.Visit{}, false
+	}
+
+	visitor, rest, ok := readString(payload[1:])
+	if !ok {
+		return domain.Visit{}, false
+	}
+
+	pageURL, rest, ok := readString(rest)
+	if !ok {
+		return domain.Visit{}, false
+	}
+
+	visit := domain.Visit{Visitor: visitor, PageURL: pageURL}
+	if op == opStore {
+		return visit, true
+	}
+
+	nanos, n := binary.Varint(rest)
+	if n <= 0 {
+		return domain.Visit{}, false
+	}
+
+	visit.Time = time.Unix(0, nanos).UTC()
+
+	return visit, true
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+
+	return append(b, s...)
+}
+
+func readString(b []byte) (string, []byte, bool) {
+	size, n := binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) < size {
+		return "", nil, false
+	}
+
+	return string(b[n : n+int(size)]), b[n+int(size):], true
+}
@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// DefaultThreshold is the number of unique visitors above which a page switches to a sketch in CountHybrid mode,
+// an exact set of that size takes roughly as much memory as a sketch of DefaultPrecision
+const DefaultThreshold = 1000
+
+// Option customizes how a repository is built
+type Option func(*options)
+
+type options struct {
+	counting  CountingMode
+	precision uint8
+	threshold domain.Count
+
+	rollupAfter time.Duration
+	maxWindow   time.Duration
+	now         func() time.Time
+
+	visitorHistory bool
+
+	memoryBudget int64
+	spillDir     string
+
+	syncPolicy   SyncPolicy
+	syncInterval time.Duration
+
+	snapshotInterval  time.Duration
+	snapshotRetention int
+}
+
+func defaultOptions() options {
+	return options{
+		counting:  CountExact,
+		precision: DefaultPrecision,
+		threshold: DefaultThreshold,
+
+		rollupAfter: DefaultRollupAfter,
+		maxWindow:   DefaultMaxWindow,
+		now:         time.Now,
+
+		syncPolicy:   SyncInterval,
+		syncInterval: time.Second,
+
+		snapshotInterval:  10 * time.Minute,
+		snapshotRetention: 2,
+	}
+}
+
+func buildOptions(opts []Option) options {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// WithSyncPolicy defines when the visit log of a file backed repository is flushed to disk,
+// interval is only relevant for SyncInterval
+func WithSyncPolicy(policy SyncPolicy, interval time.Duration) Option {
+	return func(o *options) {
+		o.syncPolicy = policy
+		o.syncInterval = interval
+	}
+}
+
+// WithSnapshots defines how often a file backed repository snapshots its data (0 disables periodic snapshots) and
+// how many snapshots are kept on disk
+func WithSnapshots(interval time.Duration, retention int) Option {
+	return func(o *options) {
+		o.snapshotInterval = interval
+		o.snapshotRetention = retention
+	}
+}
+
+// WithCounting defines how unique visitors are accounted for, precision is only relevant for CountApproximate and
+// CountHybrid and is clamped to [MinPrecision, MaxPrecision]
+func WithCounting(mode CountingMode, precision uint8) Option {
+	return func(o *options) {
+		o.counting = mode
+		o.precision = min(max(precision, MinPrecision), MaxPrecision)
+	}
+}
+
+// WithHybridThreshold defines the number of unique visitors above which a page switches to a sketch in CountHybrid mode
+func WithHybridThreshold(threshold domain.Count) Option {
+	return func(o *options) {
+		o.threshold = threshold
+	}
+}
+
+// WithRollupAfter defines how long visits are kept in hourly buckets before being rolled up into daily ones,
+// time ranges that go further back than that are resolved to the day
+func WithRollupAfter(rollupAfter time.Duration) Option {
+	return func(o *options) {
+		o.rollupAfter = rollupAfter
+	}
+}
+
+// WithMaxWindow defines the largest rolling window unique visitors can be counted in, visitors are kept in memory
+// for that long after they were last seen in a page
+func WithMaxWindow(maxWindow time.Duration) Option {
+	return func(o *options) {
+		o.maxWindow = maxWindow
+	}
+}
+
+// WithVisitorHistory defines whether the pages each visitor was seen in are kept, it's off by default. The history keeps
+// every visitor id (even when counting approximately), so it's only worth its memory where visitors are looked up
+func WithVisitorHistory(enabled bool) Option {
+	return func(o *options) {
+		o.visitorHistory = enabled
+	}
+}
+
+// WithMemoryBudget bounds the memory used by the pages and the recent visitors to about budget bytes (0, the default,
+// doesn't bound it). Above it, the least recently touched pages are spilled to a file in dir (the temporary directory
+// when empty) and faulted back in when they're touched again, see pageSpill. The visitor history isn't part of it, it
+// must stay off for the budget to hold
+func WithMemoryBudget(budget int64, dir string) Option {
+	return func(o *options) {
+		o.memoryBudget = budget
+		o.spillDir = dir
+	}
+}
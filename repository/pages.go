@@ -0,0 +1,258 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// pagesFile is the name of the file the registered pages are persisted to, within the data directory
+const pagesFile = "pages.json"
+
+// InMemoryPageRegistry stores the registered pages in a map of page urls (key) with their page (values). Retired pages
+// are kept (flagged as such), so that they can be brought back with Update.
+//
+// persist, when set, is called with every page under the write lock each time a page changes, the change is only kept
+// if it succeeds (see FilePageRegistry)
+type InMemoryPageRegistry struct {
+	m       sync.RWMutex
+	pages   map[domain.PageURL]domain.Page
+	now     func() time.Time
+	persist func(pages map[domain.PageURL]domain.Page) error
+}
+
+// NewInMemoryPageRegistry is a constructor for the in-memory PageRegistry
+func NewInMemoryPageRegistry() *InMemoryPageRegistry {
+	return &InMemoryPageRegistry{
+		pages: make(map[domain.PageURL]domain.Page),
+		now:   time.Now,
+	}
+}
+
+// Register adds the page, Registered and Updated are set to the current time
+func (r *InMemoryPageRegistry) Register(page domain.Page) (domain.Page, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	_, found := r.pages[page.URL]
+	if found {
+		return domain.Page{}, fmt.Errorf("%w: %s", domain.ErrPageExists, page.URL)
+	}
+
+	now := r.now()
+	page.Registered = now
+	page.Updated = now
+
+	return page, r.put(page)
+}
+
+// Page returns the page registered with the url
+func (r *InMemoryPageRegistry) Page(url domain.PageURL) (domain.Page, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	page, found := r.pages[url]
+	if !found {
+		return domain.Page{}, fmt.Errorf("%w: %s", domain.ErrPageNotFound, url)
+	}
+
+	return page, nil
+}
+
+// Pages lists every registered page sorted by url
+func (r *InMemoryPageRegistry) Pages() ([]domain.Page, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return slices.SortedFunc(maps.Values(r.pages), func(a, b domain.Page) int {
+		return strings.Compare(a.URL, b.URL)
+	}), nil
+}
+
+// Update changes the title and retired flag of the page, Registered is kept and Updated is set to the current time
+func (r *InMemoryPageRegistry) Update(page domain.Page) (domain.Page, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	current, found := r.pages[page.URL]
+	if !found {
+		return domain.Page{}, fmt.Errorf("%w: %s", domain.ErrPageNotFound, page.URL)
+	}
+
+	current.Title = page.Title
+	current.Retired = page.Retired
+	current.Updated = r.now()
+
+	return current, r.put(current)
+}
+
+// Retire flags the page as retired, retiring a retired page doesn't change it
+func (r *InMemoryPageRegistry) Retire(url domain.PageURL) (domain.Page, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	page, found := r.pages[url]
+	if !found {
+		return domain.Page{}, fmt.Errorf("%w: %s", domain.ErrPageNotFound, url)
+	}
+
+	if page.Retired {
+		return page, nil
+	}
+
+	page.Retired = true
+	page.Updated = r.now()
+
+	return page, r.put(page)
+}
+
+// put stores the page and persists the change (if required), the previous page is restored if it can't be persisted.
+// Callers must hold the write lock
+func (r *InMemoryPageRegistry) put(page domain.Page) error {
+	previous, existed := r.pages[page.URL]
+	r.pages[page.URL] = page
+
+	if r.persist == nil {
+		return nil
+	}
+
+	err := r.persist(r.pages)
+	if err != nil {
+		if existed {
+			r.pages[page.URL] = previous
+		} else {
+			delete(r.pages, page.URL)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// FilePageRegistry is a durable PageRegistry, the pages are kept in memory (see InMemoryPageRegistry) and every
+// change rewrites a JSON file with all of them. A page registry is expected to be small and rarely changed, so the
+// whole file is written (to a temporary file renamed over the previous one, so it's never left half-written)
+type FilePageRegistry struct {
+	mem  *InMemoryPageRegistry
+	path string
+}
+
+// page is the persisted representation of a domain.Page
+type page struct {
+	URL        string    `json:"page_url"`
+	Title      string    `json:"title,omitempty"`
+	Retired    bool      `json:"retired,omitempty"`
+	Registered time.Time `json:"registered_at"`
+	Updated    time.Time `json:"updated_at"`
+}
+
+// NewFilePageRegistry is a constructor for the file backed PageRegistry, pages are stored in dir (usually the same
+// directory as the visits)
+func NewFilePageRegistry(dir string) (*FilePageRegistry, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &FilePageRegistry{
+		mem:  NewInMemoryPageRegistry(),
+		path: filepath.Join(dir, pagesFile),
+	}
+
+	err = f.load()
+	if err != nil {
+		return nil, err
+	}
+
+	f.mem.persist = f.write
+
+	return f, nil
+}
+
+// load reads the pages from the file, a missing file means no page is registered yet
+func (f *FilePageRegistry) load() error {
+	b, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var pages []page
+
+	err = json.Unmarshal(b, &pages)
+	if err != nil {
+		return fmt.Errorf("unable to read pages %s: %w", f.path, err)
+	}
+
+	for _, p := range pages {
+		f.mem.pages[p.URL] = domain.Page{
+			URL:        p.URL,
+			Title:      p.Title,
+			Retired:    p.Retired,
+			Registered: p.Registered,
+			Updated:    p.Updated,
+		}
+	}
+
+	return nil
+}
+
+// write replaces the file with the given pages
+func (f *FilePageRegistry) write(pages map[domain.PageURL]domain.Page) error {
+	persisted := make([]page, 0, len(pages))
+	for _, url := range slices.Sorted(maps.Keys(pages)) {
+		p := pages[url]
+		persisted = append(persisted, page{
+			URL:        p.URL,
+			Title:      p.Title,
+			Retired:    p.Retired,
+			Registered: p.Registered,
+			Updated:    p.Updated,
+		})
+	}
+
+	b, err := json.MarshalIndent(persisted, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(f.path, b)
+}
+
+// Register adds the page and only keeps it if the file could be written
+func (f *FilePageRegistry) Register(page domain.Page) (domain.Page, error) {
+	return f.mem.Register(page)
+}
+
+// Page reads the page from memory, the file is only read on startup
+func (f *FilePageRegistry) Page(url domain.PageURL) (domain.Page, error) {
+	return f.mem.Page(url)
+}
+
+// Pages reads the pages from memory, the file is only read on startup
+func (f *FilePageRegistry) Pages() ([]domain.Page, error) {
+	return f.mem.Pages()
+}
+
+// Update changes the page and only keeps the change if the file could be written
+func (f *FilePageRegistry) Update(page domain.Page) (domain.Page, error) {
+	return f.mem.Update(page)
+}
+
+// Retire retires the page and only keeps the change if the file could be written
+func (f *FilePageRegistry) Retire(url domain.PageURL) (domain.Page, error) {
+	return f.mem.Retire(url)
+}
@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+func TestInMemoryPageRegistry(t *testing.T) {
+	c := &clock{now: time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)}
+
+	r := NewInMemoryPageRegistry()
+	r.now = c.Now
+
+	page, err := r.Register(domain.Page{URL: "/blog", Title: "Blog"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !page.Registered.Equal(c.now) || !page.Updated.Equal(c.now) {
+		t.Errorf("got %v, expected registered and updated at %v", page, c.now)
+	}
+
+	_, err = r.Register(domain.Page{URL: "/blog"})
+	if !errors.Is(err, domain.ErrPageExists) {
+		t.Errorf("got %v, expected %v", err, domain.ErrPageExists)
+	}
+
+	_, err = r.Register(domain.Page{URL: "/about"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.now = c.now.Add(time.Hour)
+
+	page, err = r.Retire("/blog")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !page.Retired || !page.Updated.Equal(c.now) || page.Registered.Equal(c.now) {
+		t.Errorf("got %v, expected a retired page updated at %v", page, c.now)
+	}
+
+	page, err = r.Update(domain.Page{URL: "/blog", Title: "New blog"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if page.Retired || page.Title != "New blog" {
+		t.Errorf("got %v, expected an active page titled New blog", page)
+	}
+
+	_, err = r.Update(domain.Page{URL: "/unknown"})
+	if !errors.Is(err, domain.ErrPageNotFound) {
+		t.Errorf("got %v, expected %v", err, domain.ErrPageNotFound)
+	}
+
+	_, err = r.Retire("/unknown")
+	if !errors.Is(err, domain.ErrPageNotFound) {
+		t.Errorf("got %v, expected %v", err, domain.ErrPageNotFound)
+	}
+
+	_, err = r.Page("/unknown")
+	if !errors.Is(err, domain.ErrPageNotFound) {
+		t.Errorf("got %v, expected %v", err, domain.ErrPageNotFound)
+	}
+
+	pages, err := r.Pages()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pages) != 2 || pages[0].URL != "/about" || pages[1].URL != "/blog" {
+		t.Errorf("got %v, expected /about and /blog", pages)
+	}
+}
+
+func TestFilePageRegistry(t *testing.T) {
+	dir := t.TempDir()
+
+	r, err := NewFilePageRegistry(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = r.Register(domain.Page{URL: "/blog", Title: "Blog"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = r.Register(domain.Page{URL: "/about"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = r.Retire("/about")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = NewFilePageRegistry(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blog, err := r.Page("/blog")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if blog.Title != "Blog" || blog.Retired || blog.Registered.IsZero() {
+		t.Errorf("got %v, expected the registered /blog page", blog)
+	}
+
+	about, err := r.Page("/about")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !about.Retired {
+		t.Errorf("got %v, expected the retired /about page", about)
+	}
+
+	// a change that can't be written is not kept (a directory in the way of the temporary file makes writes fail)
+	err = os.Mkdir(filepath.Join(dir, pagesFile+".tmp"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = r.Register(domain.Page{URL: "/contact"})
+	if err == nil {
+		t.Fatal("got no error, expected the registry file not to be writable")
+	}
+
+	_, err = r.Page("/contact")
+	if !errors.Is(err, domain.ErrPageNotFound) {
+		t.Errorf("got %v, expected %v", err, domain.ErrPageNotFound)
+	}
+}
@@ -0,0 +1,425 @@
+package repository
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// DefaultMaxQuarantined is the number of visits the quarantine holds at most, unless configured otherwise
+const DefaultMaxQuarantined = 100_000
+
+// quarantineFile is the name of the file the quarantined visits are persisted to, within the data directory
+const quarantineFile = "quarantine.log"
+
+// quarantineCompactAfter is the number of lines of the quarantine file that no longer hold a visit (the visits released
+// and the releases themselves) past which it's compacted, if there are more of them than visits held
+const quarantineCompactAfter = 10_000
+
+// the changes recorded in the quarantine file
+const (
+	opHold    = "hold"
+	opRelease = "release"
+	opForget  = "forget"
+)
+
+// quarantineRecord is a line of the quarantine file, Op tells what it holds: a visit held (opHold), the release of the
+// visits held for PageURL (opRelease) or the drop of the visits of Visitor (opForget)
+type quarantineRecord struct {
+	Op      string    `json:"op"`
+	Visitor string    `json:"visitor_id,omitempty"`
+	PageURL string    `json:"page_url,omitempty"`
+	Time    time.Time `json:"time,omitzero"`
+}
+
+// InMemoryQuarantine holds the visits to unregistered pages in a map of page urls (key) with their visits (values),
+// in the order they were received. At most max visits are held across every page, so that a crawler hitting unknown
+// urls can't grow it indefinitely. Unless persist is set, held visits are lost on restart.
+//
+// persist, when set, is called with every change under the lock before it's made, the change is only made if it
+// succeeds (see FileQuarantine)
+type InMemoryQuarantine struct {
+	m       sync.Mutex
+	max     int
+	held    int
+	visits  map[domain.PageURL][]domain.Visit
+	persist func(record quarantineRecord) error
+}
+
+// NewInMemoryQuarantine is a constructor for the in-memory VisitQuarantine, max bounds the number of visits held
+func NewInMemoryQuarantine(max int) *InMemoryQuarantine {
+	return &InMemoryQuarantine{
+		max:    max,
+		visits: make(map[domain.PageURL][]domain.Visit),
+	}
+}
+
+// Quarantine holds the visit until its page is released
+func (q *InMemoryQuarantine) Quarantine(visit domain.Visit) error {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if q.held >= q.max {
+		return fmt.Errorf("%w: %d visits held", domain.ErrQuarantineFull, q.held)
+	}
+
+	err := q.log(quarantineRecord{Op: opHold, Visitor: visit.Visitor, PageURL: visit.PageURL, Time: visit.Time})
+	if err != nil {
+		return err
+	}
+
+	q.hold(visit)
+
+	return nil
+}
+
+// hold adds the visit to the ones of its page, callers must hold the lock
+func (q *InMemoryQuarantine) hold(visit domain.Visit) {
+	q.visits[visit.PageURL] = append(q.visits[visit.PageURL], visit)
+	q.held++
+}
+
+// Release removes and returns the visits held for the page, in the order they were received
+func (q *InMemoryQuarantine) Release(url domain.PageURL) ([]domain.Visit, error) {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	visits := q.visits[url]
+	if len(visits) == 0 {
+		return nil, nil
+	}
+
+	err := q.log(quarantineRecord{Op: opRelease, PageURL: url})
+	if err != nil {
+		return nil, err
+	}
+
+	return q.release(url), nil
+}
+
+// release removes and returns the visits held for the page, callers must hold the lock
+func (q *InMemoryQuarantine) release(url domain.PageURL) []domain.Visit {
+	visits := q.visits[url]
+	delete(q.visits, url)
+	q.held -= len(visits)
+
+	return visits
+}
+
+// Forget drops the visits held of the visitor, from every page
+func (q *InMemoryQuarantine) Forget(visitor string) (domain.Count, error) {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if !q.holds(visitor) {
+		return 0, nil
+	}
+
+	err := q.log(quarantineRecord{Op: opForget, Visitor: visitor})
+	if err != nil {
+		return 0, err
+	}
+
+	return q.forget(visitor), nil
+}
+
+// holds reports whether a visit of the visitor is held, callers must hold the lock
+func (q *InMemoryQuarantine) holds(visitor string) bool {
+	for _, visits := range q.visits {
+		for _, visit := range visits {
+			if visit.Visitor == visitor {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// forget drops the visits of the visitor and returns how many were, callers must hold the lock
+func (q *InMemoryQuarantine) forget(visitor string) domain.Count {
+	var dropped domain.Count
+	for url, visits := range q.visits {
+		kept := slices.DeleteFunc(visits, func(visit domain.Visit) bool { return visit.Visitor == visitor })
+		dropped += domain.Count(len(visits) - len(kept))
+
+		if len(kept) == 0 {
+			delete(q.visits, url)
+		} else {
+			q.visits[url] = kept
+		}
+	}
+
+	q.held -= int(dropped)
+
+	return dropped
+}
+
+// log persists the change (if required), callers must hold the lock
+func (q *InMemoryQuarantine) log(record quarantineRecord) error {
+	if q.persist == nil {
+		return nil
+	}
+
+	return q.persist(record)
+}
+
+// Quarantined returns the number of visits held per page
+func (q *InMemoryQuarantine) Quarantined() (map[domain.PageURL]domain.Count, error) {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	counts := make(map[domain.PageURL]domain.Count, len(q.visits))
+	for url, visits := range q.visits {
+		counts[url] = domain.Count(len(visits))
+	}
+
+	return counts, nil
+}
+
+// FileQuarantine is a durable VisitQuarantine, the visits are held in memory (see InMemoryQuarantine) and every change
+// is appended to a file, kept open, as a line of JSON flushed before the change is made. On startup the file is replayed
+// and then rewritten with only the visits still held. It's also rewritten whenever a visitor is forgotten, so that its
+// visits don't stay on disk, and once the lines that no longer hold a visit pass quarantineCompactAfter
+type FileQuarantine struct {
+	mem  *InMemoryQuarantine
+	path string
+	file *os.File
+	size int64
+	// lines is the number of lines of the file
+	lines int
+	// stale is set when the file couldn't be rewritten once a visitor was forgotten, until it is
+	stale bool
+}
+
+// NewFileQuarantine is a constructor for the file backed VisitQuarantine, visits are stored in dir (usually the same
+// directory as the visits) and max bounds the number of visits held. The visits held before a restart are held again,
+// even if there are more than max of them
+func NewFileQuarantine(dir string, max int) (*FileQuarantine, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &FileQuarantine{
+		mem:  NewInMemoryQuarantine(max),
+		path: filepath.Join(dir, quarantineFile),
+	}
+
+	err = f.load()
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.rewrite()
+	if err != nil {
+		if f.file != nil {
+			err = errors.Join(err, f.file.Close())
+		}
+
+		return nil, err
+	}
+
+	f.mem.persist = f.append
+
+	return f, nil
+}
+
+// load replays the changes of the file, a missing file means no visit is held yet. A last line without its newline was
+// cut short by a crash while it was written: its change was never made, so it's skipped
+func (f *FileQuarantine) load() error {
+	file, err := os.Open(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		var record quarantineRecord
+
+		err = json.Unmarshal(line, &record)
+		if err != nil {
+			return fmt.Errorf("unable to read quarantine %s: %w", f.path, err)
+		}
+
+		switch record.Op {
+		case opHold:
+			f.mem.hold(domain.Visit{Visitor: record.Visitor, PageURL: record.PageURL, Time: record.Time})
+		case opRelease:
+			f.mem.release(record.PageURL)
+		case opForget:
+			f.mem.forget(record.Visitor)
+		default:
+			return fmt.Errorf("unable to read quarantine %s: unknown op %q", f.path, record.Op)
+		}
+	}
+}
+
+// rewrite replaces the file with the visits held (see writeFileAtomic) and reopens it, so the next changes are appended
+// to the new file. Callers must hold the lock of the visits, or be their only user
+func (f *FileQuarantine) rewrite() error {
+	var b []byte
+	for _, url := range slices.Sorted(maps.Keys(f.mem.visits)) {
+		for _, visit := range f.mem.visits[url] {
+			line, err := json.Marshal(quarantineRecord{Op: opHold, Visitor: visit.Visitor, PageURL: visit.PageURL, Time: visit.Time})
+			if err != nil {
+				return err
+			}
+
+			b = append(append(b, line...), '\n')
+		}
+	}
+
+	err := writeFileAtomic(f.path, b)
+	if err == nil {
+		f.lines = f.mem.held
+	}
+
+	// the file may have been replaced even if it failed (e.g. the directory couldn't be synced)
+	return errors.Join(err, f.reopen())
+}
+
+// reopen opens the file at its path for appending, closing the previous one. If it can't be opened the previous one is
+// kept
+func (f *FileQuarantine) reopen() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return errors.Join(err, file.Close())
+	}
+
+	previous := f.file
+	f.file, f.size = file, size
+
+	if previous == nil {
+		return nil
+	}
+
+	return previous.Close()
+}
+
+// append writes the change to the file and flushes it, it's called by the in-memory quarantine under its lock. If the
+// change can't be written whatever part of it was is cut off, so the next change starts on a line of its own
+func (f *FileQuarantine) append(record quarantineRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.file.Write(append(line, '\n'))
+	if err == nil {
+		err = f.file.Sync()
+	}
+
+	if err != nil {
+		return errors.Join(err, f.file.Truncate(f.size))
+	}
+
+	f.size += int64(len(line)) + 1
+	f.lines++
+
+	return nil
+}
+
+// compact rewrites the file once the lines that no longer hold a visit pass quarantineCompactAfter and outnumber the
+// visits held, so it doesn't grow with every visit ever held. The caller must hold the lock of the visits. The changes
+// are already in the file, so a failure is only logged and compacting is retried after the next change
+func (f *FileQuarantine) compact() {
+	stale := f.lines - f.mem.held
+	if stale <= quarantineCompactAfter || stale <= f.mem.held {
+		return
+	}
+
+	err := f.rewrite()
+	if err != nil {
+		log.Printf("unable to compact the quarantine %s: %v", f.path, err)
+	}
+}
+
+// Quarantine holds the visit until its page is released, it's only held if the file could be written
+func (f *FileQuarantine) Quarantine(visit domain.Visit) error {
+	return f.mem.Quarantine(visit)
+}
+
+// Release removes and returns the visits held for the page, they're only removed if the file could be written. The
+// file is compacted once enough visits were released (see compact)
+func (f *FileQuarantine) Release(url domain.PageURL) ([]domain.Visit, error) {
+	visits, err := f.mem.Release(url)
+	if err != nil || len(visits) == 0 {
+		return visits, err
+	}
+
+	f.mem.m.Lock()
+	defer f.mem.m.Unlock()
+
+	f.compact()
+
+	return visits, nil
+}
+
+// Forget drops the visits held of the visitor, then rewrites the file without them. If it can't be rewritten the
+// visits are still dropped (on restart too) but stay on disk: the error is returned and the next Forget (e.g. a retry)
+// rewrites the file, even if there's nothing left to drop
+func (f *FileQuarantine) Forget(visitor string) (domain.Count, error) {
+	dropped, err := f.mem.Forget(visitor)
+	if err != nil {
+		return 0, err
+	}
+
+	f.mem.m.Lock()
+	defer f.mem.m.Unlock()
+
+	if dropped == 0 && !f.stale {
+		return 0, nil
+	}
+
+	err = f.rewrite()
+	f.stale = err != nil
+
+	return dropped, err
+}
+
+// Quarantined reads the number of visits held per page from memory, the file is only read on startup
+func (f *FileQuarantine) Quarantined() (map[domain.PageURL]domain.Count, error) {
+	return f.mem.Quarantined()
+}
+
+// Close closes the file, the quarantine can't be used afterward
+func (f *FileQuarantine) Close() error {
+	f.mem.m.Lock()
+	defer f.mem.m.Unlock()
+
+	return f.file.Close()
+}
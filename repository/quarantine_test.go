@@ -0,0 +1,279 @@
+package repository
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+func TestInMemoryQuarantine(t *testing.T) {
+	q := NewInMemoryQuarantine(3)
+
+	for _, visit := range []domain.Visit{
+		{Visitor: "a", PageURL: "/blog"},
+		{Visitor: "b", PageURL: "/blog"},
+		{Visitor: "a", PageURL: "/about"},
+	} {
+		err := q.Quarantine(visit)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := q.Quarantine(domain.Visit{Visitor: "c", PageURL: "/blog"})
+	if !errors.Is(err, domain.ErrQuarantineFull) {
+		t.Errorf("got %v, expected %v", err, domain.ErrQuarantineFull)
+	}
+
+	counts, err := q.Quarantined()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(counts) != 2 || counts["/blog"] != 2 || counts["/about"] != 1 {
+		t.Errorf("got %v, expected 2 visits of /blog and 1 of /about", counts)
+	}
+
+	visits, err := q.Release("/blog")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(visits) != 2 || visits[0].Visitor != "a" || visits[1].Visitor != "b" {
+		t.Errorf("got %v, expected the visits of a and b in order", visits)
+	}
+
+	// releasing frees room for new visits
+	err = q.Quarantine(domain.Visit{Visitor: "c", PageURL: "/blog"})
+	if err != nil {
+		t.Errorf("got %v, expected no error", err)
+	}
+}
+
+func TestInMemoryQuarantineForget(t *testing.T) {
+	q := NewInMemoryQuarantine(3)
+
+	for _, visit := range []domain.Visit{
+		{Visitor: "a", PageURL: "/blog"},
+		{Visitor: "b", PageURL: "/blog"},
+		{Visitor: "a", PageURL: "/about"},
+	} {
+		err := q.Quarantine(visit)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dropped, err := q.Forget("a")
+	if err != nil || dropped != 2 {
+		t.Errorf("got %v and %v, expected 2 visits dropped", dropped, err)
+	}
+
+	counts, err := q.Quarantined()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(counts) != 1 || counts["/blog"] != 1 {
+		t.Errorf("got %v, expected 1 visit of /blog", counts)
+	}
+
+	// forgetting frees room for new visits
+	for _, visit := range []domain.Visit{{Visitor: "c", PageURL: "/blog"}, {Visitor: "d", PageURL: "/blog"}} {
+		err := q.Quarantine(visit)
+		if err != nil {
+			t.Errorf("got %v, expected no error", err)
+		}
+	}
+}
+
+func TestFileQuarantine(t *testing.T) {
+	base := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	hold := func(t *testing.T, q *FileQuarantine, visits ...domain.Visit) {
+		t.Helper()
+
+		for _, visit := range visits {
+			err := q.Quarantine(visit)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	t.Run("survives a restart", func(t *testing.T) {
+		dir := t.TempDir()
+
+		q, err := NewFileQuarantine(dir, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hold(t, q,
+			domain.Visit{Visitor: "a", PageURL: "/blog", Time: base},
+			domain.Visit{Visitor: "b", PageURL: "/blog", Time: base.Add(time.Minute)},
+			domain.Visit{Visitor: "a", PageURL: "/about"},
+			domain.Visit{Visitor: "c", PageURL: "/pricing"},
+		)
+
+		_, err = q.Release("/about")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dropped, err := q.Forget("c")
+		if err != nil || dropped != 1 {
+			t.Errorf("got %v and %v, expected 1 visit dropped", dropped, err)
+		}
+
+		q, err = NewFileQuarantine(dir, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		counts, err := q.Quarantined()
+		if err != nil || !reflect.DeepEqual(counts, map[domain.PageURL]domain.Count{"/blog": 2}) {
+			t.Errorf("got %v and %v, expected 2 visits of /blog", counts, err)
+		}
+
+		visits, err := q.Release("/blog")
+		expected := []domain.Visit{
+			{Visitor: "a", PageURL: "/blog", Time: base},
+			{Visitor: "b", PageURL: "/blog", Time: base.Add(time.Minute)},
+		}
+		if err != nil || !reflect.DeepEqual(visits, expected) {
+			t.Errorf("got %v and %v, expected %v", visits, err, expected)
+		}
+	})
+
+	t.Run("forgotten visitors don't stay on disk", func(t *testing.T) {
+		dir := t.TempDir()
+
+		q, err := NewFileQuarantine(dir, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hold(t, q, domain.Visit{Visitor: "forgotten", PageURL: "/blog"}, domain.Visit{Visitor: "b", PageURL: "/blog"})
+
+		_, err = q.Forget("forgotten")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := os.ReadFile(filepath.Join(dir, quarantineFile))
+		if err != nil || bytes.Contains(b, []byte("forgotten")) {
+			t.Errorf("got %s and %v, expected the visitor to be gone from the file", b, err)
+		}
+	})
+
+	t.Run("released visits are compacted away", func(t *testing.T) {
+		dir := t.TempDir()
+
+		q, err := NewFileQuarantine(dir, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer func() {
+			_ = q.Close()
+		}()
+
+		hold(t, q, domain.Visit{Visitor: "kept", PageURL: "/kept"})
+
+		// every visit released leaves its hold and the release behind, until there are enough of them
+		for range quarantineCompactAfter / 2 {
+			hold(t, q, domain.Visit{Visitor: "released", PageURL: "/released"})
+
+			_, err := q.Release("/released")
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if q.lines != quarantineCompactAfter+1 {
+			t.Errorf("got %d lines, expected %d before compacting", q.lines, quarantineCompactAfter+1)
+		}
+
+		hold(t, q, domain.Visit{Visitor: "released", PageURL: "/released"})
+
+		_, err = q.Release("/released")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := os.ReadFile(filepath.Join(dir, quarantineFile))
+		if err != nil || q.lines != 1 || bytes.Count(b, []byte("\n")) != 1 || !bytes.Contains(b, []byte("kept")) {
+			t.Errorf("got %d lines, %s and %v, expected only the visit kept", q.lines, b, err)
+		}
+
+		// changes are appended to the compacted file
+		hold(t, q, domain.Visit{Visitor: "new", PageURL: "/kept"})
+
+		reopened, err := NewFileQuarantine(dir, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer func() {
+			_ = reopened.Close()
+		}()
+
+		counts, err := reopened.Quarantined()
+		if err != nil || !reflect.DeepEqual(counts, map[domain.PageURL]domain.Count{"/kept": 2}) {
+			t.Errorf("got %v and %v, expected 2 visits of /kept", counts, err)
+		}
+	})
+
+	t.Run("a change cut short by a crash is skipped", func(t *testing.T) {
+		dir := t.TempDir()
+
+		q, err := NewFileQuarantine(dir, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hold(t, q, domain.Visit{Visitor: "a", PageURL: "/blog"})
+
+		file, err := os.OpenFile(filepath.Join(dir, quarantineFile), os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = file.WriteString(`{"op":"hold","visitor_id":"b","page_u`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_ = file.Close()
+
+		q, err = NewFileQuarantine(dir, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		counts, err := q.Quarantined()
+		if err != nil || counts["/blog"] != 1 {
+			t.Errorf("got %v and %v, expected 1 visit of /blog", counts, err)
+		}
+
+		// the file was rewritten, new changes start on a line of their own
+		hold(t, q, domain.Visit{Visitor: "c", PageURL: "/blog"})
+
+		q, err = NewFileQuarantine(dir, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		counts, err = q.Quarantined()
+		if err != nil || counts["/blog"] != 2 {
+			t.Errorf("got %v and %v, expected 2 visits of /blog", counts, err)
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"container/list"
+	"context"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// DefaultMaxWindow is the largest rolling window unique visitors can be counted in
+const DefaultMaxWindow = time.Hour
+
+// recentVisitors keeps the last time each visitor was seen in each page, for the visits made within the max window.
+//   - pages is a map of page urls (key) with the visitors seen recently (values), ordered by when they were last seen
+//     (oldest first), so both counting a window and expiring old visitors only touch the entries involved
+//   - index is a map of page urls (key) with the list element of each visitor (values), to update the last seen time
+//
+// Entries expire as the window slides: a page drops its expired visitors whenever it's written to and every page is
+// swept once every quarter of the max window, so memory is bounded by the visitors seen within the max window even
+// for pages that stop receiving visits.
+type recentVisitors struct {
+	maxWindow time.Duration
+	pages     map[domain.PageURL]*list.List
+	index     map[domain.PageURL]map[visitorID]*list.Element
+	swept     time.Time
+	// visits is the number of entries across every page, for the memory budget
+	visits int
+}
+
+type recentVisit struct {
+	visitor visitorID
+	seen    time.Time
+}
+
+func newRecentVisitors(maxWindow time.Duration) *recentVisitors {
+	return &recentVisitors{
+		maxWindow: maxWindow,
+		pages:     make(map[domain.PageURL]*list.List),
+		index:     make(map[domain.PageURL]map[visitorID]*list.Element),
+	}
+}
+
+// touch records that the visitor was seen in the page when the visit was made, visits from the future (e.g. clock
+// skew between clients) are seen now and visits older than the max window are ignored
+func (r *recentVisitors) touch(visit domain.Visit, now time.Time) {
+	seen := visit.Time
+	if seen.After(now) {
+		seen = now
+	}
+
+	if seen.Before(now.Add(-r.maxWindow)) {
+		return
+	}
+
+	r.see(visit.PageURL, visit.Visitor, seen)
+
+	r.expire(visit.PageURL, now)
+
+	if now.Sub(r.swept) >= r.maxWindow/4 {
+		r.sweep(now)
+	}
+}
+
+// see records that the visitor was last seen in the page at seen, unless it was already seen later
+func (r *recentVisitors) see(url domain.PageURL, visitor visitorID, seen time.Time) {
+	visitors, found := r.pages[url]
+	if !found {
+		visitors = list.New()
+		r.pages[url] = visitors
+		r.index[url] = make(map[visitorID]*list.Element)
+	}
+
+	index := r.index[url]
+
+	element, found := index[visitor]
+	switch {
+	case !found:
+		index[visitor] = insertOrdered(visitors, &recentVisit{visitor: visitor, seen: seen})
+		r.visits++
+	case element.Value.(*recentVisit).seen.Before(seen):
+		visitors.Remove(element)
+		index[visitor] = insertOrdered(visitors, &recentVisit{visitor: visitor, seen: seen})
+	}
+}
+
+// drop forgets every visitor of the page
+func (r *recentVisitors) drop(url domain.PageURL) {
+	r.visits -= len(r.index[url])
+	delete(r.pages, url)
+	delete(r.index, url)
+}
+
+// insertOrdered inserts the visit keeping the list ordered by seen, visits usually arrive in order so the position is
+// found right at the back
+func insertOrdered(visitors *list.List, visit *recentVisit) *list.Element {
+	for e := visitors.Back(); e != nil; e = e.Prev() {
+		if !e.Value.(*recentVisit).seen.After(visit.seen) {
+			return visitors.InsertAfter(visit, e)
+		}
+	}
+
+	return visitors.PushFront(visit)
+}
+
+// expire drops the visitors of the page last seen before the max window
+func (r *recentVisitors) expire(url domain.PageURL, now time.Time) {
+	visitors := r.pages[url]
+	cutoff := now.Add(-r.maxWindow)
+
+	for e := visitors.Front(); e != nil && e.Value.(*recentVisit).seen.Before(cutoff); e = visitors.Front() {
+		visitors.Remove(e)
+		delete(r.index[url], e.Value.(*recentVisit).visitor)
+		r.visits--
+	}
+
+	if visitors.Len() == 0 {
+		delete(r.pages, url)
+		delete(r.index, url)
+	}
+}
+
+// sweep expires the visitors of every page
+func (r *recentVisitors) sweep(now time.Time) {
+	for url := range r.pages {
+		r.expire(url, now)
+	}
+
+	r.swept = now
+}
+
+// count returns the number of visitors of the page seen within the window
+func (r *recentVisitors) count(url domain.PageURL, window time.Duration, now time.Time) domain.Count {
+	visitors, found := r.pages[url]
+	if !found {
+		return 0
+	}
+
+	cutoff := now.Add(-window)
+
+	var count domain.Count
+	for e := visitors.Back(); e != nil && !e.Value.(*recentVisit).seen.Before(cutoff); e = e.Prev() {
+		count++
+	}
+
+	return count
+}
+
+// CountRecentUniqueVisitors counts the visitors of the page seen within the last window (e.g. 5 minutes),
+// the window can't be larger than the max window the repository was built with
+func (i *InMemoryVisitRepository) CountRecentUniqueVisitors(ctx context.Context, url domain.PageURL, window time.Duration) (domain.UniqueVisitors, error) {
+	if window <= 0 || window > i.opts.maxWindow {
+		return domain.UniqueVisitors{}, domain.ErrInvalidWindow
+	}
+
+	err := i.m.RLock(ctx)
+	if err != nil {
+		return domain.UniqueVisitors{}, err
+	}
+
+	defer i.m.RUnlock()
+
+	return domain.UniqueVisitors{Count: i.recent.count(url, window, i.opts.now())}, nil
+}
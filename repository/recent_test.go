@@ -0,0 +1,213 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// clock is a fake time source the tests move forward manually
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func withClock(c *clock) Option {
+	return func(o *options) {
+		o.now = c.Now
+	}
+}
+
+func TestInMemoryRepositoryRecent(t *testing.T) {
+	base := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	type count struct {
+		window        time.Duration
+		expectedCount domain.Count
+	}
+
+	type testCase struct {
+		description string
+		inputs      []domain.Visit
+		elapsed     time.Duration
+		counts      []count
+	}
+
+	testCases := []testCase{
+		{
+			description: "visitors in different windows",
+			inputs: []domain.Visit{
+				{Visitor: "id", PageURL: "url", Time: base.Add(-50 * time.Minute)},
+				{Visitor: "id2", PageURL: "url", Time: base.Add(-10 * time.Minute)},
+				{Visitor: "id3", PageURL: "url", Time: base.Add(-2 * time.Minute)},
+				{Visitor: "id4", PageURL: "url2", Time: base.Add(-2 * time.Minute)},
+			},
+			counts: []count{
+				{window: 5 * time.Minute, expectedCount: 1},
+				{window: 15 * time.Minute, expectedCount: 2},
+				{window: time.Hour, expectedCount: 3},
+			},
+		},
+		{
+			description: "last seen time is updated",
+			inputs: []domain.Visit{
+				{Visitor: "id", PageURL: "url", Time: base.Add(-50 * time.Minute)},
+				{Visitor: "id", PageURL: "url", Time: base.Add(-time.Minute)},
+				// an older visit doesn't move the last seen time back
+				{Visitor: "id", PageURL: "url", Time: base.Add(-30 * time.Minute)},
+			},
+			counts: []count{
+				{window: 5 * time.Minute, expectedCount: 1},
+				{window: time.Hour, expectedCount: 1},
+			},
+		},
+		{
+			description: "window slides",
+			inputs: []domain.Visit{
+				{Visitor: "id", PageURL: "url", Time: base.Add(-10 * time.Minute)},
+				{Visitor: "id2", PageURL: "url", Time: base},
+			},
+			elapsed: 8 * time.Minute,
+			counts: []count{
+				{window: 5 * time.Minute, expectedCount: 0},
+				{window: 15 * time.Minute, expectedCount: 1},
+				{window: 30 * time.Minute, expectedCount: 2},
+			},
+		},
+		{
+			description: "visits older than the max window or without time are ignored",
+			inputs: []domain.Visit{
+				{Visitor: "id", PageURL: "url", Time: base.Add(-2 * time.Hour)},
+				{Visitor: "id2", PageURL: "url"},
+			},
+			counts: []count{
+				{window: time.Hour, expectedCount: 0},
+			},
+		},
+		{
+			description: "visits from the future are seen now",
+			inputs: []domain.Visit{
+				{Visitor: "id", PageURL: "url", Time: base.Add(time.Hour)},
+			},
+			elapsed: 10 * time.Minute,
+			counts: []count{
+				{window: 5 * time.Minute, expectedCount: 0},
+				{window: 15 * time.Minute, expectedCount: 1},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			c := &clock{now: base}
+			r := NewVisitsInMemoryRepository(withClock(c))
+
+			for _, visit := range tc.inputs {
+				err := r.Store(context.Background(), visit)
+				if err != nil {
+					t.Fatal("unexpected error", err)
+				}
+			}
+
+			c.now = c.now.Add(tc.elapsed)
+
+			for _, cnt := range tc.counts {
+				counter, err := r.CountRecentUniqueVisitors(context.Background(), "url", cnt.window)
+				if err != nil {
+					t.Fatal("unexpected error", err)
+				}
+
+				if counter.Count != cnt.expectedCount {
+					t.Errorf("%v: got %v, expected %v", cnt.window, counter.Count, cnt.expectedCount)
+				}
+			}
+		})
+	}
+
+	r := NewVisitsInMemoryRepository(WithMaxWindow(15 * time.Minute))
+
+	for _, window := range []time.Duration{0, -time.Minute, 16 * time.Minute} {
+		_, err := r.CountRecentUniqueVisitors(context.Background(), "url", window)
+		if !errors.Is(err, domain.ErrInvalidWindow) {
+			t.Errorf("%v: got %v, expected %v", window, err, domain.ErrInvalidWindow)
+		}
+	}
+}
+
+func TestInMemoryRepositoryRecentExpiry(t *testing.T) {
+	base := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	c := &clock{now: base}
+	r := NewVisitsInMemoryRepository(withClock(c), WithMaxWindow(time.Hour))
+
+	for _, visit := range []domain.Visit{
+		{Visitor: "id", PageURL: "url", Time: base},
+		{Visitor: "id2", PageURL: "url", Time: base},
+		{Visitor: "id", PageURL: "url2", Time: base},
+	} {
+		err := r.Store(context.Background(), visit)
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+	}
+
+	// sustained traffic on a single page, the idle pages are swept as well
+	for i := range 8 {
+		c.now = c.now.Add(15 * time.Minute)
+
+		err := r.Store(context.Background(), domain.Visit{Visitor: "id3", PageURL: "url3", Time: c.now.Add(-time.Duration(i) * time.Second)})
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+	}
+
+	if len(r.recent.pages) != 1 || len(r.recent.index) != 1 {
+		t.Errorf("got %v pages, expected %v", len(r.recent.pages), 1)
+	}
+
+	if l := r.recent.pages["url3"].Len(); l != 1 {
+		t.Errorf("got %v visitors, expected %v", l, 1)
+	}
+
+	// the all-time count is not affected
+	counter, _ := r.CountUniqueVisitors(context.Background(), "url")
+	if counter.Count != 2 {
+		t.Errorf("got %v, expected %v", counter.Count, 2)
+	}
+}
+
+func TestFileRepositoryRecent(t *testing.T) {
+	dir := t.TempDir()
+	c := &clock{now: time.Date(2024, 1, 10, 12, 30, 0, 0, time.UTC)}
+
+	r, err := NewFileVisitRepository(dir, withClock(c), WithSnapshots(0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = r.Close()
+	}()
+
+	for _, visit := range []domain.Visit{
+		{Visitor: "id", PageURL: "url", Time: c.now.Add(-20 * time.Minute)},
+		// not logged, it's in the same hour, but the last seen time is still updated
+		{Visitor: "id", PageURL: "url", Time: c.now.Add(-time.Minute)},
+	} {
+		err := r.Store(context.Background(), visit)
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+	}
+
+	counter, _ := r.CountRecentUniqueVisitors(context.Background(), "url", 5*time.Minute)
+	if counter.Count != 1 {
+		t.Errorf("got %v, expected %v", counter.Count, 1)
+	}
+}
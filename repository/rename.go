@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"context"
+	"iter"
+	"maps"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// rename moves the visitor of the page to a new id, keeping the latest time either was seen at
+func (r *recentVisitors) rename(url domain.PageURL, from, to visitorID) {
+	element, found := r.index[url][from]
+	if !found {
+		return
+	}
+
+	seen := element.Value.(*recentVisit).seen
+	r.forget(url, from)
+	r.see(url, to, seen)
+}
+
+// rename moves the pages of the visitor to a new id, the ranges of the pages both were seen in are merged
+func (h visitorHistory) rename(from, to visitorID) {
+	pages, found := h[from]
+	if !found {
+		return
+	}
+
+	delete(h, from)
+
+	target, found := h[to]
+	if !found {
+		h[to] = pages
+
+		return
+	}
+
+	for url, seen := range pages {
+		current, found := target[url]
+		if !found {
+			target[url] = seen
+
+			continue
+		}
+
+		current.extend(seen.first)
+		current.extend(seen.last)
+	}
+}
+
+// moveTo replaces the visitor with its new id in the set, reporting whether the visitor was there. A sketch can't
+// forget the visitor, so it's left as is. adapt converts the set once the new id is added (see adapt and adaptBucket)
+func moveTo(visitors visitorSet, from, to visitorID, adapt func(visitorSet) visitorSet) (visitorSet, bool) {
+	if !visitors.remove(from) {
+		return visitors, false
+	}
+
+	visitors.add(to)
+
+	return adapt(visitors), true
+}
+
+// seenIn returns the pages the visitor may be held in (to be moved or erased): the pages of its history, or every page
+// when the history is turned off. The caller must hold the write lock
+func (i *InMemoryVisitRepository) seenIn(visitor visitorID) iter.Seq[domain.PageURL] {
+	if i.history == nil {
+		return maps.Keys(i.count)
+	}
+
+	return func(yield func(domain.PageURL) bool) {
+		for url := range i.history[visitor] {
+			_, counted := i.count[url]
+			if counted && !yield(url) {
+				return
+			}
+		}
+	}
+}
+
+// renames reports whether renaming the visitor would change anything, so that only those renames are logged. With the
+// history on it's enough to look the visitor up, otherwise every page is looked at (spilled pages are read, not
+// faulted in). The caller must hold the write lock
+func (i *InMemoryVisitRepository) renames(from, to visitorID) (bool, error) {
+	if from == to {
+		return false, nil
+	}
+
+	if i.history != nil {
+		_, found := i.history[from]
+
+		return found, nil
+	}
+
+	for url := range i.count {
+		visitors, buckets, err := i.peek(url)
+		if err != nil {
+			return false, err
+		}
+
+		_, recent := i.recent.index[url][from]
+		if recent || holds(visitors, buckets, from) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// rename moves the visitor to a new id in every page it was seen in (the all-time sets, the time buckets, the recent
+// visitors and the history) and returns the number of pages it was moved in, the caller must hold the write lock. A
+// page both ids were seen in counts them once from then on. The pages are found through the history (see seenIn), and
+// pages counted with a sketch keep the old id (and count the new one apart once it's seen). Spilled pages are only
+// faulted in when they hold the old id
+func (i *InMemoryVisitRepository) rename(from, to visitorID) (domain.Count, error) {
+	if from == to {
+		return 0, nil
+	}
+
+	var moved domain.Count
+	for url := range i.seenIn(from) {
+		err := i.faultIf(url, func(visitors visitorSet, buckets *timeBuckets) bool {
+			return holds(visitors, buckets, from)
+		})
+		if err != nil {
+			return moved, err
+		}
+
+		visitors, resident := i.data[url]
+		if !resident {
+			i.recent.rename(url, from, to)
+
+			continue
+		}
+
+		visitors, found := moveTo(visitors, from, to, i.adapt)
+		if found {
+			i.data[url] = visitors
+			i.count[url] = visitors.estimate().Count
+			i.top.update(url, i.uniqueVisitors(url))
+			moved++
+		}
+
+		buckets, found := i.buckets[url]
+		if found {
+			for key, set := range buckets.hours {
+				buckets.hours[key], _ = moveTo(set, from, to, i.adaptBucket)
+			}
+
+			for key, set := range buckets.days {
+				buckets.days[key], _ = moveTo(set, from, to, i.adaptBucket)
+			}
+		}
+
+		i.recent.rename(url, from, to)
+
+		i.account(url, false)
+		i.fit()
+	}
+
+	i.history.rename(from, to)
+
+	return moved, nil
+}
+
+// RenameVisitors moves every visit of each visitor to its new id (e.g. when its pseudonym changes), under a single
+// lock, and returns the number of pages they were moved in
+func (i *InMemoryVisitRepository) RenameVisitors(ctx context.Context, renames []domain.Rename) (domain.Count, error) {
+	err := i.m.Lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	defer i.m.Unlock()
+
+	var moved domain.Count
+	for _, rename := range renames {
+		n, err := i.rename(rename.From, rename.To)
+		moved += n
+
+		if err != nil {
+			return moved, err
+		}
+	}
+
+	return moved, nil
+}
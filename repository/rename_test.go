@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+func TestRenameVisitor(t *testing.T) {
+	base := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	visits := []domain.Visit{
+		{Visitor: "old", PageURL: "/blog", Time: base},
+		{Visitor: "old", PageURL: "/about", Time: base.Add(time.Minute)},
+		// the visitor was already seen under its new id in /blog
+		{Visitor: "new", PageURL: "/blog", Time: base.Add(2 * time.Minute)},
+		{Visitor: "other", PageURL: "/blog", Time: base},
+	}
+
+	expectRenamed := func(t *testing.T, r fullRepository) {
+		t.Helper()
+
+		for url, expected := range map[domain.PageURL]domain.Count{"/blog": 2, "/about": 1} {
+			count, err := r.CountUniqueVisitors(context.Background(), url)
+			if err != nil || count.Count != expected {
+				t.Errorf("%s: got %v and %v, expected %v", url, count.Count, err, expected)
+			}
+
+			count, err = r.CountUniqueVisitorsBetween(context.Background(), url, base, base.Add(time.Hour))
+			if err != nil || count.Count != expected {
+				t.Errorf("%s between: got %v and %v, expected %v", url, count.Count, err, expected)
+			}
+		}
+
+		pages, err := r.ListVisitorPages(context.Background(), "new")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []domain.VisitedPage{
+			{PageURL: "/blog", FirstSeen: base, LastSeen: base.Add(2 * time.Minute)},
+			{PageURL: "/about", FirstSeen: base.Add(time.Minute), LastSeen: base.Add(time.Minute)},
+		}
+		if !reflect.DeepEqual(pages, expected) {
+			t.Errorf("got %v, expected %v", pages, expected)
+		}
+
+		pages, err = r.ListVisitorPages(context.Background(), "old")
+		if err != nil || len(pages) != 0 {
+			t.Errorf("got %v and %v, expected no pages", pages, err)
+		}
+	}
+
+	t.Run("in memory", func(t *testing.T) {
+		i := NewVisitsInMemoryRepository(WithVisitorHistory(true), withClock(&clock{now: base.Add(5 * time.Minute)}))
+
+		err := i.StoreBatch(context.Background(), visits)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// renames of visitors that aren't held change nothing
+		moved, err := i.RenameVisitors(context.Background(), []domain.Rename{{From: "unknown", To: "other"}, {From: "old", To: "new"}})
+		if err != nil || moved != 2 {
+			t.Errorf("got %v and %v, expected 2 pages", moved, err)
+		}
+
+		expectRenamed(t, i)
+
+		recent, err := i.CountRecentUniqueVisitors(context.Background(), "/blog", time.Hour)
+		if err != nil || recent.Count != 2 {
+			t.Errorf("got %v and %v, expected 2 recent visitors", recent.Count, err)
+		}
+	})
+
+	t.Run("without the history", func(t *testing.T) {
+		i := NewVisitsInMemoryRepository(WithVisitorHistory(false), withClock(&clock{now: base.Add(5 * time.Minute)}))
+
+		err := i.StoreBatch(context.Background(), visits)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// every page is looked at
+		moved, err := i.RenameVisitors(context.Background(), []domain.Rename{{From: "old", To: "new"}})
+		if err != nil || moved != 2 {
+			t.Errorf("got %v and %v, expected 2 pages", moved, err)
+		}
+
+		count, err := i.CountUniqueVisitors(context.Background(), "/blog")
+		if err != nil || count.Count != 2 {
+			t.Errorf("got %v and %v, expected 2", count.Count, err)
+		}
+	})
+
+	t.Run("renames that change nothing aren't logged", func(t *testing.T) {
+		r, err := NewFileVisitRepository(t.TempDir(), WithVisitorHistory(true), withClock(&clock{now: base}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer func() {
+			_ = r.Close()
+		}()
+
+		err = r.StoreBatch(context.Background(), visits)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tc := range []struct {
+			description string
+			from, to    string
+			moved       domain.Count
+			records     int
+		}{
+			{description: "moved", from: "old", to: "new", moved: 2, records: len(visits) + 1},
+			{description: "already moved", from: "old", to: "new", records: len(visits) + 1},
+			{description: "unknown visitor", from: "unknown", to: "new", records: len(visits) + 1},
+			{description: "same id", from: "new", to: "new", records: len(visits) + 1},
+		} {
+			moved, err := r.RenameVisitors(context.Background(), []domain.Rename{{From: tc.from, To: tc.to}})
+			if err != nil || moved != tc.moved {
+				t.Errorf("%s: got %v and %v, expected %v pages", tc.description, moved, err, tc.moved)
+			}
+
+			if r.log.records != tc.records {
+				t.Errorf("%s: got %v records, expected %v", tc.description, r.log.records, tc.records)
+			}
+		}
+	})
+
+	t.Run("survives a restart", func(t *testing.T) {
+		dir := t.TempDir()
+
+		r, err := NewFileVisitRepository(dir, WithVisitorHistory(true), WithSnapshots(0, 1), withClock(&clock{now: base}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = r.StoreBatch(context.Background(), visits)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = r.RenameVisitors(context.Background(), []domain.Rename{{From: "old", To: "new"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err = NewFileVisitRepository(dir, WithVisitorHistory(true), WithSnapshots(0, 1), withClock(&clock{now: base}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer func() {
+			_ = r.Close()
+		}()
+
+		expectRenamed(t, r)
+	})
+}
@@ -0,0 +1,233 @@
+package repository
+
+import (
+	"context"
+	"iter"
+	"maps"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// DefaultRetentionBatch is the number of pages (or visitors) handled per lock hold when expiring data, unless
+// configured otherwise
+const DefaultRetentionBatch = 500
+
+// Retention defines how long data is kept, a zero duration keeps it forever
+//   - Visits: visits older than this are dropped, along with the time buckets that held them
+//   - Pages: pages without visits for this long are dropped entirely
+//   - Batch: the number of pages, then visitors, handled per lock hold, so writers never wait for a whole run
+type Retention struct {
+	Visits time.Duration
+	Pages  time.Duration
+	Batch  int
+}
+
+// Expired is what enforcing a retention removed
+//   - Pages: the pages dropped
+//   - Buckets: the hourly and daily buckets dropped
+//   - Visitors: the unique visitors no longer counted, summed across pages (estimated for pages counted with sketches)
+//   - History: the pages dropped from the history of the visitors
+type Expired struct {
+	Pages    domain.Count
+	Buckets  domain.Count
+	Visitors domain.Count
+	History  domain.Count
+}
+
+// dropBefore drops the buckets that ended by cutoff (unix seconds), returning how many there were
+func (b *timeBuckets) dropBefore(cutoff int64) domain.Count {
+	var dropped domain.Count
+	for h := range b.hours {
+		if h+hour <= cutoff {
+			delete(b.hours, h)
+			dropped++
+		}
+	}
+
+	for d := range b.days {
+		if d+day <= cutoff {
+			delete(b.days, d)
+			dropped++
+		}
+	}
+
+	return dropped
+}
+
+// sets returns every bucket
+func (b *timeBuckets) sets() []visitorSet {
+	sets := make([]visitorSet, 0, len(b.hours)+len(b.days))
+	for _, set := range b.hours {
+		sets = append(sets, set)
+	}
+
+	for _, set := range b.days {
+		sets = append(sets, set)
+	}
+
+	return sets
+}
+
+// Expire drops the data older than the retention. Pages are handled a batch at a time, then the visitor history, and
+// the write lock is released between batches so visits keep being stored while it runs (pages stored meanwhile may be
+// left for the next run). Visits are dropped a bucket at a time and the all-time count of the page is then rebuilt from
+// its remaining buckets, so visits without a time (only counted all-time) are dropped along. Spilled pages are read
+// from the spill file and only faulted in when visits are dropped from them. When ctx is done (or a spilled page can't
+// be read) the run stops, what was removed until then is returned along with the error
+func (i *InMemoryVisitRepository) Expire(ctx context.Context, retention Retention) (Expired, error) {
+	now := i.opts.now()
+
+	batch := retention.Batch
+	if batch <= 0 {
+		batch = DefaultRetentionBatch
+	}
+
+	var expired Expired
+
+	err := inBatches(ctx, &i.m, maps.Keys(i.count), batch, func(url domain.PageURL) error {
+		return i.expirePage(url, retention, now, &expired)
+	})
+	if err != nil {
+		return expired, err
+	}
+
+	err = inBatches(ctx, &i.m, maps.Keys(i.history), batch, func(visitor visitorID) error {
+		i.expireHistory(visitor, retention, now, &expired)
+
+		return nil
+	})
+
+	return expired, err
+}
+
+// inBatches calls fn with every key, batch keys per hold of the write lock, until fn fails. The keys are read under the
+// lock too: a map can be changed while it's iterated, keys added meanwhile may or may not be produced and keys deleted
+// meanwhile aren't
+func inBatches[K comparable](ctx context.Context, m *rwLock, keys iter.Seq[K], batch int, fn func(K) error) error {
+	next, stop := iter.Pull(keys)
+	defer stop()
+
+	for {
+		// the lock is taken right away when it's free, even if ctx is done
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		err = m.Lock(ctx)
+		if err != nil {
+			return err
+		}
+
+		done := false
+		for n := 0; n < batch && !done && err == nil; n++ {
+			key, ok := next()
+			if ok {
+				err = fn(key)
+			}
+
+			done = !ok
+		}
+
+		m.Unlock()
+
+		if done || err != nil {
+			return err
+		}
+	}
+}
+
+// expirePage drops the page if it had no visits within the page retention, otherwise its visits older than the visit
+// retention. The caller must hold the write lock
+func (i *InMemoryVisitRepository) expirePage(url domain.PageURL, retention Retention, now time.Time, expired *Expired) error {
+	// the buckets of a spilled page are a copy read from the spill file, which replaces the page if it's changed
+	visitors, buckets, err := i.peek(url)
+	if err != nil {
+		return err
+	}
+
+	// the newest hour of the page is when it was last visited, pages only visited without a time never were
+	if retention.Pages > 0 && (buckets == nil || buckets.newest+hour <= now.Add(-retention.Pages).Unix()) {
+		i.dropPage(url, buckets, expired)
+
+		return nil
+	}
+
+	if retention.Visits <= 0 || buckets == nil {
+		return nil
+	}
+
+	dropped := buckets.dropBefore(now.Add(-retention.Visits).Unix())
+	if dropped == 0 {
+		return nil
+	}
+
+	expired.Buckets += dropped
+
+	remaining := buckets.sets()
+	if len(remaining) == 0 {
+		i.dropPage(url, buckets, expired)
+
+		return nil
+	}
+
+	i.restore(url, visitors, buckets, false)
+
+	visitors = i.adapt(union(remaining...))
+	count := visitors.estimate().Count
+
+	if count < i.count[url] {
+		expired.Visitors += i.count[url] - count
+	}
+
+	i.data[url] = visitors
+	i.count[url] = count
+	i.top.update(url, i.uniqueVisitors(url))
+	i.account(url, false)
+	i.fit()
+
+	return nil
+}
+
+// dropPage forgets the page, its history is dropped when the history is expired. The caller must hold the write lock
+func (i *InMemoryVisitRepository) dropPage(url domain.PageURL, buckets *timeBuckets, expired *Expired) {
+	expired.Pages++
+	expired.Visitors += i.count[url]
+
+	if buckets != nil {
+		expired.Buckets += domain.Count(len(buckets.hours) + len(buckets.days))
+	}
+
+	i.unaccount(url)
+
+	delete(i.data, url)
+	delete(i.count, url)
+	delete(i.buckets, url)
+
+	i.recent.drop(url)
+	i.top.remove(url)
+	i.sorted.remove(url)
+	i.pages.remove(url)
+}
+
+// expireHistory drops the pages of the visitor that were dropped or that it wasn't seen in within the visit retention,
+// the visitor is dropped once it has no pages left. The caller must hold the write lock
+func (i *InMemoryVisitRepository) expireHistory(visitor visitorID, retention Retention, now time.Time, expired *Expired) {
+	pages := i.history[visitor]
+	cutoff := now.Add(-retention.Visits)
+
+	for url, seen := range pages {
+		_, found := i.count[url]
+		if found && (retention.Visits <= 0 || seen.last.After(cutoff)) {
+			continue
+		}
+
+		delete(pages, url)
+		expired.History++
+	}
+
+	if len(pages) == 0 {
+		delete(i.history, visitor)
+	}
+}
@@ -0,0 +1,272 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+func TestExpire(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	days := func(n int) time.Time { return now.Add(-time.Duration(n) * 24 * time.Hour) }
+
+	visits := []domain.Visit{
+		// a was only seen long ago, b recently
+		{Visitor: "a", PageURL: "/blog", Time: days(400)},
+		{Visitor: "b", PageURL: "/blog", Time: days(400)},
+		{Visitor: "b", PageURL: "/blog", Time: days(1)},
+		// /old had no visits for 100 days
+		{Visitor: "a", PageURL: "/old", Time: days(100)},
+		{Visitor: "c", PageURL: "/new", Time: days(2)},
+	}
+
+	retention := Retention{Visits: 365 * 24 * time.Hour, Pages: 90 * 24 * time.Hour, Batch: 1}
+
+	expectExpired := func(t *testing.T, r fullRepository) {
+		t.Helper()
+
+		for url, expected := range map[domain.PageURL]domain.Count{"/blog": 1, "/old": 0, "/new": 1} {
+			count, err := r.CountUniqueVisitors(context.Background(), url)
+			if err != nil || count.Count != expected {
+				t.Errorf("%s: got %v and %v, expected %v", url, count.Count, err, expected)
+			}
+		}
+
+		count, err := r.CountUniqueVisitorsBetween(context.Background(), "/blog", days(500).Truncate(24*time.Hour), days(300).Truncate(24*time.Hour))
+		if err != nil || count.Count != 0 {
+			t.Errorf("got %v and %v, expected the old visits to be dropped", count.Count, err)
+		}
+
+		pages, err := r.ListPages(context.Background(), domain.PageQuery{Order: domain.OrderByURL, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectedPages := []domain.PageCount{
+			{PageURL: "/blog", UniqueVisitors: domain.UniqueVisitors{Count: 1}},
+			{PageURL: "/new", UniqueVisitors: domain.UniqueVisitors{Count: 1}},
+		}
+		if !reflect.DeepEqual(pages, expectedPages) {
+			t.Errorf("got %v, expected %v", pages, expectedPages)
+		}
+
+		rollup, err := r.CountUniqueVisitorsMatching(context.Background(), "/old")
+		if err != nil || rollup.Pages != 0 {
+			t.Errorf("got %v and %v, expected /old to be dropped", rollup, err)
+		}
+
+		history, err := r.ListVisitorPages(context.Background(), "a")
+		if err != nil || len(history) != 0 {
+			t.Errorf("got %v and %v, expected the history of a to be dropped", history, err)
+		}
+
+		history, err = r.ListVisitorPages(context.Background(), "b")
+		if err != nil || len(history) != 1 {
+			t.Errorf("got %v and %v, expected the history of b to be kept", history, err)
+		}
+	}
+
+	t.Run("in memory", func(t *testing.T) {
+		i := NewVisitsInMemoryRepository(WithVisitorHistory(true), withClock(&clock{now: now}))
+
+		err := i.StoreBatch(context.Background(), visits)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expired, err := i.Expire(context.Background(), retention)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := Expired{Pages: 1, Buckets: 2, Visitors: 2, History: 2}
+		if expired != expected {
+			t.Errorf("got %+v, expected %+v", expired, expected)
+		}
+
+		expectExpired(t, i)
+
+		top, err := i.CountTopPages(context.Background(), 10, 0)
+		if err != nil || len(top) != 2 {
+			t.Errorf("got %v and %v, expected 2 pages", top, err)
+		}
+
+		// nothing is left to expire
+		expired, err = i.Expire(context.Background(), retention)
+		if err != nil || expired != (Expired{}) {
+			t.Errorf("got %+v and %v, expected nothing expired", expired, err)
+		}
+	})
+
+	t.Run("stops when the context is done", func(t *testing.T) {
+		i := NewVisitsInMemoryRepository(WithVisitorHistory(true), withClock(&clock{now: now}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := i.Expire(ctx, retention)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v, expected %v", err, context.Canceled)
+		}
+	})
+
+	t.Run("visits keep being stored meanwhile", func(t *testing.T) {
+		i := NewVisitsInMemoryRepository(WithVisitorHistory(true), withClock(&clock{now: now}))
+
+		for n := range 1000 {
+			err := i.Store(context.Background(), domain.Visit{Visitor: "a", PageURL: fmt.Sprintf("/old/%d", n), Time: days(100)})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+
+			for n := range 1000 {
+				_ = i.Store(context.Background(), domain.Visit{Visitor: "b", PageURL: fmt.Sprintf("/new/%d", n), Time: now})
+			}
+		}()
+
+		expired, err := i.Expire(context.Background(), Retention{Pages: 90 * 24 * time.Hour, Batch: 10})
+		if err != nil || expired.Pages != 1000 {
+			t.Errorf("got %+v and %v, expected 1000 pages expired", expired, err)
+		}
+
+		<-done
+
+		rollup, err := i.CountUniqueVisitorsMatching(context.Background(), "/new/*")
+		if err != nil || rollup.Pages != 1000 {
+			t.Errorf("got %v and %v, expected the new pages to be kept", rollup, err)
+		}
+	})
+
+	t.Run("survives a restart once snapshotted", func(t *testing.T) {
+		dir := t.TempDir()
+
+		r, err := NewFileVisitRepository(dir, WithVisitorHistory(true), WithSnapshots(0, 1), withClock(&clock{now: now}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = r.StoreBatch(context.Background(), visits)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = r.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = r.Expire(context.Background(), retention)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// nothing was logged since the last snapshot, it's replaced anyway
+		err = r.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err = NewFileVisitRepository(dir, WithVisitorHistory(true), WithSnapshots(0, 1), withClock(&clock{now: now}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer func() {
+			_ = r.Close()
+		}()
+
+		expectExpired(t, r)
+	})
+}
+
+func TestJanitor(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	i := NewVisitsInMemoryRepository(WithVisitorHistory(true), withClock(&clock{now: now}))
+
+	err := i.Store(context.Background(), domain.Visit{Visitor: "a", PageURL: "/old", Time: now.Add(-48 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	janitor := NewJanitor(i, Retention{Pages: 24 * time.Hour}, time.Hour)
+
+	_, err = janitor.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]string{"runs": "1", "pages": "1", "visitors": "1", "history": "1"} {
+		got := janitor.Metrics().Get(name)
+		if got == nil || got.String() != expected {
+			t.Errorf("%s: got %v, expected %v", name, got, expected)
+		}
+	}
+}
+
+func TestPageIndexesRemove(t *testing.T) {
+	urls := []domain.PageURL{"/a", "/a/b", "/a/c", "/b"}
+
+	sorted := &sortedPages{}
+	trie := newPageTrie()
+	board := newLeaderboard()
+
+	for n, url := range urls {
+		sorted.insert(url)
+		trie.insert(url)
+		board.update(url, domain.UniqueVisitors{Count: domain.Count(n)})
+	}
+
+	for _, url := range []domain.PageURL{"/a/b", "/b", "/unknown"} {
+		sorted.remove(url)
+		trie.remove(url)
+		board.remove(url)
+	}
+
+	var listed []domain.PageURL
+	sorted.ascend("", func(url domain.PageURL) bool {
+		listed = append(listed, url)
+
+		return true
+	})
+
+	var matched []domain.PageURL
+	trie.match(segments("/**"), func(url domain.PageURL) {
+		matched = append(matched, url)
+	})
+
+	top := board.top(10)
+
+	expected := []domain.PageURL{"/a", "/a/c"}
+	if !reflect.DeepEqual(listed, expected) {
+		t.Errorf("sorted: got %v, expected %v", listed, expected)
+	}
+
+	if len(matched) != 2 {
+		t.Errorf("trie: got %v, expected %v", matched, expected)
+	}
+
+	if len(top) != 2 || top[0].PageURL != "/a/c" || top[1].PageURL != "/a" {
+		t.Errorf("leaderboard: got %v, expected /a/c then /a", top)
+	}
+
+	_, found := trie.children[""].children["b"]
+	if found {
+		t.Errorf("trie: expected the node of /b to be dropped")
+	}
+}
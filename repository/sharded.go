@@ -0,0 +1,390 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"hash/maphash"
+	"slices"
+	"strings"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// ShardedVisitRepository spreads the pages over several InMemoryVisitRepository shards, picked by a hash of the page
+// url, each with its own lock. Writes to pages in different shards don't wait for each other, which the single lock of
+// InMemoryVisitRepository serializes.
+//
+// Reads of a single page go to its shard. Reads of several pages (bulk counts, combinations, rollups, top pages, page
+// listings and the visitor history) take the read lock of every shard involved, always in shard order so that they
+// can't deadlock, and merge their results: they're a consistent snapshot just like with a single lock. Writes only ever
+// hold the lock of a single shard:
+//   - a batch is stored a shard at a time, so readers may see part of it
+//   - erasures, renames and expiry go through the shards one after the other
+//
+// Options apply to every shard, except the memory budget which is split between them
+type ShardedVisitRepository struct {
+	shards []*InMemoryVisitRepository
+	seed   maphash.Seed
+	opts   options
+}
+
+// NewShardedVisitRepository is a constructor for the sharded VisitRepository, shards is at least 1
+func NewShardedVisitRepository(shards int, opts ...Option) *ShardedVisitRepository {
+	o := buildOptions(opts)
+
+	shards = max(shards, 1)
+	o.memoryBudget /= int64(shards)
+
+	s := &ShardedVisitRepository{
+		shards: make([]*InMemoryVisitRepository, shards),
+		seed:   maphash.MakeSeed(),
+		opts:   o,
+	}
+
+	for n := range s.shards {
+		s.shards[n] = newInMemoryRepository(o)
+	}
+
+	return s
+}
+
+// shardOf returns the position of the shard holding the page
+func (s *ShardedVisitRepository) shardOf(url domain.PageURL) int {
+	return int(maphash.String(s.seed, url) % uint64(len(s.shards)))
+}
+
+// rlock takes the read lock of the shards at the given positions, in order. The returned function releases them
+func (s *ShardedVisitRepository) rlock(ctx context.Context, positions []int) (func(), error) {
+	slices.Sort(positions)
+	positions = slices.Compact(positions)
+
+	for n, position := range positions {
+		err := s.shards[position].m.RLock(ctx)
+		if err != nil {
+			for _, locked := range positions[:n] {
+				s.shards[locked].m.RUnlock()
+			}
+
+			return nil, err
+		}
+	}
+
+	return func() {
+		for _, position := range positions {
+			s.shards[position].m.RUnlock()
+		}
+	}, nil
+}
+
+// rlockAll takes the read lock of every shard
+func (s *ShardedVisitRepository) rlockAll(ctx context.Context) (func(), error) {
+	positions := make([]int, len(s.shards))
+	for n := range positions {
+		positions[n] = n
+	}
+
+	return s.rlock(ctx, positions)
+}
+
+// Store stores the visit in the shard of its page
+func (s *ShardedVisitRepository) Store(ctx context.Context, visit domain.Visit) error {
+	return s.shards[s.shardOf(visit.PageURL)].Store(ctx, visit)
+}
+
+// StoreBatch splits the visits by shard and stores them a shard at a time, taking each lock only once. If a shard's
+// lock can't be taken the visits of the shards before it are kept
+func (s *ShardedVisitRepository) StoreBatch(ctx context.Context, visits []domain.Visit) error {
+	batches := make([][]domain.Visit, len(s.shards))
+	for _, visit := range visits {
+		position := s.shardOf(visit.PageURL)
+		batches[position] = append(batches[position], visit)
+	}
+
+	for position, batch := range batches {
+		if len(batch) == 0 {
+			continue
+		}
+
+		err := s.shards[position].StoreBatch(ctx, batch)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CountUniqueVisitors reads the count from the shard of the page
+func (s *ShardedVisitRepository) CountUniqueVisitors(ctx context.Context, url domain.PageURL) (domain.UniqueVisitors, error) {
+	return s.shards[s.shardOf(url)].CountUniqueVisitors(ctx, url)
+}
+
+// CountUniqueVisitorsBulk reads the counts under the read lock of every shard involved, so they're consistent with
+// each other
+func (s *ShardedVisitRepository) CountUniqueVisitorsBulk(ctx context.Context, urls []domain.PageURL) (map[domain.PageURL]domain.UniqueVisitors, error) {
+	positions := make([]int, len(urls))
+	for n, url := range urls {
+		positions[n] = s.shardOf(url)
+	}
+
+	unlock, err := s.rlock(ctx, slices.Clone(positions))
+	if err != nil {
+		return nil, err
+	}
+
+	defer unlock()
+
+	counts := make(map[domain.PageURL]domain.UniqueVisitors, len(urls))
+	for n, url := range urls {
+		counts[url] = s.shards[positions[n]].uniqueVisitors(url)
+	}
+
+	return counts, nil
+}
+
+// CountUniqueVisitorsCombined combines the sets of the pages read under the read lock of every shard involved, see
+// InMemoryVisitRepository.CountUniqueVisitorsCombined
+func (s *ShardedVisitRepository) CountUniqueVisitorsCombined(ctx context.Context, urls []domain.PageURL, operator domain.SetOperator) (domain.UniqueVisitors, error) {
+	err := validCombination(urls)
+	if err != nil {
+		return domain.UniqueVisitors{}, err
+	}
+
+	positions := make([]int, len(urls))
+	for n, url := range urls {
+		positions[n] = s.shardOf(url)
+	}
+
+	unlock, err := s.rlock(ctx, slices.Clone(positions))
+	if err != nil {
+		return domain.UniqueVisitors{}, err
+	}
+
+	defer unlock()
+
+	sets := make([]visitorSet, 0, len(urls))
+	for n, url := range urls {
+		visitors, err := s.shards[positions[n]].visitorsOf(url)
+		if err != nil {
+			return domain.UniqueVisitors{}, err
+		}
+
+		sets = append(sets, visitors)
+	}
+
+	return combine(sets, operator)
+}
+
+// CountUniqueVisitorsMatching merges the visitors of the pages matching the pattern in every shard
+func (s *ShardedVisitRepository) CountUniqueVisitorsMatching(ctx context.Context, pattern string) (domain.PageRollup, error) {
+	patternSegments, err := parsePattern(pattern)
+	if err != nil {
+		return domain.PageRollup{}, err
+	}
+
+	unlock, err := s.rlockAll(ctx)
+	if err != nil {
+		return domain.PageRollup{}, err
+	}
+
+	defer unlock()
+
+	var sets []visitorSet
+	for _, shard := range s.shards {
+		matched, err := shard.matching(patternSegments)
+		if err != nil {
+			return domain.PageRollup{}, err
+		}
+
+		sets = append(sets, matched...)
+	}
+
+	return rollup(sets), nil
+}
+
+// CountUniqueVisitorsBetween counts from the time buckets of the shard of the page
+func (s *ShardedVisitRepository) CountUniqueVisitorsBetween(ctx context.Context, url domain.PageURL, from, to time.Time) (domain.UniqueVisitors, error) {
+	return s.shards[s.shardOf(url)].CountUniqueVisitorsBetween(ctx, url, from, to)
+}
+
+// CountUniqueVisitorsSeries counts from the time buckets of the shard of the page
+func (s *ShardedVisitRepository) CountUniqueVisitorsSeries(ctx context.Context, url domain.PageURL, interval time.Duration, from, to time.Time) ([]domain.Bucket, error) {
+	return s.shards[s.shardOf(url)].CountUniqueVisitorsSeries(ctx, url, interval, from, to)
+}
+
+// CountRecentUniqueVisitors counts from the recent visitors of the shard of the page
+func (s *ShardedVisitRepository) CountRecentUniqueVisitors(ctx context.Context, url domain.PageURL, window time.Duration) (domain.UniqueVisitors, error) {
+	return s.shards[s.shardOf(url)].CountRecentUniqueVisitors(ctx, url, window)
+}
+
+// CountTopPages merges the top pages of every shard, a page is only in one of them so the top of the merge is the top
+// of every page. See InMemoryVisitRepository.CountTopPages
+func (s *ShardedVisitRepository) CountTopPages(ctx context.Context, limit int, window time.Duration) ([]domain.PageCount, error) {
+	if window < 0 || window > s.opts.maxWindow {
+		return nil, domain.ErrInvalidWindow
+	}
+
+	unlock, err := s.rlockAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer unlock()
+
+	var pages []domain.PageCount
+	for _, shard := range s.shards {
+		if window == 0 {
+			pages = append(pages, shard.top.top(limit)...)
+		} else {
+			pages = append(pages, shard.recent.topRecent(limit, window, s.opts.now())...)
+		}
+	}
+
+	return firstPages(pages, limit, byRank), nil
+}
+
+// ListPages merges the pages listed by every shard, each lists up to query.Limit pages after query.After so the first
+// query.Limit of the merge are the ones listed from every page. See InMemoryVisitRepository.ListPages
+func (s *ShardedVisitRepository) ListPages(ctx context.Context, query domain.PageQuery) ([]domain.PageCount, error) {
+	if query.Limit <= 0 {
+		return []domain.PageCount{}, nil
+	}
+
+	unlock, err := s.rlockAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer unlock()
+
+	var pages []domain.PageCount
+	for _, shard := range s.shards {
+		if query.Order == domain.OrderByCount {
+			pages = append(pages, shard.listByCount(query)...)
+		} else {
+			pages = append(pages, shard.listByURL(query)...)
+		}
+	}
+
+	if query.Order == domain.OrderByCount {
+		return firstPages(pages, query.Limit, byRank), nil
+	}
+
+	return firstPages(pages, query.Limit, byURL), nil
+}
+
+// byRank orders pages from the most visited, ties by url
+func byRank(a, b domain.PageCount) int {
+	switch {
+	case ranksAbove(a, b):
+		return -1
+	case ranksAbove(b, a):
+		return 1
+	default:
+		return 0
+	}
+}
+
+func byURL(a, b domain.PageCount) int {
+	return strings.Compare(a.PageURL, b.PageURL)
+}
+
+// firstPages sorts the pages and keeps the first limit of them
+func firstPages(pages []domain.PageCount, limit int, order func(a, b domain.PageCount) int) []domain.PageCount {
+	if limit <= 0 || len(pages) == 0 {
+		return []domain.PageCount{}
+	}
+
+	slices.SortFunc(pages, order)
+
+	return pages[:min(limit, len(pages))]
+}
+
+// ListVisitorPages merges the history of the visitor in every shard, see InMemoryVisitRepository.ListVisitorPages
+func (s *ShardedVisitRepository) ListVisitorPages(ctx context.Context, visitor string) ([]domain.VisitedPage, error) {
+	if !s.opts.visitorHistory {
+		return nil, domain.ErrHistoryDisabled
+	}
+
+	unlock, err := s.rlockAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer unlock()
+
+	pages := []domain.VisitedPage{}
+	for _, shard := range s.shards {
+		pages = append(pages, shard.history.pages(visitor)...)
+	}
+
+	slices.SortFunc(pages, domain.ByFirstSeen)
+
+	return pages, nil
+}
+
+// EraseVisitor removes the visitor from every shard, one after the other, and returns the number of pages it was
+// removed from (and the ones retaining it). If a shard's lock can't be taken the visitor stays in it and the shards
+// after it
+func (s *ShardedVisitRepository) EraseVisitor(ctx context.Context, visitor string) (domain.ErasedPages, error) {
+	var erased domain.ErasedPages
+	for _, shard := range s.shards {
+		e, err := shard.EraseVisitor(ctx, visitor)
+		erased.Erased += e.Erased
+		erased.Retained += e.Retained
+
+		if err != nil {
+			return erased, err
+		}
+	}
+
+	return erased, nil
+}
+
+// RenameVisitors moves the visitors to their new id in every shard, one after the other (each shard's lock is taken
+// once for all of them), and returns the number of pages they were moved in. If a shard's lock can't be taken the
+// visitors keep their id in it and the shards after it
+func (s *ShardedVisitRepository) RenameVisitors(ctx context.Context, renames []domain.Rename) (domain.Count, error) {
+	var moved domain.Count
+	for _, shard := range s.shards {
+		n, err := shard.RenameVisitors(ctx, renames)
+		moved += n
+
+		if err != nil {
+			return moved, err
+		}
+	}
+
+	return moved, nil
+}
+
+// Expire drops the data older than the retention from every shard, one after the other, see
+// InMemoryVisitRepository.Expire
+func (s *ShardedVisitRepository) Expire(ctx context.Context, retention Retention) (Expired, error) {
+	var expired Expired
+	for _, shard := range s.shards {
+		e, err := shard.Expire(ctx, retention)
+
+		expired.Pages += e.Pages
+		expired.Buckets += e.Buckets
+		expired.Visitors += e.Visitors
+		expired.History += e.History
+
+		if err != nil {
+			return expired, err
+		}
+	}
+
+	return expired, nil
+}
+
+// Close removes the spill files of the shards, if any. The repository can't be used afterward
+func (s *ShardedVisitRepository) Close() error {
+	var err error
+	for _, shard := range s.shards {
+		err = errors.Join(err, shard.Close())
+	}
+
+	return err
+}
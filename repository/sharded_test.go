@@ -0,0 +1,312 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// shardedVisits visits 40 pages under a few prefixes, with visitors seen in several of them at different times
+func shardedVisits(base time.Time) []domain.Visit {
+	var visits []domain.Visit
+	for page := range 40 {
+		for visitor := range page%5 + 1 {
+			visits = append(visits, domain.Visit{
+				Visitor: fmt.Sprintf("v%d", (page+visitor)%11),
+				PageURL: fmt.Sprintf("/section-%d/page-%d", page%3, page),
+				Time:    base.Add(-time.Duration(page+visitor) * time.Minute),
+			})
+		}
+	}
+
+	return visits
+}
+
+func TestShardedRepository(t *testing.T) {
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	// every read must return what the same reads return from a single repository
+	expectSame := func(t *testing.T, r *ShardedVisitRepository, expected *InMemoryVisitRepository) {
+		t.Helper()
+
+		var urls []domain.PageURL
+		for page := range 40 {
+			urls = append(urls, fmt.Sprintf("/section-%d/page-%d", page%3, page))
+		}
+
+		same := func(description string, got, want any, err error) {
+			t.Helper()
+
+			if err != nil || !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: got %v and %v, expected %v", description, got, err, want)
+			}
+		}
+
+		for _, url := range urls {
+			got, err := r.CountUniqueVisitors(ctx, url)
+			want, _ := expected.CountUniqueVisitors(ctx, url)
+			same(url, got, want, err)
+
+			got, err = r.CountUniqueVisitorsBetween(ctx, url, now.Add(-time.Hour), now.Add(-10*time.Minute))
+			want, _ = expected.CountUniqueVisitorsBetween(ctx, url, now.Add(-time.Hour), now.Add(-10*time.Minute))
+			same(url+" between", got, want, err)
+
+			got, err = r.CountRecentUniqueVisitors(ctx, url, 15*time.Minute)
+			want, _ = expected.CountRecentUniqueVisitors(ctx, url, 15*time.Minute)
+			same(url+" recent", got, want, err)
+		}
+
+		bulk, err := r.CountUniqueVisitorsBulk(ctx, urls)
+		expectedBulk, _ := expected.CountUniqueVisitorsBulk(ctx, urls)
+		same("bulk", bulk, expectedBulk, err)
+
+		for _, operator := range []domain.SetOperator{domain.Union, domain.Intersection, domain.Difference} {
+			got, err := r.CountUniqueVisitorsCombined(ctx, urls[:7], operator)
+			want, _ := expected.CountUniqueVisitorsCombined(ctx, urls[:7], operator)
+			same(string(operator), got, want, err)
+		}
+
+		for _, pattern := range []string{"/section-1/*", "/**", "/none/*"} {
+			got, err := r.CountUniqueVisitorsMatching(ctx, pattern)
+			want, _ := expected.CountUniqueVisitorsMatching(ctx, pattern)
+			same(pattern, got, want, err)
+		}
+
+		for _, window := range []time.Duration{0, 15 * time.Minute} {
+			got, err := r.CountTopPages(ctx, 5, window)
+			want, _ := expected.CountTopPages(ctx, 5, window)
+			same(fmt.Sprintf("top %v", window), got, want, err)
+		}
+
+		// every page is listed once, a page at a time
+		for _, order := range []domain.PageOrder{domain.OrderByURL, domain.OrderByCount} {
+			query := domain.PageQuery{Prefix: "/section-2/", Order: order, Limit: 3}
+			for {
+				got, err := r.ListPages(ctx, query)
+				want, _ := expected.ListPages(ctx, query)
+				same(fmt.Sprintf("list by %v after %v", order, query.After), got, want, err)
+
+				if len(want) == 0 {
+					break
+				}
+
+				query.After = &want[len(want)-1]
+			}
+		}
+
+		for visitor := range 11 {
+			got, err := r.ListVisitorPages(ctx, fmt.Sprintf("v%d", visitor))
+			want, _ := expected.ListVisitorPages(ctx, fmt.Sprintf("v%d", visitor))
+			same(fmt.Sprintf("v%d pages", visitor), got, want, err)
+		}
+	}
+
+	for _, shards := range []int{1, 4, 16} {
+		t.Run(fmt.Sprintf("%d shards", shards), func(t *testing.T) {
+			r := NewShardedVisitRepository(shards, WithVisitorHistory(true), withClock(&clock{now: now}))
+			expected := NewVisitsInMemoryRepository(WithVisitorHistory(true), withClock(&clock{now: now}))
+
+			visits := shardedVisits(now)
+
+			for _, visit := range visits[:20] {
+				_ = expected.Store(ctx, visit)
+
+				err := r.Store(ctx, visit)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_ = expected.StoreBatch(ctx, visits[20:])
+
+			err := r.StoreBatch(ctx, visits[20:])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expectSame(t, r, expected)
+
+			erased, err := r.EraseVisitor(ctx, "v3")
+			expectedErased, _ := expected.EraseVisitor(ctx, "v3")
+			if err != nil || erased != expectedErased {
+				t.Errorf("got %v and %v, expected %v", erased, err, expectedErased)
+			}
+
+			moved, err := r.RenameVisitors(ctx, []domain.Rename{{From: "v4", To: "w4"}})
+			expectedMoved, _ := expected.RenameVisitors(ctx, []domain.Rename{{From: "v4", To: "w4"}})
+			if err != nil || moved != expectedMoved {
+				t.Errorf("got %v and %v, expected %v", moved, err, expectedMoved)
+			}
+
+			retention := Retention{Visits: 30 * time.Minute, Pages: 20 * time.Minute}
+
+			expired, err := r.Expire(ctx, retention)
+			expectedExpired, _ := expected.Expire(ctx, retention)
+			if err != nil || expired != expectedExpired {
+				t.Errorf("got %v and %v, expected %v", expired, err, expectedExpired)
+			}
+
+			expectSame(t, r, expected)
+		})
+	}
+
+	t.Run("invalid queries", func(t *testing.T) {
+		r := NewShardedVisitRepository(4, WithVisitorHistory(true))
+
+		_, err := r.CountTopPages(ctx, 5, -time.Minute)
+		if !errors.Is(err, domain.ErrInvalidWindow) {
+			t.Errorf("got %v, expected %v", err, domain.ErrInvalidWindow)
+		}
+
+		_, err = r.CountUniqueVisitorsCombined(ctx, nil, domain.Union)
+		if !errors.Is(err, domain.ErrInvalidCombination) {
+			t.Errorf("got %v, expected %v", err, domain.ErrInvalidCombination)
+		}
+
+		_, err = r.CountUniqueVisitorsMatching(ctx, "/[")
+		if !errors.Is(err, domain.ErrInvalidPattern) {
+			t.Errorf("got %v, expected %v", err, domain.ErrInvalidPattern)
+		}
+
+		_, err = NewShardedVisitRepository(4, WithVisitorHistory(false)).ListVisitorPages(ctx, "a")
+		if !errors.Is(err, domain.ErrHistoryDisabled) {
+			t.Errorf("got %v, expected %v", err, domain.ErrHistoryDisabled)
+		}
+	})
+
+	t.Run("cancelled reads release the locks taken", func(t *testing.T) {
+		r := NewShardedVisitRepository(4, WithVisitorHistory(true))
+
+		// a writer holding the last shard makes reads of every shard wait for it
+		err := r.shards[3].m.Lock(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cancelled, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+
+		_, err = r.CountTopPages(cancelled, 5, 0)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("got %v, expected %v", err, context.DeadlineExceeded)
+		}
+
+		r.shards[3].m.Unlock()
+
+		for n, shard := range r.shards {
+			err := shard.m.Lock(ctx)
+			if err != nil {
+				t.Fatalf("shard %d: %v", n, err)
+			}
+
+			shard.m.Unlock()
+		}
+	})
+}
+
+// benchmarkRepositories are the implementations compared by the benchmarks
+var benchmarkRepositories = []struct {
+	name string
+	new  func() fullRepository
+}{
+	{name: "in memory", new: func() fullRepository { return NewVisitsInMemoryRepository() }},
+	{name: "4 shards", new: func() fullRepository { return NewShardedVisitRepository(4) }},
+	{name: "16 shards", new: func() fullRepository { return NewShardedVisitRepository(16) }},
+	{name: "64 shards", new: func() fullRepository { return NewShardedVisitRepository(64) }},
+}
+
+// BenchmarkConcurrentStore stores visits from every goroutine at once, as in TestInMemoryRepositoryConcurrency, over
+// 1000 pages
+func BenchmarkConcurrentStore(b *testing.B) {
+	now := time.Now()
+
+	for _, repository := range benchmarkRepositories {
+		b.Run(repository.name, func(b *testing.B) {
+			r := repository.new()
+
+			var visitors atomic.Int64
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					n := visitors.Add(1)
+
+					err := r.Store(context.Background(), domain.Visit{
+						Visitor: fmt.Sprintf("id%d", n),
+						PageURL: fmt.Sprintf("/page-%d", n%1000),
+						Time:    now,
+					})
+					if err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkConcurrentStoreAndCount mixes stores with counts of the same pages, a count for every 4 stores
+func BenchmarkConcurrentStoreAndCount(b *testing.B) {
+	now := time.Now()
+
+	for _, repository := range benchmarkRepositories {
+		b.Run(repository.name, func(b *testing.B) {
+			r := repository.new()
+
+			var visitors atomic.Int64
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					n := visitors.Add(1)
+					url := fmt.Sprintf("/page-%d", n%1000)
+
+					var err error
+					if n%5 == 0 {
+						_, err = r.CountUniqueVisitors(context.Background(), url)
+					} else {
+						err = r.Store(context.Background(), domain.Visit{Visitor: fmt.Sprintf("id%d", n), PageURL: url, Time: now})
+					}
+
+					if err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestShardedRepositoryConcurrency(t *testing.T) {
+	r := NewShardedVisitRepository(8)
+
+	var wg sync.WaitGroup
+	for page := range 20 {
+		for visitor := range 50 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				err := r.Store(context.Background(), domain.Visit{Visitor: fmt.Sprintf("id%d", visitor), PageURL: fmt.Sprintf("/page-%d", page)})
+				if err != nil {
+					t.Error("unexpected error", err)
+				}
+			}()
+		}
+	}
+
+	wg.Wait()
+
+	for page := range 20 {
+		counter, err := r.CountUniqueVisitors(context.Background(), fmt.Sprintf("/page-%d", page))
+		if err != nil || counter.Count != 50 {
+			t.Errorf("got %v and %v, expected 50", counter, err)
+		}
+	}
+}
@@ -0,0 +1,506 @@
+package repository
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+const (
+	snapshotPrefix = "snapshot-"
+	snapshotSuffix = ".snap"
+
+	snapshotMagic   = "DVSN"
+	snapshotVersion = 4
+
+	// snapshotVersionExactOnly is the format written before sketches existed, where every set is exact and has no kind
+	snapshotVersionExactOnly = 1
+	// snapshotVersionNoBuckets is the format written before visits had a time, where there are no time buckets
+	snapshotVersionNoBuckets = 2
+	// snapshotVersionNoHistory is the format written before the visitor history existed
+	snapshotVersionNoHistory = 3
+
+	setKindExact  byte = 0
+	setKindSketch byte = 1
+)
+
+var errCorruptSnapshot = errors.New("corrupt snapshot")
+
+// snapshotPath returns the path of the snapshot that covers every log segment numbered below seq
+func snapshotPath(dir string, seq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%020d%s", snapshotPrefix, seq, snapshotSuffix))
+}
+
+// encodeSnapshot writes a point-in-time copy of the page -> visitors sets in mem to w, the caller must hold (at least)
+// the read lock of mem. The format is:
+//   - magic bytes and format version
+//   - number of pages, uvarint
+//   - for each page: page url and its set of visitors, strings are prefixed by their uvarint length
+//   - an exact set is written as its kind, the number of visitors (uvarint) and each visitor id
+//   - a sketch is written as its kind, its precision and its registers
+//   - number of pages with time buckets, uvarint
+//   - for each page: page url, newest hour (varint), number of hourly buckets (uvarint) followed by the key (varint) and
+//     set of each bucket, number of daily buckets (uvarint) followed by the key (varint) and set of each bucket
+//   - number of visitors in the history (uvarint, 0 when it's turned off)
+//   - for each visitor: visitor id, number of pages (uvarint) followed by the page url and the first and last time it
+//     was seen (unix nanoseconds, varint, 0 when unknown) of each page
+//   - checksum (crc32 castagnoli) of everything before it, uint32 little endian
+func encodeSnapshot(w io.Writer, mem *InMemoryVisitRepository) error {
+	buffered := bufio.NewWriter(w)
+	checksum := crc32.New(crcTable)
+	body := io.MultiWriter(buffered, checksum)
+
+	// spilled pages are read back from the spill file, they're written like the resident ones
+	spilled := mem.spilledPages()
+
+	b := append([]byte(snapshotMagic), snapshotVersion)
+	b = binary.AppendUvarint(b, uint64(len(mem.data)+len(spilled)))
+
+	_, err := body.Write(b)
+	if err != nil {
+		return err
+	}
+
+	for pageURL, visitors := range mem.data {
+		_, err := body.Write(appendPage(b[:0], pageURL, visitors))
+		if err != nil {
+			return err
+		}
+	}
+
+	withBuckets := len(mem.buckets)
+	for pageURL, page := range spilled {
+		visitors, _, err := mem.peek(pageURL)
+		if err == nil {
+			_, err = body.Write(appendPage(b[:0], pageURL, visitors))
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if page.buckets {
+			withBuckets++
+		}
+	}
+
+	_, err = body.Write(binary.AppendUvarint(b[:0], uint64(withBuckets)))
+	if err != nil {
+		return err
+	}
+
+	for pageURL, buckets := range mem.buckets {
+		_, err := body.Write(appendPageBuckets(b[:0], pageURL, buckets))
+		if err != nil {
+			return err
+		}
+	}
+
+	for pageURL, page := range spilled {
+		if !page.buckets {
+			continue
+		}
+
+		_, buckets, err := mem.peek(pageURL)
+		if err == nil {
+			_, err = body.Write(appendPageBuckets(b[:0], pageURL, buckets))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = body.Write(binary.AppendUvarint(b[:0], uint64(len(mem.history))))
+	if err != nil {
+		return err
+	}
+
+	for visitor, pages := range mem.history {
+		b = appendString(b[:0], visitor)
+		b = binary.AppendUvarint(b, uint64(len(pages)))
+
+		for pageURL, seen := range pages {
+			b = appendString(b, pageURL)
+			b = binary.AppendVarint(b, unixNano(seen.first))
+			b = binary.AppendVarint(b, unixNano(seen.last))
+		}
+
+		_, err := body.Write(b)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = buffered.Write(binary.LittleEndian.AppendUint32(nil, checksum.Sum32()))
+	if err != nil {
+		return err
+	}
+
+	return buffered.Flush()
+}
+
+// loadSnapshot reads the snapshot in path into mem, mem must be empty and is left in an undefined state if the
+// snapshot is corrupt
+func loadSnapshot(path string, mem *InMemoryVisitRepository) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	return decodeSnapshot(file, mem)
+}
+
+func decodeSnapshot(r io.Reader, mem *InMemoryVisitRepository) error {
+	buffered := bufio.NewReader(r)
+	body := &checksumReader{r: buffered, h: crc32.New(crcTable)}
+
+	header := make([]byte, len(snapshotMagic)+1)
+
+	_, err := io.ReadFull(body, header)
+	if err != nil || string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return errCorruptSnapshot
+	}
+
+	version := header[len(snapshotMagic)]
+	if version < snapshotVersionExactOnly || version > snapshotVersion {
+		return errCorruptSnapshot
+	}
+
+	pages, err := binary.ReadUvarint(body)
+	if err != nil {
+		return errCorruptSnapshot
+	}
+
+	for range pages {
+		pageURL, err := readSnapshotString(body)
+		if err != nil {
+			return err
+		}
+
+		kind := setKindExact
+		if version != snapshotVersionExactOnly {
+			kind, err = body.ReadByte()
+			if err != nil {
+				return errCorruptSnapshot
+			}
+		}
+
+		visitors, err := readVisitorSet(body, kind)
+		if err != nil {
+			return err
+		}
+
+		// older snapshots have no history, the visitors of the exact sets are the best that can be done (without times)
+		exact, isExact := visitors.(exactSet)
+		if isExact && version <= snapshotVersionNoHistory {
+			for visitor := range exact {
+				mem.history.record(domain.Visit{Visitor: visitor, PageURL: pageURL})
+			}
+		}
+
+		// sets are kept as they were written, except for exact sets that the counting mode would have turned into sketches
+		visitors = mem.adapt(visitors)
+
+		mem.data[pageURL] = visitors
+		mem.count[pageURL] = visitors.estimate().Count
+		mem.pages.insert(pageURL)
+		mem.sorted.insert(pageURL)
+		mem.top.update(pageURL, mem.uniqueVisitors(pageURL))
+		mem.account(pageURL, true)
+		mem.fit()
+	}
+
+	if version > snapshotVersionNoBuckets {
+		err := decodeBuckets(body, mem)
+		if err != nil {
+			return err
+		}
+	}
+
+	if version > snapshotVersionNoHistory {
+		err := decodeHistory(body, mem)
+		if err != nil {
+			return err
+		}
+	}
+
+	trailer := make([]byte, 4)
+
+	_, err = io.ReadFull(buffered, trailer)
+	if err != nil || binary.LittleEndian.Uint32(trailer) != body.h.Sum32() {
+		return errCorruptSnapshot
+	}
+
+	_, err = buffered.ReadByte()
+	if !errors.Is(err, io.EOF) {
+		return errCorruptSnapshot
+	}
+
+	return nil
+}
+
+func decodeBuckets(r *checksumReader, mem *InMemoryVisitRepository) error {
+	pages, err := binary.ReadUvarint(r)
+	if err != nil {
+		return errCorruptSnapshot
+	}
+
+	for range pages {
+		pageURL, err := readSnapshotString(r)
+		if err != nil {
+			return err
+		}
+
+		buckets := newTimeBuckets()
+
+		buckets.newest, err = binary.ReadVarint(r)
+		if err != nil {
+			return errCorruptSnapshot
+		}
+
+		err = readBuckets(r, mem, buckets.hours)
+		if err != nil {
+			return err
+		}
+
+		err = readBuckets(r, mem, buckets.days)
+		if err != nil {
+			return err
+		}
+
+		// the page may have been spilled since its set was read
+		err = mem.fault(pageURL, false)
+		if err != nil {
+			return err
+		}
+
+		mem.buckets[pageURL] = buckets
+		mem.account(pageURL, false)
+		mem.fit()
+	}
+
+	return nil
+}
+
+// decodeHistory reads the visitor history, it's read even if the history is turned off (and then dropped) to get to the
+// checksum
+func decodeHistory(r *checksumReader, mem *InMemoryVisitRepository) error {
+	visitors, err := binary.ReadUvarint(r)
+	if err != nil {
+		return errCorruptSnapshot
+	}
+
+	for range visitors {
+		visitor, err := readSnapshotString(r)
+		if err != nil {
+			return err
+		}
+
+		pages, err := binary.ReadUvarint(r)
+		if err != nil {
+			return errCorruptSnapshot
+		}
+
+		for range pages {
+			pageURL, err := readSnapshotString(r)
+			if err != nil {
+				return err
+			}
+
+			first, err := binary.ReadVarint(r)
+			if err != nil {
+				return errCorruptSnapshot
+			}
+
+			last, err := binary.ReadVarint(r)
+			if err != nil {
+				return errCorruptSnapshot
+			}
+
+			mem.history.record(domain.Visit{Visitor: visitor, PageURL: pageURL, Time: fromUnixNano(first)})
+			mem.history.record(domain.Visit{Visitor: visitor, PageURL: pageURL, Time: fromUnixNano(last)})
+		}
+	}
+
+	return nil
+}
+
+// unixNano is t in unix nanoseconds, 0 for the zero time
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixNano()
+}
+
+// fromUnixNano is the inverse of unixNano
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, n).UTC()
+}
+
+func appendPage(b []byte, pageURL domain.PageURL, visitors visitorSet) []byte {
+	b = appendString(b, pageURL)
+
+	return appendVisitorSet(b, visitors)
+}
+
+func appendPageBuckets(b []byte, pageURL domain.PageURL, buckets *timeBuckets) []byte {
+	b = appendString(b, pageURL)
+	b = binary.AppendVarint(b, buckets.newest)
+	b = appendBuckets(b, buckets.hours)
+
+	return appendBuckets(b, buckets.days)
+}
+
+func appendBuckets(b []byte, buckets map[int64]visitorSet) []byte {
+	b = binary.AppendUvarint(b, uint64(len(buckets)))
+	for key, visitors := range buckets {
+		b = binary.AppendVarint(b, key)
+		b = appendVisitorSet(b, visitors)
+	}
+
+	return b
+}
+
+func readBuckets(r *checksumReader, mem *InMemoryVisitRepository, buckets map[int64]visitorSet) error {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return errCorruptSnapshot
+	}
+
+	for range n {
+		key, err := binary.ReadVarint(r)
+		if err != nil {
+			return errCorruptSnapshot
+		}
+
+		kind, err := r.ReadByte()
+		if err != nil {
+			return errCorruptSnapshot
+		}
+
+		visitors, err := readVisitorSet(r, kind)
+		if err != nil {
+			return err
+		}
+
+		buckets[key] = mem.adaptBucket(visitors)
+	}
+
+	return nil
+}
+
+func appendVisitorSet(b []byte, visitors visitorSet) []byte {
+	switch v := visitors.(type) {
+	case *hyperLogLog:
+		b = append(b, setKindSketch, v.precision)
+
+		return append(b, v.registers...)
+	case exactSet:
+		b = append(b, setKindExact)
+		b = binary.AppendUvarint(b, uint64(len(v)))
+
+		for visitor := range v {
+			b = appendString(b, visitor)
+		}
+
+		return b
+	default:
+		panic(fmt.Sprintf("unknown visitor set %T", visitors))
+	}
+}
+
+func readVisitorSet(r *checksumReader, kind byte) (visitorSet, error) {
+	switch kind {
+	case setKindSketch:
+		precision, err := r.ReadByte()
+		if err != nil || precision < MinPrecision || precision > MaxPrecision {
+			return nil, errCorruptSnapshot
+		}
+
+		sketch := newHyperLogLog(precision)
+
+		_, err = io.ReadFull(r, sketch.registers)
+		if err != nil {
+			return nil, errCorruptSnapshot
+		}
+
+		return sketch, nil
+	case setKindExact:
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errCorruptSnapshot
+		}
+
+		visitors := make(exactSet, min(n, 1<<16))
+		for range n {
+			visitor, err := readSnapshotString(r)
+			if err != nil {
+				return nil, err
+			}
+
+			visitors[visitor] = struct{}{}
+		}
+
+		return visitors, nil
+	default:
+		return nil, errCorruptSnapshot
+	}
+}
+
+func readSnapshotString(r *checksumReader) (string, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil || size > maxRecordSize {
+		return "", errCorruptSnapshot
+	}
+
+	b := make([]byte, size)
+
+	_, err = io.ReadFull(r, b)
+	if err != nil {
+		return "", errCorruptSnapshot
+	}
+
+	return string(b), nil
+}
+
+// checksumReader feeds everything read through it to a hash
+type checksumReader struct {
+	r *bufio.Reader
+	h hash.Hash32
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	_, _ = c.h.Write(p[:n])
+
+	return n, err
+}
+
+func (c *checksumReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		_, _ = c.h.Write([]byte{b})
+	}
+
+	return b, err
+}
@@ -0,0 +1,606 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"log"
+	"os"
+	"sync"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// estimated memory used by a page, see pageSize. These are rough figures for the go maps holding it, they only have to
+// be in the right order of magnitude for the budget to keep the process away from running out of memory
+const (
+	// pageOverhead covers the map entries of the page, its time buckets and its place in the lru
+	pageOverhead = 512
+	// bucketOverhead covers the map entry of an hourly or daily bucket
+	bucketOverhead = 64
+	// visitorSize covers an entry of an exact set, a visitor id of a few dozen bytes and the map overhead
+	visitorSize = 64
+	// pinnedPageSize covers what a page keeps in memory even when it's spilled: its count, its place in the page index,
+	// the sorted pages and the leaderboard, and its entry in the spill file index
+	pinnedPageSize = 384
+	// recentVisitSize covers an entry of the recent visitors, see recentVisitors
+	recentVisitSize = 128
+)
+
+// minSpillGarbage is the number of bytes of pages faulted back in (or dropped) the spill file must hold, on top of
+// holding more of them than of spilled pages, before it's compacted
+const minSpillGarbage = 1 << 20
+
+var errCorruptSpill = errors.New("corrupt spilled page")
+
+// pageSpill keeps the pages of a repository within a memory budget: the all-time set and the time buckets of the pages
+// (the bulk of the memory) are accounted for and, once they take more than the budget, the least recently touched pages
+// are spilled to a file and dropped from memory. Everything else (the counts, the page index, the leaderboard and the
+// recent visitors) stays in memory, so the counts read from it are the same whether a page is spilled or not. It's
+// accounted for too (see pinned), spilling more pages then makes room for it. The visitor history isn't, a budget
+// can't be combined with it.
+//
+// Writes fault the page back in, under the write lock. Reads hold the read lock, which doesn't allow changing the maps,
+// so they read spilled pages from the file without keeping them and only note which pages they touched: those are
+// moved to the front of the lru (or faulted back in) on the next write
+type pageSpill struct {
+	limit int64
+	used  int64
+	// lru holds the resident pages (*residentPage), the most recently touched first
+	lru      *list.List
+	resident map[domain.PageURL]*list.Element
+	store    *spillStore
+
+	readM sync.Mutex
+	read  map[domain.PageURL]struct{}
+}
+
+// residentPage is a page held in memory along with its estimated size
+type residentPage struct {
+	url  domain.PageURL
+	size int64
+}
+
+func newPageSpill(limit int64, dir string) *pageSpill {
+	return &pageSpill{
+		limit:    limit,
+		lru:      list.New(),
+		resident: make(map[domain.PageURL]*list.Element),
+		store:    &spillStore{dir: dir, index: make(map[domain.PageURL]spilledPage)},
+		read:     make(map[domain.PageURL]struct{}),
+	}
+}
+
+// markRead notes that the page was read, it's safe to call under the read lock
+func (p *pageSpill) markRead(url domain.PageURL) {
+	p.readM.Lock()
+	p.read[url] = struct{}{}
+	p.readM.Unlock()
+}
+
+// takeRead returns the pages read since it was last called
+func (p *pageSpill) takeRead() map[domain.PageURL]struct{} {
+	p.readM.Lock()
+	defer p.readM.Unlock()
+
+	read := p.read
+	p.read = make(map[domain.PageURL]struct{})
+
+	return read
+}
+
+// setSize estimates the memory used by the set
+func setSize(visitors visitorSet) int64 {
+	switch v := visitors.(type) {
+	case exactSet:
+		return int64(len(v)) * visitorSize
+	case *hyperLogLog:
+		return int64(len(v.registers))
+	default:
+		return 0
+	}
+}
+
+// pageSize estimates the memory used by the all-time set and the time buckets of a page
+func pageSize(visitors visitorSet, buckets *timeBuckets) int64 {
+	size := int64(pageOverhead) + setSize(visitors)
+	if buckets == nil {
+		return size
+	}
+
+	for _, set := range buckets.hours {
+		size += bucketOverhead + setSize(set)
+	}
+
+	for _, set := range buckets.days {
+		size += bucketOverhead + setSize(set)
+	}
+
+	return size
+}
+
+// account updates the estimated size of the page and makes it the most recently touched one (or the least recently
+// touched one when it's not hot, e.g. a page faulted in to be erased from), the caller must hold the write lock
+func (i *InMemoryVisitRepository) account(url domain.PageURL, hot bool) {
+	if i.spill == nil {
+		return
+	}
+
+	size := pageSize(i.data[url], i.buckets[url])
+
+	element, found := i.spill.resident[url]
+	if found {
+		page := element.Value.(*residentPage)
+		i.spill.used += size - page.size
+		page.size = size
+
+		if hot {
+			i.spill.lru.MoveToFront(element)
+		}
+
+		return
+	}
+
+	i.spill.used += size
+
+	page := &residentPage{url: url, size: size}
+	if hot {
+		i.spill.resident[url] = i.spill.lru.PushFront(page)
+	} else {
+		i.spill.resident[url] = i.spill.lru.PushBack(page)
+	}
+}
+
+// unaccount forgets a page that was dropped, whether it was resident or spilled, the caller must hold the write lock
+func (i *InMemoryVisitRepository) unaccount(url domain.PageURL) {
+	if i.spill == nil {
+		return
+	}
+
+	element, found := i.spill.resident[url]
+	if found {
+		i.spill.used -= element.Value.(*residentPage).size
+		i.spill.lru.Remove(element)
+		delete(i.spill.resident, url)
+	}
+
+	i.spill.store.drop(url)
+}
+
+// fit spills the least recently touched pages until the resident ones are within the budget, after bringing in the
+// pages read since the last write. The caller must hold the write lock. Nothing is lost if a page can't be spilled, it's
+// kept in memory (over the budget) and the error is logged
+func (i *InMemoryVisitRepository) fit() {
+	if i.spill == nil {
+		return
+	}
+
+	for url := range i.spill.takeRead() {
+		_, resident := i.data[url]
+		if resident {
+			i.account(url, true)
+
+			continue
+		}
+
+		_, counted := i.count[url]
+		if !counted {
+			continue
+		}
+
+		err := i.fault(url, true)
+		if err != nil {
+			log.Printf("unable to fault in page %s: %v", url, err)
+		}
+	}
+
+	for i.spill.used+i.pinned() > i.spill.limit {
+		element := i.spill.lru.Back()
+		if element == nil {
+			return
+		}
+
+		err := i.evict(element.Value.(*residentPage).url)
+		if err != nil {
+			log.Printf("unable to spill pages over the memory budget: %v", err)
+
+			return
+		}
+	}
+}
+
+// pinned estimates the memory used by what stays in memory whether the pages are spilled or not, the caller must hold
+// the write lock
+func (i *InMemoryVisitRepository) pinned() int64 {
+	return int64(len(i.count))*pinnedPageSize + int64(i.recent.visits)*recentVisitSize
+}
+
+// evict writes the page to the spill file and drops it from memory, the caller must hold the write lock
+func (i *InMemoryVisitRepository) evict(url domain.PageURL) error {
+	visitors := i.data[url]
+	buckets, hasBuckets := i.buckets[url]
+
+	var precision uint8
+	sketch, isSketch := visitors.(*hyperLogLog)
+	if isSketch {
+		precision = sketch.precision
+	}
+
+	err := i.spill.store.put(url, encodeSpilled(visitors, buckets), precision, hasBuckets)
+	if err != nil {
+		return err
+	}
+
+	element := i.spill.resident[url]
+	i.spill.used -= element.Value.(*residentPage).size
+	i.spill.lru.Remove(element)
+	delete(i.spill.resident, url)
+
+	delete(i.data, url)
+	delete(i.buckets, url)
+
+	return nil
+}
+
+// fault brings a spilled page back in memory, it does nothing if the page isn't spilled. The caller must hold the write
+// lock
+func (i *InMemoryVisitRepository) fault(url domain.PageURL, hot bool) error {
+	if i.spill == nil {
+		return nil
+	}
+
+	_, spilled := i.spill.store.index[url]
+	if !spilled {
+		return nil
+	}
+
+	visitors, buckets, err := i.readSpilled(url)
+	if err != nil {
+		return err
+	}
+
+	i.restore(url, visitors, buckets, hot)
+
+	return nil
+}
+
+// restore makes a spilled page resident with the given all-time set and time buckets (read from the spill file, and
+// maybe changed since), it does nothing if the page isn't spilled. The caller must hold the write lock
+func (i *InMemoryVisitRepository) restore(url domain.PageURL, visitors visitorSet, buckets *timeBuckets, hot bool) {
+	if i.spill == nil {
+		return
+	}
+
+	_, spilled := i.spill.store.index[url]
+	if !spilled {
+		return
+	}
+
+	i.spill.store.drop(url)
+
+	i.data[url] = visitors
+	if buckets != nil {
+		i.buckets[url] = buckets
+	}
+
+	i.account(url, hot)
+}
+
+// faultIf brings a spilled page back in memory if needed reports its all-time set or time buckets have to be changed,
+// so that the pages a write doesn't change stay spilled. The caller must hold the write lock
+func (i *InMemoryVisitRepository) faultIf(url domain.PageURL, needed func(visitorSet, *timeBuckets) bool) error {
+	if i.spill == nil {
+		return nil
+	}
+
+	_, spilled := i.spill.store.index[url]
+	if !spilled {
+		return nil
+	}
+
+	visitors, buckets, err := i.readSpilled(url)
+	if err != nil || !needed(visitors, buckets) {
+		return err
+	}
+
+	return i.fault(url, false)
+}
+
+// page returns the all-time set and the time buckets of the page, read from the spill file if it's spilled (nil when the
+// page isn't known or has no buckets). The page is noted as touched. The caller must hold (at least) the read lock
+func (i *InMemoryVisitRepository) page(url domain.PageURL) (visitorSet, *timeBuckets, error) {
+	if i.spill == nil {
+		return i.data[url], i.buckets[url], nil
+	}
+
+	_, counted := i.count[url]
+	if counted {
+		i.spill.markRead(url)
+	}
+
+	return i.peek(url)
+}
+
+// peek is page without noting the page as touched
+func (i *InMemoryVisitRepository) peek(url domain.PageURL) (visitorSet, *timeBuckets, error) {
+	visitors, resident := i.data[url]
+	if resident || i.spill == nil {
+		return visitors, i.buckets[url], nil
+	}
+
+	_, spilled := i.spill.store.index[url]
+	if !spilled {
+		return nil, nil, nil
+	}
+
+	return i.readSpilled(url)
+}
+
+// precisionOf returns the precision of the sketch holding the all-time visitors of the page, 0 if they're kept exactly.
+// The caller must hold (at least) the read lock
+func (i *InMemoryVisitRepository) precisionOf(url domain.PageURL) uint8 {
+	visitors, resident := i.data[url]
+	if !resident && i.spill != nil {
+		return i.spill.store.index[url].precision
+	}
+
+	sketch, isSketch := visitors.(*hyperLogLog)
+	if !isSketch {
+		return 0
+	}
+
+	return sketch.precision
+}
+
+// spilledPages returns the spilled pages, the caller must hold (at least) the read lock and not change them
+func (i *InMemoryVisitRepository) spilledPages() map[domain.PageURL]spilledPage {
+	if i.spill == nil {
+		return nil
+	}
+
+	return i.spill.store.index
+}
+
+func (i *InMemoryVisitRepository) readSpilled(url domain.PageURL) (visitorSet, *timeBuckets, error) {
+	b, err := i.spill.store.get(url)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return decodeSpilled(b, i)
+}
+
+// Close removes the spill file, if there's one. The repository can't be used afterward
+func (i *InMemoryVisitRepository) Close() error {
+	if i.spill == nil {
+		return nil
+	}
+
+	return i.spill.store.close()
+}
+
+// encodeSpilled writes the all-time set and the time buckets (if any) of a page as they are in snapshots, followed by a
+// checksum (crc32 castagnoli) of everything before it
+func encodeSpilled(visitors visitorSet, buckets *timeBuckets) []byte {
+	b := appendVisitorSet(nil, visitors)
+
+	if buckets == nil {
+		b = append(b, 0)
+	} else {
+		b = append(b, 1)
+		b = binary.AppendVarint(b, buckets.newest)
+		b = appendBuckets(b, buckets.hours)
+		b = appendBuckets(b, buckets.days)
+	}
+
+	return binary.LittleEndian.AppendUint32(b, crc32.Checksum(b, crcTable))
+}
+
+func decodeSpilled(b []byte, mem *InMemoryVisitRepository) (visitorSet, *timeBuckets, error) {
+	if len(b) < 4 {
+		return nil, nil, errCorruptSpill
+	}
+
+	body, trailer := b[:len(b)-4], b[len(b)-4:]
+	if binary.LittleEndian.Uint32(trailer) != crc32.Checksum(body, crcTable) {
+		return nil, nil, errCorruptSpill
+	}
+
+	r := &checksumReader{r: bufio.NewReader(bytes.NewReader(body)), h: crc32.New(crcTable)}
+
+	kind, err := r.ReadByte()
+	if err != nil {
+		return nil, nil, errCorruptSpill
+	}
+
+	visitors, err := readVisitorSet(r, kind)
+	if err != nil {
+		return nil, nil, errCorruptSpill
+	}
+
+	hasBuckets, err := r.ReadByte()
+	if err != nil {
+		return nil, nil, errCorruptSpill
+	}
+
+	if hasBuckets == 0 {
+		return visitors, nil, nil
+	}
+
+	buckets := newTimeBuckets()
+
+	buckets.newest, err = binary.ReadVarint(r)
+	if err != nil {
+		return nil, nil, errCorruptSpill
+	}
+
+	err = readBuckets(r, mem, buckets.hours)
+	if err == nil {
+		err = readBuckets(r, mem, buckets.days)
+	}
+
+	if err != nil {
+		return nil, nil, errCorruptSpill
+	}
+
+	return visitors, buckets, nil
+}
+
+// spillStore keeps the spilled pages in a single append-only file, located through an in-memory index. A page faulted
+// back in (or dropped) leaves its bytes behind, the file is compacted once they're more than the bytes of the spilled
+// pages. The file is created in dir on the first spill and removed right away, where the platform allows it, so that it
+// doesn't outlive the process: spilled pages are part of the in-memory data, not of what's persisted
+type spillStore struct {
+	dir  string
+	path string
+	file *os.File
+	// size is the number of bytes written to the file, live the number of bytes of the pages in the index
+	size  int64
+	live  int64
+	index map[domain.PageURL]spilledPage
+}
+
+// spilledPage locates a page in the spill file, along with what has to be known about it without reading it
+//   - precision: the precision of its all-time set when it's a sketch, 0 when it's exact
+//   - buckets: whether it has time buckets
+type spilledPage struct {
+	offset    int64
+	length    int64
+	precision uint8
+	buckets   bool
+}
+
+// put appends the page to the file, replacing the previous copy if there was one. The index only changes once the page
+// is written, an error means the previous copy (if any) is still the one spilled
+func (s *spillStore) put(url domain.PageURL, b []byte, precision uint8, buckets bool) error {
+	if s.file == nil {
+		file, path, err := createSpillFile(s.dir)
+		if err != nil {
+			return err
+		}
+
+		s.file, s.path = file, path
+	}
+
+	_, err := s.file.WriteAt(b, s.size)
+	if err != nil {
+		return err
+	}
+
+	s.drop(url)
+
+	s.index[url] = spilledPage{offset: s.size, length: int64(len(b)), precision: precision, buckets: buckets}
+	s.size += int64(len(b))
+	s.live += int64(len(b))
+
+	// the page is spilled by now, a failed compaction only leaves the garbage in the file until the next one
+	garbage := s.size - s.live
+	if garbage > s.live && garbage > minSpillGarbage {
+		err := s.compact()
+		if err != nil {
+			log.Printf("unable to compact the spill file: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// get reads the page, it's safe to call concurrently as long as the store isn't changed meanwhile
+func (s *spillStore) get(url domain.PageURL) ([]byte, error) {
+	page, found := s.index[url]
+	if !found {
+		return nil, errCorruptSpill
+	}
+
+	b := make([]byte, page.length)
+
+	_, err := s.file.ReadAt(b, page.offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// drop forgets the page, its bytes are left as garbage until the file is compacted
+func (s *spillStore) drop(url domain.PageURL) {
+	page, found := s.index[url]
+	if !found {
+		return
+	}
+
+	s.live -= page.length
+	delete(s.index, url)
+}
+
+// compact copies the spilled pages to a new file and replaces the current one with it
+func (s *spillStore) compact() error {
+	file, path, err := createSpillFile(s.dir)
+	if err != nil {
+		return err
+	}
+
+	index := make(map[domain.PageURL]spilledPage, len(s.index))
+
+	var size int64
+	for url, page := range s.index {
+		b, err := s.get(url)
+		if err == nil {
+			_, err = file.WriteAt(b, size)
+		}
+
+		if err != nil {
+			_ = file.Close()
+			_ = removeSpillFile(path)
+
+			return err
+		}
+
+		page.offset = size
+		index[url] = page
+		size += page.length
+	}
+
+	_ = s.close()
+
+	s.file, s.path = file, path
+	s.index, s.size, s.live = index, size, size
+
+	return nil
+}
+
+// close closes and removes the file
+func (s *spillStore) close() error {
+	if s.file == nil {
+		return nil
+	}
+
+	err := errors.Join(s.file.Close(), removeSpillFile(s.path))
+	s.file = nil
+
+	return err
+}
+
+func createSpillFile(dir string) (*os.File, string, error) {
+	file, err := os.CreateTemp(dir, "pages-*.spill")
+	if err != nil {
+		return nil, "", err
+	}
+
+	// the file stays usable until it's closed, platforms that don't remove open files have it removed on close
+	_ = os.Remove(file.Name())
+
+	return file, file.Name(), nil
+}
+
+func removeSpillFile(path string) error {
+	err := os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
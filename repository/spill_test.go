@@ -0,0 +1,379 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// spillBudget holds about 4 of the pages stored by spillVisits, on top of what every page and recent visit pins
+const spillBudget = 30*pinnedPageSize + 90*recentVisitSize + 4*(pageOverhead+3*(bucketOverhead+visitorSize)+3*visitorSize)
+
+// spillVisits visits 30 pages, 3 visitors each in 3 different hours
+func spillVisits(base time.Time) []domain.Visit {
+	var visits []domain.Visit
+	for page := range 30 {
+		for visitor := range 3 {
+			visits = append(visits, domain.Visit{
+				Visitor: fmt.Sprintf("v%d", (page+visitor)%7),
+				PageURL: fmt.Sprintf("/blog/post-%d", page),
+				Time:    base.Add(time.Duration(visitor) * time.Hour),
+			})
+		}
+	}
+
+	return visits
+}
+
+// expectSameCounts compares every count of every page of the repository with the ones of a repository without budget
+func expectSameCounts(t *testing.T, r, expected fullRepository, from, to time.Time) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	for page := range 30 {
+		url := domain.PageURL(fmt.Sprintf("/blog/post-%d", page))
+
+		got, err := r.CountUniqueVisitors(ctx, url)
+		want, _ := expected.CountUniqueVisitors(ctx, url)
+		if err != nil || got != want {
+			t.Errorf("%s: got %v and %v, expected %v", url, got, err, want)
+		}
+
+		got, err = r.CountUniqueVisitorsBetween(ctx, url, from, to)
+		want, _ = expected.CountUniqueVisitorsBetween(ctx, url, from, to)
+		if err != nil || got != want {
+			t.Errorf("%s between: got %v and %v, expected %v", url, got, err, want)
+		}
+
+		pair := []domain.PageURL{url, domain.PageURL(fmt.Sprintf("/blog/post-%d", (page+1)%30))}
+		got, err = r.CountUniqueVisitorsCombined(ctx, pair, domain.Intersection)
+		want, _ = expected.CountUniqueVisitorsCombined(ctx, pair, domain.Intersection)
+		if err != nil || got != want {
+			t.Errorf("%s combined: got %v and %v, expected %v", url, got, err, want)
+		}
+	}
+
+	gotRollup, err := r.CountUniqueVisitorsMatching(ctx, "/blog/*")
+	wantRollup, _ := expected.CountUniqueVisitorsMatching(ctx, "/blog/*")
+	if err != nil || gotRollup != wantRollup {
+		t.Errorf("rollup: got %v and %v, expected %v", gotRollup, err, wantRollup)
+	}
+}
+
+// residency returns the number of resident and spilled pages, checking the resident ones are within the budget
+func residency(t *testing.T, i *InMemoryVisitRepository) (int, int) {
+	t.Helper()
+
+	if i.spill.used+i.pinned() > i.spill.limit {
+		t.Errorf("got %d bytes resident, expected at most %d", i.spill.used+i.pinned(), i.spill.limit)
+	}
+
+	if len(i.data) != i.spill.lru.Len() || len(i.data)+len(i.spill.store.index) != len(i.count) {
+		t.Errorf("got %d resident and %d spilled pages out of %d", len(i.data), len(i.spill.store.index), len(i.count))
+	}
+
+	return len(i.data), len(i.spill.store.index)
+}
+
+func TestMemoryBudget(t *testing.T) {
+	base := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	t.Run("pages over the budget are spilled, counts are unchanged", func(t *testing.T) {
+		expected := NewVisitsInMemoryRepository()
+		i := NewVisitsInMemoryRepository(WithMemoryBudget(spillBudget, t.TempDir()))
+
+		defer func() {
+			_ = i.Close()
+		}()
+
+		for _, visit := range spillVisits(base) {
+			_ = expected.Store(ctx, visit)
+
+			err := i.Store(ctx, visit)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		resident, spilled := residency(t, i)
+		if resident == 0 || spilled == 0 {
+			t.Errorf("got %d resident and %d spilled pages, expected both", resident, spilled)
+		}
+
+		expectSameCounts(t, i, expected, base, base.Add(2*time.Hour))
+
+		series, err := i.CountUniqueVisitorsSeries(ctx, "/blog/post-0", time.Hour, base, base.Add(3*time.Hour))
+		expectedSeries, _ := expected.CountUniqueVisitorsSeries(ctx, "/blog/post-0", time.Hour, base, base.Add(3*time.Hour))
+		if err != nil || !reflect.DeepEqual(series, expectedSeries) {
+			t.Errorf("got %v and %v, expected %v", series, err, expectedSeries)
+		}
+	})
+
+	t.Run("writes fault the page back in", func(t *testing.T) {
+		i := NewVisitsInMemoryRepository(WithMemoryBudget(spillBudget, t.TempDir()))
+
+		defer func() {
+			_ = i.Close()
+		}()
+
+		err := i.StoreBatch(ctx, spillVisits(base))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the first pages stored were the least recently touched
+		_, spilled := i.spill.store.index["/blog/post-0"]
+		if !spilled {
+			t.Fatal("expected /blog/post-0 to be spilled")
+		}
+
+		err = i.Store(ctx, domain.Visit{Visitor: "new", PageURL: "/blog/post-0", Time: base})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, resident := i.data["/blog/post-0"]
+		if !resident {
+			t.Error("expected /blog/post-0 to be faulted in")
+		}
+
+		count, err := i.CountUniqueVisitors(ctx, "/blog/post-0")
+		if err != nil || count.Count != 4 {
+			t.Errorf("got %v and %v, expected 4", count, err)
+		}
+
+		count, err = i.CountUniqueVisitorsBetween(ctx, "/blog/post-0", base, base.Add(time.Hour))
+		if err != nil || count.Count != 2 {
+			t.Errorf("got %v and %v, expected 2", count, err)
+		}
+
+		residency(t, i)
+	})
+
+	t.Run("reads fault the page back in on the next write", func(t *testing.T) {
+		i := NewVisitsInMemoryRepository(WithMemoryBudget(spillBudget, t.TempDir()))
+
+		defer func() {
+			_ = i.Close()
+		}()
+
+		err := i.StoreBatch(ctx, spillVisits(base))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = i.CountUniqueVisitorsBetween(ctx, "/blog/post-1", base, base.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, resident := i.data["/blog/post-1"]
+		if resident {
+			t.Error("expected /blog/post-1 to stay spilled under the read lock")
+		}
+
+		err = i.Store(ctx, domain.Visit{Visitor: "new", PageURL: "/blog/post-29", Time: base})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, resident = i.data["/blog/post-1"]
+		if !resident {
+			t.Error("expected /blog/post-1 to be faulted in")
+		}
+
+		residency(t, i)
+	})
+
+	t.Run("erasures, renames and expiry reach spilled pages", func(t *testing.T) {
+		now := base.Add(24 * time.Hour)
+
+		expected := NewVisitsInMemoryRepository(withClock(&clock{now: now}))
+		i := NewVisitsInMemoryRepository(WithMemoryBudget(spillBudget, t.TempDir()), withClock(&clock{now: now}))
+
+		defer func() {
+			_ = i.Close()
+		}()
+
+		for _, r := range []*InMemoryVisitRepository{expected, i} {
+			err := r.StoreBatch(ctx, spillVisits(base))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// v0 visited the pages numbered 0, 5 and 6 modulo 7, v1 the ones numbered 0, 1 and 6
+			erased, err := r.EraseVisitor(ctx, "v0")
+			if err != nil || erased != (domain.ErasedPages{Erased: 13}) {
+				t.Errorf("got %v and %v, expected v0 to be erased from 13 pages", erased, err)
+			}
+
+			moved, err := r.RenameVisitors(ctx, []domain.Rename{{From: "v1", To: "w1"}})
+			if err != nil || moved != 14 {
+				t.Errorf("got %v and %v, expected v1 to be moved in 14 pages", moved, err)
+			}
+
+			expired, err := r.Expire(ctx, Retention{Visits: 23 * time.Hour, Batch: 7})
+			if err != nil || expired.Buckets != 30 {
+				t.Errorf("got %v and %v, expected the oldest bucket of every page to be dropped", expired, err)
+			}
+		}
+
+		expectSameCounts(t, i, expected, base, now)
+		residency(t, i)
+	})
+
+	t.Run("spilled sketches are still estimated", func(t *testing.T) {
+		i := NewVisitsInMemoryRepository(WithCounting(CountApproximate, MinPrecision), WithMemoryBudget(pageOverhead, t.TempDir()))
+
+		defer func() {
+			_ = i.Close()
+		}()
+
+		err := i.StoreBatch(ctx, spillVisits(base))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		count, err := i.CountUniqueVisitors(ctx, "/blog/post-0")
+		if err != nil || !count.Estimated || count.ErrorBound != errorBound(MinPrecision) {
+			t.Errorf("got %v and %v, expected an estimate", count, err)
+		}
+	})
+
+	t.Run("survives a restart, from the snapshot and the log", func(t *testing.T) {
+		dir := t.TempDir()
+		visits := spillVisits(base)
+
+		expected := NewVisitsInMemoryRepository()
+		_ = expected.StoreBatch(ctx, visits)
+
+		r, err := NewFileVisitRepository(dir, WithSnapshots(0, 1), WithMemoryBudget(spillBudget, dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = r.StoreBatch(ctx, visits[:45])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = r.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = r.StoreBatch(ctx, visits[45:])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err = NewFileVisitRepository(dir, WithSnapshots(0, 1), WithMemoryBudget(spillBudget, dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer func() {
+			_ = r.Close()
+		}()
+
+		expectSameCounts(t, r, expected, base, base.Add(2*time.Hour))
+		residency(t, r.mem)
+
+		// the snapshot holds the spilled pages too
+		err = r.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		restored := NewVisitsInMemoryRepository()
+
+		err = loadSnapshot(snapshotPath(dir, r.snapshotted), restored)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectSameCounts(t, restored, expected, base, base.Add(2*time.Hour))
+	})
+}
+
+func TestSpillStoreCompaction(t *testing.T) {
+	s := &spillStore{dir: t.TempDir(), index: make(map[domain.PageURL]spilledPage)}
+
+	defer func() {
+		_ = s.close()
+	}()
+
+	kept := bytes.Repeat([]byte("k"), 1000)
+
+	err := s.put("/kept", kept, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every copy but the last one is garbage, the file is compacted once it's most of it
+	for n := range 4 {
+		err := s.put("/replaced", bytes.Repeat([]byte{byte(n)}, minSpillGarbage/2), 0, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if s.size != s.live {
+		t.Errorf("got %d bytes in the file, expected %d", s.size, s.live)
+	}
+
+	b, err := s.get("/kept")
+	if err != nil || !bytes.Equal(b, kept) {
+		t.Errorf("got %d bytes and %v, expected the page to be kept", len(b), err)
+	}
+
+	b, err = s.get("/replaced")
+	if err != nil || !bytes.Equal(b, bytes.Repeat([]byte{3}, minSpillGarbage/2)) {
+		t.Errorf("got %d bytes and %v, expected the newest copy", len(b), err)
+	}
+}
+
+func TestSpillStoreFailedCompaction(t *testing.T) {
+	s := &spillStore{dir: t.TempDir(), index: make(map[domain.PageURL]spilledPage)}
+
+	defer func() {
+		_ = s.close()
+	}()
+
+	err := s.put("/kept", []byte("kept"), 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the compacted file can't be created, the pages are spilled nonetheless
+	s.dir = filepath.Join(s.dir, "missing")
+
+	for n := range 4 {
+		err := s.put("/replaced", bytes.Repeat([]byte{byte(n)}, minSpillGarbage/2), 0, false)
+		if err != nil {
+			t.Fatalf("got %v, expected the page to be spilled", err)
+		}
+	}
+
+	if s.size == s.live {
+		t.Error("expected the file not to be compacted")
+	}
+
+	b, err := s.get("/replaced")
+	if err != nil || !bytes.Equal(b, bytes.Repeat([]byte{3}, minSpillGarbage/2)) {
+		t.Errorf("got %d bytes and %v, expected the newest copy", len(b), err)
+	}
+}
@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"container/heap"
+	"context"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// leaderboard ranks the pages by their unique visitors, it's kept up to date as visits are stored so the top pages can
+// be read without sorting every page.
+//   - ranked is a heap of the pages, the page with the most unique visitors at its root (ties broken by url)
+//   - index is a map of page urls (key) with their position in ranked (values), to fix a page's position once its
+//     count changes
+//
+// Updating a page is O(log P) and reading the top N is O(N log N), P being the number of pages
+type leaderboard struct {
+	ranked []domain.PageCount
+	index  map[domain.PageURL]int
+}
+
+func newLeaderboard() *leaderboard {
+	return &leaderboard{index: make(map[domain.PageURL]int)}
+}
+
+func (l *leaderboard) Len() int { return len(l.ranked) }
+
+func (l *leaderboard) Less(a, b int) bool { return ranksAbove(l.ranked[a], l.ranked[b]) }
+
+func (l *leaderboard) Swap(a, b int) {
+	l.ranked[a], l.ranked[b] = l.ranked[b], l.ranked[a]
+	l.index[l.ranked[a].PageURL] = a
+	l.index[l.ranked[b].PageURL] = b
+}
+
+func (l *leaderboard) Push(x any) {
+	page := x.(domain.PageCount)
+	l.index[page.PageURL] = len(l.ranked)
+	l.ranked = append(l.ranked, page)
+}
+
+func (l *leaderboard) Pop() any {
+	page := l.ranked[len(l.ranked)-1]
+	l.ranked = l.ranked[:len(l.ranked)-1]
+	delete(l.index, page.PageURL)
+
+	return page
+}
+
+// update sets the unique visitors of the page, adding it if it isn't ranked yet
+func (l *leaderboard) update(url domain.PageURL, uniqueVisitors domain.UniqueVisitors) {
+	position, found := l.index[url]
+	if !found {
+		heap.Push(l, domain.PageCount{PageURL: url, UniqueVisitors: uniqueVisitors})
+
+		return
+	}
+
+	l.ranked[position].UniqueVisitors = uniqueVisitors
+	heap.Fix(l, position)
+}
+
+// remove drops the page from the ranking
+func (l *leaderboard) remove(url domain.PageURL) {
+	position, found := l.index[url]
+	if found {
+		heap.Remove(l, position)
+	}
+}
+
+// top returns the limit pages with the most unique visitors, in order. The heap isn't changed: the candidates are the
+// children of the pages already taken, kept in a heap of their own
+func (l *leaderboard) top(limit int) []domain.PageCount {
+	limit = min(limit, len(l.ranked))
+	if limit <= 0 {
+		return []domain.PageCount{}
+	}
+
+	pages := make([]domain.PageCount, 0, limit)
+	candidates := &positions{board: l, items: []int{0}}
+
+	for len(pages) < limit {
+		position := heap.Pop(candidates).(int)
+		pages = append(pages, l.ranked[position])
+
+		for _, child := range []int{2*position + 1, 2*position + 2} {
+			if child < len(l.ranked) {
+				heap.Push(candidates, child)
+			}
+		}
+	}
+
+	return pages
+}
+
+// positions is a heap of positions in a leaderboard, ordered by the rank of the pages in them
+type positions struct {
+	board *leaderboard
+	items []int
+}
+
+func (p *positions) Len() int { return len(p.items) }
+
+func (p *positions) Less(a, b int) bool {
+	return ranksAbove(p.board.ranked[p.items[a]], p.board.ranked[p.items[b]])
+}
+
+func (p *positions) Swap(a, b int) { p.items[a], p.items[b] = p.items[b], p.items[a] }
+
+func (p *positions) Push(x any) { p.items = append(p.items, x.(int)) }
+
+func (p *positions) Pop() any {
+	item := p.items[len(p.items)-1]
+	p.items = p.items[:len(p.items)-1]
+
+	return item
+}
+
+// ranksAbove reports whether page a ranks above page b: more unique visitors, or the same and a smaller url so the
+// order is stable
+func ranksAbove(a, b domain.PageCount) bool {
+	if a.UniqueVisitors.Count != b.UniqueVisitors.Count {
+		return a.UniqueVisitors.Count > b.UniqueVisitors.Count
+	}
+
+	return a.PageURL < b.PageURL
+}
+
+// lowest is a heap of pages whose root is the page that ranks lowest, it keeps the top pages seen so far when they
+// can't be ranked ahead of time
+type lowest []domain.PageCount
+
+func (l *lowest) Len() int { return len(*l) }
+
+func (l *lowest) Less(a, b int) bool { return ranksAbove((*l)[b], (*l)[a]) }
+
+func (l *lowest) Swap(a, b int) { (*l)[a], (*l)[b] = (*l)[b], (*l)[a] }
+
+func (l *lowest) Push(x any) { *l = append(*l, x.(domain.PageCount)) }
+
+func (l *lowest) Pop() any {
+	page := (*l)[len(*l)-1]
+	*l = (*l)[:len(*l)-1]
+
+	return page
+}
+
+// topRecent returns the limit pages with the most visitors seen within the window, in order. Counts slide with the
+// window so they can't be ranked ahead of time, but only the pages visited within the max window are looked at and
+// at most limit of them are kept while doing so
+func (r *recentVisitors) topRecent(limit int, window time.Duration, now time.Time) []domain.PageCount {
+	kept := &lowest{}
+
+	for url := range r.pages {
+		page := domain.PageCount{PageURL: url, UniqueVisitors: domain.UniqueVisitors{Count: r.count(url, window, now)}}
+		if page.UniqueVisitors.Count == 0 {
+			continue
+		}
+
+		switch {
+		case kept.Len() < limit:
+			heap.Push(kept, page)
+		case ranksAbove(page, (*kept)[0]):
+			(*kept)[0] = page
+			heap.Fix(kept, 0)
+		}
+	}
+
+	pages := make([]domain.PageCount, kept.Len())
+	for i := len(pages) - 1; i >= 0; i-- {
+		pages[i] = heap.Pop(kept).(domain.PageCount)
+	}
+
+	return pages
+}
+
+// CountTopPages returns the limit pages with the most unique visitors, ranked by their count (ties by url). A window
+// of 0 ranks the all-time counts, which are maintained as visits are stored, otherwise the visitors seen within the
+// last window are ranked, the window can't be larger than the max window the repository was built with
+func (i *InMemoryVisitRepository) CountTopPages(ctx context.Context, limit int, window time.Duration) ([]domain.PageCount, error) {
+	if window < 0 || window > i.opts.maxWindow {
+		return nil, domain.ErrInvalidWindow
+	}
+
+	err := i.m.RLock(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer i.m.RUnlock()
+
+	if window == 0 {
+		return i.top.top(limit), nil
+	}
+
+	return i.recent.topRecent(limit, window, i.opts.now()), nil
+}
@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+func TestInMemoryRepositoryTopPages(t *testing.T) {
+	base := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	type testCase struct {
+		description   string
+		inputs        []domain.Visit
+		limit         int
+		window        time.Duration
+		expectedPages []domain.PageCount
+		expectedError error
+	}
+
+	page := func(url domain.PageURL, count domain.Count) domain.PageCount {
+		return domain.PageCount{PageURL: url, UniqueVisitors: domain.UniqueVisitors{Count: count}}
+	}
+
+	visits := []domain.Visit{
+		{Visitor: "a", PageURL: "/about", Time: base.Add(-50 * time.Minute)},
+		{Visitor: "b", PageURL: "/about", Time: base.Add(-40 * time.Minute)},
+		{Visitor: "c", PageURL: "/about", Time: base.Add(-30 * time.Minute)},
+		{Visitor: "a", PageURL: "/blog", Time: base.Add(-5 * time.Minute)},
+		{Visitor: "b", PageURL: "/blog", Time: base.Add(-4 * time.Minute)},
+		{Visitor: "a", PageURL: "/home", Time: base.Add(-3 * time.Minute)},
+		{Visitor: "a", PageURL: "/home", Time: base.Add(-2 * time.Minute)},
+		{Visitor: "b", PageURL: "/shop", Time: base.Add(-time.Minute)},
+	}
+
+	testCases := []testCase{
+		{
+			description:   "all-time counts, ties ranked by url",
+			inputs:        visits,
+			limit:         3,
+			expectedPages: []domain.PageCount{page("/about", 3), page("/blog", 2), page("/home", 1)},
+		},
+		{
+			description:   "limit larger than the number of pages",
+			inputs:        visits,
+			limit:         10,
+			expectedPages: []domain.PageCount{page("/about", 3), page("/blog", 2), page("/home", 1), page("/shop", 1)},
+		},
+		{
+			description:   "within a window",
+			inputs:        visits,
+			limit:         2,
+			window:        10 * time.Minute,
+			expectedPages: []domain.PageCount{page("/blog", 2), page("/home", 1)},
+		},
+		{
+			description:   "no visits",
+			limit:         3,
+			expectedPages: []domain.PageCount{},
+		},
+		{
+			description:   "window larger than the max window",
+			limit:         3,
+			window:        2 * time.Hour,
+			expectedError: domain.ErrInvalidWindow,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			i := NewVisitsInMemoryRepository(withClock(&clock{now: base}))
+
+			err := i.StoreBatch(context.Background(), tc.inputs)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pages, err := i.CountTopPages(context.Background(), tc.limit, tc.window)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("got %v, expected %v", err, tc.expectedError)
+			}
+
+			if tc.expectedError == nil && !reflect.DeepEqual(pages, tc.expectedPages) {
+				t.Errorf("got %v, expected %v", pages, tc.expectedPages)
+			}
+		})
+	}
+}
+
+func TestLeaderboardMatchesSortedCounts(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	l := newLeaderboard()
+	counts := make(map[domain.PageURL]domain.Count)
+
+	// counts go up and down (e.g. an exact set turned into a sketch), the ranking must follow
+	for range 2000 {
+		url := fmt.Sprintf("/page-%d", rng.IntN(50))
+		counts[url] = domain.Count(rng.IntN(100))
+		l.update(url, domain.UniqueVisitors{Count: counts[url]})
+	}
+
+	expected := make([]domain.PageCount, 0, len(counts))
+	for url, count := range counts {
+		expected = append(expected, domain.PageCount{PageURL: url, UniqueVisitors: domain.UniqueVisitors{Count: count}})
+	}
+
+	slices.SortFunc(expected, func(a, b domain.PageCount) int {
+		if ranksAbove(a, b) {
+			return -1
+		}
+
+		return 1
+	})
+
+	for _, limit := range []int{1, 10, len(expected)} {
+		got := l.top(limit)
+		if !reflect.DeepEqual(got, expected[:limit]) {
+			t.Errorf("got %v, expected %v", got, expected[:limit])
+		}
+	}
+}
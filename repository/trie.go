@@ -0,0 +1,189 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// pageTrie indexes the page urls by their path segments (the url split on "/"), so that the pages under a prefix or
+// matching a pattern are found by walking the segments of the pattern instead of scanning every page. Each node is a
+// segment, nodes where a page url ends hold it (more than one when urls only differ by a trailing "/")
+type pageTrie struct {
+	children map[string]*pageTrie
+	pages    []domain.PageURL
+}
+
+func newPageTrie() *pageTrie {
+	return &pageTrie{children: make(map[string]*pageTrie)}
+}
+
+// segments splits the url (or pattern) into path segments, a trailing "/" is ignored so that "/blog/" and "/blog" are
+// at the same node
+func segments(url string) []string {
+	if len(url) > 1 {
+		url = strings.TrimSuffix(url, "/")
+	}
+
+	return strings.Split(url, "/")
+}
+
+// insert indexes the page url
+func (t *pageTrie) insert(url domain.PageURL) {
+	node := t
+	for _, segment := range segments(url) {
+		child, found := node.children[segment]
+		if !found {
+			child = newPageTrie()
+			node.children[segment] = child
+		}
+
+		node = child
+	}
+
+	node.pages = append(node.pages, url)
+}
+
+// remove drops the page url, the nodes left without pages nor children are dropped along
+func (t *pageTrie) remove(url domain.PageURL) {
+	path := []*pageTrie{t}
+	keys := segments(url)
+
+	for _, segment := range keys {
+		child, found := path[len(path)-1].children[segment]
+		if !found {
+			return
+		}
+
+		path = append(path, child)
+	}
+
+	node := path[len(path)-1]
+	node.pages = slices.DeleteFunc(node.pages, func(page domain.PageURL) bool { return page == url })
+
+	for n := len(path) - 1; n > 0 && len(path[n].pages) == 0 && len(path[n].children) == 0; n-- {
+		delete(path[n-1].children, keys[n-1])
+	}
+}
+
+// match calls fn with every page url that matches the pattern segments. A segment is matched with path.Match (e.g.
+// "post-*"), a "**" segment matches any number of segments (including none). Literal segments are looked up directly,
+// only wildcard segments visit every child of a node
+func (t *pageTrie) match(pattern []string, fn func(domain.PageURL)) {
+	if len(pattern) == 0 {
+		for _, url := range t.pages {
+			fn(url)
+		}
+
+		return
+	}
+
+	segment, rest := pattern[0], pattern[1:]
+
+	switch {
+	case segment == "**":
+		t.match(rest, fn)
+
+		for _, child := range t.children {
+			child.match(pattern, fn)
+		}
+	case !isWildcard(segment):
+		child, found := t.children[segment]
+		if found {
+			child.match(rest, fn)
+		}
+	default:
+		for name, child := range t.children {
+			matched, _ := path.Match(segment, name)
+			if matched {
+				child.match(rest, fn)
+			}
+		}
+	}
+}
+
+func isWildcard(segment string) bool {
+	return strings.ContainsAny(segment, `*?[\`)
+}
+
+// validPattern reports whether every segment of the pattern is a valid path.Match pattern
+func validPattern(pattern []string) bool {
+	for _, segment := range pattern {
+		_, err := path.Match(segment, "")
+		if err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CountUniqueVisitorsMatching merges the visitors of every page whose url matches the pattern (see pageTrie.match),
+// visitors that saw several of the pages are only counted once
+func (i *InMemoryVisitRepository) CountUniqueVisitorsMatching(ctx context.Context, pattern string) (domain.PageRollup, error) {
+	patternSegments, err := parsePattern(pattern)
+	if err != nil {
+		return domain.PageRollup{}, err
+	}
+
+	err = i.m.RLock(ctx)
+	if err != nil {
+		return domain.PageRollup{}, err
+	}
+
+	defer i.m.RUnlock()
+
+	sets, err := i.matching(patternSegments)
+	if err != nil {
+		return domain.PageRollup{}, err
+	}
+
+	return rollup(sets), nil
+}
+
+// parsePattern splits the pattern in segments, checking every one of them is valid
+func parsePattern(pattern string) ([]string, error) {
+	patternSegments := segments(pattern)
+	if !validPattern(patternSegments) {
+		return nil, fmt.Errorf("%w: %s", domain.ErrInvalidPattern, pattern)
+	}
+
+	return patternSegments, nil
+}
+
+// matching returns the all-time sets of the pages matching the pattern, once per page, the caller must hold the lock
+func (i *InMemoryVisitRepository) matching(patternSegments []string) ([]visitorSet, error) {
+	// several "**" segments can reach the same page through different paths
+	matched := make(map[domain.PageURL]struct{})
+
+	var sets []visitorSet
+	var err error
+	i.pages.match(patternSegments, func(url domain.PageURL) {
+		_, found := matched[url]
+		if !found && err == nil {
+			matched[url] = struct{}{}
+
+			var visitors visitorSet
+			visitors, _, err = i.page(url)
+			sets = append(sets, visitors)
+		}
+	})
+
+	return sets, err
+}
+
+// rollup counts the pages and their visitors, visitors in several sets are counted once
+func rollup(sets []visitorSet) domain.PageRollup {
+	if len(sets) == 0 {
+		return domain.PageRollup{}
+	}
+
+	return domain.PageRollup{
+		Pages:          domain.Count(len(sets)),
+		UniqueVisitors: union(sets...).estimate(),
+	}
+}
@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"deus.ai-code-challenge/domain"
+)
+
+func TestInMemoryRepositoryMatching(t *testing.T) {
+	r := NewVisitsInMemoryRepository()
+
+	err := r.StoreBatch(context.Background(), []domain.Visit{
+		{Visitor: "id", PageURL: "/blog/post-1"},
+		{Visitor: "id2", PageURL: "/blog/post-1"},
+		{Visitor: "id", PageURL: "/blog/post-2"},
+		{Visitor: "id3", PageURL: "/blog/2024/post-3"},
+		{Visitor: "id4", PageURL: "/blog/"},
+		{Visitor: "id5", PageURL: "/blogging"},
+		{Visitor: "id6", PageURL: "https://example.com/blog/post-1"},
+	})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	type testCase struct {
+		description   string
+		pattern       string
+		expectedPages domain.Count
+		expectedCount domain.Count
+	}
+
+	testCases := []testCase{
+		{description: "single level", pattern: "/blog/*", expectedPages: 2, expectedCount: 2},
+		{description: "segment pattern", pattern: "/blog/post-?", expectedPages: 2, expectedCount: 2},
+		{description: "any depth", pattern: "/blog/**", expectedPages: 4, expectedCount: 4},
+		{description: "literal page", pattern: "/blog/post-1", expectedPages: 1, expectedCount: 2},
+		{description: "any host", pattern: "**/blog/post-1", expectedPages: 2, expectedCount: 3},
+		{description: "repeated any depth", pattern: "/**/**", expectedPages: 5, expectedCount: 5},
+		{description: "escaped wildcard", pattern: `/blog/post-\*`, expectedPages: 0, expectedCount: 0},
+		{description: "no match", pattern: "/docs/**", expectedPages: 0, expectedCount: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			rollup, err := r.CountUniqueVisitorsMatching(context.Background(), tc.pattern)
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+
+			if rollup.Pages != tc.expectedPages {
+				t.Errorf("got %v pages, expected %v", rollup.Pages, tc.expectedPages)
+			}
+
+			if rollup.UniqueVisitors.Count != tc.expectedCount {
+				t.Errorf("got %v, expected %v", rollup.UniqueVisitors.Count, tc.expectedCount)
+			}
+		})
+	}
+
+	_, err = r.CountUniqueVisitorsMatching(context.Background(), "/blog/[")
+	if !errors.Is(err, domain.ErrInvalidPattern) {
+		t.Errorf("got %v, expected %v", err, domain.ErrInvalidPattern)
+	}
+}
+
+func TestFileRepositoryMatching(t *testing.T) {
+	dir := t.TempDir()
+
+	r, err := NewFileVisitRepository(dir, WithSnapshots(0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.StoreBatch(context.Background(), []domain.Visit{
+		{Visitor: "id", PageURL: "/blog/post-1"},
+		{Visitor: "id2", PageURL: "/blog/post-2"},
+	})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	// the first page is restored from the snapshot, the second one from the log
+	err = r.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.Store(context.Background(), domain.Visit{Visitor: "id3", PageURL: "/blog/post-3"})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	err = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = NewFileVisitRepository(dir, WithSnapshots(0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = r.Close()
+	}()
+
+	rollup, _ := r.CountUniqueVisitorsMatching(context.Background(), "/blog/*")
+	if rollup.Pages != 3 || rollup.UniqueVisitors.Count != 3 {
+		t.Errorf("got %v, expected 3 pages and 3 visitors", rollup)
+	}
+}
@@ -1,8 +1,10 @@
-// Package repository is responsible for implementing an in-memory visit repository, optimized for the features requested in the code challenge.
+// Package repository is responsible for implementing an in-memory visit repository, optimized for the features requested in the code challenge,
+// and a file backed variant of it that survives restarts.
 package repository
 
 import (
-	"sync"
+	"context"
+	"time"
 
 	"deus.ai-code-challenge/domain"
 )
@@ -11,55 +13,317 @@ type visitorID = string
 
 // InMemoryVisitRepository stores page visits in a structure optimised for the requirements provided
 //   - data is a map of page urls (key) with their visitors (values)
-//     *visitors is in itself a map of visitor id (key) with no values, (go doesn't provide a set data structure natively
-//     but those can be mimicked by a map[KEY]struct{}. This ensures that visitors for a specific page are always unique
+//     *visitors is a set of visitor ids, by default every id is kept (exactSet, a map[visitorID]struct{}) which ensures
+//     that visitors for a specific page are always unique. When counting approximately a HyperLogLog sketch is kept
+//     instead, using a fixed amount of memory per page. In hybrid mode each page starts with an exact set and is
+//     switched to a sketch (under the write lock, so readers never see it half-way) once it passes the threshold
 //   - count is a map of page urls (key) with the count of unique visitors (values)
 //   - the counter is in itself a uint64 since a counter can never be negative and I'd expect a large number of unique visitor
 //     *this lookup map ensures that reads are fast when handling a big number of visitors
+//   - buckets is a map of page urls (key) with their visitors per hour/day (values), see timeBuckets. It's used to count
+//     the unique visitors within a time range by merging the sets of the buckets in it
+//   - recent holds when each visitor was last seen in each page within the max window, see recentVisitors. It's used
+//     to count the unique visitors of the last minutes
+//   - pages indexes the page urls by path segment, see pageTrie. It's used to find the pages under a prefix or
+//     matching a pattern without scanning every page
+//   - top ranks the pages by their count, see leaderboard. It's used to read the most visited pages without sorting
+//     every page
+//   - sorted keeps the page urls in order, see sortedPages. It's used to list the pages a page at a time
+//   - history is a map of visitor ids (key) with the pages they were seen in (values), see visitorHistory. It's the
+//     reverse of data, used to tell which pages a visitor saw
+//   - spill keeps data and buckets within a memory budget when one is set, see pageSpill. Pages over the budget are
+//     spilled to disk, so a page may be in count but not in data: data and buckets are read through page (or peek)
+//     and the page is faulted in (see fault) before being written to
 //
 // In terms of Big O notation this ensures both methods have an expected O(1) time complexity (exchanged for a higher space complexity)
 type InMemoryVisitRepository struct {
-	m     sync.RWMutex
-	data  map[domain.PageURL]map[visitorID]struct{}
+	m     rwLock
+	opts  options
+	data  map[domain.PageURL]visitorSet
 	count map[domain.PageURL]domain.Count
+
+	buckets map[domain.PageURL]*timeBuckets
+	recent  *recentVisitors
+	pages   *pageTrie
+	top     *leaderboard
+	sorted  *sortedPages
+	history visitorHistory
+	spill   *pageSpill
 }
 
 // NewVisitsInMemoryRepository is a constructor for the in-memory VisitRepository
-func NewVisitsInMemoryRepository() domain.VisitRepository {
+func NewVisitsInMemoryRepository(opts ...Option) *InMemoryVisitRepository {
+	return newInMemoryRepository(buildOptions(opts))
+}
+
+func newInMemoryRepository(opts options) *InMemoryVisitRepository {
+	var history visitorHistory
+	if opts.visitorHistory {
+		history = make(visitorHistory)
+	}
+
+	var spill *pageSpill
+	if opts.memoryBudget > 0 {
+		spill = newPageSpill(opts.memoryBudget, opts.spillDir)
+	}
+
 	return &InMemoryVisitRepository{
-		data:  make(map[domain.PageURL]map[visitorID]struct{}),
+		opts:  opts,
+		data:  make(map[domain.PageURL]visitorSet),
 		count: make(map[domain.PageURL]domain.Count),
+
+		buckets: make(map[domain.PageURL]*timeBuckets),
+		recent:  newRecentVisitors(opts.maxWindow),
+		pages:   newPageTrie(),
+		top:     newLeaderboard(),
+		sorted:  &sortedPages{},
+		history: history,
+		spill:   spill,
+	}
+}
+
+// newVisitorSet creates an empty set according to the counting mode
+func (i *InMemoryVisitRepository) newVisitorSet() visitorSet {
+	if i.opts.counting == CountApproximate {
+		return newHyperLogLog(i.opts.precision)
 	}
+
+	return exactSet{}
 }
 
 // Store ensures that unique visitor + page url are stored and accounted for when retrieving the counter for a page
-func (i *InMemoryVisitRepository) Store(visit domain.Visit) error {
-	i.m.Lock()
+func (i *InMemoryVisitRepository) Store(ctx context.Context, visit domain.Visit) error {
+	err := i.m.Lock(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer i.m.Unlock()
+
+	err = i.fault(visit.PageURL, true)
+	if err != nil {
+		return err
+	}
+
+	i.add(visit)
+	i.fit()
+
+	return nil
+}
+
+// StoreBatch stores every visit taking the lock only once, so the batch is accounted for as a whole
+func (i *InMemoryVisitRepository) StoreBatch(ctx context.Context, visits []domain.Visit) error {
+	err := i.m.Lock(ctx)
+	if err != nil {
+		return err
+	}
+
 	defer i.m.Unlock()
 
+	for _, visit := range visits {
+		err := i.fault(visit.PageURL, true)
+		if err != nil {
+			return err
+		}
+
+		i.add(visit)
+	}
+
+	i.fit()
+
+	return nil
+}
+
+// contains reports whether storing the visit would leave the data unchanged, the caller must hold the lock and the page
+// must be resident (see fault). The visitor history is part of the data: a visit that moves when the visitor was first
+// or last seen in the page changes it, even if the visitor was already counted
+func (i *InMemoryVisitRepository) contains(visit domain.Visit) bool {
+	visitors, pageFound := i.data[visit.PageURL]
+	if !pageFound || !visitors.accounts(visit.Visitor) || !i.history.covers(visit) {
+		return false
+	}
+
+	if visit.Time.IsZero() {
+		return true
+	}
+
+	buckets, found := i.buckets[visit.PageURL]
+
+	return found && buckets.accounts(visit.Visitor, visit.Time, i.opts.rollupAfter)
+}
+
+// add stores the visit and reports whether the data changed, the caller must hold the write lock.
+// Visits without a time (e.g. replayed from logs written before visits had one) are only accounted for in the
+// all-time count. The page must be resident (see fault)
+func (i *InMemoryVisitRepository) add(visit domain.Visit) bool {
 	visitors, pageFound := i.data[visit.PageURL]
 	if !pageFound {
-		i.data[visit.PageURL] = map[visitorID]struct{}{
-			visit.Visitor: {},
-		}
-		i.count[visit.PageURL] = 1
+		visitors = i.newVisitorSet()
+		i.data[visit.PageURL] = visitors
+		i.pages.insert(visit.PageURL)
+		i.sorted.insert(visit.PageURL)
+	}
+
+	i.history.record(visit)
 
-		return nil
+	visitors, changed := i.addTo(visitors, visit.Visitor)
+	if changed {
+		i.data[visit.PageURL] = visitors
+		i.count[visit.PageURL] = visitors.estimate().Count
+		i.top.update(visit.PageURL, i.uniqueVisitors(visit.PageURL))
 	}
 
-	_, visitorFound := visitors[visit.Visitor]
-	if !visitorFound {
-		i.data[visit.PageURL][visit.Visitor] = struct{}{}
-		i.count[visit.PageURL]++
+	if !visit.Time.IsZero() {
+		i.touch(visit)
+		changed = i.addToBucket(visit) || changed
 	}
 
-	return nil
+	i.account(visit.PageURL, true)
+
+	return changed
+}
+
+// touch records when the visitor was last seen in the page, the caller must hold the write lock.
+// It's not part of the data that's persisted, since it only matters for as long as the max window
+func (i *InMemoryVisitRepository) touch(visit domain.Visit) {
+	i.recent.touch(visit, i.opts.now())
+}
+
+// revisit accounts for a visit that leaves the data unchanged (see contains): the visitor is seen again, so when it was
+// last seen by the recent visitors is updated, the caller must hold the write lock
+func (i *InMemoryVisitRepository) revisit(visit domain.Visit) {
+	if !visit.Time.IsZero() {
+		i.touch(visit)
+	}
+}
+
+// addToBucket accounts for the visit in the time bucket it was made in, the caller must hold the write lock. Visits are
+// trusted not to be from the future (see service.MaxClockSkew): the hour of the newest visit decides which hours are
+// rolled up into days
+func (i *InMemoryVisitRepository) addToBucket(visit domain.Visit) bool {
+	buckets, found := i.buckets[visit.PageURL]
+	if !found {
+		buckets = newTimeBuckets()
+		i.buckets[visit.PageURL] = buckets
+	}
+
+	h := floor(visit.Time.Unix(), hour)
+	if h > buckets.newest {
+		buckets.newest = h
+		buckets.rollup(i.opts.rollupAfter, i.adaptBucket)
+	}
+
+	bucket, key := buckets.bucketFor(visit.Time, i.opts.rollupAfter)
+
+	visitors, found := bucket[key]
+	if !found {
+		visitors = exactSet{}
+	}
+
+	changed := visitors.add(visit.Visitor)
+	bucket[key] = i.adaptBucket(visitors)
+
+	return changed
+}
+
+// addTo adds the visitor to the set and reports whether it changed. In hybrid mode an exact set that passes the
+// threshold is replaced by a sketch, so the set to keep is returned
+func (i *InMemoryVisitRepository) addTo(visitors visitorSet, visitor visitorID) (visitorSet, bool) {
+	if !visitors.add(visitor) {
+		return visitors, false
+	}
+
+	return i.adapt(visitors), true
+}
+
+// adapt converts exact sets into sketches when the counting mode requires it
+func (i *InMemoryVisitRepository) adapt(visitors visitorSet) visitorSet {
+	exact, isExact := visitors.(exactSet)
+
+	switch {
+	case isExact && i.opts.counting == CountApproximate:
+		return exact.toSketch(i.opts.precision)
+	case isExact && i.opts.counting == CountHybrid && domain.Count(len(exact)) > i.opts.threshold:
+		return exact.toSketch(i.opts.precision)
+	default:
+		return visitors
+	}
+}
+
+// adaptBucket converts the exact sets of the time buckets into sketches, which they all start as whatever the counting
+// mode: most buckets only see a few visitors, a sketch for each of them would cost far more than the visitors it holds.
+// In hybrid mode they switch past the threshold like the pages, in approximate mode as soon as the sketch is smaller
+func (i *InMemoryVisitRepository) adaptBucket(visitors visitorSet) visitorSet {
+	exact, isExact := visitors.(exactSet)
+	if !isExact || i.opts.counting == CountExact {
+		return visitors
+	}
+
+	threshold := i.opts.threshold
+	if i.opts.counting == CountApproximate {
+		threshold = domain.Count(1<<i.opts.precision) / visitorSize
+	}
+
+	if domain.Count(len(exact)) > threshold {
+		return exact.toSketch(i.opts.precision)
+	}
+
+	return visitors
 }
 
 // CountUniqueVisitors simply reads the count map entry for the page url given
-func (i *InMemoryVisitRepository) CountUniqueVisitors(url domain.PageURL) (domain.Count, error) {
-	i.m.RLock()
+func (i *InMemoryVisitRepository) CountUniqueVisitors(ctx context.Context, url domain.PageURL) (domain.UniqueVisitors, error) {
+	err := i.m.RLock(ctx)
+	if err != nil {
+		return domain.UniqueVisitors{}, err
+	}
+
+	defer i.m.RUnlock()
+
+	return i.uniqueVisitors(url), nil
+}
+
+// CountUniqueVisitorsBulk reads the count map entries of every page url given under a single read lock, so no visit
+// is stored half-way through and the counts are consistent with each other
+func (i *InMemoryVisitRepository) CountUniqueVisitorsBulk(ctx context.Context, urls []domain.PageURL) (map[domain.PageURL]domain.UniqueVisitors, error) {
+	err := i.m.RLock(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer i.m.RUnlock()
+
+	counts := make(map[domain.PageURL]domain.UniqueVisitors, len(urls))
+	for _, url := range urls {
+		counts[url] = i.uniqueVisitors(url)
+	}
+
+	return counts, nil
+}
+
+// CountUniqueVisitorsBetween merges the time buckets of the page that overlap [from, to), recent visits are kept in
+// hourly buckets and older ones in daily buckets, so the range is resolved to the hour (or day) boundaries around it
+func (i *InMemoryVisitRepository) CountUniqueVisitorsBetween(ctx context.Context, url domain.PageURL, from, to time.Time) (domain.UniqueVisitors, error) {
+	err := i.m.RLock(ctx)
+	if err != nil {
+		return domain.UniqueVisitors{}, err
+	}
+
 	defer i.m.RUnlock()
 
-	return i.count[url], nil
+	return i.countBetween(url, from, to)
+}
+
+// uniqueVisitors reads the count of the page along with how accurate it is, the caller must hold the lock
+func (i *InMemoryVisitRepository) uniqueVisitors(url domain.PageURL) domain.UniqueVisitors {
+	precision := i.precisionOf(url)
+	if precision == 0 {
+		return domain.UniqueVisitors{Count: i.count[url]}
+	}
+
+	return domain.UniqueVisitors{
+		Count:      i.count[url],
+		Estimated:  true,
+		ErrorBound: errorBound(precision),
+	}
 }
@@ -1,12 +1,30 @@
 package repository
 
 import (
+	"context"
+	"maps"
 	"sync"
 	"testing"
 
 	"deus.ai-code-challenge/domain"
 )
 
+// fullRepository has every feature of domain.VisitRepository, which every repository of the package supports
+type fullRepository interface {
+	domain.BatchVisitRepository
+	domain.BulkVisitRepository
+	domain.CombinedVisitRepository
+	domain.RollupVisitRepository
+	domain.RangedVisitRepository
+	domain.BucketedVisitRepository
+	domain.RecentVisitRepository
+	domain.RankedVisitRepository
+	domain.ListedVisitRepository
+	domain.HistoryVisitRepository
+	domain.ErasableVisitRepository
+	domain.RenamableVisitRepository
+}
+
 func TestInMemoryRepository(t *testing.T) {
 	type count struct {
 		pageURL       domain.PageURL
@@ -141,16 +159,16 @@ func TestInMemoryRepository(t *testing.T) {
 
 			for _, input := range tc.inputs {
 				if input.store.PageURL == "" {
-					counter, err := r.CountUniqueVisitors(input.count.pageURL)
+					counter, err := r.CountUniqueVisitors(context.Background(), input.count.pageURL)
 					if err != nil {
 						t.Fatal("unexpected error", err)
 					}
 
-					if counter != input.count.expectedCount {
+					if counter.Count != input.count.expectedCount {
 						t.Errorf("got %v, expected %v", counter, input.count.expectedCount)
 					}
 				} else {
-					err := r.Store(input.store)
+					err := r.Store(context.Background(), input.store)
 					if err != nil {
 						t.Fatal("unexpected error", err)
 					}
@@ -206,7 +224,7 @@ func TestInMemoryRepositoryConcurrency(t *testing.T) {
 			wg.Add(len(tc.inputs))
 			for _, i := range tc.inputs {
 				go func() {
-					err := r.Store(i)
+					err := r.Store(context.Background(), i)
 					if err != nil {
 						t.Error("unexpected error", err)
 					}
@@ -216,15 +234,138 @@ func TestInMemoryRepositoryConcurrency(t *testing.T) {
 
 			wg.Wait()
 			for k, v := range tc.expectedCounts {
-				counter, err := r.CountUniqueVisitors(k)
+				counter, err := r.CountUniqueVisitors(context.Background(), k)
 				if err != nil {
 					t.Error("unexpected error", err)
 				}
 
-				if counter != v {
+				if counter.Count != v {
 					t.Errorf("got %v, expected %v", counter, v)
 				}
 			}
 		})
 	}
 }
+
+func TestInMemoryRepositoryApproximate(t *testing.T) {
+	r := NewVisitsInMemoryRepository(WithCounting(CountApproximate, DefaultPrecision))
+
+	for _, visit := range []domain.Visit{
+		{Visitor: "id", PageURL: "url"},
+		{Visitor: "id", PageURL: "url"},
+		{Visitor: "id2", PageURL: "url"},
+		{Visitor: "id", PageURL: "url2"},
+	} {
+		err := r.Store(context.Background(), visit)
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+	}
+
+	expected := map[domain.PageURL]domain.UniqueVisitors{
+		"url":  {Count: 2, Estimated: true, ErrorBound: errorBound(DefaultPrecision)},
+		"url2": {Count: 1, Estimated: true, ErrorBound: errorBound(DefaultPrecision)},
+		"url3": {Count: 0},
+	}
+
+	for k, v := range expected {
+		counter, err := r.CountUniqueVisitors(context.Background(), k)
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+
+		if counter != v {
+			t.Errorf("%s: got %v, expected %v", k, counter, v)
+		}
+	}
+}
+
+func TestInMemoryRepositoryHybrid(t *testing.T) {
+	r := NewVisitsInMemoryRepository(WithCounting(CountHybrid, DefaultPrecision), WithHybridThreshold(3))
+
+	store := func(visits ...domain.Visit) {
+		for _, visit := range visits {
+			err := r.Store(context.Background(), visit)
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+		}
+	}
+
+	expectCount := func(url domain.PageURL, expected domain.UniqueVisitors) {
+		counter, err := r.CountUniqueVisitors(context.Background(), url)
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+
+		if counter != expected {
+			t.Errorf("%s: got %v, expected %v", url, counter, expected)
+		}
+	}
+
+	store(
+		domain.Visit{Visitor: "id", PageURL: "url"},
+		domain.Visit{Visitor: "id2", PageURL: "url"},
+		domain.Visit{Visitor: "id3", PageURL: "url"},
+		domain.Visit{Visitor: "id3", PageURL: "url"},
+		domain.Visit{Visitor: "id", PageURL: "url2"},
+	)
+
+	// pages at (or below) the threshold stay exact
+	expectCount("url", domain.UniqueVisitors{Count: 3})
+	expectCount("url2", domain.UniqueVisitors{Count: 1})
+
+	store(domain.Visit{Visitor: "id4", PageURL: "url"})
+
+	// once a page passes the threshold it's estimated
+	expectCount("url", domain.UniqueVisitors{Count: 4, Estimated: true, ErrorBound: errorBound(DefaultPrecision)})
+	expectCount("url2", domain.UniqueVisitors{Count: 1})
+
+	store(domain.Visit{Visitor: "id4", PageURL: "url"}, domain.Visit{Visitor: "id5", PageURL: "url"})
+
+	expectCount("url", domain.UniqueVisitors{Count: 5, Estimated: true, ErrorBound: errorBound(DefaultPrecision)})
+}
+
+func TestInMemoryRepositoryBatch(t *testing.T) {
+	r := NewVisitsInMemoryRepository()
+
+	err := r.StoreBatch(context.Background(), []domain.Visit{
+		{Visitor: "id", PageURL: "url"},
+		{Visitor: "id", PageURL: "url"},
+		{Visitor: "id2", PageURL: "url"},
+		{Visitor: "id", PageURL: "url2"},
+	})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	for url, expected := range map[domain.PageURL]domain.Count{"url": 2, "url2": 1} {
+		counter, _ := r.CountUniqueVisitors(context.Background(), url)
+		if counter.Count != expected {
+			t.Errorf("%v: got %v, expected %v", url, counter.Count, expected)
+		}
+	}
+}
+
+func TestInMemoryRepositoryBulk(t *testing.T) {
+	r := NewVisitsInMemoryRepository()
+
+	err := r.StoreBatch(context.Background(), []domain.Visit{
+		{Visitor: "id", PageURL: "url"},
+		{Visitor: "id2", PageURL: "url"},
+		{Visitor: "id", PageURL: "url2"},
+	})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	counts, err := r.CountUniqueVisitorsBulk(context.Background(), []domain.PageURL{"url", "url2", "url3"})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	expected := map[domain.PageURL]domain.UniqueVisitors{"url": {Count: 2}, "url2": {Count: 1}, "url3": {}}
+	if !maps.Equal(counts, expected) {
+		t.Errorf("got %v, expected %v", counts, expected)
+	}
+}
@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"fmt"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// CountingMode defines how the unique visitors of each page are accounted for
+type CountingMode int
+
+const (
+	// CountExact keeps every visitor id, counts are exact but memory grows with the number of visitors
+	CountExact CountingMode = iota
+	// CountApproximate keeps a HyperLogLog sketch per page, counts are estimated but memory per page is fixed
+	CountApproximate
+	// CountHybrid keeps every visitor id until a page reaches a threshold of unique visitors, at which point the page
+	// switches to a HyperLogLog sketch. Small pages stay exact while the memory used by large pages is capped
+	CountHybrid
+)
+
+// ParseCountingMode converts the textual representation of a mode (exact, approximate or hybrid) into a CountingMode
+func ParseCountingMode(s string) (CountingMode, error) {
+	switch s {
+	case "exact":
+		return CountExact, nil
+	case "approximate":
+		return CountApproximate, nil
+	case "hybrid":
+		return CountHybrid, nil
+	default:
+		return 0, fmt.Errorf("unknown counting mode: %s", s)
+	}
+}
+
+// visitorSet holds the unique visitors of a page
+type visitorSet interface {
+	// add accounts for the visitor, reporting whether the set changed
+	add(visitor visitorID) bool
+	// accounts reports whether adding the visitor would leave the set unchanged
+	accounts(visitor visitorID) bool
+	// estimate returns the number of unique visitors in the set
+	estimate() domain.UniqueVisitors
+	// remove forgets the visitor, reporting whether the set changed
+	remove(visitor visitorID) bool
+}
+
+// exactSet keeps every visitor id, go doesn't provide a set data structure natively but those can be mimicked by
+// a map[KEY]struct{}
+type exactSet map[visitorID]struct{}
+
+func (e exactSet) add(visitor visitorID) bool {
+	_, found := e[visitor]
+	if found {
+		return false
+	}
+
+	e[visitor] = struct{}{}
+
+	return true
+}
+
+func (e exactSet) accounts(visitor visitorID) bool {
+	_, found := e[visitor]
+
+	return found
+}
+
+func (e exactSet) remove(visitor visitorID) bool {
+	_, found := e[visitor]
+	delete(e, visitor)
+
+	return found
+}
+
+func (e exactSet) estimate() domain.UniqueVisitors {
+	return domain.UniqueVisitors{Count: domain.Count(len(e))}
+}
+
+// toSketch builds a sketch holding the same visitors as the set
+func (e exactSet) toSketch(precision uint8) *hyperLogLog {
+	sketch := newHyperLogLog(precision)
+	for visitor := range e {
+		sketch.add(visitor)
+	}
+
+	return sketch
+}
+
+// union returns a new set holding the visitors of every set, the result is only exact if every set is exact.
+// Otherwise it's a sketch with the lowest precision among the sets, since sketches can only be merged at the same
+// precision
+func union(sets ...visitorSet) visitorSet {
+	precision := uint8(0)
+	for _, set := range sets {
+		sketch, isSketch := set.(*hyperLogLog)
+		if isSketch && (precision == 0 || sketch.precision < precision) {
+			precision = sketch.precision
+		}
+	}
+
+	if precision == 0 {
+		result := exactSet{}
+		for _, set := range sets {
+			for visitor := range set.(exactSet) {
+				result[visitor] = struct{}{}
+			}
+		}
+
+		return result
+	}
+
+	result := newHyperLogLog(precision)
+	for _, set := range sets {
+		switch s := set.(type) {
+		case exactSet:
+			for visitor := range s {
+				result.add(visitor)
+			}
+		case *hyperLogLog:
+			result.merge(s)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// MaxBulkPages bounds the number of pages counted at once
+const MaxBulkPages = 10_000
+
+const (
+	// DefaultTopPages is the number of pages ranked when no limit is given
+	DefaultTopPages = 10
+	// MaxTopPages bounds the number of pages ranked at once
+	MaxTopPages = 100
+)
+
+// globEscaper escapes the characters that have a special meaning in a glob pattern
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`)
+
+// UniqueVisitors counts the unique visitors of the page
+func (s *VisitService) UniqueVisitors(ctx context.Context, pageURL domain.PageURL) (domain.UniqueVisitors, error) {
+	err := s.validatePageURL("page url", pageURL)
+	if err != nil {
+		return domain.UniqueVisitors{}, err
+	}
+
+	return s.repo.CountUniqueVisitors(ctx, pageURL)
+}
+
+// UniqueVisitorsBetween counts the unique visitors of the page whose visits happened in [from, to)
+func (s *VisitService) UniqueVisitorsBetween(ctx context.Context, pageURL domain.PageURL, from, to time.Time) (domain.UniqueVisitors, error) {
+	err := s.validatePageURL("page url", pageURL)
+	if err != nil {
+		return domain.UniqueVisitors{}, err
+	}
+
+	ranged, ok := s.repo.(domain.RangedVisitRepository)
+	if !ok {
+		return domain.UniqueVisitors{}, fmt.Errorf("%w: counts between times", domain.ErrUnsupported)
+	}
+
+	return ranged.CountUniqueVisitorsBetween(ctx, pageURL, from, to)
+}
+
+// RecentUniqueVisitors counts the unique visitors of the page seen within the last window (e.g. 15 minutes)
+func (s *VisitService) RecentUniqueVisitors(ctx context.Context, pageURL domain.PageURL, window time.Duration) (domain.UniqueVisitors, error) {
+	err := s.validatePageURL("page url", pageURL)
+	if err != nil {
+		return domain.UniqueVisitors{}, err
+	}
+
+	recent, ok := s.repo.(domain.RecentVisitRepository)
+	if !ok {
+		return domain.UniqueVisitors{}, fmt.Errorf("%w: recent counts", domain.ErrUnsupported)
+	}
+
+	return recent.CountRecentUniqueVisitors(ctx, pageURL, window)
+}
+
+// UniqueVisitorsSeries counts the unique visitors of the page in each period of the given interval within [from, to)
+func (s *VisitService) UniqueVisitorsSeries(ctx context.Context, pageURL domain.PageURL, interval time.Duration, from, to time.Time) ([]domain.Bucket, error) {
+	err := s.validatePageURL("page url", pageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	bucketed, ok := s.repo.(domain.BucketedVisitRepository)
+	if !ok {
+		return nil, fmt.Errorf("%w: series", domain.ErrUnsupported)
+	}
+
+	return bucketed.CountUniqueVisitorsSeries(ctx, pageURL, interval, from, to)
+}
+
+// UniqueVisitorsBulk counts the unique visitors of several pages at once, pages without visits have a count of zero
+func (s *VisitService) UniqueVisitorsBulk(ctx context.Context, pageURLs []domain.PageURL) (map[domain.PageURL]domain.UniqueVisitors, error) {
+	if len(pageURLs) == 0 {
+		return nil, fmt.Errorf("%w: page urls", domain.ErrMissingField)
+	}
+
+	if len(pageURLs) > MaxBulkPages {
+		return nil, tooLarge(MaxBulkPages, "pages")
+	}
+
+	for _, pageURL := range pageURLs {
+		err := s.validatePageURL("page url", pageURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	bulk, ok := s.repo.(domain.BulkVisitRepository)
+	if !ok {
+		return nil, fmt.Errorf("%w: bulk counts", domain.ErrUnsupported)
+	}
+
+	return bulk.CountUniqueVisitorsBulk(ctx, pageURLs)
+}
+
+// UniqueVisitorsCombined counts the unique visitors of several pages combined with the operator
+func (s *VisitService) UniqueVisitorsCombined(ctx context.Context, pageURLs []domain.PageURL, operator domain.SetOperator) (domain.UniqueVisitors, error) {
+	if len(pageURLs) == 0 {
+		return domain.UniqueVisitors{}, fmt.Errorf("%w: page urls", domain.ErrMissingField)
+	}
+
+	for _, pageURL := range pageURLs {
+		err := s.validatePageURL("page url", pageURL)
+		if err != nil {
+			return domain.UniqueVisitors{}, err
+		}
+	}
+
+	combined, ok := s.repo.(domain.CombinedVisitRepository)
+	if !ok {
+		return domain.UniqueVisitors{}, fmt.Errorf("%w: combined counts", domain.ErrUnsupported)
+	}
+
+	return combined.CountUniqueVisitorsCombined(ctx, pageURLs, operator)
+}
+
+// UniqueVisitorsMatching counts the unique visitors of every page whose url matches the glob pattern, see PrefixPattern
+// to count the pages under a prefix
+func (s *VisitService) UniqueVisitorsMatching(ctx context.Context, pattern string) (domain.PageRollup, error) {
+	rollups, ok := s.repo.(domain.RollupVisitRepository)
+	if !ok {
+		return domain.PageRollup{}, fmt.Errorf("%w: rollups", domain.ErrUnsupported)
+	}
+
+	return rollups.CountUniqueVisitorsMatching(ctx, pattern)
+}
+
+// TopPages returns the limit pages with the most unique visitors, ranked by their count. A window of 0 ranks the
+// all-time counts, otherwise the visitors seen within the last window (e.g. 15 minutes) are ranked
+func (s *VisitService) TopPages(ctx context.Context, limit int, window time.Duration) ([]domain.PageCount, error) {
+	if limit < 1 || limit > MaxTopPages {
+		return nil, fmt.Errorf("%w: must be between 1 and %d", domain.ErrInvalidLimit, MaxTopPages)
+	}
+
+	ranked, ok := s.repo.(domain.RankedVisitRepository)
+	if !ok {
+		return nil, fmt.Errorf("%w: top pages", domain.ErrUnsupported)
+	}
+
+	return ranked.CountTopPages(ctx, limit, window)
+}
+
+// PrefixPattern returns the pattern that matches every page under the url prefix. The prefix is matched by whole path
+// segments, so /blog matches /blog/post-1 but not /blogging
+func PrefixPattern(prefix string) string {
+	return strings.TrimSuffix(globEscaper.Replace(prefix), "/") + "/**"
+}
+
+// NormalizePageURL returns the canonical form of the page url (the url as given without a normalizer), i.e. the page
+// its visits are accounted for in
+func (s *VisitService) NormalizePageURL(pageURL domain.PageURL) (domain.PageURL, error) {
+	err := s.validatePageURL("page url", pageURL)
+	if err != nil {
+		return "", err
+	}
+
+	if s.normalizer == nil {
+		return pageURL, nil
+	}
+
+	return s.normalizer.Normalize(pageURL)
+}
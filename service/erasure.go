@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// EraseVisitor removes the visitor from every page and drops its quarantined visits, then records the erasure in the
+// audit trail along with the caller that asked for it. The erasure is only audited once the visitor is gone; if the
+// audit can't be recorded the error is returned and the call can be retried, erasing an erased visitor changes nothing.
+// When visitor ids are pseudonymized the visitor is erased under its pseudonym of every key, and the erasure is
+// audited with the current one (the raw id isn't recorded)
+func (s *VisitService) EraseVisitor(ctx context.Context, visitor string, caller domain.Caller) (domain.Erasure, error) {
+	if visitor == "" {
+		return domain.Erasure{}, fmt.Errorf("%w: visitor id", domain.ErrMissingField)
+	}
+
+	if s.audit == nil {
+		return domain.Erasure{}, domain.ErrAuditUnavailable
+	}
+
+	erasable, ok := s.repo.(domain.ErasableVisitRepository)
+	if !ok {
+		return domain.Erasure{}, fmt.Errorf("%w: erasures", domain.ErrUnsupported)
+	}
+
+	ids := s.pseudonymsOf(visitor)
+
+	var pages, retained, quarantined domain.Count
+	for _, id := range ids {
+		erased, err := erasable.EraseVisitor(ctx, id)
+		if err != nil {
+			return domain.Erasure{}, err
+		}
+
+		pages += erased.Erased
+		retained += erased.Retained
+
+		if s.registry.Quarantine != nil {
+			dropped, err := s.registry.Quarantine.Forget(id)
+			if err != nil {
+				return domain.Erasure{}, err
+			}
+
+			quarantined += dropped
+		}
+	}
+
+	erasure := domain.Erasure{
+		Visitor:     ids[0],
+		Caller:      caller,
+		Time:        s.now(),
+		Pages:       pages,
+		Retained:    retained,
+		Quarantined: quarantined,
+	}
+
+	err := s.audit.Record(erasure)
+	if err != nil {
+		return domain.Erasure{}, err
+	}
+
+	return erasure, nil
+}
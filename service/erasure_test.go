@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/repository"
+)
+
+func TestVisitServiceEraseVisitor(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	pages := repository.NewInMemoryPageRegistry()
+	quarantine := repository.NewInMemoryQuarantine(10)
+	audit := repository.NewInMemoryErasureAudit()
+	repo := repository.NewVisitsInMemoryRepository()
+
+	_, err := pages.Register(domain.Page{URL: "known"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	visits := NewVisitService(repo,
+		WithRegistry(Registry{Pages: pages, Quarantine: quarantine, UnknownPages: QuarantineUnknownPages}),
+		WithErasureAudit(audit),
+	)
+	visits.now = func() time.Time { return now }
+
+	_, err = visits.RecordVisits(ctx, []domain.Visit{
+		{Visitor: "a", PageURL: "known"},
+		{Visitor: "a", PageURL: "unknown"},
+		{Visitor: "b", PageURL: "known"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	erasure, err := visits.EraseVisitor(ctx, "a", domain.Caller{Name: "legal"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := domain.Erasure{Visitor: "a", Caller: domain.Caller{Name: "legal"}, Time: now, Pages: 1, Quarantined: 1}
+	if erasure != expected {
+		t.Errorf("got %v, expected %v", erasure, expected)
+	}
+
+	recorded := audit.Erasures()
+	if len(recorded) != 1 || recorded[0] != expected {
+		t.Errorf("got %v, expected %v", recorded, []domain.Erasure{expected})
+	}
+
+	// the visits held for the unknown page aren't stored once it's registered
+	_, released, err := visits.RegisterPage(ctx, domain.Page{URL: "unknown"})
+	if err != nil || released != 0 {
+		t.Errorf("got %v and %v, expected no visit released", released, err)
+	}
+
+	count, err := visits.UniqueVisitors(ctx, "known")
+	if err != nil || count.Count != 1 {
+		t.Errorf("got %v and %v, expected %v", count.Count, err, 1)
+	}
+
+	_, err = visits.EraseVisitor(ctx, "", domain.Caller{Name: "legal"})
+	if domain.KindOf(err) != domain.KindInvalid {
+		t.Errorf("got %v, expected %v", domain.KindOf(err), domain.KindInvalid)
+	}
+
+	_, err = NewVisitService(repo).EraseVisitor(ctx, "b", domain.Caller{Name: "legal"})
+	if !errors.Is(err, domain.ErrAuditUnavailable) {
+		t.Errorf("got %v, expected %v", err, domain.ErrAuditUnavailable)
+	}
+}
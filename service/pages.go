@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"deus.ai-code-challenge/domain"
+)
+
+const (
+	// DefaultListedPages is the number of pages listed at once when no limit is given
+	DefaultListedPages = 50
+	// MaxListedPages bounds the number of pages listed at once
+	MaxListedPages = 1000
+)
+
+// PageList is a page of the listed pages, Next is the cursor of the following one (empty when there are no more)
+type PageList struct {
+	Pages []domain.PageCount
+	Next  string
+}
+
+// cursor is where a listing stopped, it's tied to the prefix and order of the listing it belongs to
+type cursor struct {
+	Prefix string           `json:"p,omitempty"`
+	Order  domain.PageOrder `json:"o"`
+	URL    string           `json:"u"`
+	Count  domain.Count     `json:"c,omitempty"`
+}
+
+// encodeCursor returns the opaque cursor of the listing after the page
+func encodeCursor(prefix string, order domain.PageOrder, page domain.PageCount) string {
+	b, _ := json.Marshal(cursor{Prefix: prefix, Order: order, URL: page.PageURL, Count: page.UniqueVisitors.Count})
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor returns the last page listed before the cursor, it must belong to a listing with the same prefix and order
+func decodeCursor(s, prefix string, order domain.PageOrder) (*domain.PageCount, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, domain.ErrInvalidCursor
+	}
+
+	c := cursor{}
+
+	err = json.Unmarshal(b, &c)
+	if err != nil || c.Prefix != prefix || c.Order != order {
+		return nil, domain.ErrInvalidCursor
+	}
+
+	return &domain.PageCount{PageURL: c.URL, UniqueVisitors: domain.UniqueVisitors{Count: c.Count}}, nil
+}
+
+// ListPages lists the pages whose url starts with the prefix along with their unique visitors, limit at a time, by url
+// (the default) or from the most visited. The cursor is the Next of the previous call (empty for the first one).
+// Cursors point after the last page listed rather than at a position, so pages added in between don't make a page be
+// listed twice or skipped; when listing by count a page whose count changes in between may move across the cursor
+func (s *VisitService) ListPages(ctx context.Context, prefix string, order domain.PageOrder, cursor string, limit int) (PageList, error) {
+	if order == "" {
+		order = domain.OrderByURL
+	}
+
+	if order != domain.OrderByURL && order != domain.OrderByCount {
+		return PageList{}, fmt.Errorf("%w: %s", domain.ErrInvalidOrder, order)
+	}
+
+	if limit < 1 || limit > MaxListedPages {
+		return PageList{}, fmt.Errorf("%w: must be between 1 and %d", domain.ErrInvalidLimit, MaxListedPages)
+	}
+
+	query := domain.PageQuery{Prefix: prefix, Order: order, Limit: limit + 1}
+
+	if cursor != "" {
+		after, err := decodeCursor(cursor, prefix, order)
+		if err != nil {
+			return PageList{}, err
+		}
+
+		query.After = after
+	}
+
+	listed, ok := s.repo.(domain.ListedVisitRepository)
+	if !ok {
+		return PageList{}, fmt.Errorf("%w: page listings", domain.ErrUnsupported)
+	}
+
+	// one more page than asked for tells whether there's a next one
+	pages, err := listed.ListPages(ctx, query)
+	if err != nil {
+		return PageList{}, err
+	}
+
+	if len(pages) <= limit {
+		return PageList{Pages: pages}, nil
+	}
+
+	pages = pages[:limit]
+
+	return PageList{Pages: pages, Next: encodeCursor(prefix, order, pages[limit-1])}, nil
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/repository"
+)
+
+func TestVisitServiceListPagesCursor(t *testing.T) {
+	ctx := context.Background()
+	visits := NewVisitService(repository.NewVisitsInMemoryRepository())
+
+	store := func(urls ...string) {
+		for _, url := range urls {
+			_, err := visits.RecordVisit(ctx, domain.Visit{Visitor: "a", PageURL: url})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	store("/b", "/d", "/f", "/h")
+
+	var listed []string
+
+	list, err := visits.ListPages(ctx, "/", domain.OrderByURL, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		for _, page := range list.Pages {
+			listed = append(listed, page.PageURL)
+		}
+
+		// pages added while listing, before and after the cursor
+		store(fmt.Sprintf("/a%d", len(listed)), fmt.Sprintf("/z%d", len(listed)))
+
+		if list.Next == "" || len(listed) > 10 {
+			break
+		}
+
+		list, err = visits.ListPages(ctx, "/", domain.OrderByURL, list.Next, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// pages before the cursor are never listed, the ones after it are, each page is listed once
+	expected := []string{"/b", "/d", "/f", "/h", "/z2", "/z4"}
+	if fmt.Sprint(listed) != fmt.Sprint(expected) {
+		t.Errorf("got %v, expected %v", listed, expected)
+	}
+
+	_, err = visits.ListPages(ctx, "/blog", domain.OrderByURL, encodeCursor("/", domain.OrderByURL, domain.PageCount{PageURL: "/b"}), 2)
+	if !errors.Is(err, domain.ErrInvalidCursor) {
+		t.Errorf("got %v, expected %v", err, domain.ErrInvalidCursor)
+	}
+
+	_, err = visits.ListPages(ctx, "/", domain.OrderByURL, "not a cursor", 2)
+	if !errors.Is(err, domain.ErrInvalidCursor) {
+		t.Errorf("got %v, expected %v", err, domain.ErrInvalidCursor)
+	}
+}
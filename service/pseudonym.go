@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// migrations remembers the visitors already moved from their previous pseudonyms, so that each one is only moved the
+// first time it's seen (after a start). It holds the current pseudonym of every visitor seen since the keys were
+// rotated, about as much as the visitor history the moves rely on
+type migrations struct {
+	m     sync.Mutex
+	moved map[string]struct{}
+}
+
+// pending returns the moves of the visitor from its previous pseudonyms if it wasn't moved yet
+func (m *migrations) pending(current string, previous []string) []domain.Rename {
+	if len(previous) == 0 {
+		return nil
+	}
+
+	m.m.Lock()
+	_, moved := m.moved[current]
+	m.m.Unlock()
+
+	if moved {
+		return nil
+	}
+
+	renames := make([]domain.Rename, 0, len(previous))
+	for _, pseudonym := range previous {
+		renames = append(renames, domain.Rename{From: pseudonym, To: current})
+	}
+
+	return renames
+}
+
+// done remembers the visitors the moves were made for
+func (m *migrations) done(renames []domain.Rename) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	if m.moved == nil {
+		m.moved = make(map[string]struct{})
+	}
+
+	for _, rename := range renames {
+		m.moved[rename.To] = struct{}{}
+	}
+}
+
+// pseudonymize replaces the visitor id with its pseudonym, if visitor ids are pseudonymized. Once the keys were
+// rotated, it also returns the moves of the visitor from its pseudonyms under the previous keys, so it isn't counted
+// twice: they're to be made (see migrate) once the visit is admitted
+func (s *VisitService) pseudonymize(visitor string) (string, []domain.Rename) {
+	if s.pseudonyms == nil {
+		return visitor, nil
+	}
+
+	current, previous := s.pseudonyms.Pseudonymize(visitor)
+
+	return current, s.migrations.pending(current, previous)
+}
+
+// migrate moves visitors from their previous pseudonyms with a single repository call, nothing is moved twice: a
+// visitor is only moved the first time it's seen and moves of visitors that aren't held change nothing
+func (s *VisitService) migrate(ctx context.Context, renames []domain.Rename) error {
+	if len(renames) == 0 {
+		return nil
+	}
+
+	renamable, ok := s.repo.(domain.RenamableVisitRepository)
+	if !ok {
+		return fmt.Errorf("%w: key rotations", domain.ErrUnsupported)
+	}
+
+	_, err := renamable.RenameVisitors(ctx, renames)
+	if err != nil {
+		return err
+	}
+
+	s.migrations.done(renames)
+
+	return nil
+}
+
+// pseudonymsOf returns every id the visitor may be held under: its pseudonyms under every key if visitor ids are
+// pseudonymized, the first one being the current pseudonym. Nothing is moved, so it can be used by reads
+func (s *VisitService) pseudonymsOf(visitor string) []string {
+	if s.pseudonyms == nil {
+		return []string{visitor}
+	}
+
+	current, _ := s.pseudonyms.Pseudonymize(visitor)
+
+	pseudonyms := []string{current}
+	for _, pseudonym := range s.pseudonyms.Pseudonyms(visitor) {
+		if pseudonym != current {
+			pseudonyms = append(pseudonyms, pseudonym)
+		}
+	}
+
+	return pseudonyms
+}
+
+// mergeVisitedPages merges the pages a visitor was seen in under each of its ids, a page seen under several ids was
+// first seen the first time it was seen under any of them (and last seen the last time). Pages are ordered by
+// domain.ByFirstSeen, as domain.HistoryVisitRepository orders them
+func mergeVisitedPages(histories ...[]domain.VisitedPage) []domain.VisitedPage {
+	if len(histories) == 1 {
+		return histories[0]
+	}
+
+	merged := make(map[domain.PageURL]domain.VisitedPage)
+	for _, history := range histories {
+		for _, page := range history {
+			current, found := merged[page.PageURL]
+			if found && (page.FirstSeen.IsZero() || (!current.FirstSeen.IsZero() && current.FirstSeen.Before(page.FirstSeen))) {
+				page.FirstSeen = current.FirstSeen
+			}
+
+			if found && current.LastSeen.After(page.LastSeen) {
+				page.LastSeen = current.LastSeen
+			}
+
+			merged[page.PageURL] = page
+		}
+	}
+
+	pages := make([]domain.VisitedPage, 0, len(merged))
+	for _, page := range merged {
+		pages = append(pages, page)
+	}
+
+	slices.SortFunc(pages, domain.ByFirstSeen)
+
+	return pages
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/pseudonym"
+	"deus.ai-code-challenge/repository"
+)
+
+// renameCounter counts the calls moving visitors to a new id
+type renameCounter struct {
+	*repository.InMemoryVisitRepository
+	calls   int
+	renames int
+}
+
+func (r *renameCounter) RenameVisitors(ctx context.Context, renames []domain.Rename) (domain.Count, error) {
+	r.calls++
+	r.renames += len(renames)
+
+	return r.InMemoryVisitRepository.RenameVisitors(ctx, renames)
+}
+
+func TestVisitServicePseudonymizedKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	old := pseudonym.Key{Secret: "0123456789abcdef-old", ActiveFrom: now.Add(-300 * 24 * time.Hour)}
+	rotated := pseudonym.Key{Secret: "0123456789abcdef-new", ActiveFrom: now.Add(-100 * 24 * time.Hour)}
+
+	newPseudonymizer := func(keys ...pseudonym.Key) *pseudonym.Pseudonymizer {
+		p, err := pseudonym.New(pseudonym.Config{Keys: keys})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return p
+	}
+
+	repo := &renameCounter{InMemoryVisitRepository: repository.NewVisitsInMemoryRepository(repository.WithVisitorHistory(true))}
+	audit := repository.NewInMemoryErasureAudit()
+
+	// visits received before the rotation, with the old key only
+	before := NewVisitService(repo, WithPseudonymizer(newPseudonymizer(old)))
+
+	_, err := before.RecordVisits(ctx, []domain.Visit{
+		{Visitor: "a", PageURL: "/blog"},
+		{Visitor: "b", PageURL: "/blog"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// raw visitor ids are never stored
+	pages, err := repo.ListVisitorPages(ctx, "a")
+	if err != nil || len(pages) != 0 {
+		t.Errorf("got %v and %v, expected the raw id to be unknown", pages, err)
+	}
+
+	// a is seen again long after the rotation, it's moved to its new pseudonym instead of counted twice. Rejected
+	// visits don't move anything, and a is only moved once however many times it's seen
+	after := NewVisitService(repo, WithPseudonymizer(newPseudonymizer(old, rotated)), WithErasureAudit(audit))
+
+	_, err = after.RecordVisit(ctx, domain.Visit{Visitor: "a", PageURL: "::invalid"})
+	if err == nil {
+		t.Fatal("expected the invalid visit to be rejected")
+	}
+
+	_, err = after.RecordVisits(ctx, []domain.Visit{
+		{Visitor: "a", PageURL: "/blog"},
+		{Visitor: "a", PageURL: "/about"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = after.RecordVisit(ctx, domain.Visit{Visitor: "a", PageURL: "/blog"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if repo.calls != 1 || repo.renames != 2 {
+		t.Errorf("got %d calls moving %d visitors, expected a single call", repo.calls, repo.renames)
+	}
+
+	count, err := after.UniqueVisitors(ctx, "/blog")
+	if err != nil || count.Count != 2 {
+		t.Errorf("got %v and %v, expected %v", count.Count, err, 2)
+	}
+
+	pages, err = after.VisitorPages(ctx, "a")
+	if err != nil || len(pages) != 2 || pages[0].PageURL != "/blog" {
+		t.Errorf("got %v and %v, expected /blog and /about", pages, err)
+	}
+
+	// b was never seen again, it's looked up under its old pseudonym without being moved
+	pages, err = after.VisitorPages(ctx, "b")
+	if err != nil || len(pages) != 1 || pages[0].PageURL != "/blog" {
+		t.Errorf("got %v and %v, expected /blog", pages, err)
+	}
+
+	pages, err = repo.ListVisitorPages(ctx, pseudonym.Hash(old.Secret, "b"))
+	if err != nil || len(pages) != 1 {
+		t.Errorf("got %v and %v, expected b to keep its old pseudonym", pages, err)
+	}
+
+	// and it's still erased under its old pseudonym
+	erasure, err := after.EraseVisitor(ctx, "b", domain.Caller{Name: "legal"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if erasure.Pages != 1 || erasure.Visitor == "b" {
+		t.Errorf("got %v, expected a pseudonymized erasure from 1 page", erasure)
+	}
+
+	count, err = after.UniqueVisitors(ctx, "/blog")
+	if err != nil || count.Count != 1 {
+		t.Errorf("got %v and %v, expected %v", count.Count, err, 1)
+	}
+}
+
+func TestMergeVisitedPages(t *testing.T) {
+	base := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	previous := []domain.VisitedPage{
+		{PageURL: "/blog", FirstSeen: base, LastSeen: base.Add(time.Hour)},
+		{PageURL: "/about", FirstSeen: base.Add(time.Minute), LastSeen: base.Add(time.Minute)},
+		{PageURL: "/untimed"},
+	}
+	current := []domain.VisitedPage{
+		{PageURL: "/pricing", FirstSeen: base.Add(-time.Hour), LastSeen: base.Add(-time.Hour)},
+		{PageURL: "/blog", FirstSeen: base.Add(30 * time.Minute), LastSeen: base.Add(2 * time.Hour)},
+		{PageURL: "/untimed", FirstSeen: base.Add(3 * time.Hour), LastSeen: base.Add(3 * time.Hour)},
+	}
+
+	expected := []domain.VisitedPage{
+		{PageURL: "/pricing", FirstSeen: base.Add(-time.Hour), LastSeen: base.Add(-time.Hour)},
+		{PageURL: "/blog", FirstSeen: base, LastSeen: base.Add(2 * time.Hour)},
+		{PageURL: "/about", FirstSeen: base.Add(time.Minute), LastSeen: base.Add(time.Minute)},
+		{PageURL: "/untimed", FirstSeen: base.Add(3 * time.Hour), LastSeen: base.Add(3 * time.Hour)},
+	}
+
+	merged := mergeVisitedPages(current, previous)
+	if !reflect.DeepEqual(merged, expected) {
+		t.Errorf("got %v, expected %v", merged, expected)
+	}
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// UnknownPagePolicy defines what happens to the visits of pages that aren't registered (or are retired)
+type UnknownPagePolicy string
+
+const (
+	// AcceptUnknownPages stores every visit, the registry isn't checked
+	AcceptUnknownPages UnknownPagePolicy = "accept"
+	// RejectUnknownPages fails the visits of unknown pages with domain.ErrUnknownPage
+	RejectUnknownPages UnknownPagePolicy = "reject"
+	// QuarantineUnknownPages holds the visits of unknown pages, they're stored once the page is registered
+	QuarantineUnknownPages UnknownPagePolicy = "quarantine"
+)
+
+// ParseUnknownPagePolicy converts the textual representation of a policy (accept, reject or quarantine) into an
+// UnknownPagePolicy
+func ParseUnknownPagePolicy(s string) (UnknownPagePolicy, error) {
+	switch policy := UnknownPagePolicy(s); policy {
+	case AcceptUnknownPages, RejectUnknownPages, QuarantineUnknownPages:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown pages policy: %s", s)
+	}
+}
+
+// Registry groups the registered pages, the visits held for the unknown ones and the policy that decides what happens
+// to those visits. The zero value accepts every visit
+type Registry struct {
+	Pages        domain.PageRegistry
+	Quarantine   domain.VisitQuarantine
+	UnknownPages UnknownPagePolicy
+}
+
+// admit reports whether the visit is to be stored. Visits of registered pages always are, visits of unknown pages are
+// either accepted, rejected (domain.ErrUnknownPage) or quarantined (not stored, without an error) according to the policy
+func (reg Registry) admit(visit domain.Visit) (bool, error) {
+	if reg.UnknownPages == "" || reg.UnknownPages == AcceptUnknownPages {
+		return true, nil
+	}
+
+	page, err := reg.Pages.Page(visit.PageURL)
+	if err == nil && !page.Retired {
+		return true, nil
+	}
+
+	if err != nil && !errors.Is(err, domain.ErrPageNotFound) {
+		return false, err
+	}
+
+	if reg.UnknownPages == RejectUnknownPages {
+		return false, fmt.Errorf("%w: %s", domain.ErrUnknownPage, visit.PageURL)
+	}
+
+	return false, reg.Quarantine.Quarantine(visit)
+}
+
+// release stores the visits held for the page once it's registered (or brought back), returning how many there were.
+// If they can't be stored they're held again, the visits that can't be held again either are lost: how many is
+// returned along with why, joined to the error of the repository
+func (reg Registry) release(ctx context.Context, repository domain.VisitRepository, page domain.Page) (int, error) {
+	if reg.Quarantine == nil || page.Retired {
+		return 0, nil
+	}
+
+	visits, err := reg.Quarantine.Release(page.URL)
+	if err != nil || len(visits) == 0 {
+		return 0, err
+	}
+
+	err = storeBatch(ctx, repository, visits)
+	if err != nil {
+		var lost int
+		var holdErr error
+
+		for _, visit := range visits {
+			e := reg.Quarantine.Quarantine(visit)
+			if e != nil {
+				lost++
+				holdErr = e
+			}
+		}
+
+		if lost > 0 {
+			err = errors.Join(err, fmt.Errorf("%d visits of %s couldn't be held again and are lost: %w", lost, page.URL, holdErr))
+		}
+
+		return 0, err
+	}
+
+	return len(visits), nil
+}
+
+// RegisterPage registers the page and stores the visits quarantined while it was unknown, returning how many there were
+func (s *VisitService) RegisterPage(ctx context.Context, page domain.Page) (domain.Page, int, error) {
+	err := s.validatePageURL("page url", page.URL)
+	if err != nil {
+		return domain.Page{}, 0, err
+	}
+
+	page, err = s.registry.Pages.Register(page)
+	if err != nil {
+		return domain.Page{}, 0, err
+	}
+
+	released, err := s.registry.release(ctx, s.repo, page)
+
+	return page, released, err
+}
+
+// UpdatePage changes the title of the page and whether it's retired, the visits quarantined while it was retired are
+// stored when it's brought back (returning how many there were)
+func (s *VisitService) UpdatePage(ctx context.Context, page domain.Page) (domain.Page, int, error) {
+	if page.URL == "" {
+		return domain.Page{}, 0, fmt.Errorf("%w: page url", domain.ErrMissingField)
+	}
+
+	page, err := s.registry.Pages.Update(page)
+	if err != nil {
+		return domain.Page{}, 0, err
+	}
+
+	released, err := s.registry.release(ctx, s.repo, page)
+
+	return page, released, err
+}
+
+// RetirePage retires the page, its visits are kept
+func (s *VisitService) RetirePage(pageURL domain.PageURL) (domain.Page, error) {
+	if pageURL == "" {
+		return domain.Page{}, fmt.Errorf("%w: page url", domain.ErrMissingField)
+	}
+
+	return s.registry.Pages.Retire(pageURL)
+}
+
+// Pages lists every registered page (including the retired ones) sorted by url
+func (s *VisitService) Pages() ([]domain.Page, error) {
+	return s.registry.Pages.Pages()
+}
+
+// Quarantined returns the number of visits held per unknown page
+func (s *VisitService) Quarantined() (map[domain.PageURL]domain.Count, error) {
+	return s.registry.Quarantine.Quarantined()
+}
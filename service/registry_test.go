@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/repository"
+)
+
+func TestParseUnknownPagePolicy(t *testing.T) {
+	type testCase struct {
+		input          string
+		expectedPolicy UnknownPagePolicy
+		expectError    bool
+	}
+
+	testCases := []testCase{
+		{input: "accept", expectedPolicy: AcceptUnknownPages},
+		{input: "reject", expectedPolicy: RejectUnknownPages},
+		{input: "quarantine", expectedPolicy: QuarantineUnknownPages},
+		{input: "drop", expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			policy, err := ParseUnknownPagePolicy(tc.input)
+			if (err != nil) != tc.expectError {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectError)
+			}
+
+			if policy != tc.expectedPolicy {
+				t.Errorf("got %v, expected %v", policy, tc.expectedPolicy)
+			}
+		})
+	}
+}
+
+// failingRepository fails to store any visit
+type failingRepository struct {
+	domain.VisitRepository
+}
+
+func (failingRepository) Store(ctx context.Context, visit domain.Visit) error {
+	return errors.New("disk full")
+}
+
+// fullQuarantine refuses to hold visits once full is set
+type fullQuarantine struct {
+	*repository.InMemoryQuarantine
+	full bool
+}
+
+func (q *fullQuarantine) Quarantine(visit domain.Visit) error {
+	if q.full {
+		return domain.ErrQuarantineFull
+	}
+
+	return q.InMemoryQuarantine.Quarantine(visit)
+}
+
+func TestRegistryRelease(t *testing.T) {
+	ctx := context.Background()
+
+	type testCase struct {
+		description      string
+		full             bool
+		expectedHeld     domain.Count
+		expectedFullFail bool
+	}
+
+	testCases := []testCase{
+		{
+			description:  "visits that can't be stored are held again",
+			expectedHeld: 2,
+		},
+		{
+			description:      "visits that can't be held again are reported",
+			full:             true,
+			expectedFullFail: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			quarantine := &fullQuarantine{InMemoryQuarantine: repository.NewInMemoryQuarantine(10)}
+			reg := Registry{Pages: repository.NewInMemoryPageRegistry(), Quarantine: quarantine, UnknownPages: QuarantineUnknownPages}
+
+			for _, visitor := range []string{"a", "b"} {
+				err := quarantine.Quarantine(domain.Visit{Visitor: visitor, PageURL: "/blog"})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			quarantine.full = tc.full
+
+			released, err := reg.release(ctx, failingRepository{repository.NewVisitsInMemoryRepository()}, domain.Page{URL: "/blog"})
+			if err == nil || released != 0 {
+				t.Errorf("got %v and %v, expected the store to fail", released, err)
+			}
+
+			if errors.Is(err, domain.ErrQuarantineFull) != tc.expectedFullFail {
+				t.Errorf("got %v, expected the lost visits to be reported: %v", err, tc.expectedFullFail)
+			}
+
+			counts, _ := quarantine.Quarantined()
+			if counts["/blog"] != tc.expectedHeld {
+				t.Errorf("got %v, expected %v visits held", counts["/blog"], tc.expectedHeld)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+// Package service is responsible for the business rules of the service (which visits are accepted, how page urls are
+// validated, what happens to visits of unknown pages...), independently of the transport they arrive through. Transports
+// (e.g. the http api) only parse their input and report the result, domain errors carry a domain.ErrorKind so each
+// transport can map them to its own status codes.
+package service
+
+import (
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// VisitService records visits and answers questions about them, validating the input and applying the page url policy
+// and the page registry before reaching the repository
+type VisitService struct {
+	repo       domain.VisitRepository
+	normalizer domain.PageURLNormalizer
+	policy     PageURLPolicy
+	registry   Registry
+	audit      domain.ErasureAudit
+	pseudonyms domain.VisitorPseudonymizer
+	migrations *migrations
+	now        func() time.Time
+}
+
+// Option customizes how a VisitService is built
+type Option func(*VisitService)
+
+// NewVisitService is a constructor for the VisitService, by default any page url that can be parsed is accepted, urls
+// are kept as given and visits of every page are stored. The features the repository doesn't support (see
+// domain.VisitRepository) fail with domain.ErrUnsupported
+func NewVisitService(repo domain.VisitRepository, opts ...Option) *VisitService {
+	s := &VisitService{
+		repo:       repo,
+		migrations: &migrations{},
+		now:        time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// WithNormalizer defines how page urls are canonicalized, it's only used to preview the canonical form of a url
+// (the repository is expected to canonicalize them itself)
+func WithNormalizer(normalizer domain.PageURLNormalizer) Option {
+	return func(s *VisitService) {
+		s.normalizer = normalizer
+	}
+}
+
+// WithPageURLPolicy defines which page urls are accepted
+func WithPageURLPolicy(policy PageURLPolicy) Option {
+	return func(s *VisitService) {
+		s.policy = policy
+	}
+}
+
+// WithRegistry defines the pages visits are expected for and what happens to the visits of the other ones, it's
+// required to manage pages
+func WithRegistry(registry Registry) Option {
+	return func(s *VisitService) {
+		s.registry = registry
+	}
+}
+
+// WithErasureAudit defines where the erasures of visitors are recorded, it's required to erase visitors
+func WithErasureAudit(audit domain.ErasureAudit) Option {
+	return func(s *VisitService) {
+		s.audit = audit
+	}
+}
+
+// WithPseudonymizer replaces visitor ids with their pseudonym before they're stored or looked up, so that raw visitor
+// ids are never kept
+func WithPseudonymizer(pseudonyms domain.VisitorPseudonymizer) Option {
+	return func(s *VisitService) {
+		s.pseudonyms = pseudonyms
+	}
+}
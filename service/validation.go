@@ -0,0 +1,82 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// DefaultMaxPageURLLength is the maximum length of a page url in strict mode, unless configured otherwise
+const DefaultMaxPageURLLength = 2048
+
+// PageURLPolicy defines which page urls are accepted, the zero value accepts any url that can be parsed.
+// In strict mode page urls must be absolute http(s) urls of at most MaxLength characters (0 means no limit) and, if
+// AllowedHosts is set, their host must be in it. Entries are either a host (example.org) or a wildcard for its
+// subdomains (*.example.org, which doesn't include example.org itself), ports are ignored
+type PageURLPolicy struct {
+	Strict       bool
+	MaxLength    int
+	AllowedHosts []string
+}
+
+// validate checks the page url against the policy, urls that can't be parsed are always invalid
+func (p PageURLPolicy) validate(pageURL string) error {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return fmt.Errorf("%w: %s", domain.ErrInvalidPageURL, pageURL)
+	}
+
+	if !p.Strict {
+		return nil
+	}
+
+	if p.MaxLength > 0 && len(pageURL) > p.MaxLength {
+		return fmt.Errorf("%w: longer than %d characters", domain.ErrRejectedPageURL, p.MaxLength)
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: not an absolute http(s) url: %s", domain.ErrRejectedPageURL, pageURL)
+	}
+
+	if len(p.AllowedHosts) > 0 && !p.allows(u.Hostname()) {
+		return fmt.Errorf("%w: host not allowed: %s", domain.ErrRejectedPageURL, u.Hostname())
+	}
+
+	return nil
+}
+
+// allows reports whether the host is in the allowlist, hosts are case-insensitive
+func (p PageURLPolicy) allows(host string) bool {
+	host = strings.ToLower(host)
+
+	for _, allowed := range p.AllowedHosts {
+		allowed = strings.ToLower(allowed)
+
+		parent, isWildcard := strings.CutPrefix(allowed, "*.")
+		if isWildcard && strings.HasSuffix(host, "."+parent) {
+			return true
+		}
+
+		if host == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validatePageURL checks that the page url is given and accepted by the policy, field names it in the error
+func (s *VisitService) validatePageURL(field, pageURL string) error {
+	if pageURL == "" {
+		return fmt.Errorf("%w: %s", domain.ErrMissingField, field)
+	}
+
+	return s.policy.validate(pageURL)
+}
+
+// tooLarge is the error of an input with more than limit items
+func tooLarge(limit int, items string) error {
+	return &domain.Error{Kind: domain.KindTooLarge, Msg: fmt.Sprintf("batch can't have more than %d %s", limit, items)}
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"deus.ai-code-challenge/domain"
+)
+
+func TestPageURLPolicyValidate(t *testing.T) {
+	type testCase struct {
+		description   string
+		policy        PageURLPolicy
+		pageURL       string
+		expectedError string
+	}
+
+	strict := PageURLPolicy{
+		Strict:       true,
+		MaxLength:    40,
+		AllowedHosts: []string{"example.org", "*.Example.NET"},
+	}
+
+	testCases := []testCase{
+		{
+			description: "success: relative url in lenient mode",
+			policy:      PageURLPolicy{},
+			pageURL:     "/blog",
+		},
+		{
+			description:   "error: unparsable url in lenient mode",
+			policy:        PageURLPolicy{},
+			pageURL:       "http://[::1",
+			expectedError: "invalid page url: http://[::1",
+		},
+		{
+			description: "success: allowed host",
+			policy:      strict,
+			pageURL:     "https://example.org/blog",
+		},
+		{
+			description: "success: allowed host with a port and different casing",
+			policy:      strict,
+			pageURL:     "http://EXAMPLE.org:8080/blog",
+		},
+		{
+			description: "success: subdomain of a wildcard host",
+			policy:      strict,
+			pageURL:     "https://shop.example.net/cart",
+		},
+		{
+			description:   "error: wildcard host doesn't include its parent",
+			policy:        strict,
+			pageURL:       "https://example.net/cart",
+			expectedError: "page url rejected: host not allowed: example.net",
+		},
+		{
+			description:   "error: host not allowed",
+			policy:        strict,
+			pageURL:       "https://example.org.evil.com/blog",
+			expectedError: "page url rejected: host not allowed: example.org.evil.com",
+		},
+		{
+			description:   "error: relative url",
+			policy:        strict,
+			pageURL:       "/blog",
+			expectedError: "page url rejected: not an absolute http(s) url: /blog",
+		},
+		{
+			description:   "error: not http(s)",
+			policy:        strict,
+			pageURL:       "ftp://example.org/file",
+			expectedError: "page url rejected: not an absolute http(s) url: ftp://example.org/file",
+		},
+		{
+			description:   "error: too long",
+			policy:        strict,
+			pageURL:       "https://example.org/" + strings.Repeat("a", 21),
+			expectedError: "page url rejected: longer than 40 characters",
+		},
+		{
+			description: "success: any host without an allowlist",
+			policy:      PageURLPolicy{Strict: true},
+			pageURL:     "https://example.com/" + strings.Repeat("a", 100),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			err := tc.policy.validate(tc.pageURL)
+			if err == nil && tc.expectedError != "" {
+				t.Errorf("got %v, expected %v", err, tc.expectedError)
+			}
+
+			if err != nil && err.Error() != tc.expectedError {
+				t.Errorf("got %v, expected %v", err, tc.expectedError)
+			}
+
+			if err != nil && domain.KindOf(err) != domain.KindInvalid {
+				t.Errorf("got %v, expected %v", domain.KindOf(err), domain.KindInvalid)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+)
+
+// MaxBatchSize bounds the number of visits recorded at once, so that a single call can't hold the repository for too long
+const MaxBatchSize = 1000
+
+// ErrBatchTooLarge is returned when more than MaxBatchSize visits are recorded at once
+var ErrBatchTooLarge = tooLarge(MaxBatchSize, "events")
+
+// MaxClockSkew is how far ahead of the server clock the time of a visit can be (e.g. a client clock running ahead)
+const MaxClockSkew = 5 * time.Minute
+
+// Outcome is what happened to a visit that was recorded
+type Outcome int
+
+const (
+	// Rejected visits were not stored, because they're invalid or refused
+	Rejected Outcome = iota
+	// Stored visits are accounted for
+	Stored
+	// Quarantined visits are held until their page is registered
+	Quarantined
+)
+
+// Result is the outcome of one of the visits recorded together, Err is only set when it was rejected
+type Result struct {
+	Outcome Outcome
+	Err     error
+}
+
+// ValidateVisit checks that the visitor and page url are given and that the page url is accepted by the policy
+func (s *VisitService) ValidateVisit(visit domain.Visit) error {
+	if visit.Visitor == "" {
+		return fmt.Errorf("%w: visitor id", domain.ErrMissingField)
+	}
+
+	return s.validatePageURL("page url", visit.PageURL)
+}
+
+// RecordVisit validates and stores the visit, visits of pages that aren't registered are handled according to the
+// registry policy. A visit without a time happened now (see visitTime for visits from the future), the visitor id is
+// replaced with its pseudonym (if required) before the visit is held or stored, and the visitor is moved from its
+// previous pseudonyms once the visit is admitted
+func (s *VisitService) RecordVisit(ctx context.Context, visit domain.Visit) (Outcome, error) {
+	err := s.ValidateVisit(visit)
+	if err != nil {
+		return Rejected, err
+	}
+
+	visit.Time, err = visitTime(visit.Time, s.now())
+	if err != nil {
+		return Rejected, err
+	}
+
+	var renames []domain.Rename
+	visit.Visitor, renames = s.pseudonymize(visit.Visitor)
+
+	store, err := s.registry.admit(visit)
+	if err != nil {
+		return Rejected, err
+	}
+
+	err = s.migrate(ctx, renames)
+	if err != nil {
+		return Rejected, err
+	}
+
+	if !store {
+		return Quarantined, nil
+	}
+
+	err = s.repo.Store(ctx, visit)
+	if err != nil {
+		return Rejected, err
+	}
+
+	return Stored, nil
+}
+
+// RecordVisits validates every visit and stores the accepted ones with a single repository call, an invalid visit
+// doesn't prevent the others from being stored. The visitors of the admitted visits are moved from their previous
+// pseudonyms with a single repository call too. The results are in the same order as the visits, the error is only
+// returned if the visits couldn't be stored at all (e.g. the repository failed)
+func (s *VisitService) RecordVisits(ctx context.Context, visits []domain.Visit) ([]Result, error) {
+	if len(visits) > MaxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+
+	results := make([]Result, len(visits))
+	accepted := make([]domain.Visit, 0, len(visits))
+
+	var renames []domain.Rename
+
+	now := s.now()
+
+	for i, visit := range visits {
+		err := s.ValidateVisit(visit)
+		if err != nil {
+			results[i] = Result{Outcome: Rejected, Err: err}
+
+			continue
+		}
+
+		visit.Time, err = visitTime(visit.Time, now)
+		if err != nil {
+			results[i] = Result{Outcome: Rejected, Err: err}
+
+			continue
+		}
+
+		var pending []domain.Rename
+		visit.Visitor, pending = s.pseudonymize(visit.Visitor)
+
+		store, err := s.registry.admit(visit)
+		if err != nil {
+			results[i] = Result{Outcome: Rejected, Err: err}
+
+			continue
+		}
+
+		renames = append(renames, pending...)
+
+		if !store {
+			results[i] = Result{Outcome: Quarantined}
+
+			continue
+		}
+
+		accepted = append(accepted, visit)
+		results[i] = Result{Outcome: Stored}
+	}
+
+	err := s.migrate(ctx, renames)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(accepted) > 0 {
+		err := storeBatch(ctx, s.repo, accepted)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// VisitorPages returns the pages the visitor was seen in along with when, in the order they were first seen in. When
+// visitor ids are pseudonymized the pages seen under each of its pseudonyms are merged, looking the visitor up doesn't
+// move it to its current pseudonym
+func (s *VisitService) VisitorPages(ctx context.Context, visitor string) ([]domain.VisitedPage, error) {
+	if visitor == "" {
+		return nil, fmt.Errorf("%w: visitor id", domain.ErrMissingField)
+	}
+
+	history, ok := s.repo.(domain.HistoryVisitRepository)
+	if !ok {
+		return nil, fmt.Errorf("%w: visitor history", domain.ErrUnsupported)
+	}
+
+	ids := s.pseudonymsOf(visitor)
+
+	histories := make([][]domain.VisitedPage, 0, len(ids))
+	for _, id := range ids {
+		pages, err := history.ListVisitorPages(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		histories = append(histories, pages)
+	}
+
+	return mergeVisitedPages(histories...), nil
+}
+
+// visitTime returns when a visit made at t happened: now if t is unknown or ahead of now by up to MaxClockSkew. Visits
+// further ahead are rejected, the repository would account for them in an hour that hasn't come yet (rolling the hours
+// before it up into days early)
+func visitTime(t, now time.Time) (time.Time, error) {
+	switch {
+	case t.IsZero():
+		return now, nil
+	case t.After(now.Add(MaxClockSkew)):
+		return time.Time{}, fmt.Errorf("%w: %s is more than %s ahead", domain.ErrFutureVisit, t.Format(time.RFC3339), MaxClockSkew)
+	case t.After(now):
+		return now, nil
+	default:
+		return t, nil
+	}
+}
+
+// storeBatch stores the visits with a single call if the repository supports it, one at a time otherwise (the visits
+// before the one that failed are kept then)
+func storeBatch(ctx context.Context, repo domain.VisitRepository, visits []domain.Visit) error {
+	batches, ok := repo.(domain.BatchVisitRepository)
+	if ok {
+		return batches.StoreBatch(ctx, visits)
+	}
+
+	for _, visit := range visits {
+		err := repo.Store(ctx, visit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
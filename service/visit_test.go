@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"deus.ai-code-challenge/domain"
+	"deus.ai-code-challenge/repository"
+)
+
+func TestVisitServiceRecordVisits(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	pages := repository.NewInMemoryPageRegistry()
+	quarantine := repository.NewInMemoryQuarantine(10)
+	repo := repository.NewVisitsInMemoryRepository()
+
+	_, err := pages.Register(domain.Page{URL: "known"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	visits := NewVisitService(repo, WithRegistry(Registry{
+		Pages:        pages,
+		Quarantine:   quarantine,
+		UnknownPages: QuarantineUnknownPages,
+	}))
+	visits.now = func() time.Time { return now }
+
+	results, err := visits.RecordVisits(ctx, []domain.Visit{
+		{Visitor: "a", PageURL: "known"},
+		{Visitor: "", PageURL: "known"},
+		{Visitor: "b", PageURL: "unknown"},
+		{Visitor: "c", PageURL: "known"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Outcome{Stored, Rejected, Quarantined, Stored}
+	for i, result := range results {
+		if result.Outcome != expected[i] {
+			t.Errorf("got %v, expected %v", result.Outcome, expected[i])
+		}
+	}
+
+	if domain.KindOf(results[1].Err) != domain.KindInvalid {
+		t.Errorf("got %v, expected %v", domain.KindOf(results[1].Err), domain.KindInvalid)
+	}
+
+	count, err := visits.UniqueVisitors(ctx, "known")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count.Count != 2 {
+		t.Errorf("got %v, expected %v", count.Count, 2)
+	}
+
+	_, released, err := visits.RegisterPage(ctx, domain.Page{URL: "unknown"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if released != 1 {
+		t.Errorf("got %v, expected %v", released, 1)
+	}
+
+	_, err = visits.RecordVisits(ctx, make([]domain.Visit, MaxBatchSize+1))
+	if domain.KindOf(err) != domain.KindTooLarge {
+		t.Errorf("got %v, expected %v", domain.KindOf(err), domain.KindTooLarge)
+	}
+}
+
+// basicRepository only stores and counts visits, hiding every other feature of the repository it wraps
+type basicRepository struct {
+	domain.VisitRepository
+}
+
+func TestVisitServiceUnsupportedFeatures(t *testing.T) {
+	ctx := context.Background()
+
+	visits := NewVisitService(basicRepository{repository.NewVisitsInMemoryRepository()})
+
+	// batches are stored one visit at a time
+	_, err := visits.RecordVisits(ctx, []domain.Visit{{Visitor: "a", PageURL: "/blog"}, {Visitor: "b", PageURL: "/blog"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := visits.UniqueVisitors(ctx, "/blog")
+	if err != nil || count.Count != 2 {
+		t.Errorf("got %v and %v, expected 2", count, err)
+	}
+
+	_, err = visits.TopPages(ctx, 1, 0)
+	if !errors.Is(err, domain.ErrUnsupported) {
+		t.Errorf("got %v, expected %v", err, domain.ErrUnsupported)
+	}
+
+	_, err = visits.VisitorPages(ctx, "a")
+	if !errors.Is(err, domain.ErrUnsupported) {
+		t.Errorf("got %v, expected %v", err, domain.ErrUnsupported)
+	}
+}
+
+func TestVisitServiceFutureVisits(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	repo := repository.NewVisitsInMemoryRepository()
+
+	visits := NewVisitService(repo)
+	visits.now = func() time.Time { return now }
+
+	results, err := visits.RecordVisits(ctx, []domain.Visit{
+		{Visitor: "a", PageURL: "/blog", Time: now.Add(MaxClockSkew)},
+		{Visitor: "b", PageURL: "/blog", Time: now.Add(MaxClockSkew + time.Second)},
+		{Visitor: "c", PageURL: "/blog", Time: now.Add(-time.Hour)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Outcome{Stored, Rejected, Stored}
+	for i, result := range results {
+		if result.Outcome != expected[i] {
+			t.Errorf("got %v, expected %v", result.Outcome, expected[i])
+		}
+	}
+
+	if !errors.Is(results[1].Err, domain.ErrFutureVisit) {
+		t.Errorf("got %v, expected %v", results[1].Err, domain.ErrFutureVisit)
+	}
+
+	_, err = visits.RecordVisit(ctx, domain.Visit{Visitor: "d", PageURL: "/blog", Time: now.Add(24 * time.Hour)})
+	if !errors.Is(err, domain.ErrFutureVisit) {
+		t.Errorf("got %v, expected %v", err, domain.ErrFutureVisit)
+	}
+
+	// visits within the skew happened now
+	count, err := repo.CountUniqueVisitorsBetween(ctx, "/blog", now, now.Add(time.Second))
+	if err != nil || count.Count != 1 {
+		t.Errorf("got %v and %v, expected 1", count, err)
+	}
+}